@@ -0,0 +1,106 @@
+// Command apikey issues, lists and revokes API keys directly in Valkey, without going through the admin API
+// It reads the same configuration as the server ($CONFIG_FILE and the environment); deploys use it to issue the cron
+// generator's key
+//
+// Usage:
+//
+//	apikey create -name cron-generator -scopes generate   # prints the secret on stdout
+//	apikey create -name cron-generator -scopes generate -replace   # also revokes older keys with that name
+//	apikey list
+//	apikey revoke -id <key-id>
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"cgc-lb-and-cdn-backend/internal/auth"
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "create" && os.Args[1] != "list" && os.Args[1] != "revoke") {
+		fmt.Fprintf(os.Stderr, "usage: apikey create -name NAME -scopes %s [-replace] | list | revoke -id ID\n", strings.Join(auth.AllScopes, ","))
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	name := flags.String("name", "", "key name (create)")
+	scopes := flags.String("scopes", "", "comma-separated scopes (create)")
+	replace := flags.Bool("replace", false, "revoke other keys with the same name once the new key is stored (create)")
+	id := flags.String("id", "", "key ID (revoke)")
+	flags.Parse(os.Args[2:])
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	valkeyClient, err := storage.NewValkeyClient(cfg.Valkey)
+	if err != nil {
+		log.Fatalf("Failed to connect to Valkey: %v", err)
+	}
+	defer valkeyClient.Close()
+
+	switch command {
+	case "create":
+		scopeList, err := auth.ParseScopes(*scopes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var key *storage.APIKey
+		var secret string
+		if *replace {
+			var revoked int
+			key, secret, revoked, err = auth.RotateKey(ctx, valkeyClient, *name, scopeList)
+			if key == nil {
+				log.Fatalf("Failed to create API key: %v", err)
+			}
+			if err != nil {
+				log.Printf("Failed to revoke older %s keys: %v", key.Name, err) // The new key works regardless
+			} else if revoked > 0 {
+				log.Printf("Revoked %d older %s key(s)", revoked, key.Name)
+			}
+		} else {
+			key, secret, err = auth.IssueKey(ctx, valkeyClient, *name, scopeList)
+			if err != nil {
+				log.Fatalf("Failed to create API key: %v", err)
+			}
+		}
+		log.Printf("Created API key %s (%s) with scopes %s", key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Println(secret) // Only the secret goes to stdout so scripts can capture it
+
+	case "list":
+		keys, err := valkeyClient.ListAPIKeys(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list API keys: %v", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(keys); err != nil {
+			log.Fatalf("Failed to write keys: %v", err)
+		}
+
+	case "revoke":
+		deleted, err := valkeyClient.DeleteAPIKey(ctx, *id)
+		if err != nil {
+			log.Fatalf("Failed to revoke API key: %v", err)
+		}
+		if !deleted {
+			log.Fatalf("API key %s not found", *id)
+		}
+		log.Printf("Revoked API key %s", *id)
+	}
+}
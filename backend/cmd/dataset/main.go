@@ -0,0 +1,120 @@
+// Command dataset exports the pairs and votes held in Valkey to a file, or imports such a file into Valkey
+// It reads the same configuration as the server ($CONFIG_FILE and the environment), so pointing it at another cluster
+// migrates the data
+//
+// Usage:
+//
+//	dataset export -format parquet -out votes.parquet
+//	dataset import -format parquet -in votes.parquet
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/dataset"
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "export" && os.Args[1] != "import") {
+		fmt.Fprintln(os.Stderr, "usage: dataset export|import [-format csv|jsonl|parquet] [-out file | -in file] [-force]")
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	formatName := flags.String("format", string(dataset.FormatJSONL), "file format: csv, jsonl or parquet")
+	out := flags.String("out", "-", "export destination file (- for stdout)")
+	in := flags.String("in", "-", "import source file (- for stdin)")
+	force := flags.Bool("force", false, "import votes even if the target already has some")
+	flags.Parse(os.Args[2:])
+
+	format, err := dataset.ParseFormat(*formatName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	valkeyClient, err := storage.NewValkeyClient(cfg.Valkey)
+	if err != nil {
+		log.Fatalf("Failed to connect to Valkey: %v", err)
+	}
+	defer valkeyClient.Close()
+
+	// Votes older than the hot window live in Spaces; exporting them needs the archive
+	if spacesClient, err := storage.NewSpacesClient(cfg.Spaces); err != nil {
+		log.Printf("Warning: Spaces unavailable, exports will fail once votes have been archived: %v", err)
+	} else {
+		valkeyClient.SetVoteArchive(spacesClient)
+	}
+
+	var summary *dataset.Summary
+	if command == "export" {
+		summary, err = runExport(ctx, valkeyClient, format, *out)
+	} else {
+		summary, err = runImport(ctx, valkeyClient, format, *in, *force)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", command, err)
+	}
+
+	report, _ := json.Marshal(summary)
+	log.Printf("%s complete: %s", command, report)
+}
+
+// runExport writes the dataset to path, or stdout when path is "-"
+func runExport(ctx context.Context, valkeyClient *storage.ValkeyClient, format dataset.Format, path string) (*dataset.Summary, error) {
+	var output io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	writer, err := dataset.NewWriter(format, output)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataset.Export(ctx, valkeyClient, writer)
+}
+
+// runImport reads the dataset from path, or stdin when path is "-"
+func runImport(ctx context.Context, valkeyClient *storage.ValkeyClient, format dataset.Format, path string, force bool) (*dataset.Summary, error) {
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader, err := dataset.NewReader(format, input)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return dataset.Import(ctx, valkeyClient, reader, dataset.ImportOptions{Force: force})
+}
@@ -0,0 +1,63 @@
+// Command reindex rebuilds the Valkey pair index (pair:* and pairs:all) from the images stored in Spaces
+// It reads the same configuration as the server ($CONFIG_FILE and the environment) and prints a JSON report
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/reindex"
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would be restored without writing to Valkey")
+	workers := flag.Int("workers", reindex.DefaultWorkers, "number of pairs processed in parallel")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	spacesClient, err := storage.NewSpacesClient(cfg.Spaces)
+	if err != nil {
+		log.Fatalf("Failed to initialize Spaces client: %v", err)
+	}
+
+	valkeyClient, err := storage.NewValkeyClient(cfg.Valkey)
+	if err != nil {
+		log.Fatalf("Failed to connect to Valkey: %v", err)
+	}
+	defer valkeyClient.Close()
+
+	report, err := reindex.NewRebuilder(spacesClient, valkeyClient).Run(ctx, reindex.Options{
+		DryRun:  *dryRun,
+		Workers: *workers,
+	})
+	if err != nil {
+		log.Fatalf("Reindex failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+
+	log.Printf("Reindex complete: %d restored, %d relisted, %d unchanged, %d half-written, %d orphaned, %d errors",
+		len(report.Restored), len(report.Relisted), report.Unchanged, len(report.HalfWritten), len(report.Orphaned), len(report.Errors))
+
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,68 +1,362 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"cgc-lb-and-cdn-backend/internal/agents"
+	"cgc-lb-and-cdn-backend/internal/auth"
+	"cgc-lb-and-cdn-backend/internal/concurrency"
 	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/events"
+	"cgc-lb-and-cdn-backend/internal/fraud"
 	"cgc-lb-and-cdn-backend/internal/handlers"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/metrics"
+	"cgc-lb-and-cdn-backend/internal/models"
 	"cgc-lb-and-cdn-backend/internal/providers"
+	"cgc-lb-and-cdn-backend/internal/ratelimit"
+	"cgc-lb-and-cdn-backend/internal/rooms"
+	"cgc-lb-and-cdn-backend/internal/settings"
 	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/internal/tracing"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	// Load configuration
-	cfg := config.Load()
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE); environment variables override it")
+	flag.Parse()
+
+	// Load and validate configuration before starting anything, so mistakes fail here rather than at first use
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Configuration error", err)
+	}
+
+	// Log JSON at the configured level from here on, with the configured credentials redacted
+	if err := logging.Setup(cfg.Logging, cfg.Secrets()); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	// Set up tracing first so spans from startup work are exported too
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	// Background jobs run until shutdown, after the last request has been served
+	background, stopBackground := context.WithCancel(context.Background())
 
 	// Create orchestrator agent
 	orchestrator := agents.NewImageOrchestrator()
+	orchestrator.SetDuplicatePolicy(cfg.Images.DuplicateThreshold, cfg.Images.DuplicateRetries)
+
+	// Initialize Spaces client, shared by the providers' uploads and the admin endpoints
+	spacesClient, err := storage.NewSpacesClient(cfg.Spaces)
+	if err != nil {
+		slog.Warn("Failed to initialize Spaces client, continuing without image uploads - generation will fail", "error", err)
+	}
 
 	// Initialize and register providers
-	if err := initializeProviders(orchestrator); err != nil {
-		log.Fatalf("Failed to initialize providers: %v", err)
+	if err := initializeProviders(orchestrator, cfg.Providers, spacesClient); err != nil {
+		fatal("Failed to initialize providers", err)
 	}
 
 	// Initialize Valkey client
-	valkeyClient, err := storage.NewValkeyClient()
+	valkeyClient, err := storage.NewValkeyClient(cfg.Valkey)
 	if err != nil {
-		log.Printf("Warning: Failed to initialize Valkey client: %v", err)
-		log.Printf("Continuing without vote persistence - leaderboard will be unavailable")
+		slog.Warn("Failed to initialize Valkey client, continuing without vote persistence - leaderboard will be unavailable", "error", err)
 	} else {
-		log.Printf("✓ Connected to Valkey database")
+		slog.Info("Connected to Valkey database")
+		if err := metrics.RegisterPairInventory(valkeyClient); err != nil {
+			slog.Warn("Failed to register pair inventory metrics", "error", err)
+		}
+	}
+
+	// Cap concurrent calls to each provider across droplets; with no configured cap, a provider's quota API
+	// (Leonardo's concurrency slots) sets it once known
+	concurrencyLimits := map[string]int{
+		"freepik":       cfg.Providers.Freepik.MaxConcurrency,
+		"google-imagen": cfg.Providers.Google.MaxConcurrency,
+		"leonardo-ai":   cfg.Providers.Leonardo.MaxConcurrency,
 	}
+	slots := concurrency.NewSlots(valkeyClient, func(name string) int {
+		if limit := concurrencyLimits[name]; limit > 0 {
+			return limit
+		}
+		if provider, exists := orchestrator.GetProvider(name); exists {
+			if quota := provider.GetStatus().QuotaInfo; quota != nil {
+				return quota.ConcurrencySlots
+			}
+		}
+		return 0
+	}, cfg.Providers.SlotLease)
+	orchestrator.SetConcurrencyLimiter(slots, cfg.Providers.QueueWait)
+	go refreshQuotas(background, orchestrator)
+
+	// Retire pairs from rotation in the background according to the retention rules
+	retentionPolicy := storage.RetentionPolicy{
+		MaxVotes:      cfg.Retention.MaxVotes,
+		MaxAge:        cfg.Retention.MaxAge,
+		SweepInterval: cfg.Retention.SweepInterval,
+	}
+	if valkeyClient != nil {
+		valkeyClient.StartRetentionSweeper(background, retentionPolicy)
+	}
+
+	// Archive old votes to Spaces; moves votes out of the pre-stream votes:all list on first start
+	voteLogPolicy := storage.VoteLogPolicy{
+		HotWindow:       cfg.VoteLog.HotWindow,
+		CompactInterval: cfg.VoteLog.CompactInterval,
+	}
+	if valkeyClient != nil {
+		if migrated, err := valkeyClient.MigrateLegacyVotes(context.Background()); err != nil {
+			slog.Warn("Failed to migrate legacy votes", "error", err)
+		} else if migrated > 0 {
+			slog.Info("Migrated legacy votes into the vote log", "votes", migrated)
+		}
+		if spacesClient != nil {
+			valkeyClient.SetVoteArchive(spacesClient)
+			valkeyClient.StartVoteCompactor(background, voteLogPolicy)
+		}
+
+		// Clusters from before the per-pair win counters get them built from the vote log once
+		go func() {
+			if seeded, err := valkeyClient.SeedPairWins(background); err != nil {
+				slog.Warn("Failed to build win counters", "error", err)
+			} else if seeded {
+				slog.Info("Built win counters from the vote log")
+			}
+		}()
+	}
+
+	// Fan live events out to SSE clients on every droplet through Valkey pub/sub
+	broker := events.NewBroker(valkeyClient)
+	broker.Start(background)
+	orchestrator.SetStatusListener(func(status *models.ProviderStatus) {
+		if err := broker.Publish(context.Background(), events.TypeProviderStatus, status); err != nil {
+			slog.Warn("Failed to publish provider status event", "provider", status.Name, "error", err)
+		}
+	})
+
+	// Apply runtime settings changed through the admin API on every droplet without a redeploy
+	providerNames := make([]string, 0)
+	for name := range orchestrator.GetProviderStatus() {
+		providerNames = append(providerNames, name)
+	}
+	settingsManager := settings.NewManager(valkeyClient, providerNames, cfg.Runtime.PollInterval)
+	settingsManager.Start(background)
+	settingsManager.OnChange(func(runtime *storage.RuntimeSettings) {
+		controls := make(map[string]agents.ProviderControl)
+		for name, provider := range runtime.Providers {
+			control := agents.ProviderControl{Enabled: provider.Enabled, Weight: provider.Weight}
+			if provider.ResetAt != nil {
+				control.ResetAt = *provider.ResetAt
+			}
+			controls[name] = control
+		}
+		orchestrator.SetProviderControls(controls)
+	})
+
+	// Authenticate callers with scoped API keys; ADMIN_API_KEY works as a bootstrap admin key
+	anonymousScopes, err := auth.ParseScopes(strings.Join(cfg.Auth.AnonymousScopes, ","))
+	if err != nil {
+		fatal("Invalid AUTH_ANONYMOUS_SCOPES", err)
+	}
+	authenticator := auth.NewAuthenticator(valkeyClient, cfg.Admin.APIKey, anonymousScopes)
+
+	// Score votes for ballot stuffing and bots; suspicious votes are flagged or quarantined
+	detector := fraud.NewDetector(valkeyClient, fraud.Policy{
+		Mode:              cfg.Fraud.Mode,
+		MaxVotesPerMinute: cfg.Fraud.MaxVotesPerMinute,
+		MinVoteLatency:    cfg.Fraud.MinVoteLatency,
+		SameSideMinVotes:  cfg.Fraud.SameSideMinVotes,
+		SameSidePercent:   cfg.Fraud.SameSidePercent,
+		MaxSessionsPerIP:  cfg.Fraud.MaxSessionsPerIP,
+		FlagTTL:           cfg.Fraud.FlagTTL,
+	})
 
 	// Create handlers
-	imageHandler := handlers.NewImageHandler(orchestrator, valkeyClient)
+	imageHandler := handlers.NewImageHandler(orchestrator, valkeyClient, broker, detector, settingsManager)
+	healthHandler := handlers.NewHealthHandler(orchestrator, valkeyClient, spacesClient, cfg.Valkey.Configured(), cfg.Spaces.Configured())
+	adminHandler := handlers.NewAdminHandler(valkeyClient, spacesClient, retentionPolicy, voteLogPolicy)
+	settingsHandler := handlers.NewSettingsHandler(settingsManager)
+	providerHandler := handlers.NewProviderHandler(orchestrator, settingsManager)
+	eventsHandler := handlers.NewEventsHandler(broker)
+
+	// Finish Leonardo generations on their completion callbacks, which any droplet may receive, instead of keeping
+	// the generation requests waiting
+	var leonardoCallbacks *providers.LeonardoAIProvider
+	if cfg.Providers.Leonardo.WebhookSecret != "" {
+		if provider, exists := orchestrator.GetProvider("leonardo-ai"); exists {
+			if leonardo, ok := provider.(*providers.LeonardoAIProvider); ok && leonardo.EnableCallbacks(background, valkeyClient, imageHandler.FinishPendingGeneration) {
+				leonardoCallbacks = leonardo
+			}
+		}
+	}
+	webhookHandler := handlers.NewWebhookHandler(leonardoCallbacks, cfg.Providers.Leonardo.WebhookSecret)
+
+	// Battle rooms keep their state in Valkey, so they are only available with it
+	var roomManager *rooms.Manager
+	if valkeyClient != nil {
+		roomManager = rooms.NewManager(valkeyClient, broker, detector)
+	}
+	roomHandler := handlers.NewRoomHandler(roomManager)
+
+	// Rate limit each route per client, with buckets shared by every droplet
+	limits, err := parseRouteLimits(cfg.RateLimit)
+	if err != nil {
+		fatal("Invalid rate limit configuration", err)
+	}
+	limiter := ratelimit.NewLimiter(valkeyClient)
 
 	// Setup Gin router
-	router := setupRouter(imageHandler)
+	router := setupRouter(authenticator, limiter, limits, settingsManager, healthHandler, imageHandler, adminHandler, settingsHandler, providerHandler, eventsHandler, webhookHandler, roomHandler)
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
 
 	// Start server
 	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("Starting server on %s", addr)
-	log.Printf("Available endpoints:")
-	log.Printf("  GET  /health - Health check")
-	log.Printf("  POST /api/v1/generate - Generate image pair")
-	log.Printf("  GET  /api/v1/status - Get provider status")
-	log.Printf("  GET  /api/v1/images/pair - Get random image pair")
-	log.Printf("  POST /api/v1/images/rate - Submit comparison rating")
-	log.Printf("  GET  /api/v1/statistics - Get voting statistics")
-	log.Printf("  GET  /api/v1/images/winners?side=left|right - Get winning images")
-
-	if err := router.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	slog.Info("Starting server", "addr", addr)
+	slog.Debug("Available endpoint", "endpoint", "GET /livez", "description", "Liveness check")
+	slog.Debug("Available endpoint", "endpoint", "GET /readyz", "description", "Readiness check (Valkey and Spaces reachable)")
+	slog.Debug("Available endpoint", "endpoint", "GET /health", "description", "Provider availability summary")
+	slog.Debug("Available endpoint", "endpoint", "GET /health/deep", "description", "Dependency latency, provider states and pair inventory (backend port only)")
+	slog.Debug("Available endpoint", "endpoint", "GET /metrics", "description", "Prometheus metrics (backend port only, not proxied by nginx)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/generate", "description", "Generate image pair (generate scope)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/status", "description", "Get provider status")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/images/pair", "description", "Get random image pair")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/images/rate", "description", "Submit comparison rating")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/statistics", "description", "Get voting statistics")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/images/winners?side=left|right", "description", "Get winning images")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/images/archive", "description", "Get retired image pairs")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/events", "description", "Stream live votes, new pairs and provider status (SSE)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/rooms", "description", "Create a battle room")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/rooms/:id", "description", "Get a battle room")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/rooms/:id/ws?name=...&host_token=...", "description", "Join a battle room (WebSocket)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/webhooks/leonardo", "description", "Leonardo generation callbacks (webhook secret)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/duplicates", "description", "Find near-duplicate images (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/pairs/sweep", "description", "Apply retention rules now (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/pairs/:id/retire", "description", "Retire a pair (admin)")
+	slog.Debug("Available endpoint", "endpoint", "DELETE /api/v1/admin/pairs/:id", "description", "Delete a pair everywhere (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/reindex", "description", "Rebuild the pair index from Spaces (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/votes/compact", "description", "Archive old votes to Spaces now (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/consistency", "description", "Report drift between aggregates and raw data (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/consistency/repair", "description", "Rewrite drifting aggregates (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/export?format=csv|jsonl|parquet", "description", "Export pairs and votes (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/import?format=csv|jsonl|parquet", "description", "Import an exported dataset (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/keys", "description", "List API keys (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/keys", "description", "Issue an API key (admin)")
+	slog.Debug("Available endpoint", "endpoint", "DELETE /api/v1/admin/keys/:id", "description", "Revoke an API key (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/fraud", "description", "List flagged sessions and IPs (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/fraud/quarantine?subject=...", "description", "List quarantined votes (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/fraud/quarantine/:id/reinstate", "description", "Count a quarantined vote (admin)")
+	slog.Debug("Available endpoint", "endpoint", "DELETE /api/v1/admin/fraud/quarantine/:id", "description", "Discard a quarantined vote (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/fraud/subjects/:subject/reinstate", "description", "Clear a voter and reinstate its votes (admin)")
+	slog.Debug("Available endpoint", "endpoint", "DELETE /api/v1/admin/fraud/flags/:subject", "description", "Clear a flag (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/settings", "description", "Get runtime settings (admin)")
+	slog.Debug("Available endpoint", "endpoint", "PATCH /api/v1/admin/settings", "description", "Change runtime settings (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/settings/audit", "description", "List runtime settings changes (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/providers/:name", "description", "Get a provider's status, quota and settings (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/providers/:name/enable|disable", "description", "Enable or disable a provider (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/providers/:name/reset", "description", "Clear a provider's error state (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/providers/:name/quota/refresh", "description", "Refresh a provider's quota (admin)")
+	slog.Debug("Available endpoint", "endpoint", "POST /api/v1/admin/providers/:name/test", "description", "Run a test generation with one provider (admin)")
+	slog.Debug("Available endpoint", "endpoint", "GET /api/v1/admin/providers/:name/errors", "description", "List a provider's recent errors (admin)")
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// SSE streams never go idle, so end them as soon as shutdown starts
+	server.RegisterOnShutdown(broker.Close)
+
+	// The first SIGTERM or SIGINT starts a graceful shutdown; a second one exits immediately
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("Failed to start server", err)
+	case <-signals.Done():
+		stopSignals()
+	}
+
+	// Fail readiness while still serving, so the load balancer drains this droplet before the listener closes
+	slog.Info("Shutting down, draining load balancer traffic", "drain_delay", cfg.Server.DrainDelay.String())
+	healthHandler.StartDraining()
+	time.Sleep(cfg.Server.DrainDelay)
+
+	// Stop accepting connections and wait for in-flight requests, such as generations still uploading
+	slog.Info("Waiting for in-flight requests", "timeout", cfg.Server.ShutdownTimeout.String())
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("In-flight requests did not finish in time, closing connections", "error", err)
+		server.Close()
+	}
+	if err := roomHandler.Close(shutdownCtx); err != nil {
+		slog.Warn("Room participants did not leave in time", "error", err)
+	}
+
+	// Let a running sweep or compaction finish its writes, then close storage and export the remaining spans
+	stopBackground()
+	if valkeyClient != nil {
+		if err := valkeyClient.Close(); err != nil {
+			slog.Warn("Failed to close Valkey client", "error", err)
+		}
+	}
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTracing()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs a startup failure and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// refreshQuotas loads each provider's quota once at startup, so quota-derived concurrency limits apply
+// from the first generation on
+func refreshQuotas(ctx context.Context, orchestrator *agents.ImageOrchestrator) {
+	for name := range orchestrator.GetProviderStatus() {
+		provider, exists := orchestrator.GetProvider(name)
+		if !exists || !provider.IsAvailable() {
+			continue
+		}
+
+		refreshCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := provider.RefreshQuota(refreshCtx); err != nil {
+			slog.Warn("Failed to refresh provider quota", "provider", name, "error", err)
+		}
+		cancel()
 	}
 }
 
 // initializeProviders creates and registers all image providers
-func initializeProviders(orchestrator *agents.ImageOrchestrator) error {
+func initializeProviders(orchestrator *agents.ImageOrchestrator, cfg config.ProvidersConfig, spaces *storage.SpacesClient) error {
 	// Create providers
-	freepikProvider := providers.NewFreepikProvider()
-	googleProvider := providers.NewGoogleImagenProvider()
-	leonardoProvider := providers.NewLeonardoAIProvider()
+	freepikProvider := providers.NewFreepikProvider(cfg.Freepik, spaces)
+	googleProvider := providers.NewGoogleImagenProvider(cfg.Google, spaces)
+	leonardoProvider := providers.NewLeonardoAIProvider(cfg.Leonardo, spaces)
 
 	// Register providers with orchestrator
 	if err := orchestrator.RegisterProvider(freepikProvider); err != nil {
@@ -79,53 +373,147 @@ func initializeProviders(orchestrator *agents.ImageOrchestrator) error {
 
 	// Log provider status
 	status := orchestrator.GetProviderStatus()
-	log.Printf("Initialized %d providers:", len(status))
+	slog.Info("Initialized providers", "providers", len(status))
 	for name, s := range status {
-		availabilityStatus := "✓ Available"
-		if !s.Available {
-			availabilityStatus = "✗ Unavailable: " + s.LastError
+		if s.Available {
+			slog.Info("Provider available", "provider", name)
+		} else {
+			slog.Warn("Provider unavailable", "provider", name, "error", s.LastError)
 		}
-		log.Printf("  %s: %s", name, availabilityStatus)
 	}
 
 	return nil
 }
 
 // setupRouter configures the Gin router with all routes and middleware
-func setupRouter(imageHandler *handlers.ImageHandler) *gin.Engine {
+func setupRouter(authenticator *auth.Authenticator, limiter *ratelimit.Limiter, limits routeLimits, settingsManager *settings.Manager, healthHandler *handlers.HealthHandler, imageHandler *handlers.ImageHandler, adminHandler *handlers.AdminHandler, settingsHandler *handlers.SettingsHandler, providerHandler *handlers.ProviderHandler, eventsHandler *handlers.EventsHandler, webhookHandler *handlers.WebhookHandler, roomHandler *handlers.RoomHandler) *gin.Engine {
 	// Set Gin mode (can be overridden with GIN_MODE env var)
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
 
 	// Add middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	router.Use(logging.Middleware())
+	router.Use(logging.Recovery())
+	router.Use(tracing.Middleware())
+	router.Use(metrics.Middleware())
 	router.Use(corsMiddleware())
 
-	// Health check endpoint
-	router.GET("/health", imageHandler.HealthCheck)
+	// Health checks: the load balancer routes on /readyz; /health/deep is for operators and is not proxied by nginx
+	router.GET("/livez", healthHandler.Livez)
+	router.GET("/readyz", healthHandler.Readyz)
+	router.GET("/health", healthHandler.Health)
+	router.GET("/health/deep", healthHandler.Deep)
+
+	// Prometheus metrics; nginx only proxies the health checks and /api/, so this is reachable on the VPC only
+	router.GET("/metrics", metrics.Handler())
 
-	// API routes
+	// API routes, grouped by the scope they require
+	// Requests without an API key get AUTH_ANONYMOUS_SCOPES (read and vote by default)
+	// Maintenance mode turns away everything but the admin endpoints, so operators can still turn it off
 	api := router.Group("/api/v1")
+	maintenance := settingsManager.Maintenance()
+
+	read := api.Group("", maintenance, authenticator.RequireScope(auth.ScopeRead), limiter.Limit(limits.read))
+	{
+		read.GET("/status", imageHandler.GetProviderStatus)
+		read.GET("/images/pair", imageHandler.GetImagePair)
+		read.GET("/statistics", imageHandler.GetStatistics)
+		read.GET("/images/winners", imageHandler.GetWinners)
+		read.GET("/images/archive", imageHandler.GetArchivedPairs)
+		read.GET("/events", eventsHandler.StreamEvents)
+		read.GET("/rooms/:id", roomHandler.GetRoom)
+	}
+
+	vote := api.Group("", maintenance, authenticator.RequireScope(auth.ScopeVote))
+	{
+		vote.POST("/images/rate", limiter.Limit(limits.vote), imageHandler.SubmitRating)
+		vote.POST("/rooms", limiter.Limit(limits.createRoom), roomHandler.CreateRoom)
+		vote.GET("/rooms/:id/ws", limiter.Limit(limits.read), roomHandler.Connect)
+	}
+
+	// Generation spends provider credits
+	generate := api.Group("", maintenance, authenticator.RequireScope(auth.ScopeGenerate))
+	{
+		generate.POST("/generate", limiter.Limit(limits.generate), imageHandler.GenerateImage)
+	}
+
+	// Provider callbacks authenticate with the provider's webhook secret rather than an API key, and are accepted
+	// in maintenance mode so generations already under way can finish
+	api.POST("/webhooks/leonardo", webhookHandler.LeonardoCallback)
+
+	admin := api.Group("/admin", authenticator.RequireScope(auth.ScopeAdmin))
 	{
-		api.POST("/generate", imageHandler.GenerateImage)
-		api.GET("/status", imageHandler.GetProviderStatus)
-		api.GET("/images/pair", imageHandler.GetImagePair)
-		api.POST("/images/rate", imageHandler.SubmitRating)
-		api.GET("/statistics", imageHandler.GetStatistics)
-		api.GET("/images/winners", imageHandler.GetWinners)
+		admin.GET("/duplicates", adminHandler.GetDuplicates)
+		admin.POST("/pairs/sweep", adminHandler.SweepRetiredPairs)
+		admin.POST("/pairs/:id/retire", adminHandler.RetirePair)
+		admin.DELETE("/pairs/:id", adminHandler.DeletePair)
+		admin.POST("/reindex", adminHandler.Reindex)
+		admin.POST("/votes/compact", adminHandler.CompactVotes)
+		admin.GET("/consistency", adminHandler.CheckConsistency)
+		admin.POST("/consistency/repair", adminHandler.RepairConsistency)
+		admin.GET("/export", adminHandler.Export)
+		admin.POST("/import", adminHandler.Import)
+		admin.GET("/keys", adminHandler.ListAPIKeys)
+		admin.POST("/keys", adminHandler.CreateAPIKey)
+		admin.DELETE("/keys/:id", adminHandler.DeleteAPIKey)
+		admin.GET("/fraud", adminHandler.GetFraudReport)
+		admin.GET("/fraud/quarantine", adminHandler.ListQuarantinedVotes)
+		admin.POST("/fraud/quarantine/:id/reinstate", adminHandler.ReinstateQuarantinedVote)
+		admin.DELETE("/fraud/quarantine/:id", adminHandler.DiscardQuarantinedVote)
+		admin.POST("/fraud/subjects/:subject/reinstate", adminHandler.ReinstateFraudSubject)
+		admin.DELETE("/fraud/flags/:subject", adminHandler.ClearFraudFlag)
+		admin.GET("/settings", settingsHandler.GetSettings)
+		admin.PATCH("/settings", settingsHandler.UpdateSettings)
+		admin.GET("/settings/audit", settingsHandler.GetSettingsAudit)
+		admin.GET("/providers/:name", providerHandler.GetProvider)
+		admin.POST("/providers/:name/enable", providerHandler.EnableProvider)
+		admin.POST("/providers/:name/disable", providerHandler.DisableProvider)
+		admin.POST("/providers/:name/reset", providerHandler.ResetProvider)
+		admin.POST("/providers/:name/quota/refresh", providerHandler.RefreshQuota)
+		admin.POST("/providers/:name/test", providerHandler.TestProvider)
+		admin.GET("/providers/:name/errors", providerHandler.GetProviderErrors)
 	}
 
 	return router
 }
 
+// routeLimits are the rate limit rules applied in setupRouter
+type routeLimits struct {
+	read       ratelimit.Rule
+	vote       ratelimit.Rule
+	generate   ratelimit.Rule
+	createRoom ratelimit.Rule
+}
+
+// parseRouteLimits parses the configured rate limits
+func parseRouteLimits(cfg config.RateLimitConfig) (routeLimits, error) {
+	var limits routeLimits
+	var err error
+
+	if limits.read, err = ratelimit.ParseRule("read", cfg.Read); err != nil {
+		return limits, err
+	}
+	if limits.vote, err = ratelimit.ParseRule("vote", cfg.Vote); err != nil {
+		return limits, err
+	}
+	if limits.generate, err = ratelimit.ParseRule("generate", cfg.Generate); err != nil {
+		return limits, err
+	}
+	if limits.createRoom, err = ratelimit.ParseRule("create_room", cfg.CreateRoom); err != nil {
+		return limits, err
+	}
+
+	return limits, nil
+}
+
 // corsMiddleware adds CORS headers to responses
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, "+ratelimit.SessionHeader+", "+logging.RequestIDHeader)
+		c.Header("Access-Control-Expose-Headers", "RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, "+logging.RequestIDHeader)
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
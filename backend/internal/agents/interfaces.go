@@ -24,6 +24,15 @@ type ImageProvider interface {
 
 	// RefreshQuota updates quota information from the provider's API
 	RefreshQuota(ctx context.Context) error
+
+	// ResetStatus clears the last error, error count and quota/rate-limit flags, making the provider available again
+	ResetStatus()
+
+	// RecentErrors returns up to limit of the errors passed to HandleError, newest first
+	RecentErrors(limit int) []models.ProviderErrorRecord
+
+	// RollbackImages deletes images the provider uploaded, along with their variants
+	RollbackImages(ctx context.Context, images []models.GeneratedImage)
 }
 
 // Agent represents a generic agent in the ADK framework
@@ -56,6 +65,14 @@ type OrchestratorAgent interface {
 
 	// GetProvider returns a specific provider by name
 	GetProvider(name string) (ImageProvider, bool)
+
+	// CompletePending records the outcome of a generation a provider finished in the background, returning the
+	// pair to store unless it failed or is a near-duplicate
+	CompletePending(ctx context.Context, provider string, response *models.ImageResponse, err error) (*models.ImageResponse, error)
+
+	// TestProvider generates a pair with the named provider alone, even if an operator disabled it,
+	// and records the outcome in its status like any other attempt
+	TestProvider(ctx context.Context, name string, req *models.ImageRequest) (*models.ImageResponse, error)
 }
 
 // ProviderAgent wraps an image provider with agent capabilities
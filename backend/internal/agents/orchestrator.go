@@ -2,16 +2,40 @@ package agents
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"math/rand"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
+	"cgc-lb-and-cdn-backend/internal/concurrency"
+	"cgc-lb-and-cdn-backend/internal/imaging"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/metrics"
 	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	// ErrNearDuplicate is returned when a provider keeps producing left and right images that are perceptually identical
+	ErrNearDuplicate = errors.New("generated images are near-duplicates")
+
+	// ErrUnknownProvider is returned for a provider name that was never registered
+	ErrUnknownProvider = errors.New("unknown provider")
+)
+
+// tracer records provider selection and each provider attempt
+var tracer = otel.Tracer("cgc-lb-and-cdn-backend/internal/agents")
+
+// logger records provider selection and fallback for each generation request
+var logger = logging.Logger("orchestrator")
+
 // ImageOrchestrator implements the OrchestratorAgent interface
 type ImageOrchestrator struct {
 	name      string
@@ -19,8 +43,32 @@ type ImageOrchestrator struct {
 	status    map[string]*models.ProviderStatus
 	mutex     sync.RWMutex
 	random    *rand.Rand
+
+	// Near-duplicate detection (see SetDuplicatePolicy)
+	duplicateThreshold int
+	duplicateRetries   int
+
+	// Called whenever a provider becomes available or unavailable (see SetStatusListener)
+	statusListener func(status *models.ProviderStatus)
+
+	// Operator overrides from the runtime settings (see SetProviderControls)
+	controls map[string]ProviderControl
+
+	// Provider concurrency slots shared across droplets (see SetConcurrencyLimiter); nil means unlimited
+	slots     *concurrency.Slots
+	queueWait time.Duration
 }
 
+// ProviderControl is an operator's runtime override for a provider
+type ProviderControl struct {
+	Enabled bool
+	Weight  float64   // Relative chance of being tried first; 0 makes the provider a last resort
+	ResetAt time.Time // When an operator last asked for the provider's error state to be cleared
+}
+
+// defaultProviderControl applies to providers without an override
+var defaultProviderControl = ProviderControl{Enabled: true, Weight: 1}
+
 // NewImageOrchestrator creates a new orchestrator agent
 func NewImageOrchestrator() *ImageOrchestrator {
 	return &ImageOrchestrator{
@@ -28,6 +76,7 @@ func NewImageOrchestrator() *ImageOrchestrator {
 		providers: make(map[string]ImageProvider),
 		status:    make(map[string]*models.ProviderStatus),
 		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		controls:  make(map[string]ProviderControl),
 	}
 }
 
@@ -38,96 +87,291 @@ func (o *ImageOrchestrator) Execute(ctx context.Context, input interface{}) (int
 		return nil, fmt.Errorf("invalid input type: expected *models.ImageRequest")
 	}
 
-	log.Printf("[ADK] Starting image generation for request: %s", req.RequestID)
+	logger.InfoContext(ctx, "Starting image generation", "pair_id", req.PairID)
 
 	// Select initial provider
 	decision, err := o.SelectProvider(ctx, req)
 	if err != nil {
-		log.Printf("[ADK] Failed to select provider: %v", err)
+		logger.ErrorContext(ctx, "Failed to select provider", "error", err)
+		metrics.Generations.WithLabelValues("none", metrics.OutcomeError).Inc()
 		return nil, fmt.Errorf("failed to select provider: %w", err)
 	}
 
-	log.Printf("[ADK] Selected provider: %s, fallback order: %v", decision.SelectedProvider, decision.FallbackOrder)
+	o.mutex.RLock()
+	slots, waitBudget := o.slots, o.queueWait
+	o.mutex.RUnlock()
+	if slots != nil {
+		decision.FallbackOrder = preferFreeProviders(ctx, slots, decision.FallbackOrder)
+		decision.SelectedProvider = decision.FallbackOrder[0]
+	}
+
+	logger.InfoContext(ctx, "Selected provider", "provider", decision.SelectedProvider, "fallback_order", decision.FallbackOrder)
 
 	// Try providers in fallback order
 	for i, providerName := range decision.FallbackOrder {
-		log.Printf("[ADK] Trying provider %d/%d: %s", i+1, len(decision.FallbackOrder), providerName)
+		logger.DebugContext(ctx, "Trying provider", "provider", providerName, "attempt", i+1, "providers", len(decision.FallbackOrder))
 
 		provider, exists := o.providers[providerName]
 		if !exists {
-			log.Printf("[ADK] Provider %s not found, skipping", providerName)
+			logger.WarnContext(ctx, "Provider not found, skipping", "provider", providerName)
 			continue
 		}
 
 		if !provider.IsAvailable() {
-			log.Printf("[ADK] Provider %s not available, skipping", providerName)
+			logger.InfoContext(ctx, "Provider not available, skipping", "provider", providerName)
 			continue
 		}
 
-		log.Printf("[ADK] Calling provider %s for generation", providerName)
-		response, err := provider.Generate(ctx, req)
-		if err != nil {
-			log.Printf("[ADK] Provider %s failed with error: %v", providerName, err)
+		// The time spent queueing for slots counts against one budget for the whole request
+		release := func() {}
+		if slots != nil {
+			queued := time.Now()
+			release, err = slots.Acquire(ctx, providerName, queued.Add(waitBudget))
+			waitBudget -= time.Since(queued)
+			if errors.Is(err, concurrency.ErrWaitTimeout) {
+				logger.InfoContext(ctx, "Provider busy", "provider", providerName)
+				if providerName == decision.FallbackOrder[len(decision.FallbackOrder)-1] {
+					metrics.Generations.WithLabelValues("none", metrics.OutcomeError).Inc()
+					return nil, fmt.Errorf("all providers failed, last error from %s: %w", providerName, err)
+				}
+				metrics.Fallbacks.WithLabelValues(providerName, "BUSY").Inc()
+				continue
+			}
+			if err != nil {
+				metrics.Generations.WithLabelValues("none", metrics.OutcomeCancelled).Inc()
+				return nil, fmt.Errorf("waiting for %s: %w", providerName, err)
+			}
+		}
 
+		attemptCtx, span := tracer.Start(ctx, "orchestrator.attempt", trace.WithAttributes(
+			attribute.String("provider", providerName),
+			attribute.Int("orchestrator.attempt", i+1),
+		))
+		start := time.Now()
+		response, err := provider.Generate(attemptCtx, req)
+		if err == nil && !response.Pending {
+			response, err = o.regenerateNearDuplicates(attemptCtx, provider, req, response)
+		}
+		release()
+		outcome := metrics.Outcome(err)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			outcome = metrics.OutcomeCancelled
+		case errors.Is(err, ErrNearDuplicate):
+			outcome = metrics.OutcomeNearDuplicate
+		case err == nil && response.Pending:
+			outcome = metrics.OutcomePending
+		}
+		metrics.GenerationDuration.WithLabelValues(providerName, outcome).Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.String("orchestrator.outcome", outcome))
+		tracing.End(span, err)
+
+		if outcome == metrics.OutcomeCancelled {
+			// The caller went away or ran out of time, which says nothing about the provider
+			logger.InfoContext(ctx, "Generation cancelled", "provider", providerName, "error", ctx.Err())
+			metrics.Generations.WithLabelValues("none", metrics.OutcomeCancelled).Inc()
+			return nil, fmt.Errorf("generation with %s cancelled: %w", providerName, ctx.Err())
+		}
+		if errors.Is(err, ErrNearDuplicate) {
+			// Not the provider's fault, so leave its status alone and just move on
+			logger.WarnContext(ctx, "Provider kept returning near-duplicate images", "provider", providerName)
+			if providerName == decision.FallbackOrder[len(decision.FallbackOrder)-1] {
+				metrics.Generations.WithLabelValues("none", metrics.OutcomeError).Inc()
+				return nil, fmt.Errorf("all providers failed, last error from %s: %w", providerName, err)
+			}
+			metrics.Fallbacks.WithLabelValues(providerName, "NEAR_DUPLICATE").Inc()
+			continue
+		}
+		if err != nil {
 			// Handle provider error and check if we should continue
 			providerErr := provider.HandleError(err)
 			o.updateProviderStatus(providerName, providerErr)
 
-			log.Printf("[ADK] Provider %s error details - Quota: %t, Rate Limited: %t, Retryable: %t",
-				providerName, providerErr.IsQuotaHit, providerErr.IsRateLimit, providerErr.Retryable)
+			logger.WarnContext(ctx, "Provider failed", "provider", providerName, "error", err, "code", providerErr.Code,
+				"quota_hit", providerErr.IsQuotaHit, "rate_limited", providerErr.IsRateLimit, "retryable", providerErr.Retryable)
 
 			// If this was the last provider, return the error
 			if providerName == decision.FallbackOrder[len(decision.FallbackOrder)-1] {
-				log.Printf("[ADK] All providers exhausted, returning final error from %s", providerName)
+				logger.ErrorContext(ctx, "All providers exhausted", "last_provider", providerName)
+				metrics.Generations.WithLabelValues("none", metrics.OutcomeError).Inc()
 				return nil, fmt.Errorf("all providers failed, last error from %s: %w", providerName, err)
 			}
 
 			// Continue to next provider
-			log.Printf("[ADK] Falling back to next provider in list")
+			logger.InfoContext(ctx, "Falling back to next provider", "provider", providerName)
+			metrics.Fallbacks.WithLabelValues(providerName, providerErr.Code).Inc()
 			continue
 		}
 
+		if response.Pending {
+			// The outcome is recorded by CompletePending once the provider has finished the pair
+			logger.InfoContext(ctx, "Provider accepted generation, finishing it in the background", "provider", providerName)
+			metrics.Generations.WithLabelValues(providerName, metrics.OutcomePending).Inc()
+			return response, nil
+		}
+
 		// Success! Update provider status
-		log.Printf("[ADK] Provider %s succeeded, generated %d images", providerName, len(response.Images))
+		logger.InfoContext(ctx, "Provider succeeded", "provider", providerName, "images", len(response.Images))
 		o.updateProviderSuccessStatus(providerName)
+		metrics.Generations.WithLabelValues(providerName, metrics.OutcomeSuccess).Inc()
 		return response, nil
 	}
 
-	log.Printf("[ADK] No available providers found")
+	logger.ErrorContext(ctx, "No available providers found")
+	metrics.Generations.WithLabelValues("none", metrics.OutcomeError).Inc()
 	return nil, fmt.Errorf("no available providers")
 }
 
-// SelectProvider chooses the best provider using random selection with availability filtering
-func (o *ImageOrchestrator) SelectProvider(ctx context.Context, req *models.ImageRequest) (*models.AgentDecision, error) {
+// SetDuplicatePolicy configures near-duplicate detection
+// A pair whose left and right perceptual hashes are within threshold bits of each other is regenerated
+// up to retries times before falling back to the next provider; a threshold of 0 disables the check
+func (o *ImageOrchestrator) SetDuplicatePolicy(threshold, retries int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.duplicateThreshold = threshold
+	o.duplicateRetries = retries
+}
+
+// SetConcurrencyLimiter caps concurrent calls to each provider with slots
+// A generation waits at most queueWait in total for slots; a provider whose slot does not come free in time is
+// skipped like a failed one, and providers with a free slot are tried before busy ones
+func (o *ImageOrchestrator) SetConcurrencyLimiter(slots *concurrency.Slots, queueWait time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.slots = slots
+	o.queueWait = queueWait
+}
+
+// preferFreeProviders moves providers with a free slot ahead of busy ones, keeping the order within each group
+func preferFreeProviders(ctx context.Context, slots *concurrency.Slots, order []string) []string {
+	free := make([]string, 0, len(order))
+	var busy []string
+	for _, name := range order {
+		if slots.Free(ctx, name) {
+			free = append(free, name)
+		} else {
+			busy = append(busy, name)
+		}
+	}
+	return append(free, busy...)
+}
+
+// CompletePending records the outcome of a generation a provider accepted and then finished in the background,
+// as Execute does for generations that finish within the request
+// A near-duplicate pair cannot be regenerated at this point, so it is deleted and ErrNearDuplicate returned
+func (o *ImageOrchestrator) CompletePending(ctx context.Context, providerName string, response *models.ImageResponse, err error) (*models.ImageResponse, error) {
+	provider, exists := o.GetProvider(providerName)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, providerName)
+	}
+
+	if err != nil {
+		providerErr := provider.HandleError(err)
+		o.updateProviderStatus(providerName, providerErr)
+		logger.WarnContext(ctx, "Provider failed to finish generation", "provider", providerName, "error", err, "code", providerErr.Code)
+		metrics.Generations.WithLabelValues(providerName, metrics.OutcomeError).Inc()
+		return nil, err
+	}
+
 	o.mutex.RLock()
-	defer o.mutex.RUnlock()
+	threshold := o.duplicateThreshold
+	o.mutex.RUnlock()
+	if distance, ok := pairDistance(response); ok && threshold > 0 && distance <= threshold {
+		logger.WarnContext(ctx, "Dropping near-duplicate pair", "provider", providerName, "distance", distance, "threshold", threshold)
+		provider.RollbackImages(ctx, response.Images)
+		metrics.Generations.WithLabelValues(providerName, metrics.OutcomeNearDuplicate).Inc()
+		return nil, fmt.Errorf("%w: distance %d within threshold %d", ErrNearDuplicate, distance, threshold)
+	}
+
+	o.updateProviderSuccessStatus(providerName)
+	metrics.Generations.WithLabelValues(providerName, metrics.OutcomeSuccess).Inc()
+	return response, nil
+}
+
+// regenerateNearDuplicates asks the provider for a fresh pair while the current one is a near-duplicate
+// Each rejected pair is deleted from Spaces before asking again, so neither a failed regeneration nor giving up
+// leaves it behind
+func (o *ImageOrchestrator) regenerateNearDuplicates(ctx context.Context, provider ImageProvider, req *models.ImageRequest, response *models.ImageResponse) (*models.ImageResponse, error) {
+	o.mutex.RLock()
+	threshold, retries := o.duplicateThreshold, o.duplicateRetries
+	o.mutex.RUnlock()
+
+	for attempt := 0; ; attempt++ {
+		distance, ok := pairDistance(response)
+		if !ok || threshold <= 0 || distance > threshold {
+			return response, nil
+		}
+
+		logger.InfoContext(ctx, "Pair is a near-duplicate", "pair_id", req.PairID, "provider", provider.GetName(),
+			"distance", distance, "threshold", threshold, "attempt", attempt+1, "attempts", retries+1)
+
+		provider.RollbackImages(ctx, response.Images)
+		if attempt >= retries {
+			return nil, fmt.Errorf("%w: distance %d within threshold %d", ErrNearDuplicate, distance, threshold)
+		}
+
+		var err error
+		response, err = provider.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pairDistance returns the Hamming distance between the left and right image hashes
+// ok is false when the response does not carry two parseable hashes
+func pairDistance(response *models.ImageResponse) (int, bool) {
+	if response == nil || len(response.Images) < 2 {
+		return 0, false
+	}
+
+	left, err := imaging.ParseHash(response.Images[0].PHash)
+	if err != nil {
+		return 0, false
+	}
+	right, err := imaging.ParseHash(response.Images[1].PHash)
+	if err != nil {
+		return 0, false
+	}
+
+	return imaging.HammingDistance(left, right), true
+}
+
+// SelectProvider chooses the best provider using weighted random selection with availability filtering
+// Providers disabled by an operator are never selected
+func (o *ImageOrchestrator) SelectProvider(ctx context.Context, req *models.ImageRequest) (*models.AgentDecision, error) {
+	_, span := tracer.Start(ctx, "orchestrator.select_provider")
+
+	o.mutex.Lock() // The random source is not safe for concurrent use
+	defer o.mutex.Unlock()
 
 	// Get all available providers
 	availableProviders := make([]string, 0)
 	for name, provider := range o.providers {
-		if provider.IsAvailable() {
+		if provider.IsAvailable() && o.control(name).Enabled {
 			availableProviders = append(availableProviders, name)
 		}
 	}
 
 	if len(availableProviders) == 0 {
-		return nil, fmt.Errorf("no available providers")
+		err := fmt.Errorf("no available providers")
+		tracing.End(span, err)
+		return nil, err
 	}
 
-	// Shuffle for random selection - treat all providers equally until errors occur
-	o.random.Shuffle(len(availableProviders), func(i, j int) {
-		availableProviders[i], availableProviders[j] = availableProviders[j], availableProviders[i]
-	})
-
-	log.Printf("[INFO] Selecting providers: %s", strings.Join(availableProviders, ", "))
+	o.weightedShuffle(availableProviders)
+	span.SetAttributes(attribute.StringSlice("orchestrator.fallback_order", availableProviders))
+	span.End()
 
 	return &models.AgentDecision{
 		SelectedProvider: availableProviders[0],
-		Reasoning:        "Random selection from available providers",
+		Reasoning:        "Weighted random selection from available providers",
 		FallbackOrder:    availableProviders,
 		Confidence:       1.0,
 		Metadata: map[string]string{
-			"selection_method": "random",
+			"selection_method": "weighted_random",
 			"total_available":  fmt.Sprintf("%d", len(availableProviders)),
 		},
 	}, nil
@@ -135,22 +379,16 @@ func (o *ImageOrchestrator) SelectProvider(ctx context.Context, req *models.Imag
 
 // HandleProviderFailure manages fallback when a provider fails
 func (o *ImageOrchestrator) HandleProviderFailure(ctx context.Context, provider string, err *models.ProviderError, req *models.ImageRequest) (*models.AgentDecision, error) {
+	// Update provider status
+	o.updateProviderStatus(provider, err)
+
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
 
-	// Update provider status
-	if status, exists := o.status[provider]; exists {
-		status.Available = !err.IsQuotaHit && !err.IsRateLimit
-		status.LastError = err.Message
-		status.ErrorCount++
-		status.QuotaHit = err.IsQuotaHit
-		status.RateLimited = err.IsRateLimit
-	}
-
 	// Get remaining available providers
 	availableProviders := make([]string, 0)
 	for name, prov := range o.providers {
-		if name != provider && prov.IsAvailable() {
+		if name != provider && prov.IsAvailable() && o.control(name).Enabled {
 			availableProviders = append(availableProviders, name)
 		}
 	}
@@ -159,10 +397,7 @@ func (o *ImageOrchestrator) HandleProviderFailure(ctx context.Context, provider
 		return nil, fmt.Errorf("no fallback providers available")
 	}
 
-	// Shuffle remaining providers
-	o.random.Shuffle(len(availableProviders), func(i, j int) {
-		availableProviders[i], availableProviders[j] = availableProviders[j], availableProviders[i]
-	})
+	o.weightedShuffle(availableProviders)
 
 	return &models.AgentDecision{
 		SelectedProvider: availableProviders[0],
@@ -172,11 +407,119 @@ func (o *ImageOrchestrator) HandleProviderFailure(ctx context.Context, provider
 		Metadata: map[string]string{
 			"failed_provider":  provider,
 			"failure_reason":   err.Message,
-			"selection_method": "fallback_random",
+			"selection_method": "fallback_weighted_random",
 		},
 	}, nil
 }
 
+// SetStatusListener registers a callback invoked (outside the orchestrator lock) with a copy of a provider's
+// status whenever its availability, quota or rate-limit state changes
+func (o *ImageOrchestrator) SetStatusListener(listener func(status *models.ProviderStatus)) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.statusListener = listener
+}
+
+// SetProviderControls replaces the operator overrides; providers missing from controls are enabled with weight 1
+// A provider whose ResetAt moved forward has its error state cleared
+// The status listener is told about every provider that was enabled or disabled
+func (o *ImageOrchestrator) SetProviderControls(controls map[string]ProviderControl) {
+	o.mutex.Lock()
+	var toggled []models.ProviderStatus
+	var reset []string
+	for name, status := range o.status {
+		before := o.control(name)
+		after, ok := controls[name]
+		if !ok {
+			after = defaultProviderControl
+		}
+		if before.Enabled != after.Enabled {
+			snapshot := *status
+			snapshot.Disabled = !after.Enabled
+			toggled = append(toggled, snapshot)
+		}
+		if after.ResetAt.After(before.ResetAt) {
+			reset = append(reset, name)
+		}
+	}
+	o.controls = controls
+	listener := o.statusListener
+	o.mutex.Unlock()
+
+	for i := range toggled {
+		logger.Info("Provider toggled", "provider", toggled[i].Name, "disabled", toggled[i].Disabled)
+		if listener != nil {
+			listener(&toggled[i])
+		}
+	}
+	for _, name := range reset {
+		o.resetProvider(name)
+	}
+}
+
+// resetProvider clears a provider's error state, both its own and the orchestrator's view of it
+func (o *ImageOrchestrator) resetProvider(name string) {
+	provider, exists := o.GetProvider(name)
+	if !exists {
+		return
+	}
+
+	provider.ResetStatus()
+	available := provider.IsAvailable()
+	o.changeProviderStatus(name, func(status *models.ProviderStatus) {
+		status.Available = available
+		status.LastError = ""
+		status.ErrorCount = 0
+		status.QuotaHit = false
+		status.RateLimited = false
+	})
+	logger.Info("Provider reset", "provider", name, "available", available)
+}
+
+// TestProvider generates a pair with the named provider alone, even if an operator disabled it
+// The outcome is recorded in the provider's status like any other attempt; a provider that is out of quota or
+// rate limited refuses to generate until it is reset
+func (o *ImageOrchestrator) TestProvider(ctx context.Context, name string, req *models.ImageRequest) (response *models.ImageResponse, err error) {
+	provider, exists := o.GetProvider(name)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+
+	ctx, span := tracer.Start(ctx, "orchestrator.test", trace.WithAttributes(attribute.String("provider", name)))
+	defer func() { tracing.End(span, err) }()
+
+	o.mutex.RLock()
+	slots, queueWait := o.slots, o.queueWait
+	o.mutex.RUnlock()
+	if slots != nil {
+		release, err := slots.Acquire(ctx, name, time.Now().Add(queueWait))
+		if err != nil {
+			return nil, fmt.Errorf("waiting for %s: %w", name, err)
+		}
+		defer release()
+	}
+
+	// A test reports and deletes the images, so it never leaves the generation to finish in the background
+	req.Wait = true
+	logger.InfoContext(ctx, "Testing provider", "provider", name, "pair_id", req.PairID)
+	response, err = provider.Generate(ctx, req)
+	if err != nil && ctx.Err() != nil {
+		logger.InfoContext(ctx, "Provider test cancelled", "provider", name, "error", ctx.Err())
+		return nil, err
+	}
+	if err != nil {
+		providerErr := provider.HandleError(err)
+		o.updateProviderStatus(name, providerErr)
+		logger.WarnContext(ctx, "Provider test failed", "provider", name, "error", err, "code", providerErr.Code)
+		return nil, err
+	}
+
+	o.updateProviderSuccessStatus(name)
+	logger.InfoContext(ctx, "Provider test succeeded", "provider", name, "images", len(response.Images))
+	return response, nil
+}
+
 // RegisterProvider adds a new provider to the orchestrator
 func (o *ImageOrchestrator) RegisterProvider(provider ImageProvider) error {
 	o.mutex.Lock()
@@ -212,6 +555,7 @@ func (o *ImageOrchestrator) GetProviderStatus() map[string]*models.ProviderStatu
 			ErrorCount:  status.ErrorCount,
 			QuotaHit:    status.QuotaHit,
 			RateLimited: status.RateLimited,
+			Disabled:    !o.control(name).Enabled,
 		}
 	}
 
@@ -239,29 +583,24 @@ func (o *ImageOrchestrator) GetCapabilities() []string {
 		"automatic_fallback",
 		"quota_management",
 		"random_load_balancing",
+		"near_duplicate_detection",
 	}
 }
 
 // updateProviderStatus updates status after an error
 func (o *ImageOrchestrator) updateProviderStatus(providerName string, err *models.ProviderError) {
-	o.mutex.Lock()
-	defer o.mutex.Unlock()
-
-	if status, exists := o.status[providerName]; exists {
+	o.changeProviderStatus(providerName, func(status *models.ProviderStatus) {
 		status.Available = !err.IsQuotaHit && !err.IsRateLimit
 		status.LastError = err.Message
 		status.ErrorCount++
 		status.QuotaHit = err.IsQuotaHit
 		status.RateLimited = err.IsRateLimit
-	}
+	})
 }
 
 // updateProviderSuccessStatus updates status after a successful generation
 func (o *ImageOrchestrator) updateProviderSuccessStatus(providerName string) {
-	o.mutex.Lock()
-	defer o.mutex.Unlock()
-
-	if status, exists := o.status[providerName]; exists {
+	o.changeProviderStatus(providerName, func(status *models.ProviderStatus) {
 		status.Available = true
 		status.LastSuccess = time.Now()
 		status.LastError = ""
@@ -271,5 +610,58 @@ func (o *ImageOrchestrator) updateProviderSuccessStatus(providerName string) {
 		}
 		status.QuotaHit = false
 		status.RateLimited = false
+	})
+}
+
+// changeProviderStatus applies update to a provider's status and notifies the status listener if its
+// availability, quota or rate-limit state changed
+func (o *ImageOrchestrator) changeProviderStatus(providerName string, update func(status *models.ProviderStatus)) {
+	o.mutex.Lock()
+	status, exists := o.status[providerName]
+	if !exists {
+		o.mutex.Unlock()
+		return
+	}
+
+	before := *status
+	update(status)
+	changed := before.Available != status.Available || before.QuotaHit != status.QuotaHit ||
+		before.RateLimited != status.RateLimited
+	snapshot := *status
+	snapshot.Disabled = !o.control(providerName).Enabled
+	listener := o.statusListener
+	o.mutex.Unlock()
+
+	if changed && listener != nil {
+		listener(&snapshot)
 	}
 }
+
+// control returns the operator override for a provider; callers must hold the mutex
+func (o *ImageOrchestrator) control(name string) ProviderControl {
+	if control, ok := o.controls[name]; ok {
+		return control
+	}
+	return defaultProviderControl
+}
+
+// weightedShuffle orders providers so each is tried first with probability proportional to its weight
+// (weighted sampling without replacement); zero-weight providers go last in random order
+// Callers must hold the mutex
+func (o *ImageOrchestrator) weightedShuffle(providers []string) {
+	o.random.Shuffle(len(providers), func(i, j int) {
+		providers[i], providers[j] = providers[j], providers[i]
+	})
+
+	keys := make(map[string]float64, len(providers))
+	for _, name := range providers {
+		keys[name] = -1 // Zero weights sort after every positive weight
+		if weight := o.control(name).Weight; weight > 0 {
+			keys[name] = math.Pow(o.random.Float64(), 1/weight)
+		}
+	}
+
+	sort.SliceStable(providers, func(i, j int) bool {
+		return keys[providers[i]] > keys[providers[j]]
+	})
+}
@@ -0,0 +1,338 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/imaging"
+	"cgc-lb-and-cdn-backend/internal/models"
+)
+
+// fakeProvider returns one scripted pair per Generate call, or err if set, and records what it was asked to
+// roll back and how often it was reset
+type fakeProvider struct {
+	name       string
+	pairs      [][2]uint64 // Left and right perceptual hash of each generated pair
+	err        error
+	block      bool // Generate waits for the context to be cancelled
+	pending    bool // Generate leaves the pair to be finished in the background
+	generated  int
+	rolledBack int
+	resets     int
+}
+
+func (f *fakeProvider) Generate(ctx context.Context, req *models.ImageRequest) (*models.ImageResponse, error) {
+	if f.block {
+		f.generated++
+		<-ctx.Done()
+		return nil, fmt.Errorf("request failed: %w", ctx.Err())
+	}
+	if f.err != nil {
+		f.generated++
+		return nil, f.err
+	}
+	if f.pending {
+		f.generated++
+		return &models.ImageResponse{Provider: f.name, Success: true, Pending: true}, nil
+	}
+	pair := f.pairs[f.generated]
+	f.generated++
+	return &models.ImageResponse{
+		Provider: f.name,
+		Success:  true,
+		Images: []models.GeneratedImage{
+			{ID: req.PairID, PHash: imaging.FormatHash(pair[0])},
+			{ID: req.PairID, PHash: imaging.FormatHash(pair[1])},
+		},
+	}, nil
+}
+
+func (f *fakeProvider) GetStatus() *models.ProviderStatus {
+	return &models.ProviderStatus{Name: f.name, Available: true}
+}
+
+func (f *fakeProvider) GetName() string                                     { return f.name }
+func (f *fakeProvider) IsAvailable() bool                                   { return true }
+func (f *fakeProvider) RefreshQuota(ctx context.Context) error              { return nil }
+func (f *fakeProvider) ResetStatus()                                        { f.resets++ }
+func (f *fakeProvider) RecentErrors(limit int) []models.ProviderErrorRecord { return nil }
+
+func (f *fakeProvider) HandleError(err error) *models.ProviderError {
+	return &models.ProviderError{Provider: f.name, Code: "ERROR", Message: err.Error(), IsRateLimit: err == errRateLimited}
+}
+
+// errRateLimited makes fakeProvider report a rate limit, taking it out of rotation
+var errRateLimited = errors.New("429 too many requests")
+
+func (f *fakeProvider) RollbackImages(ctx context.Context, images []models.GeneratedImage) {
+	f.rolledBack++
+}
+
+func TestNearDuplicateRegeneration(t *testing.T) {
+	const (
+		duplicate = 0x0f // Four bits away from 0x00
+		distinct  = 0xf0f0f0f0f0f0f0f0
+	)
+
+	tests := []struct {
+		name           string
+		threshold      int
+		retries        int
+		pairs          [][2]uint64
+		wantErr        error
+		wantGenerated  int
+		wantRolledBack int
+	}{
+		{"distinct pair", 4, 2, [][2]uint64{{0x00, distinct}}, nil, 1, 0},
+		{"check disabled", 0, 2, [][2]uint64{{0x00, 0x00}}, nil, 1, 0},
+		{"regenerated once", 4, 2, [][2]uint64{{0x00, duplicate}, {0x00, distinct}}, nil, 2, 1},
+		{"just above threshold", 3, 0, [][2]uint64{{0x00, duplicate}}, nil, 1, 0},
+		{
+			"gives up after retries",
+			4, 1,
+			[][2]uint64{{0x00, duplicate}, {0x00, 0x00}},
+			ErrNearDuplicate, 2, 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{name: "fake", pairs: tt.pairs}
+			orchestrator := NewImageOrchestrator()
+			orchestrator.RegisterProvider(provider)
+			orchestrator.SetDuplicatePolicy(tt.threshold, tt.retries)
+
+			result, err := orchestrator.Execute(context.Background(), &models.ImageRequest{PairID: "pair", Prompt: "prompt"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Execute error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				response := result.(*models.ImageResponse)
+				if distance, _ := pairDistance(response); tt.threshold > 0 && distance <= tt.threshold {
+					t.Errorf("returned a near-duplicate pair (distance %d)", distance)
+				}
+			}
+			if provider.generated != tt.wantGenerated {
+				t.Errorf("generated %d pairs, want %d", provider.generated, tt.wantGenerated)
+			}
+			if provider.rolledBack != tt.wantRolledBack {
+				t.Errorf("rolled back %d pairs, want %d", provider.rolledBack, tt.wantRolledBack)
+			}
+		})
+	}
+}
+
+func TestPairDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		images []models.GeneratedImage
+		want   int
+		wantOK bool
+	}{
+		{"no images", nil, 0, false},
+		{"one image", []models.GeneratedImage{{PHash: imaging.FormatHash(0)}}, 0, false},
+		{"missing hash", []models.GeneratedImage{{PHash: imaging.FormatHash(0)}, {}}, 0, false},
+		{"identical", []models.GeneratedImage{{PHash: imaging.FormatHash(7)}, {PHash: imaging.FormatHash(7)}}, 0, true},
+		{"three bits apart", []models.GeneratedImage{{PHash: imaging.FormatHash(0)}, {PHash: imaging.FormatHash(7)}}, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pairDistance(&models.ImageResponse{Images: tt.images})
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("pairDistance() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetProviderControlsReset(t *testing.T) {
+	earlier := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		before     time.Time
+		after      time.Time
+		wantResets int
+	}{
+		{"first reset", time.Time{}, earlier, 1},
+		{"newer reset", earlier, later, 1},
+		{"unchanged", earlier, earlier, 0},
+		{"never reset", time.Time{}, time.Time{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{name: "fake", err: errRateLimited}
+			orchestrator := NewImageOrchestrator()
+			orchestrator.RegisterProvider(provider)
+			orchestrator.SetProviderControls(map[string]ProviderControl{"fake": {Enabled: true, Weight: 1, ResetAt: tt.before}})
+			provider.resets = 0
+
+			// Take the provider out of rotation, then apply settings that may ask for a reset
+			orchestrator.TestProvider(context.Background(), "fake", &models.ImageRequest{PairID: "pair"})
+			orchestrator.SetProviderControls(map[string]ProviderControl{"fake": {Enabled: true, Weight: 1, ResetAt: tt.after}})
+
+			if provider.resets != tt.wantResets {
+				t.Errorf("provider reset %d times, want %d", provider.resets, tt.wantResets)
+			}
+			status := orchestrator.GetProviderStatus()["fake"]
+			cleared := status.Available && !status.RateLimited && status.ErrorCount == 0 && status.LastError == ""
+			if cleared != (tt.wantResets > 0) {
+				t.Errorf("status = %+v, want cleared = %v", status, tt.wantResets > 0)
+			}
+		})
+	}
+}
+
+func TestTestProvider(t *testing.T) {
+	tests := []struct {
+		name           string
+		provider       string
+		err            error
+		disabled       bool
+		wantErr        error
+		wantErrorCount int
+	}{
+		{"success", "fake", nil, false, nil, 0},
+		{"disabled provider is still tested", "fake", nil, true, nil, 0},
+		{"failure is recorded", "fake", errRateLimited, false, errRateLimited, 1},
+		{"unknown provider", "other", nil, false, ErrUnknownProvider, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{name: "fake", pairs: [][2]uint64{{0x00, 0xff}}, err: tt.err}
+			orchestrator := NewImageOrchestrator()
+			orchestrator.RegisterProvider(provider)
+			orchestrator.SetProviderControls(map[string]ProviderControl{"fake": {Enabled: !tt.disabled, Weight: 1}})
+
+			response, err := orchestrator.TestProvider(context.Background(), tt.provider, &models.ImageRequest{PairID: "pair"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TestProvider error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(response.Images) != 2 {
+				t.Errorf("response has %d images, want 2", len(response.Images))
+			}
+			if got := orchestrator.GetProviderStatus()["fake"].ErrorCount; got != tt.wantErrorCount {
+				t.Errorf("error count = %d, want %d", got, tt.wantErrorCount)
+			}
+		})
+	}
+}
+
+func TestCancelledGeneration(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, orchestrator *ImageOrchestrator) error
+	}{
+		{"execute", func(ctx context.Context, orchestrator *ImageOrchestrator) error {
+			_, err := orchestrator.Execute(ctx, &models.ImageRequest{PairID: "pair", Prompt: "prompt"})
+			return err
+		}},
+		{"test", func(ctx context.Context, orchestrator *ImageOrchestrator) error {
+			_, err := orchestrator.TestProvider(ctx, "fake", &models.ImageRequest{PairID: "pair", Prompt: "prompt"})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := []*fakeProvider{{name: "fake", block: true}, {name: "other", block: true}}
+			orchestrator := NewImageOrchestrator()
+			for _, provider := range providers {
+				orchestrator.RegisterProvider(provider)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := tt.run(ctx, orchestrator); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("error = %v, want the context's", err)
+			}
+
+			// The caller giving up says nothing about the provider, and no other provider is tried
+			generated := 0
+			for _, provider := range providers {
+				generated += provider.generated
+				status := orchestrator.GetProviderStatus()[provider.name]
+				if !status.Available || status.ErrorCount != 0 || status.LastError != "" {
+					t.Errorf("%s status = %+v, want it untouched", provider.name, status)
+				}
+			}
+			if generated != 1 {
+				t.Errorf("generated %d times, want 1", generated)
+			}
+		})
+	}
+}
+
+func TestPendingGeneration(t *testing.T) {
+	provider := &fakeProvider{name: "fake", pending: true}
+	orchestrator := NewImageOrchestrator()
+	orchestrator.RegisterProvider(provider)
+	orchestrator.SetDuplicatePolicy(4, 2)
+
+	// A pending pair has no images to compare yet, so it is neither checked nor regenerated
+	result, err := orchestrator.Execute(context.Background(), &models.ImageRequest{PairID: "pair", Prompt: "prompt"})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !result.(*models.ImageResponse).Pending || provider.generated != 1 || provider.rolledBack != 0 {
+		t.Errorf("response %+v after %d generations and %d rollbacks, want one pending pair", result, provider.generated, provider.rolledBack)
+	}
+}
+
+func TestCompletePending(t *testing.T) {
+	const distinct = 0xf0f0f0f0f0f0f0f0
+
+	tests := []struct {
+		name           string
+		provider       string
+		hashes         [2]uint64
+		err            error
+		wantErr        error
+		wantRolledBack int
+		wantErrorCount int
+	}{
+		{"finished", "fake", [2]uint64{0x00, distinct}, nil, nil, 0, 0},
+		{"near-duplicate", "fake", [2]uint64{0x00, 0x0f}, nil, ErrNearDuplicate, 1, 0},
+		{"failed", "fake", [2]uint64{}, errRateLimited, errRateLimited, 0, 1},
+		{"unknown provider", "other", [2]uint64{0x00, distinct}, nil, ErrUnknownProvider, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{name: "fake"}
+			orchestrator := NewImageOrchestrator()
+			orchestrator.RegisterProvider(provider)
+			orchestrator.SetDuplicatePolicy(4, 2)
+
+			var response *models.ImageResponse
+			if tt.err == nil {
+				response = &models.ImageResponse{Provider: tt.provider, Success: true, Images: []models.GeneratedImage{
+					{PHash: imaging.FormatHash(tt.hashes[0])},
+					{PHash: imaging.FormatHash(tt.hashes[1])},
+				}}
+			}
+
+			got, err := orchestrator.CompletePending(context.Background(), tt.provider, response, tt.err)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompletePending error = %v, want %v", err, tt.wantErr)
+			}
+			if (got != nil) != (tt.wantErr == nil) {
+				t.Errorf("CompletePending returned %+v, want a pair only on success", got)
+			}
+			// Deleted rather than regenerated, since the request that asked for it is long gone
+			if provider.rolledBack != tt.wantRolledBack || provider.generated != 0 {
+				t.Errorf("rolled back %d pairs and generated %d, want %d and none", provider.rolledBack, provider.generated, tt.wantRolledBack)
+			}
+			if count := orchestrator.GetProviderStatus()["fake"].ErrorCount; count != tt.wantErrorCount {
+				t.Errorf("error count = %d, want %d", count, tt.wantErrorCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Package auth issues and verifies scoped API keys
+// Keys are random secrets handed out once; Valkey only stores their SHA-256 hash alongside the key's scopes
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// Scopes an API key can grant
+const (
+	ScopeGenerate = "generate" // Generate image pairs (spends provider credits)
+	ScopeVote     = "vote"     // Submit votes and take part in battle rooms
+	ScopeRead     = "read"     // View pairs, statistics, winners and live events
+	ScopeAdmin    = "admin"    // Operator endpoints; implies every other scope
+)
+
+// AllScopes lists every valid scope
+var AllScopes = []string{ScopeGenerate, ScopeVote, ScopeRead, ScopeAdmin}
+
+// keyPrefix marks API key secrets so they are recognisable in configs and secret scanners
+const keyPrefix = "cgc_"
+
+var (
+	// ErrInvalidKey is returned for keys that were never issued or have been revoked
+	ErrInvalidKey = errors.New("invalid API key")
+
+	// ErrKeyStoreUnavailable is returned when issued keys cannot be checked because Valkey is unavailable
+	ErrKeyStoreUnavailable = errors.New("API key store unavailable")
+)
+
+// Principal is the caller a request was authenticated as
+type Principal struct {
+	KeyID     string   `json:"key_id,omitempty"` // Empty for anonymous callers and the bootstrap key
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Anonymous bool     `json:"anonymous"`
+}
+
+// HasScope reports whether the principal may use endpoints requiring scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves bearer tokens to principals
+type Authenticator struct {
+	valkey          *storage.ValkeyClient
+	bootstrapKey    string
+	anonymousScopes []string
+}
+
+// NewAuthenticator creates an authenticator
+// bootstrapKey (ADMIN_API_KEY) is accepted as an admin key without being stored, so the first keys can be issued;
+// callers without a key are granted anonymousScopes
+func NewAuthenticator(valkey *storage.ValkeyClient, bootstrapKey string, anonymousScopes []string) *Authenticator {
+	return &Authenticator{
+		valkey:          valkey,
+		bootstrapKey:    bootstrapKey,
+		anonymousScopes: anonymousScopes,
+	}
+}
+
+// Anonymous returns the principal used for requests without a key
+func (a *Authenticator) Anonymous() *Principal {
+	return &Principal{Name: "anonymous", Scopes: a.anonymousScopes, Anonymous: true}
+}
+
+// Authenticate resolves an API key secret to the principal it was issued to
+func (a *Authenticator) Authenticate(ctx context.Context, secret string) (*Principal, error) {
+	if a.bootstrapKey != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(a.bootstrapKey)) == 1 {
+		return &Principal{Name: "bootstrap", Scopes: []string{ScopeAdmin}}, nil
+	}
+
+	if !strings.HasPrefix(secret, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+	if a.valkey == nil {
+		return nil, ErrKeyStoreUnavailable
+	}
+
+	key, err := a.valkey.GetAPIKeyByHash(ctx, hashSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrInvalidKey
+	}
+
+	return &Principal{KeyID: key.ID, Name: key.Name, Scopes: key.Scopes}, nil
+}
+
+// IssueKey creates a key with the given scopes and returns it together with its secret
+// The secret is only available here; it cannot be recovered later
+func IssueKey(ctx context.Context, valkey *storage.ValkeyClient, name string, scopes []string) (*storage.APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", fmt.Errorf("name is required")
+	}
+	scopes, err := ParseScopes(strings.Join(scopes, ","))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := keyPrefix + hex.EncodeToString(random)
+
+	key := &storage.APIKey{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(name),
+		Prefix:    secret[:len(keyPrefix)+8],
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := valkey.StoreAPIKey(ctx, hashSecret(secret), key); err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+// RotateKey issues a key like IssueKey, then revokes every other key with the same name
+// The new key is stored before the old ones are revoked, so the name always has a working key
+func RotateKey(ctx context.Context, valkey *storage.ValkeyClient, name string, scopes []string) (*storage.APIKey, string, int, error) {
+	key, secret, err := IssueKey(ctx, valkey, name, scopes)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	revoked, err := valkey.DeleteAPIKeysByName(ctx, key.Name, key.ID)
+	if err != nil {
+		return key, secret, 0, err
+	}
+
+	return key, secret, revoked, nil
+}
+
+// ParseScopes parses a comma-separated scope list, rejecting unknown scopes and dropping duplicates
+func ParseScopes(list string) ([]string, error) {
+	scopes := []string{}
+	seen := make(map[string]bool)
+
+	for _, scope := range strings.Split(list, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		if !validScope(scope) {
+			return nil, fmt.Errorf("unknown scope %q (valid scopes: %s)", scope, strings.Join(AllScopes, ", "))
+		}
+		seen[scope] = true
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
+
+// validScope reports whether scope is one of AllScopes
+func validScope(scope string) bool {
+	for _, valid := range AllScopes {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// hashSecret is how a secret is stored; keys are 256-bit random values, so an unsalted SHA-256 is sufficient
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted []string
+		scope   string
+		want    bool
+	}{
+		{[]string{ScopeRead, ScopeVote}, ScopeVote, true},
+		{[]string{ScopeRead, ScopeVote}, ScopeGenerate, false},
+		{[]string{ScopeGenerate}, ScopeAdmin, false},
+		{[]string{ScopeAdmin}, ScopeGenerate, true},
+		{[]string{ScopeAdmin}, ScopeAdmin, true},
+		{nil, ScopeRead, false},
+	}
+
+	for _, tt := range tests {
+		principal := &Principal{Scopes: tt.granted}
+		if got := principal.HasScope(tt.scope); got != tt.want {
+			t.Errorf("%v.HasScope(%q) = %v, want %v", tt.granted, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"read,vote", []string{ScopeRead, ScopeVote}, false},
+		{" generate , generate,", []string{ScopeGenerate}, false},
+		{"", []string{}, false},
+		{"read,root", nil, true},
+		{"READ", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseScopes(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseScopes(%q) error = %v, want error %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseScopes(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	authenticator := NewAuthenticator(nil, "bootstrap-secret", []string{ScopeRead})
+
+	principal, err := authenticator.Authenticate(ctx, "bootstrap-secret")
+	if err != nil {
+		t.Fatalf("Authenticate(bootstrap): %v", err)
+	}
+	if principal.Name != "bootstrap" || !principal.HasScope(ScopeGenerate) {
+		t.Errorf("bootstrap key authenticated as %+v", principal)
+	}
+
+	if _, err := authenticator.Authenticate(ctx, "not-a-key"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Authenticate(unprefixed) error = %v, want ErrInvalidKey", err)
+	}
+	if _, err := authenticator.Authenticate(ctx, keyPrefix+"abcd"); !errors.Is(err, ErrKeyStoreUnavailable) {
+		t.Errorf("Authenticate without a key store error = %v, want ErrKeyStoreUnavailable", err)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authenticator := NewAuthenticator(nil, "bootstrap-secret", []string{ScopeRead, ScopeVote})
+
+	tests := []struct {
+		name          string
+		scope         string
+		authorization string
+		wantStatus    int
+		wantPrincipal string
+	}{
+		{"anonymous with scope", ScopeVote, "", http.StatusOK, "anonymous"},
+		{"anonymous without scope", ScopeGenerate, "", http.StatusUnauthorized, ""},
+		{"bootstrap key", ScopeAdmin, "Bearer bootstrap-secret", http.StatusOK, "bootstrap"},
+		{"invalid key on an anonymous route", ScopeRead, "Bearer wrong", http.StatusUnauthorized, ""},
+		{"not a bearer token", ScopeRead, "Basic Ym9vdHN0cmFw", http.StatusUnauthorized, ""},
+		{"empty bearer token", ScopeRead, "Bearer ", http.StatusUnauthorized, ""},
+		{"key store unavailable", ScopeRead, "Bearer " + keyPrefix + "abcd", http.StatusServiceUnavailable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			router := gin.New()
+			router.GET("/", authenticator.RequireScope(tt.scope), func(c *gin.Context) {
+				principal = PrincipalFromContext(c)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantPrincipal == "" {
+				if principal != nil {
+					t.Errorf("handler ran as %+v", principal)
+				}
+				return
+			}
+			if principal == nil || principal.Name != tt.wantPrincipal {
+				t.Errorf("principal = %+v, want %s", principal, tt.wantPrincipal)
+			}
+		})
+	}
+}
+
+func TestRequireScopeChallengesAnonymousCallers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", NewAuthenticator(nil, "", []string{ScopeRead}).RequireScope(ScopeGenerate), func(c *gin.Context) {})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := recorder.Header().Get("WWW-Authenticate"); got == "" {
+		t.Error("anonymous 401 has no WWW-Authenticate header")
+	}
+}
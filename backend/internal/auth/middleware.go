@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logger reports API key lookups that failed
+var logger = logging.Logger("auth")
+
+// principalContextKey is the gin context key the authenticated principal is stored under
+const principalContextKey = "auth.principal"
+
+// RequireScope rejects requests whose caller lacks scope
+// Callers authenticate with "Authorization: Bearer <key>"; requests without a key are treated as anonymous
+// A key that is present but invalid is always rejected, even on routes anonymous callers may use
+func (a *Authenticator) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := a.principal(c)
+		if err != nil {
+			if errors.Is(err, ErrInvalidKey) {
+				utils.RespondWithError(c, http.StatusUnauthorized, "Invalid API key", "UNAUTHORIZED", nil)
+			} else {
+				logger.ErrorContext(c.Request.Context(), "Failed to check API key", "error", err)
+				utils.RespondWithError(c, http.StatusServiceUnavailable, "Unable to check API key", "AUTH_UNAVAILABLE", nil)
+			}
+			c.Abort()
+			return
+		}
+
+		if !principal.HasScope(scope) {
+			if principal.Anonymous {
+				c.Header("WWW-Authenticate", `Bearer realm="api"`)
+				utils.RespondWithError(c, http.StatusUnauthorized, "An API key is required", "UNAUTHORIZED", map[string]string{
+					"required_scope": scope,
+				})
+			} else {
+				utils.RespondWithError(c, http.StatusForbidden, "API key lacks the required scope", "FORBIDDEN", map[string]string{
+					"required_scope": scope,
+				})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// PrincipalFromContext returns the caller authenticated by RequireScope, or nil on routes without it
+func PrincipalFromContext(c *gin.Context) *Principal {
+	if value, exists := c.Get(principalContextKey); exists {
+		if principal, ok := value.(*Principal); ok {
+			return principal
+		}
+	}
+	return nil
+}
+
+// principal authenticates the request's bearer token, falling back to the anonymous principal
+func (a *Authenticator) principal(c *gin.Context) (*Principal, error) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return a.Anonymous(), nil
+	}
+
+	secret, found := strings.CutPrefix(header, "Bearer ")
+	if !found || secret == "" {
+		return nil, ErrInvalidKey
+	}
+
+	return a.Authenticate(c.Request.Context(), secret)
+}
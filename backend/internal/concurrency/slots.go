@@ -0,0 +1,157 @@
+// Package concurrency caps how many calls each image provider handles at once, with slots and a first-come,
+// first-served queue held in Valkey so the cap holds across every droplet behind the load balancer
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/metrics"
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// logger reports slot operations that could not be made
+var logger = logging.Logger("concurrency")
+
+// ErrWaitTimeout is returned when no slot came free before the deadline
+var ErrWaitTimeout = errors.New("timed out waiting for a provider slot")
+
+const (
+	// pollInterval is how often a queued caller asks again whether its turn has come (plus up to 50% jitter)
+	pollInterval = 250 * time.Millisecond
+
+	// waiterTimeout drops a queued caller that stopped polling, so a crashed droplet does not block the queue
+	waiterTimeout = 5 * time.Second
+
+	// releaseTimeout bounds freeing a slot, which happens even after the caller's context was cancelled
+	releaseTimeout = 2 * time.Second
+)
+
+// Slots hands out provider concurrency slots
+// The limit is looked up on every call, so it follows the provider's quota as it is refreshed; a limit of 0 means
+// unlimited. Without Valkey, or when Valkey fails, calls go ahead unlimited rather than failing
+type Slots struct {
+	valkey *storage.ValkeyClient
+	limit  func(provider string) int
+	lease  time.Duration
+}
+
+// NewSlots creates a slot limiter; a held slot is renewed every lease/3 and expires after lease if its holder dies
+func NewSlots(valkey *storage.ValkeyClient, limit func(provider string) int, lease time.Duration) *Slots {
+	return &Slots{
+		valkey: valkey,
+		limit:  limit,
+		lease:  lease,
+	}
+}
+
+// Acquire waits in line for one of the provider's slots until the deadline, returning ErrWaitTimeout if none came
+// free in time; it always asks at least once, even with the deadline already past
+// The returned release function must be called once the provider call is done
+func (s *Slots) Acquire(ctx context.Context, provider string, deadline time.Time) (func(), error) {
+	limit := s.limit(provider)
+	if s.valkey == nil || limit <= 0 {
+		return func() {}, nil
+	}
+
+	holder := uuid.New().String()
+	start := time.Now()
+	for {
+		acquired, ahead, err := s.valkey.AcquireProviderSlot(ctx, provider, holder, limit, s.lease, waiterTimeout)
+		if err != nil {
+			s.release(provider, holder)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.WarnContext(ctx, "Calling provider without a slot after slot check failed", "provider", provider, "error", err)
+			return func() {}, nil
+		}
+		if acquired {
+			metrics.SlotWait.WithLabelValues(provider, metrics.OutcomeSuccess).Observe(time.Since(start).Seconds())
+			return s.hold(provider, holder), nil
+		}
+
+		wait := pollInterval + time.Duration(rand.Int63n(int64(pollInterval/2)))
+		if time.Now().Add(wait).After(deadline) {
+			s.release(provider, holder)
+			metrics.SlotWait.WithLabelValues(provider, metrics.OutcomeTimeout).Observe(time.Since(start).Seconds())
+			logger.DebugContext(ctx, "Gave up waiting for provider slot", "provider", provider, "ahead", ahead, "limit", limit)
+			return nil, ErrWaitTimeout
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.release(provider, holder)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Free reports whether the provider has a slot nobody is queued for, i.e. whether Acquire would not have to wait
+func (s *Slots) Free(ctx context.Context, provider string) bool {
+	limit := s.limit(provider)
+	if s.valkey == nil || limit <= 0 {
+		return true
+	}
+
+	inUse, queued, err := s.valkey.ProviderSlotUsage(ctx, provider)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to read provider slot usage", "provider", provider, "error", err)
+		return true
+	}
+	return inUse+queued < int64(limit)
+}
+
+// hold keeps renewing the holder's lease until the returned release function is called
+func (s *Slots) hold(provider, holder string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+				renewed, err := s.valkey.RenewProviderSlot(ctx, provider, holder, s.lease)
+				cancel()
+				switch {
+				case err != nil:
+					logger.Warn("Failed to renew provider slot", "provider", provider, "error", err)
+				case !renewed:
+					logger.Warn("Provider slot lease expired before the call finished", "provider", provider)
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			s.release(provider, holder)
+		})
+	}
+}
+
+// release frees the holder's slot or place in the queue
+func (s *Slots) release(provider, holder string) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	if err := s.valkey.ReleaseProviderSlot(ctx, provider, holder); err != nil {
+		// The lease or waiter timeout frees it eventually
+		logger.Warn("Failed to release provider slot", "provider", provider, "error", err)
+	}
+}
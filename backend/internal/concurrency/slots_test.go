@@ -0,0 +1,42 @@
+package concurrency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// The slot scripts themselves are tested against an in-memory Valkey in the storage package
+func TestSlotsUnlimited(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+	}{
+		{"no limit", 0},
+		{"negative limit", -1},
+		{"limit without Valkey", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var asked []string
+			slots := NewSlots(nil, func(provider string) int {
+				asked = append(asked, provider)
+				return tt.limit
+			}, time.Minute)
+
+			// Even a deadline already past does not hold an unlimited call back
+			release, err := slots.Acquire(context.Background(), "openai", time.Now().Add(-time.Second))
+			if err != nil {
+				t.Fatalf("Acquire: %v", err)
+			}
+			release()
+			if !slots.Free(context.Background(), "openai") {
+				t.Error("Free = false, want true")
+			}
+			if len(asked) != 2 || asked[0] != "openai" {
+				t.Errorf("limit looked up for %v, want openai on every call", asked)
+			}
+		})
+	}
+}
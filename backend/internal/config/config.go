@@ -1,43 +1,645 @@
+// Package config loads the server configuration from defaults, an optional YAML or TOML file and environment
+// variables, in that order of precedence, and validates it before anything is started
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
 )
 
+// FileEnv names the environment variable holding the config file path when none is passed explicitly
+const FileEnv = "CONFIG_FILE"
+
 // Config holds the application configuration
 type Config struct {
-	Server ServerConfig `json:"server"`
-	Images ImagesConfig `json:"images"`
+	Server    ServerConfig    `json:"server" yaml:"server"`
+	Images    ImagesConfig    `json:"images" yaml:"images"`
+	Valkey    ValkeyConfig    `json:"valkey" yaml:"valkey"`
+	Spaces    SpacesConfig    `json:"spaces" yaml:"spaces"`
+	Providers ProvidersConfig `json:"providers" yaml:"providers"`
+	Admin     AdminConfig     `json:"admin" yaml:"admin"`
+	Auth      AuthConfig      `json:"auth" yaml:"auth"`
+
+	RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+	Fraud     FraudConfig     `json:"fraud" yaml:"fraud"`
+
+	Retention RetentionConfig `json:"retention" yaml:"retention"`
+	VoteLog   VoteLogConfig   `json:"vote_log" yaml:"vote_log"`
+
+	Runtime RuntimeConfig `json:"runtime" yaml:"runtime"`
+	Tracing TracingConfig `json:"tracing" yaml:"tracing"`
+	Logging LoggingConfig `json:"logging" yaml:"logging"`
 }
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
-	Port string `json:"port"`
-	Host string `json:"host"`
+	Port string `json:"port" yaml:"port"`
+	Host string `json:"host" yaml:"host"`
+
+	// TrustedProxies are the proxies (nginx, the load balancer) whose X-Forwarded-For entries are believed
+	// when working out the client IP
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+
+	// On SIGTERM the server fails its readiness check for DrainDelay so the load balancer stops sending traffic,
+	// then waits up to ShutdownTimeout for in-flight requests such as generations to finish
+	DrainDelay      time.Duration `json:"drain_delay" yaml:"drain_delay"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
 }
 
 // ImagesConfig holds image-related configuration
 type ImagesConfig struct {
-	Directory string `json:"directory"`
+	Directory string `json:"directory" yaml:"directory"`
+
+	// DuplicateThreshold is the maximum Hamming distance between the left and right perceptual hashes
+	// at which a pair is considered a near-duplicate (0 disables the check)
+	DuplicateThreshold int `json:"duplicate_threshold" yaml:"duplicate_threshold"`
+
+	// DuplicateRetries is how many times a provider is asked to regenerate a near-duplicate pair
+	// before the orchestrator falls back to the next provider
+	DuplicateRetries int `json:"duplicate_retries" yaml:"duplicate_retries"`
+}
+
+// ValkeyConfig holds the Valkey (managed Redis) connection; leaving Host and Port empty runs without it
+type ValkeyConfig struct {
+	Host     string `json:"host" yaml:"host"`
+	Port     string `json:"port" yaml:"port"`
+	Password string `json:"-" yaml:"password"`
+}
+
+// Configured reports whether a Valkey connection is configured
+func (v ValkeyConfig) Configured() bool {
+	return v.Host != "" || v.Port != ""
+}
+
+// Addr returns the host:port to connect to
+func (v ValkeyConfig) Addr() string {
+	return v.Host + ":" + v.Port
+}
+
+// SpacesConfig holds DigitalOcean Spaces credentials; leaving the endpoint and keys empty runs without it
+type SpacesConfig struct {
+	Bucket    string `json:"bucket" yaml:"bucket"`
+	Endpoint  string `json:"endpoint" yaml:"endpoint"` // e.g. nyc3.digitaloceanspaces.com
+	AccessKey string `json:"-" yaml:"access_key"`
+	SecretKey string `json:"-" yaml:"secret_key"`
+
+	// Timeout bounds each request to Spaces, such as a single part of a multipart upload
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// Configured reports whether Spaces credentials are configured
+func (s SpacesConfig) Configured() bool {
+	return s.Endpoint != "" || s.AccessKey != "" || s.SecretKey != ""
+}
+
+// ProvidersConfig holds per-provider settings; a provider without an API key is registered as unavailable
+type ProvidersConfig struct {
+	Google   GoogleConfig   `json:"google" yaml:"google"`
+	Leonardo LeonardoConfig `json:"leonardo" yaml:"leonardo"`
+	Freepik  FreepikConfig  `json:"freepik" yaml:"freepik"`
+
+	// QueueWait is how long a generation waits in line for a provider's concurrency slot before falling back
+	// to the next provider
+	QueueWait time.Duration `json:"queue_wait" yaml:"queue_wait"`
+
+	// SlotLease is how long a slot stays held without renewal, so slots held by a crashed droplet come back
+	SlotLease time.Duration `json:"slot_lease" yaml:"slot_lease"`
+}
+
+// GoogleConfig holds Google Imagen settings
+type GoogleConfig struct {
+	APIKey         string `json:"-" yaml:"api_key"`
+	Model          string `json:"model" yaml:"model"`
+	MaxConcurrency int    `json:"max_concurrency" yaml:"max_concurrency"` // Across all droplets; 0 is unlimited
+
+	// Timeout bounds each call to the Imagen API
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// LeonardoConfig holds Leonardo AI settings
+type LeonardoConfig struct {
+	APIKey  string `json:"-" yaml:"api_key"`
+	BaseURL string `json:"base_url" yaml:"base_url"`
+	ModelID string `json:"model_id" yaml:"model_id"`
+
+	// MaxConcurrency caps calls across all droplets; 0 uses the concurrency slots reported by the quota API
+	MaxConcurrency int `json:"max_concurrency" yaml:"max_concurrency"`
+
+	// Timeout bounds each HTTP call to Leonardo: starting a generation, each status poll and each image download
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+
+	// WebhookSecret is the webhook callback API key set for the API key in Leonardo's dashboard; Leonardo sends it
+	// as a bearer token with each callback. Setting it makes generations wait for callbacks instead of polling
+	WebhookSecret string `json:"-" yaml:"webhook_secret"`
+}
+
+// FreepikConfig holds Freepik settings
+type FreepikConfig struct {
+	APIKey         string `json:"-" yaml:"api_key"`
+	BaseURL        string `json:"base_url" yaml:"base_url"`
+	MaxConcurrency int    `json:"max_concurrency" yaml:"max_concurrency"` // Across all droplets; 0 is unlimited
+
+	// Timeout bounds each call to Freepik, which returns the images in the response
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// RateLimitConfig holds per-route rate limits written as "<limit>/<window>" (e.g. "60/1m"; "0" disables)
+type RateLimitConfig struct {
+	Read       string `json:"read" yaml:"read"`               // Pairs, statistics, winners, events
+	Vote       string `json:"vote" yaml:"vote"`               // POST /images/rate
+	Generate   string `json:"generate" yaml:"generate"`       // POST /generate
+	CreateRoom string `json:"create_room" yaml:"create_room"` // POST /rooms
+}
+
+// FraudConfig holds vote fraud and bot detection configuration
+type FraudConfig struct {
+	Mode              string        `json:"mode" yaml:"mode"`                                 // off or quarantine
+	MaxVotesPerMinute int64         `json:"max_votes_per_minute" yaml:"max_votes_per_minute"` // Per session; IPs get five times as many
+	MinVoteLatency    time.Duration `json:"min_vote_latency" yaml:"min_vote_latency"`         // Votes sooner than this after the pair was served are suspicious
+	SameSideMinVotes  int64         `json:"same_side_min_votes" yaml:"same_side_min_votes"`   // Votes before same-side voting is judged
+	SameSidePercent   int64         `json:"same_side_percent" yaml:"same_side_percent"`       // Share of votes on one side that is suspicious
+	MaxSessionsPerIP  int64         `json:"max_sessions_per_ip" yaml:"max_sessions_per_ip"`   // Distinct sessions per IP per hour
+	FlagTTL           time.Duration `json:"flag_ttl" yaml:"flag_ttl"`                         // How long a suspicious session or IP stays flagged
+}
+
+// RetentionConfig holds image pair lifecycle configuration
+// A zero limit disables that retirement rule
+type RetentionConfig struct {
+	MaxVotes      int64         `json:"max_votes" yaml:"max_votes"`           // Retire a pair from rotation after this many votes
+	MaxAge        time.Duration `json:"max_age" yaml:"max_age"`               // Retire a pair from rotation after this long
+	SweepInterval time.Duration `json:"sweep_interval" yaml:"sweep_interval"` // How often retirement rules are applied
+}
+
+// VoteLogConfig holds vote log archiving configuration
+type VoteLogConfig struct {
+	HotWindow       time.Duration `json:"hot_window" yaml:"hot_window"`             // Votes newer than this stay in Valkey
+	CompactInterval time.Duration `json:"compact_interval" yaml:"compact_interval"` // How often older days are archived to Spaces (0 disables)
 }
 
-// Load loads configuration from environment variables
-func Load() *Config {
+// RuntimeConfig holds how runtime settings (changed through the admin API) are watched
+type RuntimeConfig struct {
+	// PollInterval is how often settings are re-read in case a pub/sub announcement was missed
+	PollInterval time.Duration `json:"poll_interval" yaml:"poll_interval"`
+}
+
+// TracingConfig holds OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Exporter     string  `json:"exporter" yaml:"exporter"`           // none, stdout or otlp
+	OTLPEndpoint string  `json:"otlp_endpoint" yaml:"otlp_endpoint"` // OTLP/HTTP collector URL, e.g. http://collector:4318
+	ServiceName  string  `json:"service_name" yaml:"service_name"`
+	SampleRatio  float64 `json:"sample_ratio" yaml:"sample_ratio"` // Share of new traces recorded, from 0 to 1
+}
+
+// LoggingConfig holds structured logging configuration
+type LoggingConfig struct {
+	Level string `json:"level" yaml:"level"` // debug, info, warn or error
+}
+
+// AdminConfig holds admin API configuration
+type AdminConfig struct {
+	// APIKey is a bootstrap key with the admin scope, used to issue the first API keys (optional)
+	APIKey string `json:"-" yaml:"api_key"`
+}
+
+// AuthConfig holds API key authentication configuration
+type AuthConfig struct {
+	// AnonymousScopes are granted to requests without an API key
+	AnonymousScopes []string `json:"anonymous_scopes" yaml:"anonymous_scopes"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnvOrDefault("PORT", "8080"),
-			Host: getEnvOrDefault("HOST", "0.0.0.0"),
+			Port: "8080",
+			Host: "0.0.0.0",
+			TrustedProxies: []string{
+				"127.0.0.1", "::1", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+			},
+			DrainDelay:      35 * time.Second,
+			ShutdownTimeout: 120 * time.Second,
 		},
 		Images: ImagesConfig{
-			Directory: getEnvOrDefault("IMAGES_DIR", "images"),
+			Directory:          "images",
+			DuplicateThreshold: 5,
+			DuplicateRetries:   1,
+		},
+		Spaces: SpacesConfig{
+			Bucket:  "cgc-lb-and-cdn-content",
+			Timeout: 60 * time.Second,
+		},
+		Providers: ProvidersConfig{
+			Google: GoogleConfig{
+				Model:          "imagen-3.0-generate-002",
+				MaxConcurrency: 4,
+				Timeout:        60 * time.Second,
+			},
+			Leonardo: LeonardoConfig{
+				BaseURL: "https://cloud.leonardo.ai/api/rest/v1",
+				ModelID: "6bef9f1b-29cb-40c7-b9df-32b51c1f67d3", // Leonardo Creative model
+				Timeout: 60 * time.Second,
+			},
+			Freepik: FreepikConfig{
+				BaseURL:        "https://api.freepik.com",
+				MaxConcurrency: 4,
+				Timeout:        60 * time.Second,
+			},
+			QueueWait: 20 * time.Second,
+			SlotLease: time.Minute,
+		},
+		Auth: AuthConfig{
+			AnonymousScopes: []string{"read", "vote"},
+		},
+		RateLimit: RateLimitConfig{
+			Read:       "600/1m",
+			Vote:       "60/1m",
+			Generate:   "10/1h",
+			CreateRoom: "10/1h",
+		},
+		Fraud: FraudConfig{
+			Mode:              "quarantine",
+			MaxVotesPerMinute: 20,
+			MinVoteLatency:    700 * time.Millisecond,
+			SameSideMinVotes:  20,
+			SameSidePercent:   95,
+			MaxSessionsPerIP:  25,
+			FlagTTL:           24 * time.Hour,
+		},
+		Retention: RetentionConfig{
+			SweepInterval: time.Hour,
+		},
+		VoteLog: VoteLogConfig{
+			HotWindow:       7 * 24 * time.Hour,
+			CompactInterval: time.Hour,
+		},
+		Runtime: RuntimeConfig{
+			PollInterval: 15 * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "cgc-lb-and-cdn-backend",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Level: "info",
 		},
 	}
 }
 
-// getEnvOrDefault gets an environment variable or returns a default value
-func getEnvOrDefault(key, defaultValue string) string {
+// Load builds the configuration from defaults, the YAML (.yaml, .yml) or TOML (.toml) file at path,
+// and environment variables, each overriding the last, then validates it
+// An empty path falls back to $CONFIG_FILE; with neither, only defaults and environment variables are used
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile overlays the settings in a config file; keys it does not mention keep their current values
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		// TOML has no duration type, so decode it generically and re-encode it as YAML,
+		// which shares the yaml struct tags and parses "1h"-style durations
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to convert config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true) // A misspelt key is an error rather than a silently ignored setting
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv overlays the settings given as environment variables
+func (c *Config) loadEnv() error {
+	env := &envLoader{}
+
+	env.string("PORT", &c.Server.Port)
+	env.string("HOST", &c.Server.Host)
+	env.list("TRUSTED_PROXIES", &c.Server.TrustedProxies)
+	env.duration("SHUTDOWN_DRAIN_DELAY", &c.Server.DrainDelay)
+	env.duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	env.string("IMAGES_DIR", &c.Images.Directory)
+	env.int("DUPLICATE_HAMMING_THRESHOLD", &c.Images.DuplicateThreshold)
+	env.int("DUPLICATE_MAX_RETRIES", &c.Images.DuplicateRetries)
+
+	env.string("DO_VALKEY_HOST", &c.Valkey.Host)
+	env.string("DO_VALKEY_PORT", &c.Valkey.Port)
+	env.string("DO_VALKEY_PASSWORD", &c.Valkey.Password)
+
+	env.string("DO_SPACES_BUCKET", &c.Spaces.Bucket)
+	env.string("DO_SPACES_ENDPOINT", &c.Spaces.Endpoint)
+	env.string("DO_SPACES_ACCESS_KEY", &c.Spaces.AccessKey)
+	env.string("DO_SPACES_SECRET_KEY", &c.Spaces.SecretKey)
+	env.duration("DO_SPACES_TIMEOUT", &c.Spaces.Timeout)
+
+	env.string("GOOGLE_API_KEY", &c.Providers.Google.APIKey)
+	env.string("GOOGLE_IMAGEN_MODEL", &c.Providers.Google.Model)
+	env.string("LEONARDO_API_KEY", &c.Providers.Leonardo.APIKey)
+	env.string("LEONARDO_BASE_URL", &c.Providers.Leonardo.BaseURL)
+	env.string("LEONARDO_MODEL_ID", &c.Providers.Leonardo.ModelID)
+	env.string("LEONARDO_WEBHOOK_SECRET", &c.Providers.Leonardo.WebhookSecret)
+	env.string("FREEPIK_API_KEY", &c.Providers.Freepik.APIKey)
+	env.string("FREEPIK_BASE_URL", &c.Providers.Freepik.BaseURL)
+	env.int("GOOGLE_MAX_CONCURRENCY", &c.Providers.Google.MaxConcurrency)
+	env.int("LEONARDO_MAX_CONCURRENCY", &c.Providers.Leonardo.MaxConcurrency)
+	env.int("FREEPIK_MAX_CONCURRENCY", &c.Providers.Freepik.MaxConcurrency)
+	env.duration("GOOGLE_TIMEOUT", &c.Providers.Google.Timeout)
+	env.duration("LEONARDO_TIMEOUT", &c.Providers.Leonardo.Timeout)
+	env.duration("FREEPIK_TIMEOUT", &c.Providers.Freepik.Timeout)
+	env.duration("PROVIDER_QUEUE_WAIT", &c.Providers.QueueWait)
+	env.duration("PROVIDER_SLOT_LEASE", &c.Providers.SlotLease)
+
+	env.string("ADMIN_API_KEY", &c.Admin.APIKey)
+	env.list("AUTH_ANONYMOUS_SCOPES", &c.Auth.AnonymousScopes)
+
+	env.string("RATE_LIMIT_READ", &c.RateLimit.Read)
+	env.string("RATE_LIMIT_VOTE", &c.RateLimit.Vote)
+	env.string("RATE_LIMIT_GENERATE", &c.RateLimit.Generate)
+	env.string("RATE_LIMIT_CREATE_ROOM", &c.RateLimit.CreateRoom)
+
+	env.string("FRAUD_MODE", &c.Fraud.Mode)
+	env.int64("FRAUD_MAX_VOTES_PER_MINUTE", &c.Fraud.MaxVotesPerMinute)
+	env.duration("FRAUD_MIN_VOTE_LATENCY", &c.Fraud.MinVoteLatency)
+	env.int64("FRAUD_SAME_SIDE_MIN_VOTES", &c.Fraud.SameSideMinVotes)
+	env.int64("FRAUD_SAME_SIDE_PERCENT", &c.Fraud.SameSidePercent)
+	env.int64("FRAUD_MAX_SESSIONS_PER_IP", &c.Fraud.MaxSessionsPerIP)
+	env.duration("FRAUD_FLAG_TTL", &c.Fraud.FlagTTL)
+
+	env.int64("RETENTION_MAX_VOTES", &c.Retention.MaxVotes)
+	env.duration("RETENTION_MAX_AGE", &c.Retention.MaxAge)
+	env.duration("RETENTION_SWEEP_INTERVAL", &c.Retention.SweepInterval)
+
+	env.duration("VOTE_LOG_HOT_WINDOW", &c.VoteLog.HotWindow)
+	env.duration("VOTE_LOG_COMPACT_INTERVAL", &c.VoteLog.CompactInterval)
+
+	env.duration("SETTINGS_POLL_INTERVAL", &c.Runtime.PollInterval)
+
+	env.string("TRACING_EXPORTER", &c.Tracing.Exporter)
+	env.string("TRACING_OTLP_ENDPOINT", &c.Tracing.OTLPEndpoint)
+	env.string("TRACING_SERVICE_NAME", &c.Tracing.ServiceName)
+	env.float64("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+
+	env.string("LOG_LEVEL", &c.Logging.Level)
+
+	if len(env.problems) > 0 {
+		return fmt.Errorf("invalid environment:\n  - %s", strings.Join(env.problems, "\n  - "))
+	}
+	return nil
+}
+
+// Validate reports every setting that is out of range or inconsistent, not just the first
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(c.Server.Port), "server.port (PORT) must be a port number, got %q", c.Server.Port)
+	check(c.Server.DrainDelay >= 0, "server.drain_delay (SHUTDOWN_DRAIN_DELAY) must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+	check(c.Images.Directory != "", "images.directory (IMAGES_DIR) must not be empty")
+	check(c.Images.DuplicateThreshold >= 0 && c.Images.DuplicateThreshold <= 64,
+		"images.duplicate_threshold (DUPLICATE_HAMMING_THRESHOLD) must be between 0 and 64, got %d", c.Images.DuplicateThreshold)
+	check(c.Images.DuplicateRetries >= 0, "images.duplicate_retries (DUPLICATE_MAX_RETRIES) must not be negative")
+
+	if c.Valkey.Configured() {
+		check(c.Valkey.Host != "", "valkey.host (DO_VALKEY_HOST) is required when valkey.port is set")
+		check(validPort(c.Valkey.Port), "valkey.port (DO_VALKEY_PORT) must be a port number, got %q", c.Valkey.Port)
+	}
+
+	check(c.Spaces.Bucket != "", "spaces.bucket (DO_SPACES_BUCKET) must not be empty")
+	check(c.Spaces.Timeout > 0, "spaces.timeout (DO_SPACES_TIMEOUT) must be positive")
+	if c.Spaces.Configured() {
+		check(c.Spaces.Endpoint != "", "spaces.endpoint (DO_SPACES_ENDPOINT) is required when Spaces keys are set")
+		check(c.Spaces.AccessKey != "", "spaces.access_key (DO_SPACES_ACCESS_KEY) is required when Spaces is configured")
+		check(c.Spaces.SecretKey != "", "spaces.secret_key (DO_SPACES_SECRET_KEY) is required when Spaces is configured")
+		check(c.Spaces.Endpoint == "" || (strings.Contains(c.Spaces.Endpoint, ".") && !strings.Contains(c.Spaces.Endpoint, "/")),
+			"spaces.endpoint (DO_SPACES_ENDPOINT) must be a host name such as nyc3.digitaloceanspaces.com, got %q", c.Spaces.Endpoint)
+	}
+
+	check(c.Providers.Google.Model != "", "providers.google.model (GOOGLE_IMAGEN_MODEL) must not be empty")
+	check(validBaseURL(c.Providers.Leonardo.BaseURL), "providers.leonardo.base_url (LEONARDO_BASE_URL) must be an http(s) URL, got %q", c.Providers.Leonardo.BaseURL)
+	check(c.Providers.Leonardo.ModelID != "", "providers.leonardo.model_id (LEONARDO_MODEL_ID) must not be empty")
+	check(c.Providers.Leonardo.WebhookSecret == "" || len(c.Providers.Leonardo.WebhookSecret) >= 16,
+		"providers.leonardo.webhook_secret (LEONARDO_WEBHOOK_SECRET) must be at least 16 characters")
+	check(validBaseURL(c.Providers.Freepik.BaseURL), "providers.freepik.base_url (FREEPIK_BASE_URL) must be an http(s) URL, got %q", c.Providers.Freepik.BaseURL)
+	check(c.Providers.Google.MaxConcurrency >= 0, "providers.google.max_concurrency (GOOGLE_MAX_CONCURRENCY) must not be negative")
+	check(c.Providers.Leonardo.MaxConcurrency >= 0, "providers.leonardo.max_concurrency (LEONARDO_MAX_CONCURRENCY) must not be negative")
+	check(c.Providers.Freepik.MaxConcurrency >= 0, "providers.freepik.max_concurrency (FREEPIK_MAX_CONCURRENCY) must not be negative")
+	check(c.Providers.Google.Timeout > 0, "providers.google.timeout (GOOGLE_TIMEOUT) must be positive")
+	check(c.Providers.Leonardo.Timeout > 0, "providers.leonardo.timeout (LEONARDO_TIMEOUT) must be positive")
+	check(c.Providers.Freepik.Timeout > 0, "providers.freepik.timeout (FREEPIK_TIMEOUT) must be positive")
+	check(c.Providers.QueueWait >= 0, "providers.queue_wait (PROVIDER_QUEUE_WAIT) must not be negative")
+	check(c.Providers.SlotLease >= 3*time.Second, "providers.slot_lease (PROVIDER_SLOT_LEASE) must be at least 3s")
+
+	// Rate limit specs are parsed by ratelimit.ParseRule at startup; only catch rules blanked out in a file here
+	check(c.RateLimit.Read != "", "rate_limit.read must not be empty (use 0 to disable)")
+	check(c.RateLimit.Vote != "", "rate_limit.vote must not be empty (use 0 to disable)")
+	check(c.RateLimit.Generate != "", "rate_limit.generate must not be empty (use 0 to disable)")
+	check(c.RateLimit.CreateRoom != "", "rate_limit.create_room must not be empty (use 0 to disable)")
+
+	check(c.Fraud.Mode == "off" || c.Fraud.Mode == "quarantine",
+		"fraud.mode (FRAUD_MODE) must be off or quarantine, got %q", c.Fraud.Mode)
+	check(c.Fraud.MaxVotesPerMinute > 0, "fraud.max_votes_per_minute (FRAUD_MAX_VOTES_PER_MINUTE) must be positive")
+	check(c.Fraud.MinVoteLatency >= 0, "fraud.min_vote_latency (FRAUD_MIN_VOTE_LATENCY) must not be negative")
+	check(c.Fraud.SameSideMinVotes > 0, "fraud.same_side_min_votes (FRAUD_SAME_SIDE_MIN_VOTES) must be positive")
+	check(c.Fraud.SameSidePercent > 50 && c.Fraud.SameSidePercent <= 100,
+		"fraud.same_side_percent (FRAUD_SAME_SIDE_PERCENT) must be between 51 and 100, got %d", c.Fraud.SameSidePercent)
+	check(c.Fraud.MaxSessionsPerIP > 0, "fraud.max_sessions_per_ip (FRAUD_MAX_SESSIONS_PER_IP) must be positive")
+	check(c.Fraud.FlagTTL > 0, "fraud.flag_ttl (FRAUD_FLAG_TTL) must be positive")
+
+	check(c.Retention.MaxVotes >= 0, "retention.max_votes (RETENTION_MAX_VOTES) must not be negative")
+	check(c.Retention.MaxAge >= 0, "retention.max_age (RETENTION_MAX_AGE) must not be negative")
+	check(c.Retention.SweepInterval > 0, "retention.sweep_interval (RETENTION_SWEEP_INTERVAL) must be positive")
+
+	check(c.VoteLog.HotWindow >= 24*time.Hour, "vote_log.hot_window (VOTE_LOG_HOT_WINDOW) must be at least 24h, since whole days are archived")
+	check(c.VoteLog.CompactInterval >= 0, "vote_log.compact_interval (VOTE_LOG_COMPACT_INTERVAL) must not be negative")
+
+	check(c.Runtime.PollInterval >= time.Second, "runtime.poll_interval (SETTINGS_POLL_INTERVAL) must be at least 1s")
+
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "stdout" || c.Tracing.Exporter == "otlp",
+		"tracing.exporter (TRACING_EXPORTER) must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	if c.Tracing.OTLPEndpoint != "" {
+		endpoint, err := url.Parse(c.Tracing.OTLPEndpoint)
+		check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+			"tracing.otlp_endpoint (TRACING_OTLP_ENDPOINT) must be an http(s) URL such as http://collector:4318, got %q", c.Tracing.OTLPEndpoint)
+	}
+	check(c.Tracing.ServiceName != "", "tracing.service_name (TRACING_SERVICE_NAME) is required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"tracing.sample_ratio (TRACING_SAMPLE_RATIO) must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	switch strings.ToLower(c.Logging.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "logging.level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.Logging.Level)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// Secrets returns the configured credentials, so the logger can redact them wherever they appear
+func (c *Config) Secrets() []string {
+	candidates := []string{
+		c.Valkey.Password,
+		c.Spaces.AccessKey,
+		c.Spaces.SecretKey,
+		c.Providers.Google.APIKey,
+		c.Providers.Leonardo.APIKey,
+		c.Providers.Leonardo.WebhookSecret,
+		c.Providers.Freepik.APIKey,
+		c.Admin.APIKey,
+	}
+
+	secrets := make([]string, 0, len(candidates))
+	for _, secret := range candidates {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// validPort reports whether port is a TCP port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// validBaseURL reports whether raw is an absolute http or https URL
+func validBaseURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// envLoader overlays environment variables onto config fields, collecting values that fail to parse
+// Unset (or empty) variables leave the field unchanged
+type envLoader struct {
+	problems []string
+}
+
+// string overrides a string setting
+func (e *envLoader) string(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
+	}
+}
+
+// int overrides an integer setting
+func (e *envLoader) int(key string, dst *int) {
 	if value := os.Getenv(key); value != "" {
-		return value
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			e.problems = append(e.problems, fmt.Sprintf("%s must be an integer, got %q", key, value))
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// int64 overrides a 64-bit integer setting
+func (e *envLoader) int64(key string, dst *int64) {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			e.problems = append(e.problems, fmt.Sprintf("%s must be an integer, got %q", key, value))
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// float64 overrides a decimal setting
+func (e *envLoader) float64(key string, dst *float64) {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.problems = append(e.problems, fmt.Sprintf("%s must be a number, got %q", key, value))
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// duration overrides a duration setting written like "720h" or "1m30s"
+func (e *envLoader) duration(key string, dst *time.Duration) {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			e.problems = append(e.problems, fmt.Sprintf("%s must be a duration such as 1h or 30s, got %q", key, value))
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// list overrides a list setting written comma-separated; "none" sets an empty list
+func (e *envLoader) list(key string, dst *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	if value == "none" {
+		*dst = []string{}
+		return
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
-	return defaultValue
+	*dst = list
 }
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file into the test's temporary directory
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() = %v", err)
+	}
+}
+
+func TestExampleFileMatchesDefaults(t *testing.T) {
+	t.Setenv(FileEnv, "")
+	cfg, err := Load("../../config.example.yaml")
+	if err != nil {
+		t.Fatalf("Load(config.example.yaml): %v", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("config.example.yaml differs from the defaults\n got: %+v\nwant: %+v", cfg, Default())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   []string // Substrings of the expected problems; none means valid
+	}{
+		{"defaults", func(*Config) {}, nil},
+		{"port", func(c *Config) { c.Server.Port = "80a" }, []string{"server.port"}},
+		{"port out of range", func(c *Config) { c.Server.Port = "70000" }, []string{"server.port"}},
+		{"duplicate threshold", func(c *Config) { c.Images.DuplicateThreshold = 65 }, []string{"images.duplicate_threshold"}},
+		{"valkey port without host", func(c *Config) { c.Valkey.Port = "25061" }, []string{"valkey.host"}},
+		{"valkey host without port", func(c *Config) { c.Valkey.Host = "db" }, []string{"valkey.port"}},
+		{"valkey", func(c *Config) { c.Valkey.Host, c.Valkey.Port = "db", "25061" }, nil},
+		{"spaces keys without endpoint", func(c *Config) { c.Spaces.AccessKey, c.Spaces.SecretKey = "a", "s" }, []string{"spaces.endpoint"}},
+		{"spaces endpoint as a URL", func(c *Config) {
+			c.Spaces.Endpoint, c.Spaces.AccessKey, c.Spaces.SecretKey = "https://nyc3.digitaloceanspaces.com", "a", "s"
+		}, []string{"spaces.endpoint"}},
+		{"spaces endpoint without keys", func(c *Config) { c.Spaces.Endpoint = "nyc3.digitaloceanspaces.com" }, []string{"spaces.access_key", "spaces.secret_key"}},
+		{"leonardo base URL", func(c *Config) { c.Providers.Leonardo.BaseURL = "cloud.leonardo.ai" }, []string{"providers.leonardo.base_url"}},
+		{"short webhook secret", func(c *Config) { c.Providers.Leonardo.WebhookSecret = "short" }, []string{"webhook_secret"}},
+		{"slot lease", func(c *Config) { c.Providers.SlotLease = time.Second }, []string{"providers.slot_lease"}},
+		{"provider timeouts", func(c *Config) {
+			c.Providers.Google.Timeout, c.Providers.Leonardo.Timeout, c.Providers.Freepik.Timeout = 0, -time.Second, 0
+		}, []string{"providers.google.timeout", "providers.leonardo.timeout", "providers.freepik.timeout"}},
+		{"spaces timeout", func(c *Config) { c.Spaces.Timeout = 0 }, []string{"spaces.timeout"}},
+		{"blank rate limit", func(c *Config) { c.RateLimit.Vote = "" }, []string{"rate_limit.vote"}},
+		{"flag fraud mode", func(c *Config) { c.Fraud.Mode = "flag" }, []string{"fraud.mode"}},
+		{"same side percent", func(c *Config) { c.Fraud.SameSidePercent = 50 }, []string{"fraud.same_side_percent"}},
+		{"hot window", func(c *Config) { c.VoteLog.HotWindow = time.Hour }, []string{"vote_log.hot_window"}},
+		{"poll interval", func(c *Config) { c.Runtime.PollInterval = 0 }, []string{"runtime.poll_interval"}},
+		{"tracing exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, []string{"tracing.exporter"}},
+		{"otlp endpoint", func(c *Config) { c.Tracing.OTLPEndpoint = "collector:4318" }, []string{"tracing.otlp_endpoint"}},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, []string{"tracing.sample_ratio"}},
+		{"log level", func(c *Config) { c.Logging.Level = "WARN" }, nil},
+		{"unknown log level", func(c *Config) { c.Logging.Level = "trace" }, []string{"logging.level"}},
+		{"every problem is reported", func(c *Config) {
+			c.Server.Port = ""
+			c.Fraud.FlagTTL = 0
+			c.Retention.SweepInterval = -time.Second
+		}, []string{"server.port", "fraud.flag_ttl", "retention.sweep_interval"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no problems", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() accepted the configuration, want problems with %v", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %v, want a problem with %s", err, want)
+				}
+			}
+			if problems := strings.Count(err.Error(), "\n  - "); problems != len(tt.want) {
+				t.Errorf("Validate() reported %d problems, want %d: %v", problems, len(tt.want), err)
+			}
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		body string
+	}{
+		{"yaml", "config.yaml", "server:\n  port: \"9000\"\n  drain_delay: 5s\nfraud:\n  mode: off\n  flag_ttl: 2h\n"},
+		{"toml", "config.toml", "[server]\nport = \"9000\"\ndrain_delay = \"5s\"\n\n[fraud]\nmode = \"off\"\nflag_ttl = \"2h\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(FileEnv, writeConfigFile(t, tt.file, tt.body))
+			t.Setenv("FRAUD_FLAG_TTL", "30m")
+			t.Setenv("TRUSTED_PROXIES", "none")
+			t.Setenv("LEONARDO_TIMEOUT", "90s")
+
+			cfg, err := Load("")
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			// The file overrides the defaults, and the environment overrides the file
+			if cfg.Server.Port != "9000" || cfg.Server.DrainDelay != 5*time.Second || cfg.Fraud.Mode != "off" {
+				t.Errorf("file settings not applied: %+v %+v", cfg.Server, cfg.Fraud)
+			}
+			if cfg.Fraud.FlagTTL != 30*time.Minute {
+				t.Errorf("FlagTTL = %v, want the environment's 30m", cfg.Fraud.FlagTTL)
+			}
+			if cfg.Providers.Leonardo.Timeout != 90*time.Second {
+				t.Errorf("Leonardo timeout = %v, want the environment's 90s", cfg.Providers.Leonardo.Timeout)
+			}
+			if len(cfg.Server.TrustedProxies) != 0 {
+				t.Errorf("TrustedProxies = %v, want none", cfg.Server.TrustedProxies)
+			}
+
+			// Keys the file leaves out keep their defaults
+			if cfg.Server.ShutdownTimeout != Default().Server.ShutdownTimeout || cfg.Fraud.MaxVotesPerMinute != Default().Fraud.MaxVotesPerMinute {
+				t.Errorf("unset keys lost their defaults: %+v %+v", cfg.Server, cfg.Fraud)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		body string
+		env  map[string]string
+		want string
+	}{
+		{"misspelt key", "config.yaml", "server:\n  prot: \"9000\"\n", nil, "field prot not found"},
+		{"unsupported extension", "config.json", "{}", nil, "must end in .yaml, .yml or .toml"},
+		{"malformed toml", "config.toml", "[server\n", nil, "failed to parse config file"},
+		{"invalid value in the file", "config.yml", "fraud:\n  same_side_percent: 40\n", nil, "fraud.same_side_percent"},
+		{"unparsable environment", "config.yaml", "", map[string]string{"FRAUD_MAX_VOTES_PER_MINUTE": "many", "SHUTDOWN_TIMEOUT": "2"},
+			"FRAUD_MAX_VOTES_PER_MINUTE must be an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := Load(writeConfigFile(t, tt.file, tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load accepted a missing config file")
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Valkey.Password = "valkey-password"
+	cfg.Admin.APIKey = "admin-key"
+
+	if got, want := cfg.Secrets(), []string{"valkey-password", "admin-key"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Secrets() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Package dataset exports every pair and vote held in Valkey to CSV, JSONL or Parquet, and imports such a
+// dataset back, for offline analysis and migrating between clusters
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+// ErrNotEmpty is returned when importing votes into a cluster that already has some
+// Re-imported votes are skipped, but votes the target recorded itself cannot be told apart from exported
+// copies of them, so merging into a live cluster needs ImportOptions.Force
+var ErrNotEmpty = errors.New("target Valkey already contains votes")
+
+// Summary counts the records processed by an export or import
+type Summary struct {
+	Pairs        int `json:"pairs"`
+	Votes        int `json:"votes"`
+	SkippedPairs int `json:"skipped_pairs,omitempty"` // Import only: pairs that already existed
+	SkippedVotes int `json:"skipped_votes,omitempty"` // Import only: votes imported before
+}
+
+// ImportOptions controls an import
+type ImportOptions struct {
+	Force bool // Import votes even when the target already has some
+}
+
+// Export writes every pair followed by every vote (oldest first) to w
+// w is closed on success so buffered rows and footers are flushed
+func Export(ctx context.Context, valkey *storage.ValkeyClient, w Writer) (*Summary, error) {
+	summary := &Summary{}
+
+	err := valkey.ScanPairs(ctx, func(pair *storage.ImagePair) error {
+		summary.Pairs++
+		return w.Write(PairRecord(pair))
+	})
+	if err != nil {
+		return summary, fmt.Errorf("failed to export pairs: %w", err)
+	}
+
+	err = valkey.ScanVotes(ctx, func(vote *storage.Vote) error {
+		summary.Votes++
+		return w.Write(VoteRecord(vote))
+	})
+	if err != nil {
+		return summary, fmt.Errorf("failed to export votes: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return summary, fmt.Errorf("failed to finish export: %w", err)
+	}
+
+	return summary, nil
+}
+
+// Import restores a dataset produced by Export
+// Pairs and votes are idempotent: existing pairs and votes imported before are skipped. Votes are replayed in
+// file order, and the target must not already hold votes unless opts.Force is set
+func Import(ctx context.Context, valkey *storage.ValkeyClient, r Reader, opts ImportOptions) (*Summary, error) {
+	summary := &Summary{}
+	if !opts.Force {
+		total, err := valkey.GetTotalVotes(ctx)
+		if err != nil {
+			return summary, err
+		}
+		if total > 0 {
+			return summary, ErrNotEmpty
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		record, err := r.Read()
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to read record %d: %w", recordNumber(summary), err)
+		}
+		if err := record.Validate(); err != nil {
+			return summary, fmt.Errorf("invalid record %d: %w", recordNumber(summary), err)
+		}
+
+		switch record.RecordType {
+		case RecordTypePair:
+			written, err := valkey.ImportPair(ctx, record.Pair())
+			if err != nil {
+				return summary, err
+			}
+			if written {
+				summary.Pairs++
+			} else {
+				summary.SkippedPairs++
+			}
+		case RecordTypeVote:
+			written, err := valkey.ImportVote(ctx, record.Vote(), record.ImportID())
+			if err != nil {
+				return summary, err
+			}
+			if written {
+				summary.Votes++
+			} else {
+				summary.SkippedVotes++
+			}
+		}
+	}
+}
+
+// recordNumber is the 1-based position of the next record of an import
+func recordNumber(summary *Summary) int {
+	return summary.Pairs + summary.SkippedPairs + summary.Votes + summary.SkippedVotes + 1
+}
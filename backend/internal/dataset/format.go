@@ -0,0 +1,465 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Format is a supported export file format
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatJSONL   Format = "jsonl"
+	FormatParquet Format = "parquet"
+)
+
+const (
+	// parquetBatchSize is how many rows are decoded from a Parquet file at a time
+	parquetBatchSize = 1000
+
+	// parquetRowGroupSize is how many rows the writer buffers before flushing a row group
+	parquetRowGroupSize = 100_000
+)
+
+// ParseFormat validates a format name
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case FormatCSV, FormatJSONL, FormatParquet:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unsupported format %q (expected csv, jsonl or parquet)", name)
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// Writer encodes records to a stream
+type Writer interface {
+	Write(Record) error
+	Close() error // Flushes buffered rows (and the Parquet footer); does not close the underlying stream
+}
+
+// Reader decodes records from a stream; Read returns io.EOF after the last record
+type Reader interface {
+	Read() (Record, error)
+	Close() error
+}
+
+// NewWriter returns a writer for the format
+func NewWriter(format Format, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return newParquetWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// NewReader returns a reader for the format
+// Parquet needs random access to its footer, so the stream is spooled to a temporary file first
+func NewReader(format Format, r io.Reader) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		return &jsonlReader{scanner: scanner}, nil
+	case FormatParquet:
+		return newParquetReader(r)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// jsonlWriter writes one JSON object per line
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) Write(record Record) error {
+	return w.encoder.Encode(record)
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+// jsonlReader reads one JSON object per line, skipping blank lines
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonlReader) Read() (Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(r.scanner.Bytes(), &record); err != nil {
+			return Record{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+func (r *jsonlReader) Close() error {
+	return nil
+}
+
+// csvColumns is the CSV header; variant maps are stored as JSON objects and times as RFC 3339
+var csvColumns = []string{
+	"record_type", "vote_id", "pair_id", "prompt", "provider", "timestamp", "winner",
+	"left_url", "right_url", "left_hash", "right_hash", "left_variants", "right_variants",
+	"retired_at", "retired_reason",
+}
+
+// csvWriter writes a header row followed by one row per record
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer}, nil
+}
+
+func (w *csvWriter) Write(record Record) error {
+	leftVariants, err := encodeVariants(record.LeftVariants)
+	if err != nil {
+		return err
+	}
+	rightVariants, err := encodeVariants(record.RightVariants)
+	if err != nil {
+		return err
+	}
+
+	var retiredAt string
+	if record.RetiredAt != nil {
+		retiredAt = record.RetiredAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return w.writer.Write([]string{
+		record.RecordType, record.VoteID, record.PairID, record.Prompt, record.Provider,
+		record.Timestamp.UTC().Format(time.RFC3339Nano), record.Winner,
+		record.LeftURL, record.RightURL, record.LeftHash, record.RightHash, leftVariants, rightVariants,
+		retiredAt, record.RetiredReason,
+	})
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// csvReader maps columns by header name, so column order does not matter
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, required := range []string{"record_type", "pair_id", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", required)
+		}
+	}
+
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvReader) Read() (Record, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		return Record{}, err
+	}
+	line, _ := r.reader.FieldPos(0)
+
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	record := Record{
+		RecordType:    field("record_type"),
+		VoteID:        field("vote_id"),
+		PairID:        field("pair_id"),
+		Prompt:        field("prompt"),
+		Provider:      field("provider"),
+		Winner:        field("winner"),
+		LeftURL:       field("left_url"),
+		RightURL:      field("right_url"),
+		LeftHash:      field("left_hash"),
+		RightHash:     field("right_hash"),
+		RetiredReason: field("retired_reason"),
+	}
+
+	if record.Timestamp, err = time.Parse(time.RFC3339Nano, field("timestamp")); err != nil {
+		return Record{}, fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+	}
+	if value := field("retired_at"); value != "" {
+		retiredAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return Record{}, fmt.Errorf("line %d: invalid retired_at: %w", line, err)
+		}
+		record.RetiredAt = &retiredAt
+	}
+	if record.LeftVariants, err = decodeVariants(field("left_variants")); err != nil {
+		return Record{}, fmt.Errorf("line %d: invalid left_variants: %w", line, err)
+	}
+	if record.RightVariants, err = decodeVariants(field("right_variants")); err != nil {
+		return Record{}, fmt.Errorf("line %d: invalid right_variants: %w", line, err)
+	}
+
+	return record, nil
+}
+
+func (r *csvReader) Close() error {
+	return nil
+}
+
+// parquetRecord is the Parquet schema of a Record
+// Times are stored as UTC nanosecond TIMESTAMP columns so analysis tools read them as native timestamps; files
+// written with microsecond timestamps (older exports) are converted on read
+type parquetRecord struct {
+	RecordType    string     `parquet:"record_type,dict"`
+	VoteID        string     `parquet:"vote_id"`
+	PairID        string     `parquet:"pair_id"`
+	Prompt        string     `parquet:"prompt"`
+	Provider      string     `parquet:"provider,dict"`
+	Timestamp     time.Time  `parquet:"timestamp"`
+	Winner        string     `parquet:"winner,dict"`
+	LeftURL       string     `parquet:"left_url"`
+	RightURL      string     `parquet:"right_url"`
+	LeftHash      string     `parquet:"left_hash"`
+	RightHash     string     `parquet:"right_hash"`
+	LeftVariants  string     `parquet:"left_variants"`
+	RightVariants string     `parquet:"right_variants"`
+	RetiredAt     *time.Time `parquet:"retired_at,optional"`
+	RetiredReason string     `parquet:"retired_reason"`
+}
+
+// parquetWriter buffers rows into row groups and writes the footer on Close
+type parquetWriter struct {
+	writer *parquet.GenericWriter[parquetRecord]
+}
+
+func newParquetWriter(w io.Writer) (*parquetWriter, error) {
+	pw := parquet.NewGenericWriter[parquetRecord](w,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+	)
+	return &parquetWriter{writer: pw}, nil
+}
+
+func (w *parquetWriter) Write(record Record) error {
+	leftVariants, err := encodeVariants(record.LeftVariants)
+	if err != nil {
+		return err
+	}
+	rightVariants, err := encodeVariants(record.RightVariants)
+	if err != nil {
+		return err
+	}
+
+	row := parquetRecord{
+		RecordType:    record.RecordType,
+		VoteID:        record.VoteID,
+		PairID:        record.PairID,
+		Prompt:        record.Prompt,
+		Provider:      record.Provider,
+		Timestamp:     record.Timestamp,
+		Winner:        record.Winner,
+		LeftURL:       record.LeftURL,
+		RightURL:      record.RightURL,
+		LeftHash:      record.LeftHash,
+		RightHash:     record.RightHash,
+		LeftVariants:  leftVariants,
+		RightVariants: rightVariants,
+		RetiredReason: record.RetiredReason,
+	}
+	if record.RetiredAt != nil {
+		row.RetiredAt = record.RetiredAt
+	}
+
+	_, err = w.writer.Write([]parquetRecord{row})
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	return w.writer.Close()
+}
+
+// parquetReader decodes a spooled Parquet file in batches
+type parquetReader struct {
+	file   *os.File
+	reader *parquet.GenericReader[parquetRecord]
+	batch  []parquetRecord
+	done   bool
+}
+
+func newParquetReader(r io.Reader) (*parquetReader, error) {
+	spool, err := os.CreateTemp("", "dataset-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, fmt.Errorf("failed to spool Parquet file: %w", err)
+	}
+
+	reader, err := openParquet(spool, size)
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, fmt.Errorf("failed to read Parquet file: %w", err)
+	}
+
+	return &parquetReader{file: spool, reader: reader}, nil
+}
+
+// openParquet opens a Parquet file for reading parquetRecord rows
+// The reader panics when the file's schema cannot be converted to parquetRecord, so that is turned into an error
+func openParquet(r io.ReaderAt, size int64) (reader *parquet.GenericReader[parquetRecord], err error) {
+	file, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			reader, err = nil, fmt.Errorf("incompatible schema: %v", recovered)
+		}
+	}()
+	return parquet.NewGenericReader[parquetRecord](file), nil
+}
+
+func (r *parquetReader) Read() (Record, error) {
+	if len(r.batch) == 0 {
+		if r.done {
+			return Record{}, io.EOF
+		}
+
+		batch := make([]parquetRecord, parquetBatchSize)
+		n, err := r.reader.Read(batch)
+		if err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return Record{}, fmt.Errorf("failed to read Parquet rows: %w", err)
+		}
+		r.batch = batch[:n]
+		if len(r.batch) == 0 {
+			return Record{}, io.EOF
+		}
+	}
+
+	row := r.batch[0]
+	r.batch = r.batch[1:]
+
+	leftVariants, err := decodeVariants(row.LeftVariants)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid left_variants for pair %s: %w", row.PairID, err)
+	}
+	rightVariants, err := decodeVariants(row.RightVariants)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid right_variants for pair %s: %w", row.PairID, err)
+	}
+
+	record := Record{
+		RecordType:    row.RecordType,
+		VoteID:        row.VoteID,
+		PairID:        row.PairID,
+		Prompt:        row.Prompt,
+		Provider:      row.Provider,
+		Timestamp:     row.Timestamp.UTC(),
+		Winner:        row.Winner,
+		LeftURL:       row.LeftURL,
+		RightURL:      row.RightURL,
+		LeftHash:      row.LeftHash,
+		RightHash:     row.RightHash,
+		LeftVariants:  leftVariants,
+		RightVariants: rightVariants,
+		RetiredReason: row.RetiredReason,
+	}
+	if row.RetiredAt != nil {
+		retiredAt := row.RetiredAt.UTC()
+		record.RetiredAt = &retiredAt
+	}
+
+	return record, nil
+}
+
+func (r *parquetReader) Close() error {
+	r.reader.Close()
+	r.file.Close()
+	return os.Remove(r.file.Name())
+}
+
+// encodeVariants stores a variant map as a JSON object, or an empty string when there are none
+func encodeVariants(variants map[string]string) (string, error) {
+	if len(variants) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(variants)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode variants: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeVariants reverses encodeVariants
+func decodeVariants(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var variants map[string]string
+	if err := json.Unmarshal([]byte(value), &variants); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
@@ -0,0 +1,219 @@
+package dataset
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// testRecords covers both record types and every optional column
+func testRecords() []Record {
+	created := time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	retired := created.Add(48 * time.Hour)
+
+	return []Record{
+		{
+			RecordType:    RecordTypePair,
+			PairID:        "pair-1",
+			Prompt:        "a lighthouse, \"at dusk\"",
+			Provider:      "openai",
+			Timestamp:     created,
+			LeftURL:       "https://cdn.example.com/images/openai/pair-1/left.png",
+			RightURL:      "https://cdn.example.com/images/openai/pair-1/right.png",
+			LeftHash:      "aa",
+			RightHash:     "bb",
+			LeftVariants:  map[string]string{"256": "l256", "placeholder": "lp"},
+			RightVariants: map[string]string{"256": "r256"},
+		},
+		{
+			RecordType:    RecordTypePair,
+			PairID:        "pair-2",
+			Prompt:        "multi\nline",
+			Provider:      "leonardo",
+			Timestamp:     created,
+			LeftURL:       "l",
+			RightURL:      "r",
+			RetiredAt:     &retired,
+			RetiredReason: "manual",
+		},
+		{
+			RecordType: RecordTypeVote,
+			VoteID:     "1772368200123-0",
+			PairID:     "pair-1",
+			Prompt:     "a lighthouse, \"at dusk\"",
+			Provider:   "openai",
+			Timestamp:  created.Add(time.Minute),
+			Winner:     "left",
+		},
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatCSV, FormatJSONL, FormatParquet} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			writer, err := NewWriter(format, &buf)
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			for _, record := range testRecords() {
+				if err := writer.Write(record); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			reader, err := NewReader(format, &buf)
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			defer reader.Close()
+
+			var got []Record
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Read: %v", err)
+				}
+				got = append(got, record)
+			}
+
+			if want := testRecords(); !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip mismatch\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParquetReaderBatches(t *testing.T) {
+	const rows = parquetBatchSize*2 + 7
+
+	var buf bytes.Buffer
+	writer, err := NewWriter(FormatParquet, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	vote := testRecords()[2]
+	for i := 0; i < rows; i++ {
+		if err := writer.Write(vote); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reader, err := NewReader(FormatParquet, &buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer reader.Close()
+
+	count := 0
+	for {
+		if _, err := reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		count++
+	}
+	if count != rows {
+		t.Errorf("read %d rows, want %d", count, rows)
+	}
+}
+
+func TestParquetReaderRejectsGarbage(t *testing.T) {
+	if _, err := NewReader(FormatParquet, strings.NewReader("not parquet")); err == nil {
+		t.Error("NewReader accepted a file that is not Parquet")
+	}
+}
+
+func TestCSVReaderWithoutVoteID(t *testing.T) {
+	// Exports made before vote IDs existed have no vote_id column
+	input := "record_type,pair_id,provider,timestamp,winner\nvote,pair-1,openai,2026-03-01T12:30:00Z,right\n"
+
+	reader, err := NewReader(FormatCSV, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if record.VoteID != "" || record.Winner != "right" || record.PairID != "pair-1" {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
+
+func TestRecordImportID(t *testing.T) {
+	vote := testRecords()[2]
+	legacy := vote
+	legacy.VoteID = ""
+	otherPair := vote
+	otherPair.PairID = "pair-9"
+	otherLegacy := legacy
+	otherLegacy.Winner = "right"
+
+	if got, want := vote.ImportID(), "pair-1/1772368200123-0"; got != want {
+		t.Errorf("ImportID() = %q, want %q", got, want)
+	}
+	if vote.ImportID() == otherPair.ImportID() {
+		t.Error("the same vote ID in two pairs shares an import ID")
+	}
+	if legacy.ImportID() != legacy.ImportID() {
+		t.Error("legacy import ID is not stable")
+	}
+	if legacy.ImportID() == otherLegacy.ImportID() {
+		t.Error("legacy votes with different winners share an import ID")
+	}
+	if !strings.HasPrefix(legacy.ImportID(), "pair-1/sha256:") {
+		t.Errorf("legacy import ID = %q, want a digest", legacy.ImportID())
+	}
+}
+
+func TestParquetReaderLegacySchema(t *testing.T) {
+	// Older exports have microsecond timestamps and no vote_id column
+	type legacyRecord struct {
+		RecordType string `parquet:"record_type"`
+		PairID     string `parquet:"pair_id"`
+		Provider   string `parquet:"provider"`
+		Timestamp  int64  `parquet:"timestamp,timestamp(microsecond)"`
+		Winner     string `parquet:"winner"`
+	}
+	timestamp := time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC)
+
+	var buf bytes.Buffer
+	writer := parquet.NewGenericWriter[legacyRecord](&buf)
+	rows := []legacyRecord{{RecordType: RecordTypeVote, PairID: "pair-1", Provider: "openai", Timestamp: timestamp.UnixMicro(), Winner: "left"}}
+	if _, err := writer.Write(rows); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reader, err := NewReader(FormatParquet, &buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer reader.Close()
+
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !record.Timestamp.Equal(timestamp) || record.VoteID != "" || record.RetiredAt != nil || record.Winner != "left" {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
@@ -0,0 +1,133 @@
+package dataset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+// Record types stored in the record_type column
+const (
+	RecordTypePair = "pair"
+	RecordTypeVote = "vote"
+)
+
+// Record is one row of an export: either a pair or a vote
+// Pairs and votes share a single flat schema so every format (including CSV and Parquet) holds both in one file;
+// columns that do not apply to a record type are left empty
+type Record struct {
+	RecordType    string            `json:"record_type"`
+	VoteID        string            `json:"vote_id,omitempty"` // Votes only: the source cluster's vote log ID
+	PairID        string            `json:"pair_id"`
+	Prompt        string            `json:"prompt"`
+	Provider      string            `json:"provider"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Winner        string            `json:"winner,omitempty"` // Votes only
+	LeftURL       string            `json:"left_url,omitempty"`
+	RightURL      string            `json:"right_url,omitempty"`
+	LeftHash      string            `json:"left_hash,omitempty"`
+	RightHash     string            `json:"right_hash,omitempty"`
+	LeftVariants  map[string]string `json:"left_variants,omitempty"`
+	RightVariants map[string]string `json:"right_variants,omitempty"`
+	RetiredAt     *time.Time        `json:"retired_at,omitempty"`
+	RetiredReason string            `json:"retired_reason,omitempty"`
+}
+
+// PairRecord converts a stored pair into a record
+func PairRecord(pair *storage.ImagePair) Record {
+	return Record{
+		RecordType:    RecordTypePair,
+		PairID:        pair.PairID,
+		Prompt:        pair.Prompt,
+		Provider:      pair.Provider,
+		Timestamp:     pair.Timestamp,
+		LeftURL:       pair.LeftURL,
+		RightURL:      pair.RightURL,
+		LeftHash:      pair.LeftHash,
+		RightHash:     pair.RightHash,
+		LeftVariants:  pair.LeftVariants,
+		RightVariants: pair.RightVariants,
+		RetiredAt:     pair.RetiredAt,
+		RetiredReason: pair.RetiredReason,
+	}
+}
+
+// VoteRecord converts a stored vote into a record
+func VoteRecord(vote *storage.Vote) Record {
+	return Record{
+		RecordType: RecordTypeVote,
+		VoteID:     vote.ID,
+		PairID:     vote.PairID,
+		Prompt:     vote.Prompt,
+		Provider:   vote.Provider,
+		Timestamp:  vote.Timestamp,
+		Winner:     vote.Winner,
+	}
+}
+
+// Pair converts a pair record back into a stored pair
+func (r Record) Pair() *storage.ImagePair {
+	return &storage.ImagePair{
+		PairID:        r.PairID,
+		Prompt:        r.Prompt,
+		Provider:      r.Provider,
+		LeftURL:       r.LeftURL,
+		RightURL:      r.RightURL,
+		Timestamp:     r.Timestamp,
+		LeftVariants:  r.LeftVariants,
+		RightVariants: r.RightVariants,
+		LeftHash:      r.LeftHash,
+		RightHash:     r.RightHash,
+		RetiredAt:     r.RetiredAt,
+		RetiredReason: r.RetiredReason,
+	}
+}
+
+// Vote converts a vote record back into a stored vote
+func (r Record) Vote() *storage.Vote {
+	return &storage.Vote{
+		PairID:    r.PairID,
+		Winner:    r.Winner,
+		Provider:  r.Provider,
+		Prompt:    r.Prompt,
+		Timestamp: r.Timestamp,
+	}
+}
+
+// ImportID is the key a vote record is deduplicated on when imported
+// Vote log IDs are only unique within a cluster, so the pair ID is included; exports made before vote IDs
+// existed fall back to a digest of the vote's fields
+func (r Record) ImportID() string {
+	if r.VoteID != "" {
+		return r.PairID + "/" + r.VoteID
+	}
+	fields := strings.Join([]string{r.PairID, r.Winner, r.Provider, r.Timestamp.UTC().Format(time.RFC3339Nano)}, "\x00")
+	sum := sha256.Sum256([]byte(fields))
+	return r.PairID + "/sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Validate checks that a record read from a file can be imported
+func (r Record) Validate() error {
+	if r.PairID == "" {
+		return fmt.Errorf("missing pair_id")
+	}
+
+	switch r.RecordType {
+	case RecordTypePair:
+		if r.LeftURL == "" || r.RightURL == "" {
+			return fmt.Errorf("pair %s is missing an image URL", r.PairID)
+		}
+	case RecordTypeVote:
+		if r.Winner != "left" && r.Winner != "right" {
+			return fmt.Errorf("vote for pair %s has invalid winner %q", r.PairID, r.Winner)
+		}
+	default:
+		return fmt.Errorf("unknown record_type %q", r.RecordType)
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+// Package events fans live votes, new pairs and provider status changes out to every connected client
+// Events are published through Valkey pub/sub so clients behind the load balancer see events from every droplet
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// logger reports events that could not be relayed or published
+var logger = logging.Logger("events")
+
+// Event types
+const (
+	TypeVote           = "vote"            // A vote was recorded (VoteEvent)
+	TypePair           = "pair"            // A new pair was generated (PairEvent)
+	TypeProviderStatus = "provider_status" // A provider became available or unavailable (models.ProviderStatus)
+)
+
+// subscriberBuffer is how many events a client may fall behind before further events are dropped for it
+const subscriberBuffer = 32
+
+// Event is a single live update
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// VoteEvent is the payload of a vote event, carrying the new totals so clients need not refetch statistics
+type VoteEvent struct {
+	PairID     string           `json:"pair_id"`
+	Winner     string           `json:"winner"`
+	SideWins   map[string]int64 `json:"side_wins"`
+	TotalVotes int64            `json:"total_votes"`
+}
+
+// PairEvent is the payload of a pair event
+type PairEvent struct {
+	PairID      string `json:"pair_id"`
+	Prompt      string `json:"prompt"`
+	Provider    string `json:"provider"`
+	LeftURL     string `json:"left_url"`
+	RightURL    string `json:"right_url"`
+	GeneratedAt string `json:"generated_at"`
+}
+
+// Broker delivers published events to every local subscriber
+// With Valkey configured, events travel through pub/sub and are delivered when they come back from it;
+// without Valkey they are delivered locally only
+type Broker struct {
+	valkey *storage.ValkeyClient
+
+	mutex       sync.RWMutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// NewBroker creates a broker; valkey may be nil for a single-instance setup
+func NewBroker(valkey *storage.ValkeyClient) *Broker {
+	return &Broker{
+		valkey:      valkey,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Start relays events from Valkey pub/sub to local subscribers until ctx is cancelled
+func (b *Broker) Start(ctx context.Context) {
+	if b.valkey == nil {
+		return
+	}
+
+	messages, closeSubscription := b.valkey.SubscribeEvents(ctx)
+	go func() {
+		<-ctx.Done()
+		closeSubscription()
+	}()
+
+	go func() {
+		logger.Info("Relaying live events from Valkey pub/sub")
+		for payload := range messages {
+			var event Event
+			if err := json.Unmarshal([]byte(payload), &event); err != nil {
+				logger.Warn("Dropping malformed event", "error", err)
+				continue
+			}
+			b.deliver(event)
+		}
+	}()
+}
+
+// Publish broadcasts an event of the given type to every client on every instance
+func (b *Broker) Publish(ctx context.Context, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+
+	event := Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		Data:      payload,
+		Timestamp: time.Now().UTC(),
+	}
+
+	if b.valkey == nil {
+		b.deliver(event)
+		return nil
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+	return b.valkey.PublishEvent(ctx, eventJSON)
+}
+
+// PublishVote broadcasts a recorded vote along with the new totals
+// Publishing is best effort; a failure only delays clients until their next update
+func (b *Broker) PublishVote(ctx context.Context, vote *storage.Vote) {
+	if b.valkey == nil {
+		return // Votes are only recorded in Valkey
+	}
+
+	sideWins, err := b.valkey.GetSideWins(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to get side wins for vote event", "error", err)
+		return
+	}
+	totalVotes, err := b.valkey.GetTotalVotes(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to get total votes for vote event", "error", err)
+		return
+	}
+
+	err = b.Publish(ctx, TypeVote, VoteEvent{
+		PairID:     vote.PairID,
+		Winner:     vote.Winner,
+		SideWins:   sideWins,
+		TotalVotes: totalVotes,
+	})
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to publish vote event", "error", err)
+	}
+}
+
+// PublishPair broadcasts a newly stored pair
+func (b *Broker) PublishPair(ctx context.Context, pair *storage.ImagePair) {
+	err := b.Publish(ctx, TypePair, PairEvent{
+		PairID:      pair.PairID,
+		Prompt:      pair.Prompt,
+		Provider:    pair.Provider,
+		LeftURL:     pair.LeftURL,
+		RightURL:    pair.RightURL,
+		GeneratedAt: pair.Timestamp.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to publish pair event", "error", err)
+	}
+}
+
+// Subscribe registers a local subscriber; call the returned function to unsubscribe
+// The channel is closed when the broker is closed
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mutex.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	b.mutex.Unlock()
+
+	return ch, func() {
+		b.mutex.Lock()
+		delete(b.subscribers, ch)
+		b.mutex.Unlock()
+	}
+}
+
+// Close ends every local subscription so long-lived streams return during shutdown
+// Clients reconnect through the load balancer to an instance that is still serving
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+	b.closed = true
+}
+
+// Subscribers returns the number of locally connected subscribers
+func (b *Broker) Subscribers() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.subscribers)
+}
+
+// deliver hands an event to every local subscriber without blocking; slow subscribers miss events
+func (b *Broker) deliver(event Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
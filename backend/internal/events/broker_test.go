@@ -0,0 +1,98 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+// receive waits briefly for the next event on ch
+func receive(t *testing.T, ch <-chan Event) (Event, bool) {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		return event, ok
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+		return Event{}, false
+	}
+}
+
+func TestBrokerPublishLocal(t *testing.T) {
+	broker := NewBroker(nil)
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	pair := &storage.ImagePair{PairID: "pair-1", Prompt: "a fox", Provider: "openai", LeftURL: "l", RightURL: "r", Timestamp: time.Now()}
+	broker.PublishPair(context.Background(), pair)
+
+	for _, ch := range []<-chan Event{first, second} {
+		event, _ := receive(t, ch)
+		if event.Type != TypePair || event.ID == "" {
+			t.Errorf("event = %+v, want a pair event with an ID", event)
+		}
+		var payload PairEvent
+		if err := json.Unmarshal(event.Data, &payload); err != nil {
+			t.Fatalf("pair payload: %v", err)
+		}
+		if payload.PairID != "pair-1" || payload.Prompt != "a fox" {
+			t.Errorf("payload = %+v", payload)
+		}
+	}
+
+	unsubscribeFirst()
+	if got := broker.Subscribers(); got != 1 {
+		t.Errorf("Subscribers() = %d after unsubscribing, want 1", got)
+	}
+}
+
+func TestBrokerPublishVoteWithoutValkey(t *testing.T) {
+	broker := NewBroker(nil)
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	broker.PublishVote(context.Background(), &storage.Vote{PairID: "pair-1", Winner: "left"})
+	select {
+	case event := <-ch:
+		t.Errorf("vote event %+v published without Valkey", event)
+	default:
+	}
+}
+
+func TestBrokerDropsForSlowSubscribers(t *testing.T) {
+	broker := NewBroker(nil)
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+10; i++ {
+		if err := broker.Publish(context.Background(), TypeProviderStatus, map[string]int{"n": i}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	if got := len(ch); got != subscriberBuffer {
+		t.Errorf("subscriber holds %d events, want the buffer of %d", got, subscriberBuffer)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := NewBroker(nil)
+	ch, unsubscribe := broker.Subscribe()
+	broker.Close()
+
+	if _, ok := receive(t, ch); ok {
+		t.Error("subscription still open after Close")
+	}
+	unsubscribe() // Must not panic after Close
+
+	late, _ := broker.Subscribe()
+	if _, ok := receive(t, late); ok {
+		t.Error("subscription opened after Close")
+	}
+	if got := broker.Subscribers(); got != 0 {
+		t.Errorf("Subscribers() = %d after Close, want 0", got)
+	}
+}
@@ -0,0 +1,214 @@
+// Package fraud scores votes for signs of ballot stuffing and bots, and holds suspicious votes back from the
+// statistics until an operator reviews them
+package fraud
+
+import (
+	"context"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// logger records suspicious votes and failed checks
+var logger = logging.Logger("fraud")
+
+// Modes decide whether votes are scored
+// There is no mode that flags voters but still counts their votes: a flagged vote that reaches the statistics,
+// winners and leaderboards is indistinguishable from any other, so only quarantine protects the results
+const (
+	ModeOff        = "off"        // Votes are not scored
+	ModeQuarantine = "quarantine" // Suspicious voters are flagged and their votes quarantined, out of the statistics
+)
+
+// Reasons a vote is considered suspicious
+const (
+	ReasonVoteRate     = "vote_rate"     // The session votes faster than any person reasonably could
+	ReasonIPVoteRate   = "ip_vote_rate"  // The IP votes faster than even a busy shared network would
+	ReasonTooFast      = "too_fast"      // The vote came in before the images could have been looked at
+	ReasonUnservedPair = "unserved_pair" // The session voted on a pair it was never shown
+	ReasonNoSession    = "no_session"    // The client sent no session ID, as scripted clients tend not to
+	ReasonSameSide     = "same_side"     // The session almost always picks the same side
+	ReasonManySessions = "many_sessions" // The IP is cycling through sessions
+	ReasonFlagged      = "flagged"       // The session is already flagged
+	ReasonFlaggedIP    = "flagged_ip"    // The IP is already flagged; it may be shared, so this counts for less
+)
+
+// weights of each reason; a vote scoring quarantineScore or more is suspicious
+var weights = map[string]float64{
+	ReasonVoteRate:     1.0,
+	ReasonIPVoteRate:   1.0,
+	ReasonTooFast:      0.6,
+	ReasonUnservedPair: 0.6,
+	ReasonNoSession:    0.3,
+	ReasonSameSide:     0.6,
+	ReasonManySessions: 0.5,
+	ReasonFlagged:      1.0,
+	ReasonFlaggedIP:    0.5,
+}
+
+// quarantineScore is the score at which a vote is suspicious
+const quarantineScore = 1.0
+
+// ipRateMultiplier allows an IP this many times the per-session vote rate, since offices and mobile carriers
+// put many people behind one address
+const ipRateMultiplier = 5
+
+// Policy holds the thresholds votes are scored against
+type Policy struct {
+	Mode              string
+	MaxVotesPerMinute int64         // Per session; an IP may cast ipRateMultiplier times as many
+	MinVoteLatency    time.Duration // Shortest plausible time between a pair being served and voted on
+	SameSideMinVotes  int64         // Votes a session must cast before same-side voting is judged
+	SameSidePercent   int64         // Share of votes on one side that counts as same-side voting
+	MaxSessionsPerIP  int64         // Distinct sessions per IP per hour before the IP is suspicious
+	FlagTTL           time.Duration // How long a suspicious session or IP stays flagged
+}
+
+// Assessment is the verdict on a single vote
+type Assessment struct {
+	Score       float64
+	Reasons     []string
+	Suspicious  bool
+	Quarantined bool // The vote was held back and must not be recorded
+}
+
+// Detector scores votes and flags or quarantines suspicious ones
+type Detector struct {
+	valkey *storage.ValkeyClient
+	policy Policy
+}
+
+// NewDetector creates a detector; with a nil Valkey client or ModeOff every vote is accepted
+func NewDetector(valkey *storage.ValkeyClient, policy Policy) *Detector {
+	return &Detector{valkey: valkey, policy: policy}
+}
+
+// Enabled reports whether votes are scored
+func (d *Detector) Enabled() bool {
+	return d.valkey != nil && d.policy.Mode != ModeOff
+}
+
+// Check scores a vote before it is recorded
+// A suspicious vote is stored in the quarantine and Quarantined is set; the caller must then not record it
+// When the check itself fails the vote is accepted rather than lost
+func (d *Detector) Check(ctx context.Context, vote *storage.Vote, sessionID, clientIP string) *Assessment {
+	if !d.Enabled() {
+		return &Assessment{}
+	}
+
+	activity, err := d.valkey.ObserveVote(ctx, sessionID, clientIP, vote.PairID, vote.Winner)
+	if err != nil {
+		logger.WarnContext(ctx, "Accepting vote after fraud check failed", "error", err)
+		return &Assessment{}
+	}
+
+	assessment, sessionReasons, ipReasons := d.assess(activity, sessionID)
+	if !assessment.Suspicious {
+		return assessment
+	}
+
+	if sessionID != "" && len(sessionReasons) > 0 {
+		d.flag(ctx, "session:"+sessionID, sessionReasons, assessment.Score)
+	}
+	if len(ipReasons) > 0 {
+		d.flag(ctx, "ip:"+clientIP, ipReasons, assessment.Score)
+	}
+
+	logger.WarnContext(ctx, "Suspicious vote", "pair_id", vote.PairID, "session_id", sessionID, "client_ip", clientIP,
+		"score", assessment.Score, "reasons", assessment.Reasons)
+
+	vote.Timestamp = time.Now()
+	quarantined := &storage.QuarantinedVote{
+		ID:            uuid.New().String(),
+		Vote:          *vote,
+		SessionID:     sessionID,
+		ClientIP:      clientIP,
+		Score:         assessment.Score,
+		Reasons:       assessment.Reasons,
+		QuarantinedAt: vote.Timestamp,
+	}
+	if err := d.valkey.QuarantineVote(ctx, quarantined); err != nil {
+		logger.WarnContext(ctx, "Accepting suspicious vote after quarantine failed", "error", err)
+		return assessment
+	}
+
+	assessment.Quarantined = true
+	return assessment
+}
+
+// assess scores a voter's activity, returning the reasons that implicate the session and the IP separately
+func (d *Detector) assess(activity *storage.VoteActivity, sessionID string) (*Assessment, []string, []string) {
+	var sessionReasons, ipReasons, reasons []string
+
+	if sessionID == "" {
+		reasons = append(reasons, ReasonNoSession)
+	} else {
+		if activity.SessionVotesLastMinute > d.policy.MaxVotesPerMinute {
+			sessionReasons = append(sessionReasons, ReasonVoteRate)
+		}
+		if activity.ServedAt == nil {
+			sessionReasons = append(sessionReasons, ReasonUnservedPair)
+		} else if time.Since(*activity.ServedAt) < d.policy.MinVoteLatency {
+			sessionReasons = append(sessionReasons, ReasonTooFast)
+		}
+		if sameSide(activity, d.policy.SameSideMinVotes, d.policy.SameSidePercent) {
+			sessionReasons = append(sessionReasons, ReasonSameSide)
+		}
+	}
+
+	if activity.IPVotesLastMinute > d.policy.MaxVotesPerMinute*ipRateMultiplier {
+		ipReasons = append(ipReasons, ReasonIPVoteRate)
+	}
+	if activity.IPSessions > d.policy.MaxSessionsPerIP {
+		ipReasons = append(ipReasons, ReasonManySessions)
+	}
+
+	reasons = append(reasons, sessionReasons...)
+	reasons = append(reasons, ipReasons...)
+	if activity.SessionFlag != nil {
+		reasons = append(reasons, ReasonFlagged)
+	}
+	if activity.IPFlag != nil {
+		reasons = append(reasons, ReasonFlaggedIP)
+	}
+
+	assessment := &Assessment{Reasons: reasons}
+	for _, reason := range reasons {
+		assessment.Score += weights[reason]
+	}
+	assessment.Suspicious = assessment.Score >= quarantineScore
+
+	return assessment, sessionReasons, ipReasons
+}
+
+// flag marks a subject as suspicious for the policy's flag TTL
+func (d *Detector) flag(ctx context.Context, subject string, reasons []string, score float64) {
+	now := time.Now().UTC()
+	flag := &storage.FraudFlag{
+		Subject:   subject,
+		Reasons:   reasons,
+		Score:     score,
+		FlaggedAt: now,
+		ExpiresAt: now.Add(d.policy.FlagTTL),
+	}
+	if err := d.valkey.FlagSubject(ctx, flag); err != nil {
+		logger.WarnContext(ctx, "Failed to flag subject", "subject", subject, "error", err)
+	}
+}
+
+// sameSide reports whether a session with enough votes has picked one side at least percent of the time
+func sameSide(activity *storage.VoteActivity, minVotes, percent int64) bool {
+	total := activity.SessionLeft + activity.SessionRight
+	if total < minVotes || total == 0 {
+		return false
+	}
+
+	most := activity.SessionLeft
+	if activity.SessionRight > most {
+		most = activity.SessionRight
+	}
+	return most*100 >= total*percent
+}
@@ -0,0 +1,205 @@
+package fraud
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+var testPolicy = Policy{
+	Mode:              ModeQuarantine,
+	MaxVotesPerMinute: 20,
+	MinVoteLatency:    700 * time.Millisecond,
+	SameSideMinVotes:  20,
+	SameSidePercent:   95,
+	MaxSessionsPerIP:  25,
+	FlagTTL:           24 * time.Hour,
+}
+
+func TestAssess(t *testing.T) {
+	served := time.Now().Add(-10 * time.Second)
+	justServed := time.Now()
+
+	// normal is a person voting at a human pace on a pair they were shown
+	normal := func() *storage.VoteActivity {
+		return &storage.VoteActivity{
+			SessionVotesLastMinute: 3,
+			IPVotesLastMinute:      3,
+			SessionLeft:            2,
+			SessionRight:           1,
+			IPSessions:             1,
+			ServedAt:               &served,
+		}
+	}
+
+	tests := []struct {
+		name           string
+		sessionID      string
+		change         func(*storage.VoteActivity)
+		wantReasons    []string
+		wantSuspicious bool
+		wantSession    []string
+		wantIP         []string
+	}{
+		{
+			name:      "normal vote",
+			sessionID: "s1",
+			change:    func(*storage.VoteActivity) {},
+		},
+		{
+			name:           "session vote rate",
+			sessionID:      "s1",
+			change:         func(a *storage.VoteActivity) { a.SessionVotesLastMinute = 21 },
+			wantReasons:    []string{ReasonVoteRate},
+			wantSuspicious: true,
+			wantSession:    []string{ReasonVoteRate},
+		},
+		{
+			name:           "IP vote rate allows shared networks",
+			sessionID:      "s1",
+			change:         func(a *storage.VoteActivity) { a.IPVotesLastMinute = 100 },
+			wantReasons:    nil,
+			wantSuspicious: false,
+		},
+		{
+			name:           "IP vote rate",
+			sessionID:      "s1",
+			change:         func(a *storage.VoteActivity) { a.IPVotesLastMinute = 101 },
+			wantReasons:    []string{ReasonIPVoteRate},
+			wantSuspicious: true,
+			wantIP:         []string{ReasonIPVoteRate},
+		},
+		{
+			name:        "too fast alone is not enough",
+			sessionID:   "s1",
+			change:      func(a *storage.VoteActivity) { a.ServedAt = &justServed },
+			wantReasons: []string{ReasonTooFast},
+			wantSession: []string{ReasonTooFast},
+		},
+		{
+			name:      "too fast on the same side",
+			sessionID: "s1",
+			change: func(a *storage.VoteActivity) {
+				a.ServedAt = &justServed
+				a.SessionLeft, a.SessionRight = 19, 1
+			},
+			wantReasons:    []string{ReasonTooFast, ReasonSameSide},
+			wantSuspicious: true,
+			wantSession:    []string{ReasonTooFast, ReasonSameSide},
+		},
+		{
+			name:      "unserved pair from a flagged IP",
+			sessionID: "s1",
+			change: func(a *storage.VoteActivity) {
+				a.ServedAt = nil
+				a.IPFlag = &storage.FraudFlag{Subject: "ip:192.0.2.1"}
+			},
+			wantReasons:    []string{ReasonUnservedPair, ReasonFlaggedIP},
+			wantSuspicious: true,
+			wantSession:    []string{ReasonUnservedPair},
+		},
+		{
+			name:           "flagged session",
+			sessionID:      "s1",
+			change:         func(a *storage.VoteActivity) { a.SessionFlag = &storage.FraudFlag{Subject: "session:s1"} },
+			wantReasons:    []string{ReasonFlagged},
+			wantSuspicious: true,
+		},
+		{
+			name:        "no session",
+			sessionID:   "",
+			change:      func(*storage.VoteActivity) {},
+			wantReasons: []string{ReasonNoSession},
+		},
+		{
+			name:           "no session from an IP cycling sessions",
+			sessionID:      "",
+			change:         func(a *storage.VoteActivity) { a.IPSessions = 26 },
+			wantReasons:    []string{ReasonNoSession, ReasonManySessions},
+			wantSuspicious: false,
+			wantIP:         []string{ReasonManySessions},
+		},
+		{
+			name:      "no session from a flagged IP cycling sessions",
+			sessionID: "",
+			change: func(a *storage.VoteActivity) {
+				a.IPSessions = 26
+				a.IPFlag = &storage.FraudFlag{Subject: "ip:192.0.2.1"}
+			},
+			wantReasons:    []string{ReasonNoSession, ReasonManySessions, ReasonFlaggedIP},
+			wantSuspicious: true,
+			wantIP:         []string{ReasonManySessions},
+		},
+	}
+
+	detector := NewDetector(nil, testPolicy)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activity := normal()
+			tt.change(activity)
+
+			assessment, sessionReasons, ipReasons := detector.assess(activity, tt.sessionID)
+			if !reflect.DeepEqual(assessment.Reasons, tt.wantReasons) {
+				t.Errorf("reasons = %v, want %v", assessment.Reasons, tt.wantReasons)
+			}
+			if assessment.Suspicious != tt.wantSuspicious {
+				t.Errorf("suspicious = %v with score %v, want %v", assessment.Suspicious, assessment.Score, tt.wantSuspicious)
+			}
+			if !reflect.DeepEqual(sessionReasons, tt.wantSession) || !reflect.DeepEqual(ipReasons, tt.wantIP) {
+				t.Errorf("session reasons %v and IP reasons %v, want %v and %v", sessionReasons, ipReasons, tt.wantSession, tt.wantIP)
+			}
+
+			var score float64
+			for _, reason := range tt.wantReasons {
+				score += weights[reason]
+			}
+			if assessment.Score != score {
+				t.Errorf("score = %v, want %v", assessment.Score, score)
+			}
+		})
+	}
+}
+
+func TestSameSide(t *testing.T) {
+	tests := []struct {
+		left, right int64
+		want        bool
+	}{
+		{0, 0, false},
+		{19, 0, false}, // Too few votes to judge
+		{20, 0, true},
+		{0, 20, true},
+		{19, 1, true}, // Exactly 95%
+		{18, 2, false},
+		{95, 5, true},
+		{94, 6, false},
+	}
+
+	for _, tt := range tests {
+		activity := &storage.VoteActivity{SessionLeft: tt.left, SessionRight: tt.right}
+		if got := sameSide(activity, testPolicy.SameSideMinVotes, testPolicy.SameSidePercent); got != tt.want {
+			t.Errorf("sameSide(%d left, %d right) = %v, want %v", tt.left, tt.right, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	off := testPolicy
+	off.Mode = ModeOff
+
+	for name, detector := range map[string]*Detector{
+		"off":       NewDetector(&storage.ValkeyClient{}, off),
+		"no valkey": NewDetector(nil, testPolicy),
+	} {
+		if detector.Enabled() {
+			t.Errorf("%s: detector is enabled", name)
+		}
+		vote := &storage.Vote{PairID: "pair-1", Winner: "left"}
+		if assessment := detector.Check(context.Background(), vote, "", "192.0.2.1"); assessment.Suspicious || assessment.Quarantined {
+			t.Errorf("%s: Check = %+v, want every vote accepted", name, assessment)
+		}
+	}
+}
@@ -0,0 +1,653 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/auth"
+	"cgc-lb-and-cdn-backend/internal/dataset"
+	"cgc-lb-and-cdn-backend/internal/imaging"
+	"cgc-lb-and-cdn-backend/internal/reindex"
+	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDuplicateDistance is the Hamming distance used when max_distance is not supplied
+const defaultDuplicateDistance = 5
+
+// AdminHandler handles operator-only endpoints under /api/v1/admin
+type AdminHandler struct {
+	valkeyClient    *storage.ValkeyClient
+	spacesClient    *storage.SpacesClient
+	retentionPolicy storage.RetentionPolicy
+	voteLogPolicy   storage.VoteLogPolicy
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(valkeyClient *storage.ValkeyClient, spacesClient *storage.SpacesClient, retentionPolicy storage.RetentionPolicy, voteLogPolicy storage.VoteLogPolicy) *AdminHandler {
+	return &AdminHandler{
+		valkeyClient:    valkeyClient,
+		spacesClient:    spacesClient,
+		retentionPolicy: retentionPolicy,
+		voteLogPolicy:   voteLogPolicy,
+	}
+}
+
+// GetDuplicates handles GET /admin/duplicates requests
+// With a "hash" query parameter it returns every image close to that hash;
+// without one it returns groups of near-identical images spanning multiple pairs
+// "max_distance" sets the Hamming threshold (default 5, 0 for exact matches only)
+func (h *AdminHandler) GetDuplicates(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Duplicate index unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	maxDistance := defaultDuplicateDistance
+	if param := c.Query("max_distance"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 0 || parsed > imaging.HashBits {
+			utils.RespondWithError(c, http.StatusBadRequest, "Invalid max_distance parameter", "INVALID_DISTANCE", map[string]string{
+				"max_distance": param,
+				"allowed":      "0-64",
+			})
+			return
+		}
+		maxDistance = parsed
+	}
+
+	if hashParam := c.Query("hash"); hashParam != "" {
+		hash, err := imaging.ParseHash(hashParam)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, "Invalid hash parameter", "INVALID_HASH", map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		matches, err := h.valkeyClient.FindSimilarImages(c.Request.Context(), hash, maxDistance)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, "Failed to search hash index", "DUPLICATES_ERROR", map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		utils.RespondWithSuccess(c, gin.H{
+			"hash":         hashParam,
+			"max_distance": maxDistance,
+			"matches":      matches,
+			"count":        len(matches),
+		}, "Similar images retrieved successfully", nil)
+		return
+	}
+
+	groups, err := h.valkeyClient.FindDuplicateGroups(c.Request.Context(), maxDistance)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to scan hash index", "DUPLICATES_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"max_distance": maxDistance,
+		"groups":       groups,
+		"count":        len(groups),
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	}, "Duplicate groups retrieved successfully", nil)
+}
+
+// RetirePair handles POST /admin/pairs/:id/retire requests
+func (h *AdminHandler) RetirePair(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Pair lifecycle unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	pairID := c.Param("id")
+	if err := h.valkeyClient.RetirePair(c.Request.Context(), pairID, "retired by admin"); err != nil {
+		utils.RespondWithError(c, http.StatusNotFound, "Failed to retire pair", "RETIRE_FAILED", map[string]string{
+			"pair_id": pairID,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{"pair_id": pairID}, "Pair retired successfully", nil)
+}
+
+// SweepRetiredPairs handles POST /admin/pairs/sweep requests, applying retention rules immediately
+func (h *AdminHandler) SweepRetiredPairs(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Pair lifecycle unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	retired, err := h.valkeyClient.SweepRetiredPairs(c.Request.Context(), h.retentionPolicy)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Retention sweep failed", "SWEEP_FAILED", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"retired":   retired,
+		"max_votes": h.retentionPolicy.MaxVotes,
+		"max_age":   h.retentionPolicy.MaxAge.String(),
+	}, "Retention sweep completed", nil)
+}
+
+// CompactVotes handles POST /admin/votes/compact requests, archiving old votes to Spaces immediately
+func (h *AdminHandler) CompactVotes(c *gin.Context) {
+	if h.valkeyClient == nil || h.spacesClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Vote archiving requires Valkey and Spaces", "VOTE_ARCHIVE_UNAVAILABLE", nil)
+		return
+	}
+
+	archived, err := h.valkeyClient.CompactVotes(c.Request.Context(), h.voteLogPolicy)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Vote compaction failed", "COMPACTION_FAILED", map[string]string{
+			"error":    err.Error(),
+			"archived": strconv.Itoa(archived),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"archived":   archived,
+		"hot_window": h.voteLogPolicy.HotWindow.String(),
+	}, "Vote compaction completed", nil)
+}
+
+// CheckConsistency handles GET /admin/consistency requests, reporting drift between aggregates and raw data
+func (h *AdminHandler) CheckConsistency(c *gin.Context) {
+	h.runConsistencyCheck(c, false)
+}
+
+// RepairConsistency handles POST /admin/consistency/repair requests, rewriting drifting aggregates and indexes
+func (h *AdminHandler) RepairConsistency(c *gin.Context) {
+	h.runConsistencyCheck(c, true)
+}
+
+// runConsistencyCheck runs the consistency check and responds with its report
+func (h *AdminHandler) runConsistencyCheck(c *gin.Context, repair bool) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Consistency check unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	report, err := h.valkeyClient.CheckConsistency(c.Request.Context(), repair)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Consistency check failed", "CONSISTENCY_FAILED", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	message := "Consistency check completed"
+	if report.Repaired {
+		message = "Consistency check completed and drift repaired"
+	}
+	utils.RespondWithSuccess(c, report, message, nil)
+}
+
+// DeletePair handles DELETE /admin/pairs/:id requests
+// Removes the pair's images from Spaces first, then every Valkey key and set membership that refers to it
+func (h *AdminHandler) DeletePair(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Pair lifecycle unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	pairID := c.Param("id")
+	pair, err := h.valkeyClient.GetImagePairByID(c.Request.Context(), pairID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusNotFound, "Pair not found", "PAIR_NOT_FOUND", map[string]string{
+			"pair_id": pairID,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	deletedObjects := make([]string, 0)
+	if h.spacesClient != nil {
+		for _, key := range pair.ObjectKeys() {
+			if err := h.spacesClient.DeleteObject(c.Request.Context(), key); err != nil {
+				// Keep the Valkey entries so the delete can be retried
+				utils.RespondWithError(c, http.StatusBadGateway, "Failed to delete pair images", "DELETE_FAILED", map[string]string{
+					"pair_id": pairID,
+					"object":  key,
+					"error":   err.Error(),
+				})
+				return
+			}
+			deletedObjects = append(deletedObjects, key)
+		}
+	}
+
+	if err := h.valkeyClient.DeletePair(c.Request.Context(), pair); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to delete pair", "DELETE_FAILED", map[string]string{
+			"pair_id": pairID,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"pair_id":         pairID,
+		"deleted_objects": deletedObjects,
+	}, "Pair deleted successfully", nil)
+}
+
+// Reindex handles POST /admin/reindex requests, rebuilding the pair index from the images stored in Spaces
+// "dry_run=true" reports what would be restored without writing; "workers" sets the parallelism
+func (h *AdminHandler) Reindex(c *gin.Context) {
+	if h.valkeyClient == nil || h.spacesClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Reindex requires Valkey and Spaces", "REINDEX_UNAVAILABLE", nil)
+		return
+	}
+
+	opts := reindex.Options{DryRun: c.Query("dry_run") == "true"}
+	if param := c.Query("workers"); param != "" {
+		workers, err := strconv.Atoi(param)
+		if err != nil || workers < 1 || workers > 64 {
+			utils.RespondWithError(c, http.StatusBadRequest, "Invalid workers parameter", "INVALID_WORKERS", map[string]string{
+				"workers": param,
+				"allowed": "1-64",
+			})
+			return
+		}
+		opts.Workers = workers
+	}
+
+	report, err := reindex.NewRebuilder(h.spacesClient, h.valkeyClient).Run(c.Request.Context(), opts)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Reindex failed", "REINDEX_FAILED", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, report, "Reindex completed", nil)
+}
+
+// Export handles GET /admin/export requests, streaming every pair and vote as CSV, JSONL or Parquet
+// "format" selects the encoding (default jsonl)
+func (h *AdminHandler) Export(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Export unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	format, err := dataset.ParseFormat(c.DefaultQuery("format", string(dataset.FormatJSONL)))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid format parameter", "INVALID_FORMAT", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("cgc-dataset-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so failures from here on can only be logged and the stream cut short
+	writer, err := dataset.NewWriter(format, c.Writer)
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "Export failed to start", "error", err)
+		return
+	}
+	summary, err := dataset.Export(c.Request.Context(), h.valkeyClient, writer)
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "Export failed", "pairs", summary.Pairs, "votes", summary.Votes, "error", err)
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Exported dataset", "pairs", summary.Pairs, "votes", summary.Votes, "format", format)
+}
+
+// Import handles POST /admin/import requests, restoring a dataset produced by Export from the request body
+// "format" selects the encoding (default jsonl); "force=true" imports votes into a cluster that already has some
+func (h *AdminHandler) Import(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Import unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	format, err := dataset.ParseFormat(c.DefaultQuery("format", string(dataset.FormatJSONL)))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid format parameter", "INVALID_FORMAT", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	reader, err := dataset.NewReader(format, c.Request.Body)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Failed to read dataset", "INVALID_DATASET", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	defer reader.Close()
+
+	summary, err := dataset.Import(c.Request.Context(), h.valkeyClient, reader, dataset.ImportOptions{
+		Force: c.Query("force") == "true",
+	})
+	if errors.Is(err, dataset.ErrNotEmpty) {
+		utils.RespondWithError(c, http.StatusConflict, "Target already contains votes; pass force=true to import anyway", "IMPORT_NOT_EMPTY", nil)
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Import failed", "IMPORT_FAILED", map[string]string{
+			"error":          err.Error(),
+			"imported_pairs": strconv.Itoa(summary.Pairs),
+			"imported_votes": strconv.Itoa(summary.Votes),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, summary, "Import completed", nil)
+}
+
+// CreateAPIKeyRequest is the body of POST /admin/keys
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+}
+
+// ListAPIKeys handles GET /admin/keys requests
+func (h *AdminHandler) ListAPIKeys(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "API keys unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	keys, err := h.valkeyClient.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to list API keys", "API_KEY_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"keys":  keys,
+		"count": len(keys),
+	}, "API keys retrieved successfully", nil)
+}
+
+// CreateAPIKey handles POST /admin/keys requests
+// The secret is only returned in this response
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "API keys unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid request body", "INVALID_REQUEST", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	key, secret, err := auth.IssueKey(c.Request.Context(), h.valkeyClient, req.Name, req.Scopes)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Failed to create API key", "API_KEY_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Issued API key", "key_id", key.ID, "name", key.Name, "scopes", key.Scopes)
+	utils.RespondWithSuccess(c, gin.H{
+		"key":    key,
+		"secret": secret,
+	}, "API key created; store the secret now, it cannot be retrieved again", nil)
+}
+
+// DeleteAPIKey handles DELETE /admin/keys/:id requests
+func (h *AdminHandler) DeleteAPIKey(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "API keys unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	keyID := c.Param("id")
+	deleted, err := h.valkeyClient.DeleteAPIKey(c.Request.Context(), keyID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to revoke API key", "API_KEY_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		utils.RespondWithError(c, http.StatusNotFound, "API key not found", "API_KEY_NOT_FOUND", map[string]string{
+			"key_id": keyID,
+		})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Revoked API key", "key_id", keyID)
+	utils.RespondWithSuccess(c, gin.H{"key_id": keyID}, "API key revoked", nil)
+}
+
+// GetFraudReport handles GET /admin/fraud requests, listing flagged sessions and IPs and the quarantine size
+func (h *AdminHandler) GetFraudReport(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Fraud detection unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	flags, err := h.valkeyClient.ListFraudFlags(c.Request.Context())
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to list flagged voters", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	quarantined, err := h.valkeyClient.ListQuarantinedVotes(c.Request.Context(), "")
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to list quarantined votes", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Quarantined votes per voter, so the flags worth reviewing first stand out
+	bySubject := make(map[string]int)
+	for _, vote := range quarantined {
+		if vote.SessionID != "" {
+			bySubject["session:"+vote.SessionID]++
+		}
+		bySubject["ip:"+vote.ClientIP]++
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"flags":                  flags,
+		"flag_count":             len(flags),
+		"quarantined":            len(quarantined),
+		"quarantined_by_subject": bySubject,
+		"timestamp":              time.Now().UTC().Format(time.RFC3339),
+	}, "Fraud report retrieved successfully", nil)
+}
+
+// ListQuarantinedVotes handles GET /admin/fraud/quarantine requests
+// "subject" ("session:<id>" or "ip:<address>") restricts the list to one voter
+func (h *AdminHandler) ListQuarantinedVotes(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Fraud detection unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	subject := c.Query("subject")
+	if subject != "" && !validFraudSubject(subject) {
+		utils.RespondWithError(c, http.StatusBadRequest, "Subject must be session:<id> or ip:<address>", "INVALID_SUBJECT", map[string]string{
+			"subject": subject,
+		})
+		return
+	}
+
+	votes, err := h.valkeyClient.ListQuarantinedVotes(c.Request.Context(), subject)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to list quarantined votes", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"votes": votes,
+		"count": len(votes),
+	}, "Quarantined votes retrieved successfully", nil)
+}
+
+// ReinstateQuarantinedVote handles POST /admin/fraud/quarantine/:id/reinstate requests, counting the vote after all
+func (h *AdminHandler) ReinstateQuarantinedVote(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Fraud detection unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	id := c.Param("id")
+	vote, err := h.valkeyClient.ReinstateQuarantinedVote(c.Request.Context(), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to reinstate vote", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	if vote == nil {
+		utils.RespondWithError(c, http.StatusNotFound, "Quarantined vote not found", "QUARANTINED_VOTE_NOT_FOUND", map[string]string{
+			"id": id,
+		})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Reinstated quarantined vote", "vote_id", id, "pair_id", vote.Vote.PairID)
+	utils.RespondWithSuccess(c, vote, "Vote reinstated", nil)
+}
+
+// DiscardQuarantinedVote handles DELETE /admin/fraud/quarantine/:id requests, dropping the vote for good
+func (h *AdminHandler) DiscardQuarantinedVote(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Fraud detection unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	id := c.Param("id")
+	vote, err := h.valkeyClient.DiscardQuarantinedVote(c.Request.Context(), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to discard vote", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	if vote == nil {
+		utils.RespondWithError(c, http.StatusNotFound, "Quarantined vote not found", "QUARANTINED_VOTE_NOT_FOUND", map[string]string{
+			"id": id,
+		})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Discarded quarantined vote", "vote_id", id, "pair_id", vote.Vote.PairID)
+	utils.RespondWithSuccess(c, gin.H{"id": id}, "Vote discarded", nil)
+}
+
+// ReinstateFraudSubject handles POST /admin/fraud/subjects/:subject/reinstate requests
+// It clears the voter's flag and reinstates every vote quarantined from it, for false positives
+func (h *AdminHandler) ReinstateFraudSubject(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Fraud detection unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	subject := c.Param("subject")
+	if !validFraudSubject(subject) {
+		utils.RespondWithError(c, http.StatusBadRequest, "Subject must be session:<id> or ip:<address>", "INVALID_SUBJECT", map[string]string{
+			"subject": subject,
+		})
+		return
+	}
+
+	if _, err := h.valkeyClient.ClearFraudFlag(c.Request.Context(), subject); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to clear flag", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	votes, err := h.valkeyClient.ListQuarantinedVotes(c.Request.Context(), subject)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to list quarantined votes", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	reinstated := 0
+	for _, vote := range votes {
+		restored, err := h.valkeyClient.ReinstateQuarantinedVote(c.Request.Context(), vote.ID)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, "Failed to reinstate votes", "FRAUD_ERROR", map[string]string{
+				"error":      err.Error(),
+				"reinstated": strconv.Itoa(reinstated),
+			})
+			return
+		}
+		if restored != nil {
+			reinstated++
+		}
+	}
+
+	logger.InfoContext(c.Request.Context(), "Reinstated voter", "subject", subject, "reinstated", reinstated)
+	utils.RespondWithSuccess(c, gin.H{
+		"subject":    subject,
+		"reinstated": reinstated,
+	}, "Voter reinstated", nil)
+}
+
+// ClearFraudFlag handles DELETE /admin/fraud/flags/:subject requests
+// Votes already quarantined stay quarantined; use the subject reinstate endpoint to release them too
+func (h *AdminHandler) ClearFraudFlag(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Fraud detection unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	subject := c.Param("subject")
+	cleared, err := h.valkeyClient.ClearFraudFlag(c.Request.Context(), subject)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to clear flag", "FRAUD_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !cleared {
+		utils.RespondWithError(c, http.StatusNotFound, "Flag not found", "FLAG_NOT_FOUND", map[string]string{
+			"subject": subject,
+		})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Cleared fraud flag", "subject", subject)
+	utils.RespondWithSuccess(c, gin.H{"subject": subject}, "Flag cleared", nil)
+}
+
+// validFraudSubject reports whether subject names a session or IP
+func validFraudSubject(subject string) bool {
+	kind, id, found := strings.Cut(subject, ":")
+	return found && id != "" && (kind == "session" || kind == "ip")
+}
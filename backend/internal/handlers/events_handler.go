@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/events"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeatInterval keeps idle streams alive through the load balancer and nginx, which close
+// connections that stay silent for 60 seconds
+const eventsHeartbeatInterval = 20 * time.Second
+
+// EventsHandler streams live events to browsers as Server-Sent Events
+type EventsHandler struct {
+	broker *events.Broker
+}
+
+// NewEventsHandler creates a new events handler
+func NewEventsHandler(broker *events.Broker) *EventsHandler {
+	return &EventsHandler{broker: broker}
+}
+
+// StreamEvents handles GET /events requests
+// Each event is written with its type as the SSE event name and its JSON payload as data
+func (h *EventsHandler) StreamEvents(c *gin.Context) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Streaming unsupported", "STREAMING_UNSUPPORTED", nil)
+		return
+	}
+
+	stream, unsubscribe := h.broker.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	c.Status(http.StatusOK)
+
+	// Ask EventSource to reconnect after 3s if the connection drops
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-stream:
+			if !ok {
+				return // The broker closed for shutdown; EventSource reconnects elsewhere
+			}
+			fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	broker := events.NewBroker(nil)
+	router := gin.New()
+	router.GET("/events", NewEventsHandler(broker).StreamEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for broker.Subscribers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := broker.Publish(context.Background(), events.TypeVote, map[string]string{"pair_id": "pair-1"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	// The stream ends once the broker closes
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		broker.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+
+	stream := string(body)
+	for _, want := range []string{"retry: 3000\n\n", "id: ", "event: vote\n", "data: {\"pair_id\":\"pair-1\"}\n\n"} {
+		if !strings.Contains(stream, want) {
+			t.Errorf("stream %q is missing %q", stream, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/agents"
+	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds each dependency check, well inside the load balancer's 15s response timeout
+const healthCheckTimeout = 3 * time.Second
+
+// errNotConnected is reported for a dependency that is configured but could not be reached at startup
+var errNotConnected = errors.New("configured but not connected at startup")
+
+// HealthHandler serves the liveness, readiness and health check endpoints
+// Readiness only depends on Valkey and Spaces: with every provider down, voting and serving existing pairs still work
+type HealthHandler struct {
+	orchestrator agents.OrchestratorAgent
+	valkeyClient *storage.ValkeyClient
+	dependencies []dependency
+
+	// draining is set once shutdown begins so the load balancer takes this droplet out of rotation
+	draining atomic.Bool
+}
+
+// dependency is a backing service the server cannot do its job without
+type dependency struct {
+	name  string
+	check func(ctx context.Context) error // nil when the service is not configured
+}
+
+// DependencyStatus is the outcome of checking one dependency
+type DependencyStatus struct {
+	Status    string  `json:"status"` // ok, failed or not_configured
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ProviderHealth is a provider's state as seen by the orchestrator
+type ProviderHealth struct {
+	State       string    `json:"state"` // available, unavailable, quota_exceeded, rate_limited or disabled
+	ErrorCount  int       `json:"error_count"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// NewHealthHandler creates a new health handler
+// A nil client whose service is configured fails readiness, since it failed to connect at startup;
+// one that is not configured at all is reported but does not
+func NewHealthHandler(orchestrator agents.OrchestratorAgent, valkeyClient *storage.ValkeyClient, spacesClient *storage.SpacesClient, valkeyConfigured, spacesConfigured bool) *HealthHandler {
+	h := &HealthHandler{
+		orchestrator: orchestrator,
+		valkeyClient: valkeyClient,
+	}
+
+	valkey := dependency{name: "valkey"}
+	switch {
+	case valkeyClient != nil:
+		valkey.check = valkeyClient.Ping
+	case valkeyConfigured:
+		valkey.check = func(context.Context) error { return errNotConnected }
+	}
+
+	spaces := dependency{name: "spaces"}
+	switch {
+	case spacesClient != nil:
+		spaces.check = spacesClient.Ping
+	case spacesConfigured:
+		spaces.check = func(context.Context) error { return errNotConnected }
+	}
+
+	h.dependencies = []dependency{valkey, spaces}
+	return h
+}
+
+// StartDraining makes readiness fail from now on, while requests are still served
+func (h *HealthHandler) StartDraining() {
+	h.draining.Store(true)
+}
+
+// Livez handles GET /livez requests: the process is up and serving HTTP
+func (h *HealthHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "ok",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// Readyz handles GET /readyz requests, which the load balancer uses to decide whether to route traffic here
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":    "draining",
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	dependencies, ready := h.checkDependencies(c.Request.Context())
+
+	readyStatus := "ready"
+	statusCode := http.StatusOK
+	if !ready {
+		readyStatus = "not_ready"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	c.JSON(statusCode, gin.H{
+		"status":       readyStatus,
+		"dependencies": dependencies,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// Deep handles GET /health/deep requests with every dependency's latency, provider states and pair inventory
+// It answers 503 only when not ready; providers being down or an empty inventory make it "degraded"
+func (h *HealthHandler) Deep(c *gin.Context) {
+	ctx := c.Request.Context()
+	dependencies, ready := h.checkDependencies(ctx)
+
+	providers := make(map[string]ProviderHealth)
+	availableCount := 0
+	for name, status := range h.orchestrator.GetProviderStatus() {
+		providers[name] = ProviderHealth{
+			State:       providerState(status),
+			ErrorCount:  status.ErrorCount,
+			LastError:   status.LastError,
+			LastSuccess: status.LastSuccess,
+		}
+		if status.Available && !status.Disabled {
+			availableCount++
+		}
+	}
+
+	response := gin.H{
+		"dependencies":        dependencies,
+		"providers":           providers,
+		"available_providers": availableCount,
+		"total_providers":     len(providers),
+		"draining":            h.draining.Load(),
+	}
+
+	emptyInventory := false
+	if h.valkeyClient != nil {
+		inventoryCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		inventory, err := h.valkeyClient.PairInventory(inventoryCtx)
+		cancel()
+		if err != nil {
+			response["inventory_error"] = err.Error()
+		} else {
+			response["inventory"] = inventory
+			emptyInventory = inventory["active"] == 0
+		}
+	}
+
+	healthStatus := "healthy"
+	statusCode := http.StatusOK
+	switch {
+	case !ready || h.draining.Load():
+		healthStatus = "unhealthy"
+		statusCode = http.StatusServiceUnavailable
+	case availableCount < len(providers) || emptyInventory:
+		healthStatus = "degraded"
+	}
+
+	response["status"] = healthStatus
+	response["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	c.JSON(statusCode, response)
+}
+
+// Health handles GET /health requests: a provider availability summary, kept for existing monitors
+// It fails when no provider is available, so the load balancer uses /readyz instead
+func (h *HealthHandler) Health(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":    "draining",
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	status := h.orchestrator.GetProviderStatus()
+
+	// Check if at least one provider is available
+	availableCount := 0
+	totalCount := len(status)
+
+	for _, providerStatus := range status {
+		if providerStatus.Available {
+			availableCount++
+		}
+	}
+
+	healthStatus := "healthy"
+	statusCode := http.StatusOK
+
+	if availableCount == 0 {
+		healthStatus = "unhealthy"
+		statusCode = http.StatusServiceUnavailable
+	} else if availableCount < totalCount {
+		healthStatus = "degraded"
+	}
+
+	c.JSON(statusCode, gin.H{
+		"status":              healthStatus,
+		"available_providers": availableCount,
+		"total_providers":     totalCount,
+		"timestamp":           time.Now().UTC().Format(time.RFC3339),
+		"providers":           status,
+	})
+}
+
+// checkDependencies checks every dependency concurrently and reports whether all configured ones are reachable
+func (h *HealthHandler) checkDependencies(ctx context.Context) (map[string]DependencyStatus, bool) {
+	results := make([]DependencyStatus, len(h.dependencies))
+
+	var wg sync.WaitGroup
+	for i, dep := range h.dependencies {
+		if dep.check == nil {
+			results[i] = DependencyStatus{Status: "not_configured"}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, dep dependency) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := dep.check(checkCtx)
+			results[i] = DependencyStatus{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+			}
+		}(i, dep)
+	}
+	wg.Wait()
+
+	ready := true
+	dependencies := make(map[string]DependencyStatus, len(results))
+	for i, dep := range h.dependencies {
+		dependencies[dep.name] = results[i]
+		if results[i].Status == "failed" {
+			ready = false
+		}
+	}
+	return dependencies, ready
+}
+
+// providerState condenses a provider's status flags into a single state
+func providerState(status *models.ProviderStatus) string {
+	switch {
+	case status.Disabled:
+		return "disabled"
+	case status.QuotaHit:
+		return "quota_exceeded"
+	case status.RateLimited:
+		return "rate_limited"
+	case !status.Available:
+		return "unavailable"
+	default:
+		return "available"
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cgc-lb-and-cdn-backend/internal/agents"
+	"cgc-lb-and-cdn-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeOrchestrator reports fixed provider statuses; the health checks use nothing else
+type fakeOrchestrator struct {
+	agents.OrchestratorAgent
+	status map[string]*models.ProviderStatus
+}
+
+func (f fakeOrchestrator) GetProviderStatus() map[string]*models.ProviderStatus {
+	return f.status
+}
+
+// check returns a dependency check with a fixed outcome
+func check(err error) func(context.Context) error {
+	return func(context.Context) error { return err }
+}
+
+// serveHealth calls a health endpoint and decodes its JSON response
+func serveHealth(t *testing.T, endpoint gin.HandlerFunc) (int, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/", endpoint)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %s", recorder.Body)
+	}
+	return recorder.Code, body
+}
+
+func TestNewHealthHandlerDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured bool
+		wantStatus string
+		wantReady  bool
+	}{
+		{"not configured", false, "not_configured", true},
+		{"configured but not connected", true, "failed", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealthHandler(fakeOrchestrator{}, nil, nil, tt.configured, tt.configured)
+			dependencies, ready := h.checkDependencies(context.Background())
+
+			if ready != tt.wantReady {
+				t.Errorf("ready = %v, want %v", ready, tt.wantReady)
+			}
+			for _, name := range []string{"valkey", "spaces"} {
+				if dependencies[name].Status != tt.wantStatus {
+					t.Errorf("%s = %+v, want %s", name, dependencies[name], tt.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestLivez(t *testing.T) {
+	// Liveness holds while draining and with every dependency down
+	h := NewHealthHandler(fakeOrchestrator{}, nil, nil, true, true)
+	h.StartDraining()
+
+	code, body := serveHealth(t, h.Livez)
+	if code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("Livez = %d %v, want 200 ok", code, body["status"])
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	down := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		valkey     func(context.Context) error
+		spaces     func(context.Context) error
+		draining   bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"all reachable", check(nil), check(nil), false, http.StatusOK, "ready"},
+		{"spaces not configured", check(nil), nil, false, http.StatusOK, "ready"},
+		{"valkey down", check(down), check(nil), false, http.StatusServiceUnavailable, "not_ready"},
+		{"spaces down", check(nil), check(down), false, http.StatusServiceUnavailable, "not_ready"},
+		{"draining", check(nil), check(nil), true, http.StatusServiceUnavailable, "draining"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every provider being down does not affect readiness
+			h := NewHealthHandler(fakeOrchestrator{status: map[string]*models.ProviderStatus{
+				"openai": {Name: "openai"},
+			}}, nil, nil, false, false)
+			h.dependencies = []dependency{{name: "valkey", check: tt.valkey}, {name: "spaces", check: tt.spaces}}
+			if tt.draining {
+				h.StartDraining()
+			}
+
+			code, body := serveHealth(t, h.Readyz)
+			if code != tt.wantCode || body["status"] != tt.wantStatus {
+				t.Errorf("Readyz = %d %v, want %d %s", code, body["status"], tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeep(t *testing.T) {
+	available := &models.ProviderStatus{Name: "openai", Available: true}
+	unavailable := &models.ProviderStatus{Name: "google", ErrorCount: 3, LastError: "timeout"}
+
+	tests := []struct {
+		name       string
+		providers  map[string]*models.ProviderStatus
+		valkey     error
+		draining   bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"healthy", map[string]*models.ProviderStatus{"openai": available}, nil, false, http.StatusOK, "healthy"},
+		{"provider down", map[string]*models.ProviderStatus{"openai": available, "google": unavailable}, nil, false, http.StatusOK, "degraded"},
+		{"valkey down", map[string]*models.ProviderStatus{"openai": available}, errors.New("refused"), false, http.StatusServiceUnavailable, "unhealthy"},
+		{"draining", map[string]*models.ProviderStatus{"openai": available}, nil, true, http.StatusServiceUnavailable, "unhealthy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealthHandler(fakeOrchestrator{status: tt.providers}, nil, nil, false, false)
+			h.dependencies = []dependency{{name: "valkey", check: check(tt.valkey)}}
+			if tt.draining {
+				h.StartDraining()
+			}
+
+			code, body := serveHealth(t, h.Deep)
+			if code != tt.wantCode || body["status"] != tt.wantStatus {
+				t.Errorf("Deep = %d %v, want %d %s", code, body["status"], tt.wantCode, tt.wantStatus)
+			}
+			if body["draining"] != tt.draining || body["total_providers"] != float64(len(tt.providers)) {
+				t.Errorf("body = %v, want draining %v and %d providers", body, tt.draining, len(tt.providers))
+			}
+			if tt.valkey != nil {
+				dependencies, _ := body["dependencies"].(map[string]interface{})
+				valkey, _ := dependencies["valkey"].(map[string]interface{})
+				if valkey["status"] != "failed" || valkey["error"] != tt.valkey.Error() {
+					t.Errorf("valkey = %v, want the failure reported", valkey)
+				}
+			}
+		})
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name       string
+		available  []bool
+		draining   bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"all available", []bool{true, true}, false, http.StatusOK, "healthy"},
+		{"some available", []bool{true, false}, false, http.StatusOK, "degraded"},
+		{"none available", []bool{false, false}, false, http.StatusServiceUnavailable, "unhealthy"},
+		{"draining", []bool{true, true}, true, http.StatusServiceUnavailable, "draining"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := make(map[string]*models.ProviderStatus)
+			for i, available := range tt.available {
+				name := string(rune('a' + i))
+				status[name] = &models.ProviderStatus{Name: name, Available: available}
+			}
+			h := NewHealthHandler(fakeOrchestrator{status: status}, nil, nil, false, false)
+			if tt.draining {
+				h.StartDraining()
+			}
+
+			code, body := serveHealth(t, h.Health)
+			if code != tt.wantCode || body["status"] != tt.wantStatus {
+				t.Errorf("Health = %d %v, want %d %s", code, body["status"], tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestProviderState(t *testing.T) {
+	tests := []struct {
+		status models.ProviderStatus
+		want   string
+	}{
+		{models.ProviderStatus{Available: true}, "available"},
+		{models.ProviderStatus{}, "unavailable"},
+		{models.ProviderStatus{RateLimited: true}, "rate_limited"},
+		{models.ProviderStatus{QuotaHit: true, RateLimited: true}, "quota_exceeded"},
+		{models.ProviderStatus{Available: true, Disabled: true, QuotaHit: true}, "disabled"},
+	}
+
+	for _, tt := range tests {
+		if got := providerState(&tt.status); got != tt.want {
+			t.Errorf("providerState(%+v) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
@@ -2,14 +2,22 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"cgc-lb-and-cdn-backend/internal/agents"
+	"cgc-lb-and-cdn-backend/internal/events"
+	"cgc-lb-and-cdn-backend/internal/fraud"
+	"cgc-lb-and-cdn-backend/internal/imaging"
+	"cgc-lb-and-cdn-backend/internal/logging"
 	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/ratelimit"
+	"cgc-lb-and-cdn-backend/internal/settings"
 	"cgc-lb-and-cdn-backend/internal/storage"
 	"cgc-lb-and-cdn-backend/pkg/utils"
 
@@ -17,6 +25,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// logger is shared by the HTTP handlers
+var logger = logging.Logger("handlers")
+
 // prompts contains 500 unique image generation prompts
 var prompts = []string{
 	// Animals with Jobs
@@ -546,13 +557,19 @@ var prompts = []string{
 type ImageHandler struct {
 	orchestrator agents.OrchestratorAgent
 	valkeyClient *storage.ValkeyClient
+	broker       *events.Broker
+	detector     *fraud.Detector
+	settings     *settings.Manager
 }
 
 // NewImageHandler creates a new image handler
-func NewImageHandler(orchestrator agents.OrchestratorAgent, valkeyClient *storage.ValkeyClient) *ImageHandler {
+func NewImageHandler(orchestrator agents.OrchestratorAgent, valkeyClient *storage.ValkeyClient, broker *events.Broker, detector *fraud.Detector, settingsManager *settings.Manager) *ImageHandler {
 	return &ImageHandler{
 		orchestrator: orchestrator,
 		valkeyClient: valkeyClient,
+		broker:       broker,
+		detector:     detector,
+		settings:     settingsManager,
 	}
 }
 
@@ -573,8 +590,27 @@ func (h *ImageHandler) GenerateImage(c *gin.Context) {
 		return
 	}
 
+	// Scheduled runs only go ahead while generation is enabled and the generation interval has passed
+	if req.Scheduled {
+		if err := h.settings.ClaimScheduledGeneration(c.Request.Context()); err != nil {
+			switch {
+			case errors.Is(err, settings.ErrGenerationPaused):
+				utils.RespondWithError(c, http.StatusConflict, "Scheduled generation is paused", "GENERATION_PAUSED", nil)
+			case errors.Is(err, settings.ErrGenerationNotDue):
+				utils.RespondWithError(c, http.StatusConflict, "Scheduled generation is not due yet", "GENERATION_NOT_DUE", map[string]string{
+					"interval_seconds": strconv.FormatInt(h.settings.Current().GenerationIntervalSeconds, 10),
+				})
+			default:
+				utils.RespondWithError(c, http.StatusServiceUnavailable, "Failed to check the generation schedule", "SCHEDULE_UNAVAILABLE", map[string]string{
+					"error": err.Error(),
+				})
+			}
+			return
+		}
+	}
+
 	// Set request metadata
-	requestID := uuid.New().String()
+	requestID := logging.RequestID(c.Request.Context())
 	pairID := uuid.New().String()
 	req.RequestID = requestID
 	req.PairID = pairID
@@ -582,7 +618,7 @@ func (h *ImageHandler) GenerateImage(c *gin.Context) {
 
 	// Use random prompt
 	req.Prompt = getRandomPrompt()
-	fmt.Printf("[INFO] Using random prompt: %s, pair_id: %s\n", req.Prompt, pairID)
+	logger.InfoContext(c.Request.Context(), "Using random prompt", "prompt", req.Prompt, "pair_id", pairID)
 
 	// Generate image pair (2 images: left and right)
 	result, err := h.orchestrator.Execute(c.Request.Context(), &req)
@@ -594,6 +630,20 @@ func (h *ImageHandler) GenerateImage(c *gin.Context) {
 	}
 
 	response, ok := result.(*models.ImageResponse)
+	if ok && response.Pending {
+		// The pair is stored and announced as a pair event once the provider has finished it
+		utils.RespondWithAccepted(c, gin.H{
+			"pair_id":  pairID,
+			"prompt":   req.Prompt,
+			"provider": response.Provider,
+			"status":   "pending",
+		}, "Image pair generation accepted", map[string]string{
+			"pair_id":    pairID,
+			"request_id": requestID,
+			"provider":   response.Provider,
+		})
+		return
+	}
 	if !ok || len(response.Images) < 2 {
 		utils.RespondWithError(c, http.StatusInternalServerError, "Invalid response - need 2 images", "INVALID_RESPONSE", nil)
 		return
@@ -604,24 +654,8 @@ func (h *ImageHandler) GenerateImage(c *gin.Context) {
 	rightImage := response.Images[1]
 	timestamp := time.Now()
 
-	// Store the pair in Valkey (simplified structure with pair-id only)
-	if h.valkeyClient != nil {
-		pair := &storage.ImagePair{
-			PairID:    pairID,
-			Prompt:    req.Prompt,
-			Provider:  response.Provider,
-			LeftURL:   leftImage.URL,
-			RightURL:  rightImage.URL,
-			Timestamp: timestamp,
-		}
-
-		if err := h.valkeyClient.StoreImagePair(c.Request.Context(), pair); err != nil {
-			fmt.Printf("[ERROR] Failed to store image pair: %v\n", err)
-			// Continue anyway - don't fail the request
-		} else {
-			fmt.Printf("[PAIR] Stored in Valkey - Pair: %s, Prompt: %s, Provider: %s\n", pairID, req.Prompt, response.Provider)
-		}
-	}
+	// The images are uploaded by now, so the pair is stored even if the client has gone away
+	h.storePair(context.WithoutCancel(c.Request.Context()), pairID, req.Prompt, response, timestamp)
 
 	// Return success response with both images
 	utils.RespondWithSuccess(c, gin.H{
@@ -638,6 +672,53 @@ func (h *ImageHandler) GenerateImage(c *gin.Context) {
 	})
 }
 
+// FinishPendingGeneration stores a pair whose generation a provider finished in the background, once the
+// orchestrator has accepted it; failed and near-duplicate generations are only recorded
+func (h *ImageHandler) FinishPendingGeneration(ctx context.Context, pending *storage.PendingGeneration, response *models.ImageResponse, err error) {
+	response, err = h.orchestrator.CompletePending(ctx, pending.Provider, response, err)
+	if err != nil {
+		logger.WarnContext(ctx, "Pending generation not stored", "pair_id", pending.PairID, "provider", pending.Provider, "error", err)
+		return
+	}
+	if len(response.Images) < 2 {
+		logger.ErrorContext(ctx, "Pending generation finished without 2 images", "pair_id", pending.PairID, "provider", pending.Provider)
+		return
+	}
+
+	h.storePair(ctx, pending.PairID, pending.Prompt, response, time.Now())
+}
+
+// storePair stores a generated pair in Valkey (simplified structure with pair-id only) and announces it
+func (h *ImageHandler) storePair(ctx context.Context, pairID, prompt string, response *models.ImageResponse, timestamp time.Time) {
+	if h.valkeyClient == nil {
+		return
+	}
+
+	leftImage := response.Images[0]
+	rightImage := response.Images[1]
+	pair := &storage.ImagePair{
+		PairID:    pairID,
+		Prompt:    prompt,
+		Provider:  response.Provider,
+		LeftURL:   leftImage.URL,
+		RightURL:  rightImage.URL,
+		Timestamp: timestamp,
+
+		LeftVariants:  leftImage.Variants,
+		RightVariants: rightImage.Variants,
+		LeftHash:      leftImage.PHash,
+		RightHash:     rightImage.PHash,
+	}
+
+	if err := h.valkeyClient.StoreImagePair(ctx, pair); err != nil {
+		logger.ErrorContext(ctx, "Failed to store image pair", "pair_id", pairID, "error", err)
+		// Continue anyway - don't fail the request
+		return
+	}
+	logger.InfoContext(ctx, "Stored image pair", "pair_id", pairID, "provider", response.Provider)
+	h.broker.PublishPair(ctx, pair)
+}
+
 // GetProviderStatus handles GET /status requests
 func (h *ImageHandler) GetProviderStatus(c *gin.Context) {
 	// Check if quota refresh is requested
@@ -660,55 +741,20 @@ func (h *ImageHandler) refreshAllProviderQuotas(ctx context.Context) {
 	providerStatus := h.orchestrator.GetProviderStatus()
 
 	for providerName := range providerStatus {
-		fmt.Printf("[STATUS] Refreshing quota for provider: %s\n", providerName)
-
 		provider, exists := h.orchestrator.GetProvider(providerName)
 		if !exists {
-			fmt.Printf("[STATUS] Provider %s not found\n", providerName)
+			logger.WarnContext(ctx, "Provider not found", "provider", providerName)
 			continue
 		}
 
 		if err := provider.RefreshQuota(ctx); err != nil {
-			fmt.Printf("[STATUS] Failed to refresh quota for %s: %v\n", providerName, err)
+			logger.WarnContext(ctx, "Failed to refresh quota", "provider", providerName, "error", err)
 		} else {
-			fmt.Printf("[STATUS] Successfully refreshed quota for %s\n", providerName)
+			logger.InfoContext(ctx, "Refreshed quota", "provider", providerName)
 		}
 	}
 }
 
-// HealthCheck handles GET /health requests
-func (h *ImageHandler) HealthCheck(c *gin.Context) {
-	status := h.orchestrator.GetProviderStatus()
-
-	// Check if at least one provider is available
-	availableCount := 0
-	totalCount := len(status)
-
-	for _, providerStatus := range status {
-		if providerStatus.Available {
-			availableCount++
-		}
-	}
-
-	healthStatus := "healthy"
-	statusCode := http.StatusOK
-
-	if availableCount == 0 {
-		healthStatus = "unhealthy"
-		statusCode = http.StatusServiceUnavailable
-	} else if availableCount < totalCount {
-		healthStatus = "degraded"
-	}
-
-	c.JSON(statusCode, gin.H{
-		"status":              healthStatus,
-		"available_providers": availableCount,
-		"total_providers":     totalCount,
-		"timestamp":           time.Now().UTC().Format(time.RFC3339),
-		"providers":           status,
-	})
-}
-
 // GetImagePair handles GET /images/pair requests
 // Supports optional "exclude" query parameter with comma-separated pair IDs
 // Supports optional "session_id" query parameter for session-based tracking
@@ -773,6 +819,11 @@ func (h *ImageHandler) GetImagePair(c *gin.Context) {
 		Provider: pair.Provider,
 		LeftURL:  pair.LeftURL,
 		RightURL: pair.RightURL,
+
+		LeftSrcset:       imaging.Srcset(pair.LeftVariants),
+		RightSrcset:      imaging.Srcset(pair.RightVariants),
+		LeftPlaceholder:  pair.LeftVariants[imaging.PlaceholderVariant],
+		RightPlaceholder: pair.RightVariants[imaging.PlaceholderVariant],
 	}
 
 	utils.RespondWithSuccess(c, response, "Image pair retrieved successfully", nil)
@@ -801,7 +852,7 @@ func (h *ImageHandler) SubmitRating(c *gin.Context) {
 		pair, err := h.valkeyClient.GetImagePairByID(c.Request.Context(), req.PairID)
 		if err != nil {
 			// Pair not found - still record the vote but without provider/prompt
-			fmt.Printf("[WARN] Could not fetch pair %s for vote metadata: %v\n", req.PairID, err)
+			logger.WarnContext(c.Request.Context(), "Could not fetch pair for vote metadata", "pair_id", req.PairID, "error", err)
 		}
 
 		vote := &storage.Vote{
@@ -817,11 +868,17 @@ func (h *ImageHandler) SubmitRating(c *gin.Context) {
 			vote.Prompt = pair.Prompt
 		}
 
-		if err := h.valkeyClient.RecordVote(c.Request.Context(), vote); err != nil {
-			fmt.Printf("[ERROR] Failed to record vote in Valkey: %v\n", err)
+		// Suspicious votes may be quarantined instead of recorded; the voter gets the usual response either way
+		// so bots learn nothing from it
+		assessment := h.detector.Check(c.Request.Context(), vote, ratelimit.SessionID(c), c.ClientIP())
+		if assessment.Quarantined {
+			logger.InfoContext(c.Request.Context(), "Vote quarantined", "pair_id", req.PairID, "winner", req.Winner, "reasons", assessment.Reasons)
+		} else if err := h.valkeyClient.RecordVote(c.Request.Context(), vote); err != nil {
+			logger.ErrorContext(c.Request.Context(), "Failed to record vote", "pair_id", req.PairID, "error", err)
 			// Continue anyway - don't fail the request if Valkey is down
 		} else {
-			fmt.Printf("[VOTE] Recorded in Valkey - Pair: %s, Winner: %s, Provider: %s\n", req.PairID, req.Winner, vote.Provider)
+			logger.InfoContext(c.Request.Context(), "Vote recorded", "pair_id", req.PairID, "winner", req.Winner, "provider", vote.Provider)
+			h.broker.PublishVote(c.Request.Context(), vote)
 		}
 	}
 
@@ -929,3 +986,41 @@ func (h *ImageHandler) GetWinners(c *gin.Context) {
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	}, fmt.Sprintf("%s winners retrieved successfully", strings.Title(side)), nil)
 }
+
+// GetArchivedPairs handles GET /images/archive requests
+// Supports optional "offset" and "limit" query parameters (default 0 and 50, limit capped at 200)
+func (h *ImageHandler) GetArchivedPairs(c *gin.Context) {
+	if h.valkeyClient == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Archive unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid offset parameter", "INVALID_OFFSET", nil)
+		return
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit <= 0 || limit > 200 {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid limit parameter", "INVALID_LIMIT", map[string]string{
+			"allowed": "1-200",
+		})
+		return
+	}
+
+	pairs, total, err := h.valkeyClient.GetArchivedPairs(c.Request.Context(), offset, limit)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to get archived pairs", "ARCHIVE_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"pairs":  pairs,
+		"count":  len(pairs),
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	}, "Archived pairs retrieved successfully", nil)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/agents"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/settings"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxProviderErrors caps the limit parameter of GET /admin/providers/:name/errors (providers keep 50)
+const maxProviderErrors = 50
+
+// ProviderHandler lets operators act on a single provider under /api/v1/admin/providers/:name
+// Enabling, disabling and resetting go through the runtime settings, so they reach every instance and are audited;
+// quota refreshes, test generations and the error history concern the instance that serves the request
+type ProviderHandler struct {
+	orchestrator agents.OrchestratorAgent
+	settings     *settings.Manager
+	instance     string
+}
+
+// providerTestRequest is the optional body of POST /admin/providers/:name/test
+type providerTestRequest struct {
+	Prompt string `json:"prompt"` // A random prompt is used when empty
+}
+
+// NewProviderHandler creates a new provider handler
+func NewProviderHandler(orchestrator agents.OrchestratorAgent, settingsManager *settings.Manager) *ProviderHandler {
+	instance, _ := os.Hostname()
+	return &ProviderHandler{
+		orchestrator: orchestrator,
+		settings:     settingsManager,
+		instance:     instance,
+	}
+}
+
+// GetProvider handles GET /admin/providers/:name requests with the provider's status, quota and settings
+func (h *ProviderHandler) GetProvider(c *gin.Context) {
+	name, provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"status":   h.orchestrator.GetProviderStatus()[name],
+		"quota":    provider.GetStatus().QuotaInfo,
+		"settings": h.settings.Current().Provider(name),
+	}, "Provider retrieved successfully", map[string]string{
+		"instance": h.instance,
+	})
+}
+
+// EnableProvider handles POST /admin/providers/:name/enable requests
+func (h *ProviderHandler) EnableProvider(c *gin.Context) {
+	h.setEnabled(c, true)
+}
+
+// DisableProvider handles POST /admin/providers/:name/disable requests
+// A disabled provider is never selected for generation until it is enabled again
+func (h *ProviderHandler) DisableProvider(c *gin.Context) {
+	h.setEnabled(c, false)
+}
+
+// ResetProvider handles POST /admin/providers/:name/reset requests
+// Every instance clears the provider's last error, error count and quota/rate-limit flags within the settings
+// poll interval, returning it to rotation
+func (h *ProviderHandler) ResetProvider(c *gin.Context) {
+	name, _, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	updated, entry, err := h.settings.ResetProvider(c.Request.Context(), settingsActor(c), name)
+	if err != nil {
+		respondWithSettingsError(c, err)
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"provider": name,
+		"change":   entry,
+	}, "Provider reset", map[string]string{
+		"version": strconv.FormatInt(updated.Version, 10),
+	})
+}
+
+// RefreshQuota handles POST /admin/providers/:name/quota/refresh requests
+// Providers without a quota API report "supported": false
+func (h *ProviderHandler) RefreshQuota(c *gin.Context) {
+	name, provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	if err := provider.RefreshQuota(c.Request.Context()); err != nil {
+		utils.RespondWithError(c, http.StatusBadGateway, "Failed to refresh quota", "QUOTA_REFRESH_FAILED", map[string]string{
+			"provider": name,
+			"error":    err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"provider": name,
+		"quota":    provider.GetStatus().QuotaInfo,
+	}, "Quota refreshed", map[string]string{
+		"instance": h.instance,
+	})
+}
+
+// TestProvider handles POST /admin/providers/:name/test requests
+// It generates one pair with this provider alone, even while it is disabled, reports the result and deletes the
+// images again, so nothing enters rotation; failures show up in the provider's error history
+// A provider that is out of quota or rate limited has to be reset before it can be tested
+func (h *ProviderHandler) TestProvider(c *gin.Context) {
+	name, provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	var body providerTestRequest
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid request body", "INVALID_REQUEST", map[string]string{
+			"validation_error": err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	req := &models.ImageRequest{
+		Prompt:    strings.TrimSpace(body.Prompt),
+		RequestID: logging.RequestID(ctx),
+		PairID:    uuid.New().String(),
+		Timestamp: time.Now(),
+	}
+	if req.Prompt == "" {
+		req.Prompt = getRandomPrompt()
+	}
+
+	start := time.Now()
+	response, err := h.orchestrator.TestProvider(ctx, name, req)
+	duration := time.Since(start)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadGateway, "Provider test failed", "PROVIDER_TEST_FAILED", map[string]string{
+			"provider":    name,
+			"error":       err.Error(),
+			"duration_ms": strconv.FormatInt(duration.Milliseconds(), 10),
+		})
+		return
+	}
+	provider.RollbackImages(ctx, response.Images)
+
+	images := make([]gin.H, 0, len(response.Images))
+	for _, image := range response.Images {
+		images = append(images, gin.H{
+			"size":     image.Size,
+			"sha256":   image.SHA256,
+			"phash":    image.PHash,
+			"variants": len(image.Variants),
+		})
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"provider":    name,
+		"pair_id":     req.PairID,
+		"prompt":      req.Prompt,
+		"images":      images,
+		"duration_ms": duration.Milliseconds(),
+	}, "Provider test succeeded", map[string]string{
+		"instance": h.instance,
+	})
+}
+
+// GetProviderErrors handles GET /admin/providers/:name/errors requests
+// Supports an optional "limit" query parameter (default 20, capped at 50)
+func (h *ProviderHandler) GetProviderErrors(c *gin.Context) {
+	name, provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > maxProviderErrors {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid limit parameter", "INVALID_LIMIT", map[string]string{
+			"allowed": "1-" + strconv.Itoa(maxProviderErrors),
+		})
+		return
+	}
+
+	errs := provider.RecentErrors(limit)
+	utils.RespondWithSuccess(c, gin.H{
+		"provider": name,
+		"errors":   errs,
+		"count":    len(errs),
+	}, "Provider errors retrieved successfully", map[string]string{
+		"instance": h.instance,
+	})
+}
+
+// setEnabled turns a provider on or off on every instance through the runtime settings
+func (h *ProviderHandler) setEnabled(c *gin.Context, enabled bool) {
+	name, _, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	updated, entry, err := h.settings.Update(c.Request.Context(), settingsActor(c), settings.Patch{
+		Providers: map[string]settings.ProviderPatch{name: {Enabled: &enabled}},
+	})
+	if err != nil {
+		respondWithSettingsError(c, err)
+		return
+	}
+
+	message := "Provider disabled"
+	if enabled {
+		message = "Provider enabled"
+	}
+	if entry == nil {
+		message += " (unchanged)"
+	}
+	utils.RespondWithSuccess(c, gin.H{
+		"provider": name,
+		"settings": updated.Provider(name),
+		"change":   entry,
+	}, message, map[string]string{
+		"version": strconv.FormatInt(updated.Version, 10),
+	})
+}
+
+// provider looks up the provider named in the path, responding with 404 if there is none
+func (h *ProviderHandler) provider(c *gin.Context) (string, agents.ImageProvider, bool) {
+	name := c.Param("name")
+	provider, exists := h.orchestrator.GetProvider(name)
+	if !exists {
+		utils.RespondWithError(c, http.StatusNotFound, "Provider not found", "PROVIDER_NOT_FOUND", map[string]string{
+			"provider": name,
+		})
+		return "", nil, false
+	}
+	return name, provider, true
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/agents"
+	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/settings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubProvider generates a fixed pair, or fails with err, and records what it was asked to roll back
+type stubProvider struct {
+	err        error
+	quotaErr   error
+	errors     []models.ProviderErrorRecord
+	rolledBack int
+}
+
+func (s *stubProvider) Generate(ctx context.Context, req *models.ImageRequest) (*models.ImageResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &models.ImageResponse{
+		Provider: "stub",
+		Success:  true,
+		Images:   []models.GeneratedImage{{ID: req.PairID, Size: 10}, {ID: req.PairID, Size: 20}},
+	}, nil
+}
+
+func (s *stubProvider) GetStatus() *models.ProviderStatus {
+	return &models.ProviderStatus{Name: "stub", Available: true}
+}
+
+func (s *stubProvider) GetName() string                        { return "stub" }
+func (s *stubProvider) IsAvailable() bool                      { return true }
+func (s *stubProvider) RefreshQuota(ctx context.Context) error { return s.quotaErr }
+func (s *stubProvider) ResetStatus()                           {}
+
+func (s *stubProvider) HandleError(err error) *models.ProviderError {
+	return &models.ProviderError{Provider: "stub", Code: "UNKNOWN_ERROR", Message: err.Error()}
+}
+
+func (s *stubProvider) RecentErrors(limit int) []models.ProviderErrorRecord {
+	return s.errors[:min(limit, len(s.errors))]
+}
+
+func (s *stubProvider) RollbackImages(ctx context.Context, images []models.GeneratedImage) {
+	s.rolledBack += len(images)
+}
+
+func TestProviderHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		err          error
+		quotaErr     error
+		wantCode     int
+		wantError    string
+		wantRollback int
+	}{
+		{"get", http.MethodGet, "/providers/stub", "", nil, nil, http.StatusOK, "", 0},
+		{"unknown provider", http.MethodGet, "/providers/other", "", nil, nil, http.StatusNotFound, "PROVIDER_NOT_FOUND", 0},
+		{"disable without Valkey", http.MethodPost, "/providers/stub/disable", "", nil, nil, http.StatusServiceUnavailable, "VALKEY_UNAVAILABLE", 0},
+		{"enable without Valkey", http.MethodPost, "/providers/stub/enable", "", nil, nil, http.StatusServiceUnavailable, "VALKEY_UNAVAILABLE", 0},
+		{"reset without Valkey", http.MethodPost, "/providers/stub/reset", "", nil, nil, http.StatusServiceUnavailable, "VALKEY_UNAVAILABLE", 0},
+		{"refresh quota", http.MethodPost, "/providers/stub/quota/refresh", "", nil, nil, http.StatusOK, "", 0},
+		{"refresh quota fails", http.MethodPost, "/providers/stub/quota/refresh", "", nil, errors.New("401"), http.StatusBadGateway, "QUOTA_REFRESH_FAILED", 0},
+		{"test with a random prompt", http.MethodPost, "/providers/stub/test", "", nil, nil, http.StatusOK, "", 2},
+		{"test with a prompt", http.MethodPost, "/providers/stub/test", `{"prompt":"a fox"}`, nil, nil, http.StatusOK, "", 2},
+		{"test with a bad body", http.MethodPost, "/providers/stub/test", `{"prompt":`, nil, nil, http.StatusBadRequest, "INVALID_REQUEST", 0},
+		{"test fails", http.MethodPost, "/providers/stub/test", "", errors.New("timeout"), nil, http.StatusBadGateway, "PROVIDER_TEST_FAILED", 0},
+		{"errors", http.MethodGet, "/providers/stub/errors?limit=1", "", nil, nil, http.StatusOK, "", 0},
+		{"errors limit too high", http.MethodGet, "/providers/stub/errors?limit=51", "", nil, nil, http.StatusBadRequest, "INVALID_LIMIT", 0},
+		{"errors limit not a number", http.MethodGet, "/providers/stub/errors?limit=all", "", nil, nil, http.StatusBadRequest, "INVALID_LIMIT", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &stubProvider{err: tt.err, quotaErr: tt.quotaErr, errors: []models.ProviderErrorRecord{
+				{At: time.Now(), Code: "RATE_LIMITED", Message: "429"},
+				{At: time.Now(), Code: "UNKNOWN_ERROR", Message: "timeout"},
+			}}
+			orchestrator := agents.NewImageOrchestrator()
+			orchestrator.RegisterProvider(provider)
+			h := NewProviderHandler(orchestrator, settings.NewManager(nil, []string{"stub"}, time.Minute))
+
+			router := gin.New()
+			router.GET("/providers/:name", h.GetProvider)
+			router.POST("/providers/:name/enable", h.EnableProvider)
+			router.POST("/providers/:name/disable", h.DisableProvider)
+			router.POST("/providers/:name/reset", h.ResetProvider)
+			router.POST("/providers/:name/quota/refresh", h.RefreshQuota)
+			router.POST("/providers/:name/test", h.TestProvider)
+			router.GET("/providers/:name/errors", h.GetProviderErrors)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantCode, recorder.Body)
+			}
+			var body struct {
+				Code string                 `json:"code"`
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response is not JSON: %s", recorder.Body)
+			}
+			if body.Code != tt.wantError {
+				t.Errorf("error code = %q, want %q", body.Code, tt.wantError)
+			}
+			// Test generations never stay in Spaces
+			if provider.rolledBack != tt.wantRollback {
+				t.Errorf("rolled back %d images, want %d", provider.rolledBack, tt.wantRollback)
+			}
+			if strings.HasSuffix(tt.path, "limit=1") && body.Data["count"] != float64(1) {
+				t.Errorf("errors response = %v, want one record", body.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/ratelimit"
+	"cgc-lb-and-cdn-backend/internal/rooms"
+	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// roomPingInterval keeps idle room connections alive through the load balancer and nginx
+	roomPingInterval = 20 * time.Second
+
+	// roomPongTimeout drops connections whose client stopped answering pings
+	roomPongTimeout = 2 * roomPingInterval
+
+	// roomWriteTimeout bounds a single write to a slow client
+	roomWriteTimeout = 10 * time.Second
+
+	// roomMaxMessageSize caps incoming commands, which are tiny
+	roomMaxMessageSize = 1024
+)
+
+// RoomHandler runs multiplayer battle rooms over WebSocket
+type RoomHandler struct {
+	manager  *rooms.Manager
+	upgrader websocket.Upgrader
+
+	// Connections are hijacked, so http.Server.Shutdown neither closes nor waits for them; Close does
+	ctx         context.Context
+	cancel      context.CancelFunc
+	connections sync.WaitGroup
+}
+
+// NewRoomHandler creates a new room handler; manager is nil when Valkey is unavailable
+func NewRoomHandler(manager *rooms.Manager) *RoomHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RoomHandler{
+		manager: manager,
+		ctx:     ctx,
+		cancel:  cancel,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Rooms carry no credentials and the API allows any origin (see corsMiddleware)
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// CreateRoom handles POST /rooms requests
+func (h *RoomHandler) CreateRoom(c *gin.Context) {
+	if h.manager == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Rooms unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	room, err := h.manager.Create(c.Request.Context())
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to create room", "ROOM_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"room_id":    room.RoomID,
+		"host_token": room.HostToken,
+		"created_at": room.CreatedAt.Format(time.RFC3339),
+	}, "Room created", nil)
+}
+
+// GetRoom handles GET /rooms/:id requests
+func (h *RoomHandler) GetRoom(c *gin.Context) {
+	if h.manager == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Rooms unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	view, err := h.manager.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondWithRoomError(c, err)
+		return
+	}
+
+	utils.RespondWithSuccess(c, view, "Room retrieved", nil)
+}
+
+// Connect handles GET /rooms/:id/ws requests, upgrading to a WebSocket that joins the room
+// The connection is the participant: closing it leaves the room
+func (h *RoomHandler) Connect(c *gin.Context) {
+	if h.manager == nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Rooms unavailable", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+
+	roomID := c.Param("id")
+	if _, err := h.manager.Get(c.Request.Context(), roomID); err != nil {
+		respondWithRoomError(c, err)
+		return
+	}
+
+	if h.ctx.Err() != nil {
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Server shutting down", "SHUTTING_DOWN", nil)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // The upgrader has already replied with an HTTP error
+	}
+	defer conn.Close()
+
+	h.connections.Add(1)
+	defer h.connections.Done()
+
+	// The request context is not cancelled when a hijacked connection closes; only its request ID is carried over
+	ctx, cancel := context.WithCancel(logging.WithRequestID(h.ctx, logging.RequestID(c.Request.Context())))
+	defer cancel()
+
+	// Subscribe before joining so this participant sees its own join
+	updates, closeUpdates := h.manager.Subscribe(ctx, roomID)
+	defer closeUpdates()
+
+	participantID, err := h.manager.Join(ctx, roomID, c.Query("name"), c.Query("host_token"), ratelimit.SessionID(c), c.ClientIP())
+	if err != nil {
+		conn.WriteJSON(rooms.Message{Type: rooms.MessageError, Error: err.Error()})
+		return
+	}
+	defer func() {
+		if err := h.manager.Leave(context.Background(), roomID, participantID); err != nil && !errors.Is(err, storage.ErrRoomNotFound) {
+			logger.WarnContext(ctx, "Failed to leave room", "room_id", roomID, "error", err)
+		}
+	}()
+
+	// Only the writer goroutine writes to the connection
+	outgoing := make(chan rooms.Message, 8)
+	go h.writeLoop(ctx, conn, updates, outgoing)
+
+	view, err := h.manager.Get(ctx, roomID)
+	if err == nil {
+		outgoing <- rooms.Message{Type: rooms.MessageWelcome, ParticipantID: participantID, Room: view}
+	}
+
+	conn.SetReadLimit(roomMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(roomPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(roomPongTimeout))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return // Closed, timed out or broken
+		}
+
+		var cmd rooms.Command
+		err = json.Unmarshal(data, &cmd)
+		if err == nil {
+			err = h.manager.Handle(ctx, roomID, participantID, cmd)
+		}
+		if err != nil {
+			select {
+			case outgoing <- rooms.Message{Type: rooms.MessageError, Error: err.Error()}:
+			default: // The client is not keeping up; drop the error
+			}
+		}
+	}
+}
+
+// writeLoop forwards room updates and direct messages to the connection and pings it until ctx is cancelled
+func (h *RoomHandler) writeLoop(ctx context.Context, conn *websocket.Conn, updates <-chan string, outgoing <-chan rooms.Message) {
+	ping := time.NewTicker(roomPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			if h.ctx.Err() != nil {
+				// Shutting down: tell the client to reconnect, which the load balancer sends to another droplet
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+					time.Now().Add(roomWriteTimeout))
+				conn.Close()
+			}
+			return
+		case payload, ok := <-updates:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(roomWriteTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, []byte(payload))
+		case msg := <-outgoing:
+			conn.SetWriteDeadline(time.Now().Add(roomWriteTimeout))
+			err = conn.WriteJSON(msg)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(roomWriteTimeout))
+		}
+
+		if err != nil {
+			conn.Close() // Unblocks the read loop, which leaves the room
+			return
+		}
+	}
+}
+
+// Close disconnects every room participant and waits until they have left their rooms or ctx expires
+func (h *RoomHandler) Close(ctx context.Context) error {
+	h.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		h.connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// respondWithRoomError maps room lookup errors to HTTP responses
+func respondWithRoomError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrRoomNotFound) {
+		utils.RespondWithError(c, http.StatusNotFound, "Room not found", "ROOM_NOT_FOUND", nil)
+		return
+	}
+
+	utils.RespondWithError(c, http.StatusInternalServerError, "Failed to get room", "ROOM_ERROR", map[string]string{
+		"error": err.Error(),
+	})
+}
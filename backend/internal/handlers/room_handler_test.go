@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/rooms"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomHandlerCloseDisconnectsParticipants(t *testing.T) {
+	h := NewRoomHandler(nil)
+
+	// Stands in for Connect past the room lookup, which needs Valkey
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		h.connections.Add(1)
+		defer h.connections.Done()
+
+		ctx, cancel := context.WithCancel(h.ctx)
+		defer cancel()
+		h.writeLoop(ctx, conn, make(chan string), make(chan rooms.Message))
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The client is told to reconnect rather than seeing the connection drop
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("ReadMessage error = %v, want a going away close", err)
+	}
+}
+
+func TestRoomHandlerClose(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections int
+		wantErr     error
+	}{
+		{"no participants", 0, nil},
+		{"participant still leaving", 1, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewRoomHandler(nil)
+			h.connections.Add(tt.connections)
+			defer h.connections.Add(-tt.connections)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := h.Close(ctx); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Close = %v, want %v", err, tt.wantErr)
+			}
+			if h.ctx.Err() == nil {
+				t.Error("Close left new connections allowed")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cgc-lb-and-cdn-backend/internal/auth"
+	"cgc-lb-and-cdn-backend/internal/settings"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingsHandler lets operators view and change the runtime settings under /api/v1/admin/settings
+type SettingsHandler struct {
+	manager *settings.Manager
+}
+
+// NewSettingsHandler creates a new settings handler
+func NewSettingsHandler(manager *settings.Manager) *SettingsHandler {
+	return &SettingsHandler{manager: manager}
+}
+
+// GetSettings handles GET /admin/settings requests
+func (h *SettingsHandler) GetSettings(c *gin.Context) {
+	utils.RespondWithSuccess(c, h.manager.Current(), "Runtime settings retrieved successfully", nil)
+}
+
+// UpdateSettings handles PATCH /admin/settings requests
+// Only the fields present in the body are changed; the change is recorded in the audit trail and
+// reaches every instance within the settings poll interval
+func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
+	var patch settings.Patch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid request body", "INVALID_REQUEST", map[string]string{
+			"validation_error": err.Error(),
+		})
+		return
+	}
+
+	updated, entry, err := h.manager.Update(c.Request.Context(), settingsActor(c), patch)
+	if err != nil {
+		respondWithSettingsError(c, err)
+		return
+	}
+
+	message := "Runtime settings updated successfully"
+	if entry == nil {
+		message = "Runtime settings unchanged"
+	}
+	utils.RespondWithSuccess(c, gin.H{
+		"settings": updated,
+		"change":   entry,
+	}, message, map[string]string{
+		"version": strconv.FormatInt(updated.Version, 10),
+	})
+}
+
+// GetSettingsAudit handles GET /admin/settings/audit requests
+// Supports an optional "limit" query parameter (default 50, capped at 1000)
+func (h *SettingsHandler) GetSettingsAudit(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit <= 0 || limit > 1000 {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid limit parameter", "INVALID_LIMIT", map[string]string{
+			"allowed": "1-1000",
+		})
+		return
+	}
+
+	entries, err := h.manager.History(c.Request.Context(), limit)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to get settings audit", "SETTINGS_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{
+		"changes": entries,
+		"count":   len(entries),
+	}, "Settings audit retrieved successfully", nil)
+}
+
+// respondWithSettingsError maps errors from settings changes to HTTP responses
+func respondWithSettingsError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, settings.ErrInvalidSettings):
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_SETTINGS", nil)
+	case errors.Is(err, settings.ErrUnavailable):
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Runtime settings unavailable", "VALKEY_UNAVAILABLE", nil)
+	default:
+		utils.RespondWithError(c, http.StatusInternalServerError, "Failed to update runtime settings", "SETTINGS_ERROR", map[string]string{
+			"error": err.Error(),
+		})
+	}
+}
+
+// settingsActor names the caller in the audit trail by API key name and ID
+func settingsActor(c *gin.Context) string {
+	principal := auth.PrincipalFromContext(c)
+	if principal == nil {
+		return "unknown"
+	}
+	if principal.KeyID != "" {
+		return principal.Name + " (" + principal.KeyID + ")"
+	}
+	return principal.Name
+}
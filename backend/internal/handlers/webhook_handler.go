@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"cgc-lb-and-cdn-backend/internal/providers"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCallbackBytes caps webhook bodies; a completion callback is a few kilobytes
+const maxCallbackBytes = 1 << 20
+
+// WebhookHandler receives callbacks from image providers
+// Any droplet may receive a callback; the generation it is for is claimed from Valkey and finished right here
+type WebhookHandler struct {
+	leonardo       *providers.LeonardoAIProvider
+	leonardoSecret string
+}
+
+// NewWebhookHandler creates a new webhook handler
+// Leonardo callbacks are refused while leonardoSecret is empty or leonardo is nil
+func NewWebhookHandler(leonardo *providers.LeonardoAIProvider, leonardoSecret string) *WebhookHandler {
+	return &WebhookHandler{
+		leonardo:       leonardo,
+		leonardoSecret: leonardoSecret,
+	}
+}
+
+// LeonardoCallback handles POST /webhooks/leonardo requests, sent by Leonardo when a generation finishes
+// The callback must carry the configured webhook secret as a bearer token. The pending generation is finished
+// before responding; callbacks for generations that are not pending are acknowledged, and 503 makes Leonardo
+// retry while Valkey is unreachable
+func (h *WebhookHandler) LeonardoCallback(c *gin.Context) {
+	if h.leonardoSecret == "" || h.leonardo == nil {
+		utils.RespondWithError(c, http.StatusNotFound, "Leonardo callbacks are not enabled", "WEBHOOK_DISABLED", nil)
+		return
+	}
+
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.leonardoSecret)) != 1 {
+		utils.RespondWithError(c, http.StatusUnauthorized, "Invalid callback credentials", "UNAUTHORIZED", nil)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCallbackBytes))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Failed to read callback", "INVALID_REQUEST", nil)
+		return
+	}
+	completion, err := providers.ParseLeonardoCallback(body)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "Invalid callback", "INVALID_REQUEST", map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	if completion == nil {
+		utils.RespondWithSuccess(c, gin.H{"accepted": false}, "Event ignored", nil)
+		return
+	}
+
+	// Once claimed, the generation is finished even if Leonardo stops waiting for the response
+	ctx := context.WithoutCancel(c.Request.Context())
+	pending, err := h.leonardo.ResumeGeneration(ctx, completion)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to claim pending generation", "generation_id", completion.GenerationID, "error", err)
+		utils.RespondWithError(c, http.StatusServiceUnavailable, "Failed to look up generation", "VALKEY_UNAVAILABLE", nil)
+		return
+	}
+	if pending == nil {
+		logger.InfoContext(ctx, "Callback for a generation that is not pending", "generation_id", completion.GenerationID, "status", completion.Status)
+		utils.RespondWithSuccess(c, gin.H{"accepted": false}, "Generation not pending", nil)
+		return
+	}
+
+	utils.RespondWithSuccess(c, gin.H{"accepted": true}, "Callback accepted", map[string]string{
+		"pair_id": pending.PairID,
+	})
+}
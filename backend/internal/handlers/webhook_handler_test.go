@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/providers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLeonardoCallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "0123456789abcdef0123456789abcdef"
+	complete := `{"type":"image_generation.complete","data":{"object":{"id":"gen-1","status":"COMPLETE"}}}`
+
+	// Callbacks are not enabled on this provider, so claiming a generation fails as it would with Valkey down
+	leonardo := providers.NewLeonardoAIProvider(config.LeonardoConfig{APIKey: "key", Timeout: time.Second}, nil)
+
+	tests := []struct {
+		name          string
+		leonardo      *providers.LeonardoAIProvider
+		secret        string
+		authorization string
+		body          string
+		wantCode      int
+		wantError     string
+	}{
+		{"no secret configured", leonardo, "", "Bearer ", complete, http.StatusNotFound, "WEBHOOK_DISABLED"},
+		{"no provider", nil, secret, "Bearer " + secret, complete, http.StatusNotFound, "WEBHOOK_DISABLED"},
+		{"missing credentials", leonardo, secret, "", complete, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"wrong secret", leonardo, secret, "Bearer " + strings.Repeat("x", len(secret)), complete, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"not a bearer token", leonardo, secret, secret, complete, http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"malformed body", leonardo, secret, "Bearer " + secret, `{"type":`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"other event", leonardo, secret, "Bearer " + secret, `{"type":"model_training.complete"}`, http.StatusOK, ""},
+		{"claim fails", leonardo, secret, "Bearer " + secret, complete, http.StatusServiceUnavailable, "VALKEY_UNAVAILABLE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/webhooks/leonardo", NewWebhookHandler(tt.leonardo, tt.secret).LeonardoCallback)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/leonardo", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantCode, recorder.Body)
+			}
+			var body struct {
+				Code string `json:"code"`
+				Data struct {
+					Accepted bool `json:"accepted"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response is not JSON: %s", recorder.Body)
+			}
+			if body.Code != tt.wantError || body.Data.Accepted {
+				t.Errorf("response = %+v, want error code %q and nothing accepted", body, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"image"
+	"io"
+
+	// Register decoders for the formats providers return
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// DigestReader wraps an image stream so that, as the stream is consumed (typically by an upload),
+// its SHA-256 and size are computed and the image is decoded concurrently, without ever holding
+// the encoded bytes in memory
+type DigestReader struct {
+	source io.ReadCloser
+	tee    io.Reader
+	hasher hash.Hash
+	size   int64
+	pipe   *io.PipeWriter
+	done   chan decodeResult
+}
+
+// Digest is the outcome of a fully consumed DigestReader
+type Digest struct {
+	SHA256 string
+	Size   int64
+	Image  image.Image // nil if the stream could not be decoded
+	Err    error       // decode error, if any
+}
+
+// decodeResult carries the decoder goroutine's output
+type decodeResult struct {
+	img image.Image
+	err error
+}
+
+// NewDigestReader starts decoding source in the background and returns a reader over it
+func NewDigestReader(source io.ReadCloser) *DigestReader {
+	pr, pw := io.Pipe()
+	d := &DigestReader{
+		source: source,
+		hasher: sha256.New(),
+		pipe:   pw,
+		done:   make(chan decodeResult, 1),
+	}
+	d.tee = io.TeeReader(source, pw)
+
+	go func() {
+		img, _, err := image.Decode(pr)
+		// Decoders can stop before EOF (e.g. after the PNG IEND chunk); keep draining so writes never block
+		io.Copy(io.Discard, pr)
+		d.done <- decodeResult{img: img, err: err}
+	}()
+
+	return d
+}
+
+// Read reads from the source, feeding the hasher and decoder as it goes
+func (d *DigestReader) Read(p []byte) (int, error) {
+	n, err := d.tee.Read(p)
+	d.hasher.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// Close closes the source; it does not stop the decoder (use Finish or Abort for that)
+func (d *DigestReader) Close() error {
+	return d.source.Close()
+}
+
+// Finish signals the end of the stream and waits for the decoder
+// Call it only after the stream has been read to completion
+func (d *DigestReader) Finish() *Digest {
+	d.pipe.Close()
+	result := <-d.done
+
+	return &Digest{
+		SHA256: hex.EncodeToString(d.hasher.Sum(nil)),
+		Size:   d.size,
+		Image:  result.img,
+		Err:    result.err,
+	}
+}
+
+// Abort stops the decoder after a failed or abandoned read
+func (d *DigestReader) Abort(err error) {
+	d.pipe.CloseWithError(err)
+	<-d.done
+	d.source.Close()
+}
@@ -0,0 +1,92 @@
+package imaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image/jpeg"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDigestReader(t *testing.T) {
+	img := testImage(64, 32)
+	pngData, err := encodePNG(img)
+	if err != nil {
+		t.Fatalf("encodePNG: %v", err)
+	}
+	var jpegBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	// Trailing bytes after the PNG's last chunk are still hashed, although the decoder stops before them
+	trailing := append(append([]byte(nil), pngData...), bytes.Repeat([]byte{0}, 1<<16)...)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		chunked    bool
+		wantDecode bool
+	}{
+		{"png", pngData, false, true},
+		{"png read a byte at a time", pngData, true, true},
+		{"jpeg", jpegBuf.Bytes(), false, true},
+		{"png with trailing bytes", trailing, false, true},
+		{"not an image", []byte("<html>error page</html>"), false, false},
+		{"empty", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := NewDigestReader(io.NopCloser(bytes.NewReader(tt.data)))
+
+			var reader io.Reader = digest
+			if tt.chunked {
+				reader = iotest.OneByteReader(digest)
+			}
+			read, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !bytes.Equal(read, tt.data) {
+				t.Fatal("DigestReader changed the stream")
+			}
+
+			result := digest.Finish()
+			sum := sha256.Sum256(tt.data)
+			if result.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("SHA256 = %s, want %x", result.SHA256, sum)
+			}
+			if result.Size != int64(len(tt.data)) {
+				t.Errorf("Size = %d, want %d", result.Size, len(tt.data))
+			}
+
+			if tt.wantDecode {
+				if result.Err != nil || result.Image == nil {
+					t.Fatalf("decode failed: %v", result.Err)
+				}
+				if bounds := result.Image.Bounds(); bounds.Dx() != 64 || bounds.Dy() != 32 {
+					t.Errorf("decoded %v, want 64x32", bounds)
+				}
+			} else if result.Err == nil {
+				t.Error("decoding succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDigestReaderAbort(t *testing.T) {
+	pngData, err := encodePNG(testImage(64, 64))
+	if err != nil {
+		t.Fatalf("encodePNG: %v", err)
+	}
+
+	// Abandoning a stream part way through must not leave the decoder blocked
+	digest := NewDigestReader(io.NopCloser(bytes.NewReader(pngData)))
+	if _, err := io.ReadFull(digest, make([]byte, len(pngData)/2)); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	digest.Abort(errors.New("upload failed"))
+}
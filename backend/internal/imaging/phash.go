@@ -0,0 +1,52 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// HashBits is the number of bits in a perceptual hash, i.e. the maximum possible Hamming distance
+const HashBits = 64
+
+// DifferenceHash computes a 64-bit dHash of img
+// The image is reduced to a 9x8 grayscale grid and each bit records whether a pixel is brighter than its right
+// neighbour, so resizing, re-encoding and small colour shifts leave the hash (nearly) unchanged
+func DifferenceHash(img image.Image) uint64 {
+	grid := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(grid, grid.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grid.GrayAt(x, y).Y > grid.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// HammingDistance returns the number of differing bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash encodes a hash as a fixed-width hex string for storage
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash decodes a hash produced by FormatHash
+func ParseHash(s string) (uint64, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q: %w", s, err)
+	}
+	return hash, nil
+}
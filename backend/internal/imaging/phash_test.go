@@ -0,0 +1,77 @@
+package imaging
+
+import (
+	"image"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xff, 0x0f, 4},
+		{0, ^uint64(0), HashBits},
+		{0xaaaaaaaaaaaaaaaa, 0x5555555555555555, HashBits},
+	}
+
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HammingDistance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAndParseHash(t *testing.T) {
+	for _, hash := range []uint64{0, 1, 0xdeadbeef, ^uint64(0)} {
+		formatted := FormatHash(hash)
+		if len(formatted) != 16 {
+			t.Errorf("FormatHash(%x) = %q, want 16 hex digits", hash, formatted)
+		}
+		parsed, err := ParseHash(formatted)
+		if err != nil || parsed != hash {
+			t.Errorf("ParseHash(%q) = %x, %v, want %x", formatted, parsed, err, hash)
+		}
+	}
+
+	for _, invalid := range []string{"", "xyz", "10000000000000000"} {
+		if _, err := ParseHash(invalid); err == nil {
+			t.Errorf("ParseHash(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestDifferenceHash(t *testing.T) {
+	source := testImage(512, 512)
+
+	// Scaled copies of the same image hash (nearly) the same
+	half := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	draw.CatmullRom.Scale(half, half.Bounds(), source, source.Bounds(), draw.Src, nil)
+	if distance := HammingDistance(DifferenceHash(source), DifferenceHash(half)); distance > 4 {
+		t.Errorf("distance between scaled copies = %d, want at most 4", distance)
+	}
+
+	// A mirrored image brightens in the opposite direction, flipping every bit
+	mirrored := image.NewRGBA(source.Bounds())
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			mirrored.Set(511-x, y, source.At(x, y))
+		}
+	}
+	if distance := HammingDistance(DifferenceHash(source), DifferenceHash(mirrored)); distance < HashBits/2 {
+		t.Errorf("distance between mirrored images = %d, want at least %d", distance, HashBits/2)
+	}
+
+	// A flat image has no brighter neighbours
+	flat := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range flat.Pix {
+		flat.Pix[i] = 128
+	}
+	if hash := DifferenceHash(flat); hash != 0 {
+		t.Errorf("DifferenceHash(flat) = %x, want 0", hash)
+	}
+}
@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// PlaceholderVariant is the variant name of the blurred low-quality placeholder
+	PlaceholderVariant = "placeholder"
+
+	// placeholderSampleWidth is the width the image is shrunk to before being blown back up,
+	// which is what produces the blur
+	placeholderSampleWidth = 16
+
+	// placeholderWidth is the width of the encoded placeholder image
+	placeholderWidth = 64
+)
+
+// VariantWidths are the responsive widths generated for every saved image
+var VariantWidths = []int{256, 512, 1024}
+
+// Variant represents a single resized rendition of a source image
+type Variant struct {
+	Name  string // "256", "512", "1024" or "placeholder"
+	Width int    // Width of the encoded image
+	Data  []byte
+}
+
+// Srcset converts stored variant URLs into a srcset-style map of width descriptor to URL
+// Returns nil for pairs that have no variants
+func Srcset(variants map[string]string) map[string]string {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	srcset := make(map[string]string, len(VariantWidths))
+	for _, width := range VariantWidths {
+		if url, ok := variants[strconv.Itoa(width)]; ok {
+			srcset[fmt.Sprintf("%dw", width)] = url
+		}
+	}
+
+	return srcset
+}
+
+// GenerateVariants produces a PNG rendition of img for each of VariantWidths narrower than it, plus a blurred
+// placeholder
+// Images are never upscaled, and a variant as wide as the source would only re-encode it, so widths at or above
+// the source width are skipped; the original stands in for them
+func GenerateVariants(img image.Image) ([]Variant, error) {
+	variants := make([]Variant, 0, len(VariantWidths)+1)
+
+	for _, width := range VariantWidths {
+		if width >= img.Bounds().Dx() {
+			continue
+		}
+
+		resized := scale(img, width, draw.CatmullRom)
+		data, err := encodePNG(resized)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %dpx variant: %w", width, err)
+		}
+		variants = append(variants, Variant{
+			Name:  strconv.Itoa(resized.Bounds().Dx()),
+			Width: resized.Bounds().Dx(),
+			Data:  data,
+		})
+	}
+
+	// Shrinking to a handful of pixels and scaling back up with a bilinear filter gives a soft blur
+	// that is only a few hundred bytes once encoded
+	sample := resize(img, placeholderSampleWidth, draw.ApproxBiLinear)
+	data, err := encodePNG(scale(sample, placeholderWidth, draw.BiLinear))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode placeholder: %w", err)
+	}
+	variants = append(variants, Variant{
+		Name:  PlaceholderVariant,
+		Width: placeholderWidth,
+		Data:  data,
+	})
+
+	return variants, nil
+}
+
+// resize scales img down to the given width, preserving aspect ratio
+func resize(img image.Image, width int, interpolator draw.Interpolator) image.Image {
+	if img.Bounds().Dx() <= width {
+		return img
+	}
+	return scale(img, width, interpolator)
+}
+
+// scale scales img to the given width in either direction, preserving aspect ratio
+func scale(img image.Image, width int, interpolator draw.Interpolator) image.Image {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	interpolator.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// encodePNG encodes img as a best-compression PNG
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// testImage returns a width x height image with a horizontal gradient
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestGenerateVariants(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantNames     []string
+	}{
+		{"wider than every width", 2048, 1024, []string{"256", "512", "1024", PlaceholderVariant}},
+		{"as wide as the largest width", 1024, 1024, []string{"256", "512", PlaceholderVariant}},
+		{"between widths", 600, 400, []string{"256", "512", PlaceholderVariant}},
+		{"narrower than every width", 200, 200, []string{PlaceholderVariant}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variants, err := GenerateVariants(testImage(tt.width, tt.height))
+			if err != nil {
+				t.Fatalf("GenerateVariants: %v", err)
+			}
+
+			var names []string
+			for _, variant := range variants {
+				names = append(names, variant.Name)
+
+				decoded, err := png.Decode(bytes.NewReader(variant.Data))
+				if err != nil {
+					t.Fatalf("variant %s is not a PNG: %v", variant.Name, err)
+				}
+				if got := decoded.Bounds().Dx(); got != variant.Width {
+					t.Errorf("variant %s is %dpx wide, reports %d", variant.Name, got, variant.Width)
+				}
+				if variant.Name == PlaceholderVariant {
+					continue
+				}
+				if variant.Width >= tt.width {
+					t.Errorf("variant %s is not narrower than the %dpx source", variant.Name, tt.width)
+				}
+				if wantHeight := tt.height * variant.Width / tt.width; decoded.Bounds().Dy() != wantHeight {
+					t.Errorf("variant %s is %dpx high, want %d", variant.Name, decoded.Bounds().Dy(), wantHeight)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("variants = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants map[string]string
+		want     map[string]string
+	}{
+		{"no variants", nil, nil},
+		{
+			"all widths",
+			map[string]string{"256": "a", "512": "b", "1024": "c", PlaceholderVariant: "p"},
+			map[string]string{"256w": "a", "512w": "b", "1024w": "c"},
+		},
+		{
+			"narrow source",
+			map[string]string{"256": "a", "512": "b", PlaceholderVariant: "p"},
+			map[string]string{"256w": "a", "512w": "b"},
+		},
+		{"placeholder only", map[string]string{PlaceholderVariant: "p"}, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Srcset(tt.variants); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Srcset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Package logging sets up structured JSON logging with log/slog
+// Every record logged with a request's context carries its request ID (from the X-Request-ID header, or generated)
+// and trace ID, so all lines for one request can be found together; credentials are redacted before anything is written
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RequestIDHeader is read from incoming requests and set on every response
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds caller-supplied request IDs; longer or unusual ones are replaced
+	maxRequestIDLength = 128
+
+	// redacted replaces secrets in log output
+	redacted = "[REDACTED]"
+)
+
+var (
+	// sensitiveKeys are attribute key fragments whose values are never logged
+	sensitiveKeys = []string{"password", "secret", "token", "api_key", "apikey", "authorization", "cookie"}
+
+	// secretPatterns catch credentials that were not configured up front, such as issued API keys
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`cgc_[0-9a-fA-F]{8,}`),
+		regexp.MustCompile(`(?i)bearer\s+[^\s"']+`),
+	}
+
+	// requestIDPattern is what a caller-supplied request ID may contain
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+	// quietPaths are polled constantly by the load balancer and Prometheus, so they are logged at debug level
+	quietPaths = map[string]bool{
+		"/livez":   true,
+		"/readyz":  true,
+		"/health":  true,
+		"/metrics": true,
+	}
+)
+
+// contextKey is the context key for the request ID
+type contextKey struct{}
+
+// Setup makes a JSON logger at the configured level the default for slog and the log package
+// secrets are replaced with [REDACTED] wherever they appear in a message or attribute
+func Setup(cfg config.LoggingConfig, secrets []string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	redactor := newRedactor(secrets)
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactor.replaceAttr,
+	})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// Logger returns a logger that tags records with component, e.g. "orchestrator"
+// It writes through whatever the default logger is when a record is logged, so package-level loggers
+// created before Setup still get the JSON handler
+func Logger(component string) *slog.Logger {
+	return slog.New(defaultHandler{}).With("component", component)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by ctx, or ""
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
+
+// Middleware assigns each request an ID, taken from X-Request-ID when the caller sent a usable one, returns it in
+// the X-Request-ID response header, and logs the request once it has been handled
+// Only the path is logged, not the query string, since room links carry host tokens
+func Middleware() gin.HandlerFunc {
+	access := Logger("http")
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if len(requestID) > maxRequestIDLength || !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), requestID))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case quietPaths[c.Request.URL.Path]:
+			level = slog.LevelDebug
+		case status >= 500:
+			level = slog.LevelError
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		access.LogAttrs(c.Request.Context(), level, "Request handled",
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		)
+	}
+}
+
+// Recovery turns a panic in a handler into a 500 response and logs it with its stack trace
+func Recovery() gin.HandlerFunc {
+	logger := Logger("http")
+
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		logger.ErrorContext(c.Request.Context(), "Recovered from panic",
+			"panic", fmt.Sprint(recovered),
+			"stack", string(debug.Stack()),
+		)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// contextHandler adds the request and trace IDs carried by the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() && spanContext.IsSampled() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// defaultHandler hands records to the default logger's handler at the time they are logged
+// The attributes and groups added to it are replayed onto that handler in order
+type defaultHandler struct {
+	wrap []func(slog.Handler) slog.Handler
+}
+
+// Enabled implements slog.Handler
+func (h defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (h defaultHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := slog.Default().Handler()
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+// WithGroup implements slog.Handler
+func (h defaultHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+// with returns a copy of h with wrap added last
+func (h defaultHandler) with(wrap func(slog.Handler) slog.Handler) defaultHandler {
+	return defaultHandler{wrap: append(append([]func(slog.Handler) slog.Handler{}, h.wrap...), wrap)}
+}
+
+// redactor scrubs credentials out of messages and attribute values
+type redactor struct {
+	replacer *strings.Replacer
+}
+
+// newRedactor creates a redactor for the given secrets; very short values are skipped since they would
+// redact ordinary words
+func newRedactor(secrets []string) *redactor {
+	var pairs []string
+	for _, secret := range secrets {
+		if len(secret) >= 8 {
+			pairs = append(pairs, secret, redacted)
+		}
+	}
+	return &redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// replaceAttr implements slog.HandlerOptions.ReplaceAttr
+func (r *redactor) replaceAttr(_ []string, attr slog.Attr) slog.Attr {
+	if sensitiveKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, r.redact(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, r.redact(value.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, r.redact(value.String()))
+		}
+	}
+	return attr
+}
+
+// redact replaces every secret in s
+func (r *redactor) redact(s string) string {
+	s = r.replacer.Replace(s)
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// sensitiveKey reports whether an attribute with this key holds a credential
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
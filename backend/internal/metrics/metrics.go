@@ -0,0 +1,213 @@
+// Package metrics defines the Prometheus metrics the service exposes on /metrics
+// Metrics are recorded where the work happens (orchestrator, providers, Valkey client) rather than only at the edge,
+// so a slow request can be traced to the provider, upload or Valkey command behind it
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "cgc"
+
+// Outcome label values
+const (
+	OutcomeSuccess       = "success"
+	OutcomeError         = "error"
+	OutcomeNearDuplicate = "near_duplicate"
+	OutcomeTimeout       = "timeout"
+	OutcomeCancelled     = "cancelled"
+	OutcomePending       = "pending" // Accepted by the provider and finished in the background
+)
+
+// Upload kind label values
+const (
+	UploadOriginal = "original"
+	UploadVariant  = "variant"
+)
+
+// logger reports collectors that could not be read during a scrape
+var logger = logging.Logger("metrics")
+
+// inventoryTimeout bounds the Valkey reads made while a scrape is in progress
+const inventoryTimeout = 2 * time.Second
+
+var (
+	// GenerationDuration is how long a provider took to return a pair, including its uploads
+	GenerationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_generation_duration_seconds",
+		Help:      "Time a provider took to generate and upload an image pair.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 45, 60, 90, 120},
+	}, []string{"provider", "outcome"})
+
+	// ProviderErrors counts provider failures by ProviderError.Code
+	ProviderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_errors_total",
+		Help:      "Provider failures by error code.",
+	}, []string{"provider", "code"})
+
+	// Fallbacks counts times the orchestrator moved on from a provider to the next one in the fallback order,
+	// by the provider's error code (or NEAR_DUPLICATE)
+	Fallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orchestrator_fallbacks_total",
+		Help:      "Times the orchestrator fell back from a provider to the next one, by reason.",
+	}, []string{"provider", "reason"})
+
+	// SlotWait is how long generations waited for a provider concurrency slot, by whether they got one in time
+	SlotWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_slot_wait_seconds",
+		Help:      "Time spent waiting for a provider concurrency slot.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"provider", "outcome"})
+
+	// Generations counts pair generation requests handled by the orchestrator
+	Generations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orchestrator_generations_total",
+		Help:      "Image pair generations by the provider that served them (none if every provider failed).",
+	}, []string{"provider", "outcome"})
+
+	// UploadBytes counts bytes uploaded to Spaces
+	UploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes uploaded to Spaces.",
+	}, []string{"provider", "kind"})
+
+	// UploadDuration is how long a single object upload to Spaces took, retries included
+	UploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time taken to upload one object to Spaces, including retries.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"provider", "kind", "outcome"})
+
+	// ValkeyCommandDuration is how long Valkey commands took; pipelines and transactions count as one
+	ValkeyCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "valkey_command_duration_seconds",
+		Help:      "Valkey command latency; pipelines and transactions are recorded as one command.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+	}, []string{"command", "outcome"})
+
+	// Votes counts votes recorded, by winning side and the provider of the pair
+	Votes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_total",
+		Help:      "Votes recorded by winning side and the provider that generated the pair.",
+	}, []string{"side", "provider"})
+
+	// HTTPRequests counts HTTP requests by route template and status code
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration is how long HTTP requests took by route template
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// HTTPInFlight is the number of HTTP requests being served, including open event streams and room connections
+	HTTPInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+)
+
+// Outcome returns OutcomeSuccess for a nil error and OutcomeError otherwise
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records HTTP request metrics, labelled by route template (e.g. /api/v1/rooms/:id) so IDs in paths
+// don't create a series each
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		HTTPInFlight.Inc()
+		defer HTTPInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// InventorySource reports how many pairs are in each state, e.g. "active" and "archived"
+type InventorySource interface {
+	PairInventory(ctx context.Context) (map[string]int64, error)
+}
+
+// inventoryCollector reads the pair inventory from its source on every scrape, so every droplet reports the
+// shared inventory without a background poller
+type inventoryCollector struct {
+	source InventorySource
+	pairs  *prometheus.Desc
+}
+
+// RegisterPairInventory exposes the pair inventory as cgc_pairs{state="..."}
+func RegisterPairInventory(source InventorySource) error {
+	return prometheus.Register(&inventoryCollector{source: source, pairs: newInventoryDesc()})
+}
+
+// newInventoryDesc describes the cgc_pairs gauge
+func newInventoryDesc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "pairs"),
+		"Image pairs by state.",
+		[]string{"state"}, nil,
+	)
+}
+
+// Describe implements prometheus.Collector
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pairs
+}
+
+// Collect implements prometheus.Collector
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryTimeout)
+	defer cancel()
+
+	inventory, err := c.source.PairInventory(ctx)
+	if err != nil {
+		// Leave the gauge out rather than failing the whole scrape while Valkey is unreachable
+		logger.Warn("Failed to read pair inventory", "error", err)
+		return
+	}
+	for state, count := range inventory {
+		ch <- prometheus.MustNewConstMetric(c.pairs, prometheus.GaugeValue, float64(count), state)
+	}
+}
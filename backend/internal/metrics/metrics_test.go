@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutcome(t *testing.T) {
+	if got := Outcome(nil); got != OutcomeSuccess {
+		t.Errorf("Outcome(nil) = %q, want %q", got, OutcomeSuccess)
+	}
+	if got := Outcome(errors.New("boom")); got != OutcomeError {
+		t.Errorf("Outcome(error) = %q, want %q", got, OutcomeError)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/api/v1/rooms/:id", func(c *gin.Context) {
+		if got := testutil.ToFloat64(HTTPInFlight); got < 1 {
+			t.Errorf("in-flight gauge = %v while serving, want at least 1", got)
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		path   string
+		route  string
+		status string
+	}{
+		{"/api/v1/rooms/abc", "/api/v1/rooms/:id", "204"},
+		{"/api/v1/rooms/def", "/api/v1/rooms/:id", "204"},
+		{"/nowhere", "unmatched", "404"},
+	}
+
+	before := make(map[string]float64)
+	for _, tt := range tests {
+		before[tt.route] = testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodGet, tt.route, tt.status))
+	}
+	for _, tt := range tests {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+	}
+
+	// Both room IDs are counted under the one route template
+	for route, want := range map[string]float64{"/api/v1/rooms/:id": 2, "unmatched": 1} {
+		status := "204"
+		if route == "unmatched" {
+			status = "404"
+		}
+		if got := testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodGet, route, status)) - before[route]; got != want {
+			t.Errorf("requests for %s = %v, want %v", route, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(HTTPInFlight); got != 0 {
+		t.Errorf("in-flight gauge = %v after every request finished, want 0", got)
+	}
+}
+
+// fakeInventory is an InventorySource with a fixed answer
+type fakeInventory struct {
+	inventory map[string]int64
+	err       error
+}
+
+func (f fakeInventory) PairInventory(context.Context) (map[string]int64, error) {
+	return f.inventory, f.err
+}
+
+func TestInventoryCollector(t *testing.T) {
+	newCollector := func(source InventorySource) *inventoryCollector {
+		return &inventoryCollector{source: source, pairs: newInventoryDesc()}
+	}
+
+	collector := newCollector(fakeInventory{inventory: map[string]int64{"active": 12, "archived": 3}})
+	want := `
+# HELP cgc_pairs Image pairs by state.
+# TYPE cgc_pairs gauge
+cgc_pairs{state="active"} 12
+cgc_pairs{state="archived"} 3
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "cgc_pairs"); err != nil {
+		t.Error(err)
+	}
+
+	// An unreachable source leaves the gauge out instead of failing the scrape
+	failing := newCollector(fakeInventory{err: errors.New("valkey down")})
+	if count := testutil.CollectAndCount(failing); count != 0 {
+		t.Errorf("collected %d series from a failing source, want 0", count)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	Votes.WithLabelValues("left", "metrics-test").Inc()
+
+	router := gin.New()
+	router.GET("/metrics", Handler())
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d", recorder.Code)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, `cgc_votes_total{provider="metrics-test",side="left"} 1`) {
+		t.Errorf("metrics output is missing the vote counter:\n%s", body)
+	}
+}
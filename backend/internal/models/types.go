@@ -10,6 +10,8 @@ type ImageRequest struct {
 	RequestID string    `json:"request_id,omitempty"`
 	PairID    string    `json:"pair_id,omitempty"` // Unique identifier for this image pair
 	Timestamp time.Time `json:"timestamp,omitempty"`
+	Scheduled bool      `json:"scheduled,omitempty"` // Sent by the generator cron; subject to the runtime generation settings
+	Wait      bool      `json:"-"`                   // Return the images even from a provider that would finish the pair later
 }
 
 // ImageResponse represents the response from image generation
@@ -20,6 +22,7 @@ type ImageResponse struct {
 	RequestID string            `json:"request_id"`
 	Duration  time.Duration     `json:"duration"`
 	Metadata  map[string]string `json:"metadata,omitempty"`
+	Pending   bool              `json:"pending,omitempty"` // Accepted but finished in the background, so Images is empty
 }
 
 // GeneratedImage represents a single generated image
@@ -29,6 +32,13 @@ type GeneratedImage struct {
 	Path     string `json:"path"`
 	URL      string `json:"url,omitempty"`
 	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256,omitempty"`
+
+	// PHash is the hex-encoded perceptual (difference) hash used for near-duplicate detection
+	PHash string `json:"phash,omitempty"`
+
+	// Variants maps variant name ("256", "512", "1024", "placeholder") to CDN URL
+	Variants map[string]string `json:"variants,omitempty"`
 }
 
 // ProviderError represents errors from image generation providers
@@ -45,6 +55,13 @@ func (e *ProviderError) Error() string {
 	return e.Message
 }
 
+// ProviderErrorRecord is one entry in a provider's recent error history
+type ProviderErrorRecord struct {
+	At      time.Time `json:"at"`
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+}
+
 // ProviderStatus represents the current status of a provider
 type ProviderStatus struct {
 	Name        string         `json:"name"`
@@ -54,6 +71,7 @@ type ProviderStatus struct {
 	ErrorCount  int            `json:"error_count"`
 	QuotaHit    bool           `json:"quota_hit"`
 	RateLimited bool           `json:"rate_limited"`
+	Disabled    bool           `json:"disabled,omitempty"` // Turned off by an operator in the runtime settings
 	QuotaInfo   *ProviderQuota `json:"quota_info,omitempty"`
 }
 
@@ -97,6 +115,13 @@ type ImagePairResponse struct {
 	Provider string `json:"provider"`
 	LeftURL  string `json:"left_url"`
 	RightURL string `json:"right_url"`
+
+	// Srcset maps width descriptors ("256w", "512w", ...) to CDN URLs for responsive loading
+	// Pairs generated before variants existed omit these fields
+	LeftSrcset       map[string]string `json:"left_srcset,omitempty"`
+	RightSrcset      map[string]string `json:"right_srcset,omitempty"`
+	LeftPlaceholder  string            `json:"left_placeholder,omitempty"`
+	RightPlaceholder string            `json:"right_placeholder,omitempty"`
 }
 
 // ComparisonRatingRequest represents a rating submission for image comparison
@@ -1,46 +1,75 @@
 package providers
 
 import (
-	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
 	"io"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"cgc-lb-and-cdn-backend/internal/imaging"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/metrics"
 	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	// ImageCount is the number of images to generate per request (always 2 for comparison)
 	ImageCount = 2
+
+	// maxRecentErrors is how many errors each provider keeps for the admin API
+	maxRecentErrors = 50
+
+	// rollbackTimeout bounds deleting a pair's objects, which happens even after the request was cancelled
+	rollbackTimeout = 30 * time.Second
 )
 
+// tracer records uploads and provider-specific steps such as Leonardo's polling
+var tracer = otel.Tracer("cgc-lb-and-cdn-backend/internal/providers")
+
+// logger is shared by all providers; records name the provider in a "provider" attribute
+var logger = logging.Logger("providers")
+
 // BaseProvider provides common functionality for all image generation providers
 type BaseProvider struct {
 	name       string
 	status     *models.ProviderStatus
 	httpClient *http.Client
 	imageDir   string
-	bucket     string
+	spaces     *storage.SpacesClient
+	spacesErr  error // Why spaces is nil, reported on the first upload attempt
+
+	// unconfigured says why the provider cannot work at all (e.g. no API key); resetting does not make it available
+	unconfigured string
+
+	// Guards status and recentErrors, which the admin API and the concurrency limiter read while requests run
+	mutex        sync.Mutex
+	recentErrors []models.ProviderErrorRecord // Oldest first, at most maxRecentErrors
 }
 
-// NewBaseProvider creates a new base provider
-func NewBaseProvider(name string) *BaseProvider {
-	bucket := os.Getenv("DO_SPACES_BUCKET")
-	if bucket == "" {
-		bucket = "cgc-lb-and-cdn-content" // Default fallback
+// NewBaseProvider creates a new base provider that uploads its images to spaces
+// spaces is shared by every provider; when it is nil, uploads fail with a configuration error
+// timeout bounds each HTTP request made through the provider's client, reading the response included
+func NewBaseProvider(name string, spaces *storage.SpacesClient, timeout time.Duration) *BaseProvider {
+	var spacesErr error
+	if spaces == nil {
+		spacesErr = fmt.Errorf("spaces storage not configured (DO_SPACES_ENDPOINT, DO_SPACES_ACCESS_KEY, DO_SPACES_SECRET_KEY)")
 	}
 
 	return &BaseProvider{
-		name:   name,
-		bucket: bucket,
+		name:      name,
+		spaces:    spaces,
+		spacesErr: spacesErr,
 		status: &models.ProviderStatus{
 			Name:        name,
 			Available:   true,
@@ -54,7 +83,8 @@ func NewBaseProvider(name string) *BaseProvider {
 			},
 		},
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			Timeout:   timeout,
+			Transport: tracing.Transport(http.DefaultTransport),
 		},
 		imageDir: "images",
 	}
@@ -65,13 +95,24 @@ func (bp *BaseProvider) GetName() string {
 	return bp.name
 }
 
-// GetStatus returns the current status
+// GetStatus returns a copy of the current status, so callers can read it while requests update the original
 func (bp *BaseProvider) GetStatus() *models.ProviderStatus {
-	return bp.status
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	status := *bp.status
+	if bp.status.QuotaInfo != nil {
+		quota := *bp.status.QuotaInfo
+		status.QuotaInfo = &quota
+	}
+	return &status
 }
 
 // IsAvailable checks if the provider is available
 func (bp *BaseProvider) IsAvailable() bool {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
 	return bp.status.Available && !bp.status.QuotaHit && !bp.status.RateLimited
 }
 
@@ -106,6 +147,19 @@ func (bp *BaseProvider) HandleError(err error) *models.ProviderError {
 	} else {
 		providerErr.Code = "UNKNOWN_ERROR"
 	}
+	metrics.ProviderErrors.WithLabelValues(bp.name, providerErr.Code).Inc()
+
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	bp.recentErrors = append(bp.recentErrors, models.ProviderErrorRecord{
+		At:      time.Now().UTC(),
+		Code:    providerErr.Code,
+		Message: errMsg,
+	})
+	if len(bp.recentErrors) > maxRecentErrors {
+		bp.recentErrors = bp.recentErrors[len(bp.recentErrors)-maxRecentErrors:]
+	}
 
 	// Update status
 	bp.status.LastError = errMsg
@@ -117,9 +171,73 @@ func (bp *BaseProvider) HandleError(err error) *models.ProviderError {
 	return providerErr
 }
 
+// lastError returns the provider's most recent error, reported when it refuses to generate
+func (bp *BaseProvider) lastError() string {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	return bp.status.LastError
+}
+
+// recordSuccess marks the provider available after a successful generation
+func (bp *BaseProvider) recordSuccess() {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	bp.status.LastSuccess = time.Now()
+	bp.status.Available = true
+	bp.status.LastError = ""
+}
+
+// setQuota replaces the provider's quota information
+func (bp *BaseProvider) setQuota(quota *models.ProviderQuota) {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	bp.status.QuotaInfo = quota
+}
+
+// markUnconfigured makes the provider permanently unavailable, giving reason as its error
+func (bp *BaseProvider) markUnconfigured(reason string) {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	bp.unconfigured = reason
+	bp.status.Available = false
+	bp.status.LastError = reason
+}
+
+// ResetStatus makes the provider available again after an operator has dealt with the cause of its errors
+// The error history is kept, and a provider that is not configured stays unavailable
+func (bp *BaseProvider) ResetStatus() {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	bp.status.Available = bp.unconfigured == ""
+	bp.status.LastError = bp.unconfigured
+	bp.status.ErrorCount = 0
+	bp.status.QuotaHit = false
+	bp.status.RateLimited = false
+}
+
+// RecentErrors returns up to limit of the most recent errors, newest first
+func (bp *BaseProvider) RecentErrors(limit int) []models.ProviderErrorRecord {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	records := make([]models.ProviderErrorRecord, 0, min(limit, len(bp.recentErrors)))
+	for i := len(bp.recentErrors) - 1; i >= 0 && len(records) < limit; i-- {
+		records = append(records, bp.recentErrors[i])
+	}
+	return records
+}
+
 // RefreshQuota provides a default implementation (no quota support)
 func (bp *BaseProvider) RefreshQuota(ctx context.Context) error {
 	// Default implementation - no quota support
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
 	if bp.status.QuotaInfo == nil {
 		bp.status.QuotaInfo = &models.ProviderQuota{
 			Supported:   false,
@@ -129,85 +247,177 @@ func (bp *BaseProvider) RefreshQuota(ctx context.Context) error {
 	return nil
 }
 
-// SaveToSpaces uploads image data to DigitalOcean Spaces using direct HTTP
+// SaveToSpaces streams image data from its source into DigitalOcean Spaces
 // New path structure: images/<provider>/<pair-id>/<side>.png
-func (bp *BaseProvider) SaveToSpaces(imageData []byte, provider, pairID, side, prompt string) (*models.GeneratedImage, error) {
-	// Get DO Spaces configuration
-	endpoint := os.Getenv("DO_SPACES_ENDPOINT")
-	accessKey := os.Getenv("DO_SPACES_ACCESS_KEY")
-	secretKey := os.Getenv("DO_SPACES_SECRET_KEY")
-
-	if bp.bucket == "" || endpoint == "" || accessKey == "" || secretKey == "" {
-		return nil, fmt.Errorf("missing DO Spaces configuration (bucket, DO_SPACES_ENDPOINT, DO_SPACES_ACCESS_KEY, DO_SPACES_SECRET_KEY)")
+// Resized variants are stored next to the original as <side>_<variant>.png
+// The SHA-256, size and perceptual hash are computed while the bytes flow through, so the image is never
+// held in memory in encoded form
+func (bp *BaseProvider) SaveToSpaces(ctx context.Context, open storage.Opener, provider, pairID, side, prompt string) (generated *models.GeneratedImage, err error) {
+	if bp.spaces == nil {
+		return nil, bp.spacesErr
 	}
 
 	// New path structure: images/<provider>/<pair-id>/<side>.png
 	fullPath := fmt.Sprintf("images/%s/%s/%s.png", provider, pairID, side)
 
-	// Construct URL for upload
-	url := fmt.Sprintf("https://%s.%s/%s", bp.bucket, endpoint, fullPath)
+	ctx, span := tracer.Start(ctx, "provider.save_to_spaces", trace.WithAttributes(
+		attribute.String("provider", bp.name),
+		attribute.String("spaces.key", fullPath),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	metadata := map[string]string{
+		"prompt":   prompt,
+		"pair-id":  pairID,
+		"provider": provider,
+		"side":     side,
+	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(imageData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	// Every upload attempt gets a fresh digest; only the one from the successful attempt is kept
+	var digest *imaging.DigestReader
+	digestOpen := func() (io.ReadCloser, int64, error) {
+		if digest != nil {
+			digest.Abort(fmt.Errorf("upload retried"))
+		}
+		source, size, err := open()
+		if err != nil {
+			return nil, 0, err
+		}
+		digest = imaging.NewDigestReader(source)
+		return digest, size, nil
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "image/png")
-	req.Header.Set("x-amz-acl", "public-read")
-
-	// Add prompt as metadata (x-amz-meta-* headers)
-	req.Header.Set("x-amz-meta-prompt", prompt)
-	req.Header.Set("x-amz-meta-pair-id", pairID)
-	req.Header.Set("x-amz-meta-provider", provider)
-	req.Header.Set("x-amz-meta-side", side)
-
-	// Create signature for authentication
-	date := time.Now().UTC().Format(time.RFC1123)
-	req.Header.Set("Date", date)
-
-	// Create string to sign (must include x-amz headers in canonical order)
-	stringToSign := fmt.Sprintf("PUT\n\nimage/png\n%s\nx-amz-acl:public-read\nx-amz-meta-pair-id:%s\nx-amz-meta-prompt:%s\nx-amz-meta-provider:%s\nx-amz-meta-side:%s\n/%s/%s",
-		date, pairID, prompt, provider, side, bp.bucket, fullPath)
-
-	// Create signature
-	h := hmac.New(sha1.New, []byte(secretKey))
-	h.Write([]byte(stringToSign))
-	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	// Set authorization header
-	req.Header.Set("Authorization", fmt.Sprintf("AWS %s:%s", accessKey, signature))
-
-	// Make request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	start := time.Now()
+	err = bp.spaces.PutObjectStream(ctx, fullPath, digestOpen, "image/png", metadata)
+	metrics.UploadDuration.WithLabelValues(bp.name, metrics.UploadOriginal, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
+		if digest != nil {
+			digest.Abort(err)
+		}
 		return nil, fmt.Errorf("failed to upload to DO Spaces: %w", err)
 	}
-	defer resp.Body.Close()
+	result := digest.Finish()
+	metrics.UploadBytes.WithLabelValues(bp.name, metrics.UploadOriginal).Add(float64(result.Size))
+	span.SetAttributes(attribute.Int64("spaces.bytes", result.Size))
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to upload to DO Spaces: HTTP %d - %s", resp.StatusCode, string(body))
+	generated = &models.GeneratedImage{
+		ID:       pairID,                      // Use pair-id as the primary identifier
+		Filename: fmt.Sprintf("%s.png", side), // Just "left.png" or "right.png"
+		Path:     fullPath,                    // Full path in Spaces
+		URL:      bp.spaces.CDNURL(fullPath),  // CDN URL for frontend
+		Size:     result.Size,
+		SHA256:   result.SHA256,
+	}
+
+	if result.Err != nil {
+		logger.WarnContext(ctx, "Failed to decode image, skipping hash and variants", "key", fullPath, "error", result.Err)
+		return generated, nil
+	}
+	generated.PHash = imaging.FormatHash(imaging.DifferenceHash(result.Image))
+
+	// Variants are an optimization for smaller screens, so a failure here never fails the pair, unless it is
+	// because the request was cancelled: then the image is taken out again along with the variants uploaded so far
+	variants, err := bp.saveVariants(ctx, result.Image, provider, pairID, side, generated.PHash)
+	if err != nil && ctx.Err() != nil {
+		generated.Variants = variants
+		bp.RollbackImages(ctx, []models.GeneratedImage{*generated})
+		return nil, fmt.Errorf("upload of %s cancelled: %w", fullPath, ctx.Err())
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to generate variants", "key", fullPath, "error", err)
+		span.AddEvent("variants failed", trace.WithAttributes(attribute.String("error", err.Error())))
+		return generated, nil
+	}
+	generated.Variants = variants
+
+	return generated, nil
+}
+
+// saveVariants generates and uploads the responsive variants of an image
+// Returns a map of variant name to CDN URL; on error it holds the variants uploaded before the failure
+// The source's perceptual hash is only known once it has been uploaded, so it is recorded on the variants instead
+func (bp *BaseProvider) saveVariants(ctx context.Context, img image.Image, provider, pairID, side, phash string) (map[string]string, error) {
+	variants, err := imaging.GenerateVariants(img)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string]string, len(variants))
+	for _, variant := range variants {
+		variantPath := fmt.Sprintf("images/%s/%s/%s_%s.png", provider, pairID, side, variant.Name)
+		metadata := map[string]string{
+			"pair-id":  pairID,
+			"provider": provider,
+			"side":     side,
+			"variant":  variant.Name,
+			"phash":    phash,
+		}
+
+		variantURL, err := bp.putObject(ctx, variant.Data, variantPath, metadata)
+		if err != nil {
+			return urls, fmt.Errorf("failed to upload %s variant: %w", variant.Name, err)
+		}
+		urls[variant.Name] = variantURL
 	}
 
+	return urls, nil
+}
+
+// putObject uploads a public PNG object to DigitalOcean Spaces and returns its CDN URL
+// metadata keys are sent as x-amz-meta-<key> headers
+func (bp *BaseProvider) putObject(ctx context.Context, data []byte, fullPath string, metadata map[string]string) (string, error) {
+	if bp.spaces == nil {
+		return "", bp.spacesErr
+	}
+
+	start := time.Now()
+	err := bp.spaces.PutObject(ctx, fullPath, data, "image/png", metadata)
+	metrics.UploadDuration.WithLabelValues(bp.name, metrics.UploadVariant, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to DO Spaces: %w", err)
+	}
+	metrics.UploadBytes.WithLabelValues(bp.name, metrics.UploadVariant).Add(float64(len(data)))
+
 	// Return CDN URL instead of direct Spaces URL (use fullPath)
-	cdnURL := fmt.Sprintf("https://%s.%s.cdn.digitaloceanspaces.com/%s", bp.bucket, strings.TrimSuffix(endpoint, ".digitaloceanspaces.com"), fullPath)
+	return bp.spaces.CDNURL(fullPath), nil
+}
 
-	return &models.GeneratedImage{
-		ID:       pairID,                      // Use pair-id as the primary identifier
-		Filename: fmt.Sprintf("%s.png", side), // Just "left.png" or "right.png"
-		Path:     fullPath,                    // Full path in Spaces
-		URL:      cdnURL,                      // CDN URL for frontend
-		Size:     int64(len(imageData)),
-	}, nil
+// RollbackImages deletes already-uploaded images (and their variants) after a later image in the same pair failed,
+// so a half-written pair never lingers in Spaces; it also discards the images of test generations
+// It runs to completion even when ctx is cancelled, since cancellation is a common reason for rolling back
+func (bp *BaseProvider) RollbackImages(ctx context.Context, images []models.GeneratedImage) {
+	if bp.spaces == nil || len(images) == 0 {
+		return
+	}
+
+	deleteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	for _, img := range images {
+		for _, key := range ImageObjectKeys(img) {
+			if err := bp.spaces.DeleteObject(deleteCtx, key); err != nil {
+				logger.WarnContext(ctx, "Failed to roll back image", "key", key, "error", err)
+			}
+		}
+		logger.InfoContext(ctx, "Rolled back image", "key", img.Path, "pair_id", img.ID)
+	}
+}
+
+// ImageObjectKeys returns the Spaces keys of an image and all of its variants
+func ImageObjectKeys(img models.GeneratedImage) []string {
+	keys := []string{img.Path}
+	base := strings.TrimSuffix(img.Path, ".png")
+	for name := range img.Variants {
+		keys = append(keys, fmt.Sprintf("%s_%s.png", base, name))
+	}
+	return keys
 }
 
 // SaveImage saves image data to DigitalOcean Spaces CDN (production only)
 // New simplified API: uses pair-id as the atomic unit
 // index: 0 for left image, 1 for right image
-func (bp *BaseProvider) SaveImage(imageData []byte, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
+// open is called once per upload attempt and must return a fresh reader over the image each time
+func (bp *BaseProvider) SaveImage(ctx context.Context, open storage.Opener, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
 	// Determine side based on index
 	side := "left"
 	if index == 1 {
@@ -215,12 +425,13 @@ func (bp *BaseProvider) SaveImage(imageData []byte, provider, pairID, prompt str
 	}
 
 	// Always use DO Spaces (no local storage fallback)
-	return bp.SaveToSpaces(imageData, provider, pairID, side, prompt)
+	return bp.SaveToSpaces(ctx, open, provider, pairID, side, prompt)
 }
 
 // MakeHTTPRequest is a helper for making HTTP requests with error handling
-func (bp *BaseProvider) MakeHTTPRequest(method, url string, headers map[string]string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, body)
+// The request joins ctx's trace (see tracing.Transport) and is abandoned as soon as ctx is cancelled
+func (bp *BaseProvider) MakeHTTPRequest(ctx context.Context, method, url string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -0,0 +1,115 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHandleError(t *testing.T) {
+	tests := []struct {
+		err           string
+		wantCode      string
+		wantAvailable bool
+	}{
+		{"monthly quota exceeded", "QUOTA_EXCEEDED", false},
+		{"429 Too Many Requests", "RATE_LIMITED", false},
+		{"401 Unauthorized", "UNAUTHORIZED", true},
+		{"connection reset by peer", "UNKNOWN_ERROR", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			provider := NewBaseProvider("test", nil, time.Second)
+
+			providerErr := provider.HandleError(errors.New(tt.err))
+			if providerErr.Code != tt.wantCode {
+				t.Errorf("code = %s, want %s", providerErr.Code, tt.wantCode)
+			}
+			if provider.IsAvailable() != tt.wantAvailable {
+				t.Errorf("available = %v, want %v", provider.IsAvailable(), tt.wantAvailable)
+			}
+			status := provider.GetStatus()
+			if status.ErrorCount != 1 || status.LastError != tt.err {
+				t.Errorf("status = %+v, want the error counted", status)
+			}
+		})
+	}
+}
+
+func TestResetStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		unconfigured  string
+		wantAvailable bool
+		wantLastError string
+	}{
+		{"configured", "", true, ""},
+		{"not configured", "API key not set", false, "API key not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewBaseProvider("test", nil, time.Second)
+			if tt.unconfigured != "" {
+				provider.markUnconfigured(tt.unconfigured)
+			}
+			provider.HandleError(errors.New("quota exceeded"))
+
+			provider.ResetStatus()
+			status := provider.GetStatus()
+			if provider.IsAvailable() != tt.wantAvailable || status.LastError != tt.wantLastError {
+				t.Errorf("available %v with last error %q, want %v and %q", provider.IsAvailable(), status.LastError, tt.wantAvailable, tt.wantLastError)
+			}
+			if status.ErrorCount != 0 || status.QuotaHit || status.RateLimited {
+				t.Errorf("status = %+v, want the error state cleared", status)
+			}
+			if len(provider.RecentErrors(maxRecentErrors)) != 1 {
+				t.Error("reset cleared the error history")
+			}
+		})
+	}
+}
+
+func TestRecentErrors(t *testing.T) {
+	provider := NewBaseProvider("test", nil, time.Second)
+	for i := 0; i < maxRecentErrors+5; i++ {
+		provider.HandleError(fmt.Errorf("error %d", i))
+	}
+
+	tests := []struct {
+		limit     int
+		wantCount int
+	}{
+		{1, 1},
+		{20, 20},
+		{maxRecentErrors + 10, maxRecentErrors},
+	}
+
+	for _, tt := range tests {
+		records := provider.RecentErrors(tt.limit)
+		if len(records) != tt.wantCount {
+			t.Fatalf("RecentErrors(%d) returned %d records, want %d", tt.limit, len(records), tt.wantCount)
+		}
+		// Newest first; the oldest five fell out of the history
+		if newest := fmt.Sprintf("error %d", maxRecentErrors+4); records[0].Message != newest {
+			t.Errorf("RecentErrors(%d)[0] = %q, want %q", tt.limit, records[0].Message, newest)
+		}
+		if tt.wantCount == maxRecentErrors && records[len(records)-1].Message != "error 5" {
+			t.Errorf("oldest record = %q, want error 5", records[len(records)-1].Message)
+		}
+	}
+}
+
+func TestGetStatusReturnsACopy(t *testing.T) {
+	provider := NewBaseProvider("test", nil, time.Second)
+
+	status := provider.GetStatus()
+	status.Available = false
+	status.QuotaInfo.Remaining = 7
+
+	if !provider.IsAvailable() || provider.GetStatus().QuotaInfo.Remaining != 0 {
+		t.Error("changing the returned status changed the provider's")
+	}
+}
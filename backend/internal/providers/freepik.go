@@ -6,11 +6,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 	"strings"
 	"time"
 
+	"cgc-lb-and-cdn-backend/internal/config"
 	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
 )
 
 // FreepikProvider implements image generation using Freepik's API
@@ -38,22 +40,16 @@ type FreepikImage struct {
 }
 
 // NewFreepikProvider creates a new Freepik provider
-func NewFreepikProvider() *FreepikProvider {
-	apiKey := os.Getenv("FREEPIK_API_KEY")
-	if apiKey == "" {
-		// Provider will be marked as unavailable
-	}
-
+func NewFreepikProvider(cfg config.FreepikConfig, spaces *storage.SpacesClient) *FreepikProvider {
 	provider := &FreepikProvider{
-		BaseProvider: NewBaseProvider("freepik"),
-		apiKey:       apiKey,
-		baseURL:      "https://api.freepik.com",
+		BaseProvider: NewBaseProvider("freepik", spaces, cfg.Timeout),
+		apiKey:       cfg.APIKey,
+		baseURL:      cfg.BaseURL,
 	}
 
 	// Mark as unavailable if no API key
-	if apiKey == "" {
-		provider.status.Available = false
-		provider.status.LastError = "FREEPIK_API_KEY environment variable not set"
+	if cfg.APIKey == "" {
+		provider.markUnconfigured("API key not configured (providers.freepik.api_key or FREEPIK_API_KEY)")
 	}
 
 	return provider
@@ -64,10 +60,10 @@ func (fp *FreepikProvider) Generate(ctx context.Context, req *models.ImageReques
 	startTime := time.Now()
 
 	if !fp.IsAvailable() {
-		return nil, fmt.Errorf("freepik provider is not available: %s", fp.status.LastError)
+		return nil, fmt.Errorf("freepik provider is not available: %s", fp.lastError())
 	}
 
-	fmt.Printf("[FREEPIK] Starting generation with prompt: %s, count: %d\n", req.Prompt, ImageCount)
+	logger.InfoContext(ctx, "Starting generation", "provider", fp.GetName(), "prompt", req.Prompt, "count", ImageCount)
 
 	// Prepare request
 	freepikReq := FreepikRequest{
@@ -87,7 +83,7 @@ func (fp *FreepikProvider) Generate(ctx context.Context, req *models.ImageReques
 		"x-freepik-api-key": fp.apiKey,
 	}
 
-	resp, err := fp.MakeHTTPRequest("POST", fp.baseURL+"/v1/ai/text-to-image", headers, bytes.NewBuffer(jsonData))
+	resp, err := fp.MakeHTTPRequest(ctx, "POST", fp.baseURL+"/v1/ai/text-to-image", headers, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
@@ -112,11 +108,13 @@ func (fp *FreepikProvider) Generate(ctx context.Context, req *models.ImageReques
 
 		// Debug: Check if we have base64 data
 		if img.Base64 == "" {
+			fp.RollbackImages(ctx, images)
 			return nil, fmt.Errorf("image %d has empty base64 data", i+1)
 		}
 
-		generatedImg, err := fp.saveImageFromBase64(img.Base64, "freepik", req.PairID, req.Prompt, i)
+		generatedImg, err := fp.saveImageFromBase64(ctx, img.Base64, "freepik", req.PairID, req.Prompt, i)
 		if err != nil {
+			fp.RollbackImages(ctx, images)
 			return nil, fmt.Errorf("failed to save image %d: %w", i+1, err)
 		}
 
@@ -124,9 +122,7 @@ func (fp *FreepikProvider) Generate(ctx context.Context, req *models.ImageReques
 	}
 
 	// Update success status
-	fp.status.LastSuccess = time.Now()
-	fp.status.Available = true
-	fp.status.LastError = ""
+	fp.recordSuccess()
 
 	return &models.ImageResponse{
 		Images:    images,
@@ -142,8 +138,9 @@ func (fp *FreepikProvider) Generate(ctx context.Context, req *models.ImageReques
 	}, nil
 }
 
-// saveImageFromBase64 saves a base64 encoded image using shared BaseProvider method
-func (fp *FreepikProvider) saveImageFromBase64(base64Data, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
+// saveImageFromBase64 streams a base64 encoded image to Spaces using shared BaseProvider method
+// The string is decoded on the fly rather than into a second in-memory copy
+func (fp *FreepikProvider) saveImageFromBase64(ctx context.Context, base64Data, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
 	// Handle empty base64 data
 	if base64Data == "" {
 		return nil, fmt.Errorf("empty base64 data received")
@@ -157,17 +154,33 @@ func (fp *FreepikProvider) saveImageFromBase64(base64Data, provider, pairID, pro
 		}
 	}
 
-	// Decode base64
-	imageData, err := base64.StdEncoding.DecodeString(base64Data)
+	size, err := decodedBase64Len(base64Data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode base64 image (length: %d): %w", len(base64Data), err)
 	}
 
 	// Check if we got any data
-	if len(imageData) == 0 {
+	if size == 0 {
 		return nil, fmt.Errorf("decoded image data is empty")
 	}
 
+	open := func() (io.ReadCloser, int64, error) {
+		return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(base64Data))), size, nil
+	}
+
 	// Use shared BaseProvider method with new simplified API
-	return fp.BaseProvider.SaveImage(imageData, provider, pairID, prompt, index)
+	return fp.BaseProvider.SaveImage(ctx, open, provider, pairID, prompt, index)
+}
+
+// decodedBase64Len returns the number of bytes a padded standard base64 string decodes to
+// Line breaks are ignored, matching base64.NewDecoder
+func decodedBase64Len(data string) (int64, error) {
+	n := len(data) - strings.Count(data, "\n") - strings.Count(data, "\r")
+	if n%4 != 0 {
+		return 0, fmt.Errorf("illegal base64 data length %d", n)
+	}
+
+	trimmed := strings.TrimRight(data, "\r\n")
+	padding := len(trimmed) - len(strings.TrimRight(trimmed, "="))
+	return int64(n/4*3 - padding), nil
 }
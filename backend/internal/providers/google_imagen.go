@@ -1,12 +1,15 @@
 package providers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"time"
 
+	"cgc-lb-and-cdn-backend/internal/config"
 	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
 
 	"google.golang.org/genai"
 )
@@ -15,39 +18,33 @@ import (
 type GoogleImagenProvider struct {
 	*BaseProvider
 	client *genai.Client
+	model  string
 }
 
 // NewGoogleImagenProvider creates a new Google Imagen provider
-func NewGoogleImagenProvider() *GoogleImagenProvider {
-	apiKey := os.Getenv("GOOGLE_API_KEY")
-	if apiKey == "" {
+func NewGoogleImagenProvider(cfg config.GoogleConfig, spaces *storage.SpacesClient) *GoogleImagenProvider {
+	provider := &GoogleImagenProvider{
+		BaseProvider: NewBaseProvider("google-imagen", spaces, cfg.Timeout),
+		model:        cfg.Model,
+	}
+
+	if cfg.APIKey == "" {
 		// Provider will be marked as unavailable
-		provider := &GoogleImagenProvider{
-			BaseProvider: NewBaseProvider("google-imagen"),
-			client:       nil,
-		}
-		provider.status.Available = false
-		provider.status.LastError = "GOOGLE_API_KEY environment variable not set"
+		provider.markUnconfigured("API key not configured (providers.google.api_key or GOOGLE_API_KEY)")
 		return provider
 	}
 
-	// Create client
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, nil)
+	// Create client; it shares the base provider's HTTP client so calls get the configured timeout and tracing
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:     cfg.APIKey,
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: provider.httpClient,
+	})
 	if err != nil {
-		provider := &GoogleImagenProvider{
-			BaseProvider: NewBaseProvider("google-imagen"),
-			client:       nil,
-		}
-		provider.status.Available = false
-		provider.status.LastError = fmt.Sprintf("Failed to create genai client: %v", err)
+		provider.markUnconfigured(fmt.Sprintf("Failed to create genai client: %v", err))
 		return provider
 	}
-
-	provider := &GoogleImagenProvider{
-		BaseProvider: NewBaseProvider("google-imagen"),
-		client:       client,
-	}
+	provider.client = client
 
 	return provider
 }
@@ -57,14 +54,14 @@ func (gp *GoogleImagenProvider) Generate(ctx context.Context, req *models.ImageR
 	startTime := time.Now()
 
 	if !gp.IsAvailable() {
-		return nil, fmt.Errorf("google imagen provider is not available: %s", gp.status.LastError)
+		return nil, fmt.Errorf("google imagen provider is not available: %s", gp.lastError())
 	}
 
 	if gp.client == nil {
 		return nil, fmt.Errorf("genai client not initialized")
 	}
 
-	fmt.Printf("[GOOGLE-IMAGEN] Starting generation with prompt: %s, count: %d\n", req.Prompt, ImageCount)
+	logger.InfoContext(ctx, "Starting generation", "provider", gp.GetName(), "prompt", req.Prompt, "count", ImageCount)
 
 	// Create generation config
 	config := &genai.GenerateImagesConfig{
@@ -74,7 +71,7 @@ func (gp *GoogleImagenProvider) Generate(ctx context.Context, req *models.ImageR
 	// Generate images
 	generateImagesResponse, err := gp.client.Models.GenerateImages(
 		ctx,
-		"imagen-3.0-generate-002",
+		gp.model,
 		req.Prompt,
 		config,
 	)
@@ -82,7 +79,7 @@ func (gp *GoogleImagenProvider) Generate(ctx context.Context, req *models.ImageR
 		return nil, fmt.Errorf("failed to generate images: %w", err)
 	}
 
-	fmt.Printf("[GOOGLE-IMAGEN] API returned %d images (requested %d)\n", len(generateImagesResponse.GeneratedImages), ImageCount)
+	logger.DebugContext(ctx, "Imagen returned images", "provider", gp.GetName(), "images", len(generateImagesResponse.GeneratedImages), "requested", ImageCount)
 
 	// Process images
 	var images []models.GeneratedImage
@@ -90,22 +87,18 @@ func (gp *GoogleImagenProvider) Generate(ctx context.Context, req *models.ImageR
 		if i >= ImageCount {
 			break // Limit to ImageCount
 		}
-		fmt.Printf("[GOOGLE-IMAGEN] Processing image %d, size: %d bytes\n", i+1, len(image.Image.ImageBytes))
 		// Save image bytes directly with pair-id and prompt
-		generatedImg, err := gp.saveImageFromBytes(image.Image.ImageBytes, "google-imagen", req.PairID, req.Prompt, i)
+		generatedImg, err := gp.saveImageFromBytes(ctx, image.Image.ImageBytes, "google-imagen", req.PairID, req.Prompt, i)
 		if err != nil {
+			gp.RollbackImages(ctx, images)
 			return nil, fmt.Errorf("failed to save image %d: %w", i+1, err)
 		}
 
 		images = append(images, *generatedImg)
 	}
 
-	fmt.Printf("[GOOGLE-IMAGEN] Final response will contain %d images\n", len(images))
-
 	// Update success status
-	gp.status.LastSuccess = time.Now()
-	gp.status.Available = true
-	gp.status.LastError = ""
+	gp.recordSuccess()
 
 	return &models.ImageResponse{
 		Images:    images,
@@ -114,21 +107,25 @@ func (gp *GoogleImagenProvider) Generate(ctx context.Context, req *models.ImageR
 		RequestID: req.RequestID,
 		Duration:  time.Since(startTime),
 		Metadata: map[string]string{
-			"model":       "imagen-3.0-generate-002",
+			"model":       gp.model,
 			"api_version": "genai-v0.14.0",
 		},
 	}, nil
 }
 
 // saveImageFromBytes saves image bytes directly using shared BaseProvider method
-func (gp *GoogleImagenProvider) saveImageFromBytes(imageBytes []byte, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
+func (gp *GoogleImagenProvider) saveImageFromBytes(ctx context.Context, imageBytes []byte, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
 	// Check if we got any data
 	if len(imageBytes) == 0 {
 		return nil, fmt.Errorf("image bytes are empty")
 	}
 
-	fmt.Printf("[GOOGLE-IMAGEN] Saving image (size: %d bytes)\n", len(imageBytes))
+	logger.DebugContext(ctx, "Saving image", "provider", gp.GetName(), "pair_id", pairID, "index", index, "bytes", len(imageBytes))
+
+	open := func() (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader(imageBytes)), int64(len(imageBytes)), nil
+	}
 
 	// Use shared BaseProvider method with new simplified API
-	return gp.BaseProvider.SaveImage(imageBytes, provider, pairID, prompt, index)
+	return gp.BaseProvider.SaveImage(ctx, open, provider, pairID, prompt, index)
 }
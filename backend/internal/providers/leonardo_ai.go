@@ -7,10 +7,28 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 
+	"cgc-lb-and-cdn-backend/internal/config"
 	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// leonardoPollInterval is the wait between generation status checks
+	leonardoPollInterval = 5 * time.Second
+
+	// leonardoFallbackPollInterval is how often pending generations are checked on while completion callbacks are
+	// enabled, which only matters when a callback is lost
+	leonardoFallbackPollInterval = 20 * time.Second
+
+	// leonardoGenerationTimeout is how long a generation may take before it is given up on
+	leonardoGenerationTimeout = 2 * time.Minute
 )
 
 // LeonardoAIProvider implements image generation using Leonardo AI's API
@@ -19,6 +37,12 @@ type LeonardoAIProvider struct {
 	apiKey  string
 	baseURL string
 	modelID string
+
+	// Completion callbacks (see EnableCallbacks); valkey is nil while they are disabled
+	callbackMutex sync.Mutex
+	valkey        *storage.ValkeyClient
+	instance      string
+	finish        PendingFinisher
 }
 
 // LeonardoGenerationRequest represents the request structure for Leonardo AI
@@ -60,71 +84,79 @@ type LeonardoImage struct {
 }
 
 // NewLeonardoAIProvider creates a new Leonardo AI provider
-func NewLeonardoAIProvider() *LeonardoAIProvider {
-	apiKey := os.Getenv("LEONARDO_API_KEY")
-	if apiKey == "" {
-		// Provider will be marked as unavailable
-	}
-
+func NewLeonardoAIProvider(cfg config.LeonardoConfig, spaces *storage.SpacesClient) *LeonardoAIProvider {
 	provider := &LeonardoAIProvider{
-		BaseProvider: NewBaseProvider("leonardo-ai"),
-		apiKey:       apiKey,
-		baseURL:      "https://cloud.leonardo.ai/api/rest/v1",
-		modelID:      "6bef9f1b-29cb-40c7-b9df-32b51c1f67d3", // Leonardo Creative model
+		BaseProvider: NewBaseProvider("leonardo-ai", spaces, cfg.Timeout),
+		apiKey:       cfg.APIKey,
+		baseURL:      cfg.BaseURL,
+		modelID:      cfg.ModelID,
 	}
 
 	// Mark as unavailable if no API key
-	if apiKey == "" {
-		provider.status.Available = false
-		provider.status.LastError = "LEONARDO_API_KEY environment variable not set"
+	if cfg.APIKey == "" {
+		provider.markUnconfigured("API key not configured (providers.leonardo.api_key or LEONARDO_API_KEY)")
 	}
 
 	return provider
 }
 
 // Generate creates images using Leonardo AI's API
+// With completion callbacks enabled the generation is left pending once Leonardo has accepted it, and a response
+// without images is returned; the pair is finished in the background (see EnableCallbacks) unless req.Wait is set
 func (lp *LeonardoAIProvider) Generate(ctx context.Context, req *models.ImageRequest) (*models.ImageResponse, error) {
 	startTime := time.Now()
 
 	if !lp.IsAvailable() {
-		return nil, fmt.Errorf("leonardo ai provider is not available: %s", lp.status.LastError)
+		return nil, fmt.Errorf("leonardo ai provider is not available: %s", lp.lastError())
 	}
 
-	fmt.Printf("[LEONARDO-AI] Starting generation with prompt: %s, count: %d\n", req.Prompt, ImageCount)
+	logger.InfoContext(ctx, "Starting generation", "provider", lp.GetName(), "prompt", req.Prompt, "count", ImageCount)
 
 	// Step 1: Start generation
-	generationID, err := lp.startGeneration(req.Prompt, ImageCount)
+	generationID, err := lp.startGeneration(ctx, req.Prompt, ImageCount)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start generation: %w", err)
 	}
+	logger.InfoContext(ctx, "Generation started", "provider", lp.GetName(), "generation_id", generationID)
 
-	// Step 2: Poll for completion with pair-id and prompt
-	images, err := lp.pollForCompletion(ctx, generationID, "leonardo-ai", req.PairID, req.Prompt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to wait for completion: %w", err)
-	}
-
-	// Update success status
-	lp.status.LastSuccess = time.Now()
-	lp.status.Available = true
-	lp.status.LastError = ""
-
-	return &models.ImageResponse{
-		Images:    images,
+	response := &models.ImageResponse{
 		Provider:  lp.GetName(),
 		Success:   true,
 		RequestID: req.RequestID,
-		Duration:  time.Since(startTime),
 		Metadata: map[string]string{
 			"model_id":      lp.modelID,
 			"generation_id": generationID,
 			"api_version":   "v1",
 		},
-	}, nil
+	}
+
+	// Step 2: Leave the generation to its completion callback, or poll until it is done
+	var images []models.GeneratedImage
+	completion, pending := lp.leavePending(ctx, generationID, req)
+	switch {
+	case pending:
+		response.Pending = true
+		response.Duration = time.Since(startTime)
+		return response, nil
+	case completion != nil:
+		images, err = lp.finishGeneration(ctx, completion.Status, completion.ImageURLs, "leonardo-ai", req.PairID, req.Prompt)
+	default:
+		images, err = lp.pollForCompletion(ctx, generationID, "leonardo-ai", req.PairID, req.Prompt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for completion: %w", err)
+	}
+
+	// Update success status
+	lp.recordSuccess()
+
+	response.Images = images
+	response.Duration = time.Since(startTime)
+	return response, nil
 }
 
 // startGeneration initiates the image generation process
-func (lp *LeonardoAIProvider) startGeneration(prompt string, count int) (string, error) {
+func (lp *LeonardoAIProvider) startGeneration(ctx context.Context, prompt string, count int) (string, error) {
 	// Prepare request
 	leonardoReq := LeonardoGenerationRequest{
 		Height:            1024,
@@ -147,7 +179,7 @@ func (lp *LeonardoAIProvider) startGeneration(prompt string, count int) (string,
 		"Authorization": "Bearer " + lp.apiKey,
 	}
 
-	resp, err := lp.MakeHTTPRequest("POST", lp.baseURL+"/generations", headers, bytes.NewBuffer(jsonData))
+	resp, err := lp.MakeHTTPRequest(ctx, "POST", lp.baseURL+"/generations", headers, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", err
 	}
@@ -161,88 +193,127 @@ func (lp *LeonardoAIProvider) startGeneration(prompt string, count int) (string,
 	return leonardoResp.SDGenerationJob.GenerationID, nil
 }
 
-// pollForCompletion polls the API until generation is complete
+// pollForCompletion polls the generation every leonardoPollInterval until it finishes, then saves its images
+// Cancelling ctx stops the wait at once; the generation itself carries on at Leonardo
 func (lp *LeonardoAIProvider) pollForCompletion(ctx context.Context, generationID, provider, pairID, prompt string) ([]models.GeneratedImage, error) {
-	maxAttempts := 24 // 2 minutes with 5-second intervals
+	deadline := time.Now().Add(leonardoGenerationTimeout)
 
-	for attempt := 0; attempt < maxAttempts; attempt++ {
+	for attempt := 0; time.Now().Before(deadline); attempt++ {
+		timer := time.NewTimer(min(leonardoPollInterval, time.Until(deadline)))
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return nil, ctx.Err()
-		default:
+		case <-timer.C:
 		}
 
-		// Check status
-		headers := map[string]string{
-			"Authorization": "Bearer " + lp.apiKey,
-		}
-
-		url := fmt.Sprintf("%s/generations/%s", lp.baseURL, generationID)
-		resp, err := lp.MakeHTTPRequest("GET", url, headers, nil)
+		generation, err := lp.checkGeneration(ctx, generationID, attempt)
 		if err != nil {
 			return nil, err
 		}
 
-		var statusResp LeonardoStatusResponse
-		if err := lp.ParseJSONResponse(resp, &statusResp); err != nil {
-			return nil, err
-		}
-
-		generation := statusResp.GenerationsByPK
-
-		switch generation.Status {
-		case "COMPLETE":
-			// Download and save images
-			var images []models.GeneratedImage
-			for i, img := range generation.GeneratedImages {
-				if i >= ImageCount {
-					break // Limit to ImageCount
-				}
-				generatedImg, err := lp.saveImageFromURL(img.URL, provider, pairID, prompt, i)
-				if err != nil {
-					return nil, fmt.Errorf("failed to save image %d: %w", i+1, err)
-				}
-				images = append(images, *generatedImg)
-			}
-			return images, nil
-
-		case "FAILED":
-			return nil, fmt.Errorf("generation failed")
-
-		case "PENDING":
-			// Continue polling
-			time.Sleep(5 * time.Second)
-
-		default:
-			// Unknown status, continue polling
-			time.Sleep(5 * time.Second)
+		// PENDING or an unknown status: keep waiting
+		if generation.finished() {
+			return lp.finishGeneration(ctx, generation.Status, generation.imageURLs(), provider, pairID, prompt)
 		}
 	}
 
-	return nil, fmt.Errorf("generation timed out after %d attempts", maxAttempts)
+	return nil, fmt.Errorf("generation timed out after %s", leonardoGenerationTimeout)
 }
 
-// saveImageFromURL downloads and saves an image from a URL using shared BaseProvider method
-func (lp *LeonardoAIProvider) saveImageFromURL(imageURL, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
-	// Download image
-	resp, err := lp.httpClient.Get(imageURL)
+// checkGeneration fetches a generation's status and, once it is complete, its images
+func (lp *LeonardoAIProvider) checkGeneration(ctx context.Context, generationID string, attempt int) (*LeonardoGeneration, error) {
+	headers := map[string]string{
+		"Authorization": "Bearer " + lp.apiKey,
+	}
+
+	pollCtx, span := tracer.Start(ctx, "leonardo.poll", trace.WithAttributes(
+		attribute.String("leonardo.generation_id", generationID),
+		attribute.Int("leonardo.poll_attempt", attempt+1),
+	))
+
+	url := fmt.Sprintf("%s/generations/%s", lp.baseURL, generationID)
+	resp, err := lp.MakeHTTPRequest(pollCtx, "GET", url, headers, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download image: %w", err)
+		tracing.End(span, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download image: HTTP %d", resp.StatusCode)
+	var statusResp LeonardoStatusResponse
+	if err := lp.ParseJSONResponse(resp, &statusResp); err != nil {
+		tracing.End(span, err)
+		return nil, err
 	}
 
-	// Read image data into memory
-	imageData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image data: %w", err)
+	generation := statusResp.GenerationsByPK
+	span.SetAttributes(attribute.String("leonardo.status", generation.Status))
+	span.End()
+	logger.DebugContext(ctx, "Polled generation", "provider", lp.GetName(), "generation_id", generationID,
+		"attempt", attempt+1, "status", generation.Status)
+
+	return &generation, nil
+}
+
+// finished reports whether the generation is done, successfully or not; PENDING and unknown statuses are not
+func (g *LeonardoGeneration) finished() bool {
+	return g.Status == "COMPLETE" || g.Status == "FAILED"
+}
+
+// imageURLs returns the URLs of the generation's images
+func (g *LeonardoGeneration) imageURLs() []string {
+	urls := make([]string, 0, len(g.GeneratedImages))
+	for _, img := range g.GeneratedImages {
+		urls = append(urls, img.URL)
+	}
+	return urls
+}
+
+// finishGeneration saves the images of a completed generation, rolling back if any of them fails
+func (lp *LeonardoAIProvider) finishGeneration(ctx context.Context, status string, imageURLs []string, provider, pairID, prompt string) ([]models.GeneratedImage, error) {
+	if status != "COMPLETE" {
+		return nil, fmt.Errorf("generation failed")
+	}
+
+	// Download and save images
+	var images []models.GeneratedImage
+	for i, url := range imageURLs {
+		if i >= ImageCount {
+			break // Limit to ImageCount
+		}
+		generatedImg, err := lp.saveImageFromURL(ctx, url, provider, pairID, prompt, i)
+		if err != nil {
+			lp.RollbackImages(ctx, images)
+			return nil, fmt.Errorf("failed to save image %d: %w", i+1, err)
+		}
+		images = append(images, *generatedImg)
+	}
+	return images, nil
+}
+
+// saveImageFromURL streams an image from Leonardo's CDN straight into Spaces using shared BaseProvider method
+// Each upload attempt re-downloads the image, so nothing is buffered in memory
+func (lp *LeonardoAIProvider) saveImageFromURL(ctx context.Context, imageURL, provider, pairID, prompt string, index int) (*models.GeneratedImage, error) {
+	open := func() (io.ReadCloser, int64, error) {
+		// Download image
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create download request: %w", err)
+		}
+		resp, err := lp.httpClient.Do(req)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to download image: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("failed to download image: HTTP %d", resp.StatusCode)
+		}
+
+		return resp.Body, resp.ContentLength, nil
 	}
 
 	// Use shared BaseProvider method with new simplified API
-	return lp.BaseProvider.SaveImage(imageData, provider, pairID, prompt, index)
+	return lp.BaseProvider.SaveImage(ctx, open, provider, pairID, prompt, index)
 }
 
 // LeonardoUserResponse represents the response from Leonardo AI /me endpoint
@@ -271,19 +342,20 @@ type LeonardoUser struct {
 }
 
 // RefreshQuota updates quota information from Leonardo AI's /me endpoint
+// It works while the provider is out of quota, which is when operators most want to check it
 func (lp *LeonardoAIProvider) RefreshQuota(ctx context.Context) error {
-	if !lp.IsAvailable() {
-		return fmt.Errorf("provider not available")
+	if lp.apiKey == "" {
+		return fmt.Errorf("provider not configured")
 	}
 
-	fmt.Printf("[LEONARDO-AI] Refreshing quota information\n")
+	logger.InfoContext(ctx, "Refreshing quota information", "provider", lp.GetName())
 
 	// Make API request to /me endpoint
 	headers := map[string]string{
 		"Authorization": "Bearer " + lp.apiKey,
 	}
 
-	resp, err := lp.MakeHTTPRequest("GET", lp.baseURL+"/me", headers, nil)
+	resp, err := lp.MakeHTTPRequest(ctx, "GET", lp.baseURL+"/me", headers, nil)
 	if err != nil {
 		return fmt.Errorf("failed to fetch user info: %w", err)
 	}
@@ -314,7 +386,7 @@ func (lp *LeonardoAIProvider) RefreshQuota(ctx context.Context) error {
 	}
 
 	// Update quota information
-	lp.status.QuotaInfo = &models.ProviderQuota{
+	lp.setQuota(&models.ProviderQuota{
 		APITokens:          userDetail.APISubscriptionTokens,
 		PaidTokens:         userDetail.PaidTokens,
 		SubscriptionTokens: userDetail.SubscriptionTokens,
@@ -324,10 +396,10 @@ func (lp *LeonardoAIProvider) RefreshQuota(ctx context.Context) error {
 		RenewalDate:        renewalDate,
 		LastUpdated:        time.Now(),
 		Supported:          true,
-	}
+	})
 
-	fmt.Printf("[LEONARDO-AI] Quota updated - API Tokens: %d, Renewal: %s\n",
-		userDetail.APISubscriptionTokens, renewalDate.Format("2006-01-02"))
+	logger.InfoContext(ctx, "Quota updated", "provider", lp.GetName(),
+		"api_credits", userDetail.APISubscriptionTokens, "concurrency_slots", userDetail.APIConcurrencySlots, "renewal", renewalDate.Format("2006-01-02"))
 
 	return nil
 }
@@ -0,0 +1,90 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/models"
+)
+
+// fakeLeonardo starts generation gen-1, which stays pending; start runs before the generation is started
+type fakeLeonardo struct {
+	start func(r *http.Request)
+}
+
+func (f *fakeLeonardo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/generations":
+		if f.start != nil {
+			f.start(r)
+		}
+		fmt.Fprint(w, `{"sdGenerationJob":{"generationId":"gen-1"}}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/generations/gen-1":
+		fmt.Fprint(w, `{"generations_by_pk":{"status":"PENDING"}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestLeonardo returns a provider that talks to fake, with callbacks disabled so it polls
+func newTestLeonardo(t *testing.T, fake *fakeLeonardo, timeout time.Duration) *LeonardoAIProvider {
+	t.Helper()
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return NewLeonardoAIProvider(config.LeonardoConfig{APIKey: "key", BaseURL: server.URL, Timeout: timeout}, nil)
+}
+
+func TestLeonardoGenerateStopsEarly(t *testing.T) {
+	tests := []struct {
+		name         string
+		startHangs   bool
+		timeout      time.Duration
+		cancelAfter  time.Duration // 0 never cancels
+		wantCanceled bool
+	}{
+		{"cancelled while starting", true, time.Minute, 50 * time.Millisecond, true},
+		{"cancelled between polls", false, time.Minute, 50 * time.Millisecond, true},
+		{"request timeout", true, 50 * time.Millisecond, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLeonardo{}
+			provider := newTestLeonardo(t, fake, tt.timeout)
+			if tt.startHangs {
+				// Held until the test ends, before the server closes; the server only notices a client leaving once
+				// the request body has been read
+				released := make(chan struct{})
+				t.Cleanup(func() { close(released) })
+				fake.start = func(*http.Request) { <-released }
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			_, err := provider.Generate(ctx, &models.ImageRequest{PairID: "pair", Prompt: "a fox"})
+			if err == nil {
+				t.Fatal("Generate succeeded")
+			}
+			if errors.Is(err, context.Canceled) != tt.wantCanceled {
+				t.Errorf("Generate error = %v, want cancelled = %v", err, tt.wantCanceled)
+			}
+			// Well before the first poll would have been made
+			if elapsed := time.Since(start); elapsed > leonardoPollInterval/2 {
+				t.Errorf("Generate returned after %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+const (
+	// leonardoCallbackEvent is the webhook event type sent when a generation finishes
+	leonardoCallbackEvent = "image_generation.complete"
+
+	// pendingGenerationGrace keeps a pending generation in Valkey a little past the point it is given up on, so a
+	// sweep that runs late still finds and fails it rather than leaving its pair unaccounted for
+	pendingGenerationGrace = 5 * time.Minute
+
+	// earlyCallbackTTL is how long a callback that beat its generation's registration is kept for it
+	earlyCallbackTTL = time.Minute
+
+	// leonardoStatusTimedOut marks a pending generation that was given up on; Leonardo never reports it
+	leonardoStatusTimedOut = "TIMED_OUT"
+)
+
+// PendingFinisher is told how a pending generation ended, with the response holding its saved images or the error
+// it failed with; it stores the pair and records the outcome as the generation request would have
+type PendingFinisher func(ctx context.Context, pending *storage.PendingGeneration, response *models.ImageResponse, err error)
+
+// LeonardoCallback is the body of the webhook Leonardo calls when a generation finishes
+type LeonardoCallback struct {
+	Type string `json:"type"`
+	Data struct {
+		Object LeonardoCallbackGeneration `json:"object"`
+	} `json:"data"`
+}
+
+// LeonardoCallbackGeneration is the finished generation carried by a callback
+type LeonardoCallbackGeneration struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"`
+	Images []LeonardoImage `json:"images"`
+}
+
+// ParseLeonardoCallback decodes a webhook body into a completion
+// It returns nil without an error for events other than a finished generation, which are acknowledged and ignored
+func ParseLeonardoCallback(body []byte) (*storage.GenerationCompletion, error) {
+	var callback LeonardoCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("failed to parse callback: %w", err)
+	}
+	if callback.Type != leonardoCallbackEvent {
+		return nil, nil
+	}
+
+	generation := callback.Data.Object
+	if generation.ID == "" {
+		return nil, fmt.Errorf("callback has no generation ID")
+	}
+
+	completion := &storage.GenerationCompletion{
+		GenerationID: generation.ID,
+		Status:       generation.Status,
+	}
+	if completion.Status == "" {
+		completion.Status = "COMPLETE" // Implied by the event type
+	}
+	for _, image := range generation.Images {
+		completion.ImageURLs = append(completion.ImageURLs, image.URL)
+	}
+
+	return completion, nil
+}
+
+// EnableCallbacks stops generation requests from waiting for Leonardo: once a generation is accepted it is
+// recorded in Valkey and the request returns, and the droplet that receives its completion callback finishes it
+// (see ResumeGeneration), handing the result to finish
+// Until ctx is cancelled, pending generations whose callback is overdue are also polled every
+// leonardoFallbackPollInterval, by one droplet at a time, in case the callback was lost. Without Valkey the
+// provider keeps polling within the request, and false is returned
+func (lp *LeonardoAIProvider) EnableCallbacks(ctx context.Context, valkey *storage.ValkeyClient, finish PendingFinisher) bool {
+	if valkey == nil {
+		logger.Warn("Leonardo callbacks need Valkey, polling for completion instead", "provider", lp.GetName())
+		return false
+	}
+
+	instance, _ := os.Hostname()
+	lp.callbackMutex.Lock()
+	lp.valkey = valkey
+	lp.instance = instance
+	lp.finish = finish
+	lp.callbackMutex.Unlock()
+
+	go lp.pollPending(ctx)
+	logger.Info("Finishing Leonardo generations on completion callbacks", "provider", lp.GetName())
+	return true
+}
+
+// ResumeGeneration finishes the pending generation a completion callback is for, returning it once its images are
+// saved and handed to the finisher
+// It returns nil if the generation is not pending: another droplet may have finished it, or it has not been
+// recorded yet, in which case the completion is kept for the registration to pick up
+func (lp *LeonardoAIProvider) ResumeGeneration(ctx context.Context, completion *storage.GenerationCompletion) (*storage.PendingGeneration, error) {
+	valkey, _, _ := lp.callbacks()
+	if valkey == nil {
+		return nil, fmt.Errorf("leonardo callbacks are not enabled")
+	}
+
+	pending, err := valkey.ClaimPendingGeneration(ctx, lp.GetName(), completion.GenerationID, completion, earlyCallbackTTL)
+	if err != nil || pending == nil {
+		return nil, err
+	}
+
+	lp.finishPending(ctx, pending, completion.Status, completion.ImageURLs)
+	return pending, nil
+}
+
+// callbacks returns what EnableCallbacks set up; valkey is nil while callbacks are disabled
+func (lp *LeonardoAIProvider) callbacks() (*storage.ValkeyClient, string, PendingFinisher) {
+	lp.callbackMutex.Lock()
+	defer lp.callbackMutex.Unlock()
+
+	return lp.valkey, lp.instance, lp.finish
+}
+
+// leavePending records a started generation to be finished on its completion callback, reporting true when the
+// request can return without it
+// When its callback already arrived, the completion is returned instead for the request to finish it at once.
+// With callbacks disabled, for requests that wait, or when the generation cannot be recorded, it returns neither
+// and the request polls
+func (lp *LeonardoAIProvider) leavePending(ctx context.Context, generationID string, req *models.ImageRequest) (*storage.GenerationCompletion, bool) {
+	valkey, instance, _ := lp.callbacks()
+	if valkey == nil || req.Wait {
+		return nil, false
+	}
+
+	completion, err := valkey.RegisterPendingGeneration(ctx, &storage.PendingGeneration{
+		GenerationID: generationID,
+		Provider:     lp.GetName(),
+		PairID:       req.PairID,
+		Prompt:       req.Prompt,
+		RequestID:    logging.RequestID(ctx),
+		Instance:     instance,
+		StartedAt:    time.Now().UTC(),
+	}, leonardoGenerationTimeout+pendingGenerationGrace)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to record pending generation, polling for completion instead",
+			"provider", lp.GetName(), "generation_id", generationID, "error", err)
+		return nil, false
+	}
+	if completion != nil {
+		logger.InfoContext(ctx, "Generation callback arrived before the generation was recorded", "provider", lp.GetName(),
+			"generation_id", generationID, "status", completion.Status)
+		return completion, false
+	}
+
+	logger.InfoContext(ctx, "Generation left pending until its callback", "provider", lp.GetName(), "generation_id", generationID)
+	return nil, true
+}
+
+// finishPending saves a claimed generation's images and hands the outcome to the finisher
+// It is logged under the request ID of the request that started the generation
+func (lp *LeonardoAIProvider) finishPending(ctx context.Context, pending *storage.PendingGeneration, status string, imageURLs []string) {
+	ctx = logging.WithRequestID(ctx, pending.RequestID)
+	_, _, finish := lp.callbacks()
+
+	var response *models.ImageResponse
+	var images []models.GeneratedImage
+	err := fmt.Errorf("generation timed out after %s", leonardoGenerationTimeout)
+	if status != leonardoStatusTimedOut {
+		images, err = lp.finishGeneration(ctx, status, imageURLs, pending.Provider, pending.PairID, pending.Prompt)
+	}
+	if err == nil {
+		lp.recordSuccess()
+		response = &models.ImageResponse{
+			Images:    images,
+			Provider:  lp.GetName(),
+			Success:   true,
+			RequestID: pending.RequestID,
+			Duration:  time.Since(pending.StartedAt),
+			Metadata: map[string]string{
+				"model_id":      lp.modelID,
+				"generation_id": pending.GenerationID,
+				"api_version":   "v1",
+			},
+		}
+		logger.InfoContext(ctx, "Pending generation finished", "provider", lp.GetName(), "generation_id", pending.GenerationID,
+			"pair_id", pending.PairID, "duration", response.Duration)
+	} else {
+		logger.WarnContext(ctx, "Pending generation failed", "provider", lp.GetName(), "generation_id", pending.GenerationID,
+			"pair_id", pending.PairID, "error", err)
+	}
+
+	if finish != nil {
+		finish(ctx, pending, response, err)
+	}
+}
+
+// pollPending checks on overdue pending generations every leonardoFallbackPollInterval until ctx is cancelled
+func (lp *LeonardoAIProvider) pollPending(ctx context.Context) {
+	ticker := time.NewTicker(leonardoFallbackPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lp.sweepPending(ctx)
+		}
+	}
+}
+
+// sweepPending polls the generations that have been pending for longer than a callback normally takes, finishing
+// those that are done and failing those that ran out of time
+func (lp *LeonardoAIProvider) sweepPending(ctx context.Context) {
+	valkey, instance, _ := lp.callbacks()
+
+	claimed, err := valkey.ClaimPendingGenerationSweep(ctx, instance, leonardoFallbackPollInterval)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to check for overdue generations", "provider", lp.GetName(), "error", err)
+		return
+	}
+	if !claimed {
+		return // Another droplet has this round
+	}
+
+	ids, err := valkey.ListPendingGenerations(ctx, time.Now().Add(-leonardoFallbackPollInterval))
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to list overdue generations", "provider", lp.GetName(), "error", err)
+		return
+	}
+
+	for attempt, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+
+		pending, err := valkey.GetPendingGeneration(ctx, id)
+		if err != nil {
+			logger.WarnContext(ctx, "Failed to look up overdue generation", "provider", lp.GetName(), "generation_id", id, "error", err)
+			continue
+		}
+		if pending == nil {
+			// Finished or expired since it was listed; claiming clears it from the index
+			if _, err := valkey.ClaimPendingGeneration(ctx, lp.GetName(), id, nil, 0); err != nil {
+				logger.WarnContext(ctx, "Failed to clear overdue generation", "provider", lp.GetName(), "generation_id", id, "error", err)
+			}
+			continue
+		}
+
+		status, urls := "", []string(nil)
+		if time.Since(pending.StartedAt) > leonardoGenerationTimeout {
+			status = leonardoStatusTimedOut
+		} else {
+			generation, err := lp.checkGeneration(ctx, id, attempt)
+			if err != nil {
+				logger.WarnContext(ctx, "Failed to poll overdue generation", "provider", lp.GetName(), "generation_id", id, "error", err)
+				continue
+			}
+			if !generation.finished() {
+				continue
+			}
+			status, urls = generation.Status, generation.imageURLs()
+		}
+
+		claimedPending, err := valkey.ClaimPendingGeneration(ctx, lp.GetName(), id, nil, 0)
+		if err != nil || claimedPending == nil {
+			continue // Its callback won the race, or Valkey failed and the next sweep tries again
+		}
+		logger.InfoContext(ctx, "Finishing generation without its callback", "provider", lp.GetName(), "generation_id", id, "status", status)
+		lp.finishPending(ctx, claimedPending, status, urls)
+	}
+}
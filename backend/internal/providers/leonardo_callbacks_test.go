@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+// The pending generation scripts are tested against an in-memory Valkey in the storage package
+func TestParseLeonardoCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    *storage.GenerationCompletion
+		wantErr bool
+	}{
+		{
+			"complete",
+			`{"type":"image_generation.complete","data":{"object":{"id":"gen-1","status":"COMPLETE","images":[{"url":"https://cdn/a.png"},{"url":"https://cdn/b.png"}]}}}`,
+			&storage.GenerationCompletion{GenerationID: "gen-1", Status: "COMPLETE", ImageURLs: []string{"https://cdn/a.png", "https://cdn/b.png"}},
+			false,
+		},
+		{
+			"status implied by the event",
+			`{"type":"image_generation.complete","data":{"object":{"id":"gen-1","images":[]}}}`,
+			&storage.GenerationCompletion{GenerationID: "gen-1", Status: "COMPLETE"},
+			false,
+		},
+		{
+			"failed",
+			`{"type":"image_generation.complete","data":{"object":{"id":"gen-1","status":"FAILED"}}}`,
+			&storage.GenerationCompletion{GenerationID: "gen-1", Status: "FAILED"},
+			false,
+		},
+		{"other event", `{"type":"model_training.complete","data":{"object":{"id":"model-1"}}}`, nil, false},
+		{"no generation ID", `{"type":"image_generation.complete","data":{"object":{"status":"COMPLETE"}}}`, nil, true},
+		{"not JSON", `type=image_generation.complete`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLeonardoCallback([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLeonardoCallback error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLeonardoCallback = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbacksNeedValkey(t *testing.T) {
+	provider := NewLeonardoAIProvider(config.LeonardoConfig{APIKey: "key", Timeout: time.Second}, nil)
+
+	if provider.EnableCallbacks(context.Background(), nil, nil) {
+		t.Error("EnableCallbacks succeeded without Valkey")
+	}
+	if _, err := provider.ResumeGeneration(context.Background(), &storage.GenerationCompletion{GenerationID: "gen-1"}); err == nil {
+		t.Error("ResumeGeneration succeeded with callbacks disabled")
+	}
+}
@@ -0,0 +1,151 @@
+// Package ratelimit limits how often clients may call a route, with token buckets held in Valkey so every
+// droplet behind the load balancer enforces the same limits
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/auth"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logger reports rate limit checks that could not be made
+var logger = logging.Logger("ratelimit")
+
+// SessionHeader carries the frontend's anonymous session ID (see getSessionId in the frontend)
+const SessionHeader = "X-Session-ID"
+
+// maxSessionIDLength caps session IDs used in bucket keys; longer IDs are ignored
+const maxSessionIDLength = 128
+
+// Rule allows Limit requests per Window for each client, refilling continuously
+// A zero Limit disables the rule
+type Rule struct {
+	Name   string
+	Limit  int64
+	Window time.Duration
+}
+
+// ParseRule parses a rule written as "<limit>/<window>", e.g. "60/1m" or "10/1h"; "0" or "off" disables it
+func ParseRule(name, spec string) (Rule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "0" || spec == "off" {
+		return Rule{Name: name}, nil
+	}
+
+	limitPart, windowPart, found := strings.Cut(spec, "/")
+	if !found {
+		return Rule{}, fmt.Errorf("rate limit %q for %s must look like 60/1m", spec, name)
+	}
+
+	limit, err := strconv.ParseInt(strings.TrimSpace(limitPart), 10, 64)
+	if err != nil || limit < 0 {
+		return Rule{}, fmt.Errorf("invalid limit in rate limit %q for %s", spec, name)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowPart))
+	if err != nil || window < time.Second {
+		return Rule{}, fmt.Errorf("invalid window in rate limit %q for %s (at least 1s)", spec, name)
+	}
+
+	return Rule{Name: name, Limit: limit, Window: window}, nil
+}
+
+// Enabled reports whether the rule limits anything
+func (r Rule) Enabled() bool {
+	return r.Limit > 0
+}
+
+// Limiter enforces rules against buckets stored in Valkey
+type Limiter struct {
+	valkey *storage.ValkeyClient
+}
+
+// NewLimiter creates a limiter; with a nil Valkey client every request is allowed
+func NewLimiter(valkey *storage.ValkeyClient) *Limiter {
+	return &Limiter{valkey: valkey}
+}
+
+// Limit rejects requests over the rule's limit with 429 and sets RateLimit-* headers on every response
+// Requests made with an API key are limited per key; anonymous requests are limited per client IP and,
+// when the request carries one, per session ID as well
+// Must run after auth.Authenticator.RequireScope so the caller's key is known
+// When Valkey is unavailable requests are allowed rather than failing the site
+func (l *Limiter) Limit(rule Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.valkey == nil || !rule.Enabled() {
+			c.Next()
+			return
+		}
+
+		result, err := l.valkey.TakeRateLimitToken(c.Request.Context(), bucketKeys(c, rule), rule.Limit, rule.Window)
+		if err != nil {
+			logger.WarnContext(c.Request.Context(), "Allowing request after rate limit check failed", "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit, int64(rule.Window.Seconds())))
+		c.Header("RateLimit-Limit", strconv.FormatInt(rule.Limit, 10))
+		c.Header("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		c.Header("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+
+		if !result.Allowed {
+			retryAfter := strconv.FormatInt(ceilSeconds(result.RetryAfter), 10)
+			c.Header("Retry-After", retryAfter)
+			utils.RespondWithError(c, http.StatusTooManyRequests, "Too many requests, slow down", "RATE_LIMITED", map[string]string{
+				"rule":        rule.Name,
+				"limit":       strconv.FormatInt(rule.Limit, 10),
+				"window":      rule.Window.String(),
+				"retry_after": retryAfter,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// bucketKeys returns the buckets a request is counted against
+func bucketKeys(c *gin.Context, rule Rule) []string {
+	prefix := fmt.Sprintf("ratelimit:%s:", rule.Name)
+
+	if principal := auth.PrincipalFromContext(c); principal != nil && !principal.Anonymous {
+		if principal.KeyID != "" {
+			return []string{prefix + "key:" + principal.KeyID}
+		}
+		return []string{prefix + "key:" + principal.Name} // Bootstrap key
+	}
+
+	keys := []string{prefix + "ip:" + c.ClientIP()}
+	if sessionID := SessionID(c); sessionID != "" {
+		keys = append(keys, prefix+"session:"+sessionID)
+	}
+	return keys
+}
+
+// SessionID returns the anonymous session ID sent in the X-Session-ID header or session_id query parameter
+func SessionID(c *gin.Context) string {
+	id := c.GetHeader(SessionHeader)
+	if id == "" {
+		id = c.Query("session_id")
+	}
+	if len(id) > maxSessionIDLength {
+		return ""
+	}
+	return id
+}
+
+// ceilSeconds rounds a duration up to whole seconds, as the rate limit headers require
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
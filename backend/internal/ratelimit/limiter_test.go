@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Rule
+		wantErr bool
+	}{
+		{"60/1m", Rule{Name: "vote", Limit: 60, Window: time.Minute}, false},
+		{" 10 / 1h ", Rule{Name: "vote", Limit: 10, Window: time.Hour}, false},
+		{"0", Rule{Name: "vote"}, false},
+		{"off", Rule{Name: "vote"}, false},
+		{"60", Rule{}, true},
+		{"-1/1m", Rule{}, true},
+		{"many/1m", Rule{}, true},
+		{"60/soon", Rule{}, true},
+		{"60/500ms", Rule{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRule("vote", tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRule(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+		if got.Enabled() != (tt.want.Limit > 0) {
+			t.Errorf("ParseRule(%q).Enabled() = %v", tt.spec, got.Enabled())
+		}
+	}
+}
+
+func TestBucketKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rule := Rule{Name: "vote", Limit: 60, Window: time.Minute}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		target    string
+		session   string
+		want      []string
+	}{
+		{"anonymous", nil, "/", "", []string{"ratelimit:vote:ip:192.0.2.1"}},
+		{"anonymous with header", nil, "/", "abc", []string{"ratelimit:vote:ip:192.0.2.1", "ratelimit:vote:session:abc"}},
+		{"anonymous with query", nil, "/?session_id=def", "", []string{"ratelimit:vote:ip:192.0.2.1", "ratelimit:vote:session:def"}},
+		{"session ID too long", nil, "/", strings.Repeat("x", maxSessionIDLength+1), []string{"ratelimit:vote:ip:192.0.2.1"}},
+		{"anonymous principal", &auth.Principal{Name: "anonymous", Anonymous: true}, "/", "abc", []string{"ratelimit:vote:ip:192.0.2.1", "ratelimit:vote:session:abc"}},
+		{"issued key", &auth.Principal{KeyID: "key-1", Name: "ci"}, "/", "abc", []string{"ratelimit:vote:key:key-1"}},
+		{"bootstrap key", &auth.Principal{Name: "bootstrap"}, "/", "", []string{"ratelimit:vote:key:bootstrap"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			router := gin.New()
+			router.GET("/", func(c *gin.Context) {
+				if tt.principal != nil {
+					c.Set("auth.principal", tt.principal) // Where RequireScope leaves the caller
+				}
+				got = bucketKeys(c, rule)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.session != "" {
+				req.Header.Set(SessionHeader, tt.session)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bucketKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitWithoutValkey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", NewLimiter(nil).Limit(Rule{Name: "vote", Limit: 1, Window: time.Minute}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200 without Valkey", i, recorder.Code)
+		}
+	}
+}
+
+func TestCeilSeconds(t *testing.T) {
+	for d, want := range map[time.Duration]int64{0: 0, time.Millisecond: 1, time.Second: 1, 1001 * time.Millisecond: 2} {
+		if got := ceilSeconds(d); got != want {
+			t.Errorf("ceilSeconds(%v) = %d, want %d", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package reindex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+// DefaultWorkers is the number of pairs processed in parallel when Options.Workers is not set
+const DefaultWorkers = 8
+
+// Options controls a rebuild run
+type Options struct {
+	DryRun  bool // Report what would be written without touching Valkey
+	Workers int  // Number of pairs processed in parallel
+}
+
+// Report summarizes a rebuild run
+type Report struct {
+	DryRun      bool          `json:"dry_run"`
+	Objects     int           `json:"objects"`      // Objects listed under images/
+	Pairs       int           `json:"pairs"`        // Distinct <provider>/<pair-id> prefixes
+	Restored    []string      `json:"restored"`     // Pairs whose pair:<id> key was (or would be) written
+	Relisted    []string      `json:"relisted"`     // Stored pairs that were (or would be) added back to pairs:all
+	Unchanged   int           `json:"unchanged"`    // Pairs already fully indexed
+	HalfWritten []string      `json:"half_written"` // Pairs with only one of left.png/right.png
+	Orphaned    []string      `json:"orphaned"`     // Objects outside the images/<provider>/<pair-id>/<file> layout, or variants with no original
+	Errors      []string      `json:"errors"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Rebuilder reconstructs the pair:* keys and pairs:all from the images stored in Spaces,
+// the single source of truth for which pairs exist
+type Rebuilder struct {
+	spaces *storage.SpacesClient
+	valkey *storage.ValkeyClient
+}
+
+// NewRebuilder creates a new rebuilder
+func NewRebuilder(spaces *storage.SpacesClient, valkey *storage.ValkeyClient) *Rebuilder {
+	return &Rebuilder{
+		spaces: spaces,
+		valkey: valkey,
+	}
+}
+
+// pairObjects groups the objects stored under one images/<provider>/<pair-id>/ prefix
+type pairObjects struct {
+	provider string
+	pairID   string
+	files    map[string]storage.ObjectInfo // file name ("left.png", "left_256.png", ...) -> object
+}
+
+// Run lists images/, groups objects by pair and restores every complete pair that is missing from Valkey
+// Running it repeatedly is safe: existing keys are never overwritten and pairs:all never gains duplicates
+func (r *Rebuilder) Run(ctx context.Context, opts Options) (*Report, error) {
+	start := time.Now()
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+
+	objects, err := r.spaces.ListObjects(ctx, "images/")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		DryRun:      opts.DryRun,
+		Objects:     len(objects),
+		Restored:    []string{},
+		Relisted:    []string{},
+		HalfWritten: []string{},
+		Orphaned:    []string{},
+		Errors:      []string{},
+	}
+
+	pairs, orphaned := groupPairObjects(objects)
+	report.Orphaned = append(report.Orphaned, orphaned...)
+	report.Pairs = len(pairs)
+
+	var mutex sync.Mutex
+	work := make(chan *pairObjects)
+	var wg sync.WaitGroup
+
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range work {
+				outcome, err := r.processPair(ctx, group, opts.DryRun)
+
+				mutex.Lock()
+				switch {
+				case err != nil:
+					report.Errors = append(report.Errors, fmt.Sprintf("%s/%s: %v", group.provider, group.pairID, err))
+				case outcome == outcomeRestored:
+					report.Restored = append(report.Restored, group.pairID)
+				case outcome == outcomeRelisted:
+					report.Relisted = append(report.Relisted, group.pairID)
+				case outcome == outcomeUnchanged:
+					report.Unchanged++
+				case outcome == outcomeHalfWritten:
+					report.HalfWritten = append(report.HalfWritten, group.provider+"/"+group.pairID)
+				case outcome == outcomeOrphaned:
+					for file := range group.files {
+						report.Orphaned = append(report.Orphaned, fmt.Sprintf("images/%s/%s/%s", group.provider, group.pairID, file))
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, group := range pairs {
+		select {
+		case work <- group:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for _, list := range [][]string{report.Restored, report.Relisted, report.HalfWritten, report.Orphaned, report.Errors} {
+		sort.Strings(list)
+	}
+	report.Duration = time.Since(start)
+
+	return report, nil
+}
+
+// pairOutcome is what happened to a single pair
+type pairOutcome int
+
+const (
+	outcomeUnchanged pairOutcome = iota
+	outcomeRestored
+	outcomeRelisted
+	outcomeHalfWritten
+	outcomeOrphaned
+)
+
+// groupPairObjects groups objects by their images/<provider>/<pair-id>/ prefix, keyed by "<provider>/<pair-id>"
+// Keys outside that layout are returned as orphaned
+func groupPairObjects(objects []storage.ObjectInfo) (map[string]*pairObjects, []string) {
+	pairs := make(map[string]*pairObjects)
+	orphaned := make([]string, 0)
+	for _, object := range objects {
+		// Layout: images/<provider>/<pair-id>/<file>
+		parts := strings.Split(object.Key, "/")
+		if len(parts) != 4 || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+			orphaned = append(orphaned, object.Key)
+			continue
+		}
+
+		prefix := parts[1] + "/" + parts[2]
+		group, ok := pairs[prefix]
+		if !ok {
+			group = &pairObjects{provider: parts[1], pairID: parts[2], files: make(map[string]storage.ObjectInfo)}
+			pairs[prefix] = group
+		}
+		group.files[parts[3]] = object
+	}
+
+	return pairs, orphaned
+}
+
+// processPair restores one pair from its objects' listing and metadata
+func (r *Rebuilder) processPair(ctx context.Context, group *pairObjects, dryRun bool) (pairOutcome, error) {
+	left, hasLeft := group.files["left.png"]
+	right, hasRight := group.files["right.png"]
+
+	switch {
+	case !hasLeft && !hasRight:
+		return outcomeOrphaned, nil
+	case !hasLeft || !hasRight:
+		return outcomeHalfWritten, nil
+	}
+
+	state, err := r.valkey.GetPairIndexState(ctx, group.pairID)
+	if err != nil {
+		return 0, err
+	}
+	if state.Stored && (state.InRotation || state.Archived) {
+		return outcomeUnchanged, nil
+	}
+
+	outcome := outcomeRelisted
+	if !state.Stored {
+		outcome = outcomeRestored
+	}
+	if dryRun {
+		return outcome, nil
+	}
+
+	pair, err := r.buildPair(ctx, group, left, right)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.valkey.RestorePair(ctx, pair, state); err != nil {
+		return 0, err
+	}
+
+	return outcome, nil
+}
+
+// buildPair reconstructs an ImagePair from object metadata and the variants present in the listing
+func (r *Rebuilder) buildPair(ctx context.Context, group *pairObjects, left, right storage.ObjectInfo) (*storage.ImagePair, error) {
+	metadata, err := r.spaces.HeadObject(ctx, left.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := metadata["prompt"]
+	if prompt == "" {
+		prompt = "Unknown prompt"
+	}
+
+	// The timestamp of a pair is when its last image finished uploading
+	timestamp := left.LastModified
+	if right.LastModified.After(timestamp) {
+		timestamp = right.LastModified
+	}
+
+	pair := &storage.ImagePair{
+		PairID:    group.pairID,
+		Prompt:    prompt,
+		Provider:  group.provider,
+		LeftURL:   r.spaces.CDNURL(left.Key),
+		RightURL:  r.spaces.CDNURL(right.Key),
+		Timestamp: timestamp,
+	}
+
+	for _, side := range []string{"left", "right"} {
+		variants := make(map[string]string)
+		for file, object := range group.files {
+			if name, ok := strings.CutPrefix(strings.TrimSuffix(file, ".png"), side+"_"); ok {
+				variants[name] = r.spaces.CDNURL(object.Key)
+			}
+		}
+		if len(variants) == 0 {
+			continue
+		}
+
+		// Perceptual hashes are recorded on the variants (the original is uploaded before its hash is known)
+		var hash string
+		if placeholder, ok := group.files[side+"_placeholder.png"]; ok {
+			if variantMetadata, err := r.spaces.HeadObject(ctx, placeholder.Key); err == nil {
+				hash = variantMetadata["phash"]
+			}
+		}
+
+		if side == "left" {
+			pair.LeftVariants, pair.LeftHash = variants, hash
+		} else {
+			pair.RightVariants, pair.RightHash = variants, hash
+		}
+	}
+
+	return pair, nil
+}
@@ -0,0 +1,79 @@
+package reindex
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+func TestGroupPairObjects(t *testing.T) {
+	keys := []string{
+		"images/freepik/p1/left.png",
+		"images/freepik/p1/right.png",
+		"images/freepik/p1/left_256.png",
+		"images/leonardo/p2/left.png",
+		"images/stray.png",
+		"images/freepik//left.png",
+		"images/freepik/p3/nested/left.png",
+	}
+	objects := make([]storage.ObjectInfo, len(keys))
+	for i, key := range keys {
+		objects[i] = storage.ObjectInfo{Key: key}
+	}
+
+	pairs, orphaned := groupPairObjects(objects)
+
+	files := make(map[string][]string)
+	for prefix, group := range pairs {
+		if prefix != group.provider+"/"+group.pairID {
+			t.Errorf("group %s holds %s/%s", prefix, group.provider, group.pairID)
+		}
+		for file := range group.files {
+			files[prefix] = append(files[prefix], file)
+		}
+		sort.Strings(files[prefix])
+	}
+	wantFiles := map[string][]string{
+		"freepik/p1":  {"left.png", "left_256.png", "right.png"},
+		"leonardo/p2": {"left.png"},
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("groups = %v, want %v", files, wantFiles)
+	}
+
+	wantOrphaned := []string{"images/stray.png", "images/freepik//left.png", "images/freepik/p3/nested/left.png"}
+	if !reflect.DeepEqual(orphaned, wantOrphaned) {
+		t.Errorf("orphaned = %v, want %v", orphaned, wantOrphaned)
+	}
+}
+
+func TestProcessIncompletePair(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  pairOutcome
+	}{
+		{"variants only", []string{"left_256.png", "right_placeholder.png"}, outcomeOrphaned},
+		{"left only", []string{"left.png", "left_256.png"}, outcomeHalfWritten},
+		{"right only", []string{"right.png"}, outcomeHalfWritten},
+	}
+
+	// Incomplete pairs are classified from the listing alone, without looking at Valkey
+	rebuilder := NewRebuilder(nil, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &pairObjects{provider: "freepik", pairID: "p", files: make(map[string]storage.ObjectInfo)}
+			for _, file := range tt.files {
+				group.files[file] = storage.ObjectInfo{Key: "images/freepik/p/" + file}
+			}
+
+			outcome, err := rebuilder.processPair(context.Background(), group, false)
+			if err != nil || outcome != tt.want {
+				t.Errorf("processPair() = %v, %v, want %v", outcome, err, tt.want)
+			}
+		})
+	}
+}
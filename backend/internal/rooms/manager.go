@@ -0,0 +1,520 @@
+// Package rooms runs multiplayer battle rooms: a host starts a round, every participant votes on the same pair,
+// the votes are revealed together and recorded, and the host moves the room on to the next pair
+// Room state lives in Valkey and updates travel over Valkey pub/sub, so participants may be connected to any droplet
+package rooms
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/events"
+	"cgc-lb-and-cdn-backend/internal/fraud"
+	"cgc-lb-and-cdn-backend/internal/imaging"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/models"
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// logger records room lifecycle events
+var logger = logging.Logger("rooms")
+
+// Commands participants send over the room connection
+const (
+	CommandStart  = "start"  // Host: show the first pair
+	CommandVote   = "vote"   // Anyone: vote for "left" or "right" on the current pair
+	CommandReveal = "reveal" // Host: reveal the votes without waiting for everyone
+	CommandNext   = "next"   // Host: move on to the next pair once votes are revealed
+)
+
+// Message types the server sends over the room connection
+const (
+	MessageWelcome = "welcome" // Sent once on connect with the participant's own ID
+	MessageRoom    = "room"    // Sent whenever the room changes
+	MessageError   = "error"   // Sent when a command is rejected
+)
+
+const (
+	// roomIDAlphabet avoids characters that are easily confused when reading a room code aloud
+	roomIDAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	roomIDLength   = 6
+
+	// maxNameLength caps participant display names
+	maxNameLength = 32
+)
+
+var (
+	// ErrNotHost is returned for host-only commands sent by other participants
+	ErrNotHost = errors.New("only the host can do that")
+
+	// ErrNoMorePairs is returned when every pair in rotation has already been shown in the room
+	ErrNoMorePairs = errors.New("every pair has already been shown in this room")
+)
+
+// Command is a message from a participant
+type Command struct {
+	Type   string `json:"type"`
+	Winner string `json:"winner,omitempty"` // For CommandVote
+}
+
+// Message is a message to a participant
+type Message struct {
+	Type          string `json:"type"`
+	ParticipantID string `json:"participant_id,omitempty"` // MessageWelcome only
+	Room          *View  `json:"room,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// View is the room as participants see it; votes stay hidden until they are revealed
+type View struct {
+	RoomID       string                    `json:"room_id"`
+	State        string                    `json:"state"`
+	Round        int                       `json:"round"`
+	HostID       string                    `json:"host_id,omitempty"`
+	Pair         *models.ImagePairResponse `json:"pair,omitempty"`
+	Participants []ParticipantView         `json:"participants"`
+	Tally        map[string]int            `json:"tally,omitempty"` // Revealed rounds only
+}
+
+// ParticipantView is a participant as others see them
+type ParticipantView struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Voted bool   `json:"voted"`
+	Vote  string `json:"vote,omitempty"` // Revealed rounds only
+}
+
+// Manager applies participant commands to rooms stored in Valkey
+type Manager struct {
+	valkey   *storage.ValkeyClient
+	broker   *events.Broker
+	detector *fraud.Detector
+}
+
+// NewManager creates a room manager; revealed votes are scored by detector and announced through broker like
+// any other vote
+func NewManager(valkey *storage.ValkeyClient, broker *events.Broker, detector *fraud.Detector) *Manager {
+	return &Manager{
+		valkey:   valkey,
+		broker:   broker,
+		detector: detector,
+	}
+}
+
+// Create opens a new room in the waiting state
+// The returned room carries the host token the creator needs to connect as host
+func (m *Manager) Create(ctx context.Context) (*storage.Room, error) {
+	now := time.Now().UTC()
+
+	for attempt := 0; attempt < 5; attempt++ {
+		roomID, err := newRoomID()
+		if err != nil {
+			return nil, err
+		}
+
+		room := &storage.Room{
+			RoomID:       roomID,
+			HostToken:    uuid.New().String(),
+			State:        storage.RoomStateWaiting,
+			ShownPairIDs: []string{},
+			Participants: []storage.RoomParticipant{},
+			Votes:        make(map[string]string),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+
+		created, err := m.valkey.CreateRoom(ctx, room)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			logger.InfoContext(ctx, "Created room", "room_id", roomID)
+			return room, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to allocate a room ID")
+}
+
+// Get returns the current view of a room
+func (m *Manager) Get(ctx context.Context, roomID string) (*View, error) {
+	room, err := m.valkey.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return m.view(ctx, room), nil
+}
+
+// Join adds a participant to a room and returns their ID
+// Joining with the room's host token makes the participant the host. The session ID and client IP of the
+// connection are kept so the participant's votes can be scored like votes cast outside a room
+func (m *Manager) Join(ctx context.Context, roomID, name, hostToken, sessionID, clientIP string) (string, error) {
+	participant := storage.RoomParticipant{
+		ID:        uuid.New().String(),
+		Name:      cleanName(name),
+		JoinedAt:  time.Now().UTC(),
+		SessionID: sessionID,
+		ClientIP:  clientIP,
+	}
+
+	room, err := m.valkey.UpdateRoom(ctx, roomID, func(room *storage.Room) error {
+		room.Participants = append(room.Participants, participant)
+		if room.HostID == "" || (hostToken != "" && hostToken == room.HostToken) {
+			room.HostID = participant.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logger.InfoContext(ctx, "Participant joined room", "room_id", roomID, "participant", participant.Name, "participants", len(room.Participants))
+	if room.State == storage.RoomStateVoting {
+		m.markServed(ctx, room, []storage.RoomParticipant{participant})
+	}
+	m.publish(ctx, room)
+	return participant.ID, nil
+}
+
+// Leave removes a participant from a room
+// The host role passes to the longest-connected participant; the room closes when the last one leaves
+func (m *Manager) Leave(ctx context.Context, roomID, participantID string) error {
+	var revealed map[string]string
+	room, err := m.valkey.UpdateRoom(ctx, roomID, func(room *storage.Room) error {
+		revealed = nil
+
+		remaining := make([]storage.RoomParticipant, 0, len(room.Participants))
+		for _, participant := range room.Participants {
+			if participant.ID != participantID {
+				remaining = append(remaining, participant)
+			}
+		}
+		room.Participants = remaining
+		delete(room.Votes, participantID)
+
+		if len(room.Participants) == 0 {
+			room.State = storage.RoomStateClosed
+			return nil
+		}
+		if room.HostID == participantID {
+			room.HostID = room.Participants[0].ID
+		}
+
+		// The participant everyone was waiting for may just have left
+		if room.State == storage.RoomStateVoting && len(room.Votes) > 0 && allVoted(room) {
+			revealed = reveal(room)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "Participant left room", "room_id", roomID, "participant_id", participantID, "participants", len(room.Participants))
+	m.recordVotes(ctx, room, revealed)
+	m.publish(ctx, room)
+	return nil
+}
+
+// Handle applies a command from a participant
+func (m *Manager) Handle(ctx context.Context, roomID, participantID string, cmd Command) error {
+	switch cmd.Type {
+	case CommandStart, CommandNext:
+		return m.next(ctx, roomID, participantID)
+	case CommandVote:
+		return m.vote(ctx, roomID, participantID, cmd.Winner)
+	case CommandReveal:
+		return m.forceReveal(ctx, roomID, participantID)
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Type)
+	}
+}
+
+// Subscribe returns a channel of encoded Messages for a room, published from any droplet
+func (m *Manager) Subscribe(ctx context.Context, roomID string) (<-chan string, func() error) {
+	return m.valkey.SubscribeRoom(ctx, roomID)
+}
+
+// next shows a pair that has not been shown in the room yet and opens voting on it
+func (m *Manager) next(ctx context.Context, roomID, participantID string) error {
+	current, err := m.valkey.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if current.HostID != participantID {
+		return ErrNotHost
+	}
+	if current.State == storage.RoomStateVoting {
+		return fmt.Errorf("reveal the votes before moving on")
+	}
+
+	// Pick the pair outside the transaction; the round check below rejects a concurrent advance
+	pair, err := m.valkey.GetRandomImagePair(ctx, current.ShownPairIDs)
+	if err != nil {
+		if strings.Contains(err.Error(), "no unvoted pairs available") {
+			return ErrNoMorePairs
+		}
+		return err
+	}
+
+	room, err := m.valkey.UpdateRoom(ctx, roomID, func(room *storage.Room) error {
+		if room.Round != current.Round || room.State == storage.RoomStateVoting {
+			return fmt.Errorf("the room has already moved on")
+		}
+		room.State = storage.RoomStateVoting
+		room.Round++
+		room.PairID = pair.PairID
+		room.ShownPairIDs = append(room.ShownPairIDs, pair.PairID)
+		room.Votes = make(map[string]string)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "Started room round", "room_id", roomID, "round", room.Round, "pair_id", pair.PairID)
+	m.markServed(ctx, room, room.Participants)
+	m.publish(ctx, room)
+	return nil
+}
+
+// vote records a participant's choice for the current round, revealing the votes once everyone has voted
+func (m *Manager) vote(ctx context.Context, roomID, participantID, winner string) error {
+	if winner != "left" && winner != "right" {
+		return fmt.Errorf("winner must be 'left' or 'right'")
+	}
+
+	var revealed map[string]string
+	room, err := m.valkey.UpdateRoom(ctx, roomID, func(room *storage.Room) error {
+		revealed = nil
+
+		if room.State != storage.RoomStateVoting {
+			return fmt.Errorf("voting is not open")
+		}
+		if _, exists := room.Votes[participantID]; exists {
+			return fmt.Errorf("you have already voted this round")
+		}
+
+		room.Votes[participantID] = winner
+		if allVoted(room) {
+			revealed = reveal(room)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.recordVotes(ctx, room, revealed)
+	m.publish(ctx, room)
+	return nil
+}
+
+// forceReveal reveals the current round's votes without waiting for everyone
+func (m *Manager) forceReveal(ctx context.Context, roomID, participantID string) error {
+	var revealed map[string]string
+	room, err := m.valkey.UpdateRoom(ctx, roomID, func(room *storage.Room) error {
+		revealed = nil
+
+		if room.HostID != participantID {
+			return ErrNotHost
+		}
+		if room.State != storage.RoomStateVoting {
+			return fmt.Errorf("voting is not open")
+		}
+
+		revealed = reveal(room)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.recordVotes(ctx, room, revealed)
+	m.publish(ctx, room)
+	return nil
+}
+
+// markServed records that the round's pair was shown to the participants' sessions, as serving a pair outside a
+// room does, so the fraud checks for unserved pairs and hasty votes apply to room votes as well
+func (m *Manager) markServed(ctx context.Context, room *storage.Room, participants []storage.RoomParticipant) {
+	for _, participant := range participants {
+		if participant.SessionID == "" {
+			continue
+		}
+		if err := m.valkey.MarkImageAsViewed(ctx, participant.SessionID, room.PairID); err != nil {
+			logger.WarnContext(ctx, "Failed to mark room pair as served", "room_id", room.RoomID, "pair_id", room.PairID, "error", err)
+		}
+	}
+}
+
+// recordVotes scores the votes of a revealed round and stores them like votes cast outside a room; suspicious
+// votes may be quarantined instead
+// Only the droplet whose update performed the reveal calls this, so each vote is recorded once
+func (m *Manager) recordVotes(ctx context.Context, room *storage.Room, votes map[string]string) {
+	if len(votes) == 0 {
+		return
+	}
+
+	pair, err := m.valkey.GetImagePairByID(ctx, room.PairID)
+	if err != nil {
+		logger.WarnContext(ctx, "Could not fetch pair for room vote metadata", "room_id", room.RoomID, "pair_id", room.PairID, "error", err)
+	}
+
+	participants := make(map[string]storage.RoomParticipant, len(room.Participants))
+	for _, participant := range room.Participants {
+		participants[participant.ID] = participant
+	}
+
+	quarantined := 0
+	for participantID, winner := range votes {
+		vote := &storage.Vote{
+			PairID: room.PairID,
+			Winner: winner,
+		}
+		if pair != nil {
+			vote.Provider = pair.Provider
+			vote.Prompt = pair.Prompt
+		}
+
+		participant := participants[participantID]
+		assessment := m.detector.Check(ctx, vote, participant.SessionID, participant.ClientIP)
+		if assessment.Quarantined {
+			logger.InfoContext(ctx, "Room vote quarantined", "room_id", room.RoomID, "pair_id", room.PairID, "reasons", assessment.Reasons)
+			quarantined++
+			continue
+		}
+
+		if err := m.valkey.RecordVote(ctx, vote); err != nil {
+			logger.ErrorContext(ctx, "Failed to record room vote", "room_id", room.RoomID, "pair_id", room.PairID, "error", err)
+			continue
+		}
+		m.broker.PublishVote(ctx, vote)
+	}
+
+	logger.InfoContext(ctx, "Recorded room votes", "room_id", room.RoomID, "round", room.Round, "votes", len(votes), "quarantined", quarantined)
+}
+
+// publish sends the room's current view to every participant
+func (m *Manager) publish(ctx context.Context, room *storage.Room) {
+	if room.State == storage.RoomStateClosed {
+		return
+	}
+
+	payload, err := json.Marshal(Message{Type: MessageRoom, Room: m.view(ctx, room)})
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to marshal room", "room_id", room.RoomID, "error", err)
+		return
+	}
+	if err := m.valkey.PublishRoomUpdate(ctx, room.RoomID, payload); err != nil {
+		logger.WarnContext(ctx, "Failed to publish room", "room_id", room.RoomID, "error", err)
+	}
+}
+
+// view builds what participants see of a room
+func (m *Manager) view(ctx context.Context, room *storage.Room) *View {
+	revealed := room.State == storage.RoomStateRevealed
+
+	view := &View{
+		RoomID:       room.RoomID,
+		State:        room.State,
+		Round:        room.Round,
+		HostID:       room.HostID,
+		Participants: make([]ParticipantView, 0, len(room.Participants)),
+	}
+
+	for _, participant := range room.Participants {
+		vote, voted := room.Votes[participant.ID]
+		participantView := ParticipantView{
+			ID:    participant.ID,
+			Name:  participant.Name,
+			Voted: voted,
+		}
+		if revealed {
+			participantView.Vote = vote
+		}
+		view.Participants = append(view.Participants, participantView)
+	}
+
+	if revealed {
+		view.Tally = map[string]int{"left": 0, "right": 0}
+		for _, winner := range room.Votes {
+			view.Tally[winner]++
+		}
+	}
+
+	if room.PairID != "" {
+		pair, err := m.valkey.GetImagePairByID(ctx, room.PairID)
+		if err != nil {
+			logger.WarnContext(ctx, "Could not fetch pair for room", "room_id", room.RoomID, "pair_id", room.PairID, "error", err)
+		} else {
+			view.Pair = &models.ImagePairResponse{
+				PairID:   pair.PairID,
+				Prompt:   pair.Prompt,
+				Provider: pair.Provider,
+				LeftURL:  pair.LeftURL,
+				RightURL: pair.RightURL,
+
+				LeftSrcset:       imaging.Srcset(pair.LeftVariants),
+				RightSrcset:      imaging.Srcset(pair.RightVariants),
+				LeftPlaceholder:  pair.LeftVariants[imaging.PlaceholderVariant],
+				RightPlaceholder: pair.RightVariants[imaging.PlaceholderVariant],
+			}
+		}
+	}
+
+	return view
+}
+
+// allVoted reports whether every participant has voted in the current round
+func allVoted(room *storage.Room) bool {
+	for _, participant := range room.Participants {
+		if _, voted := room.Votes[participant.ID]; !voted {
+			return false
+		}
+	}
+	return true
+}
+
+// reveal moves a room to the revealed state and returns the votes to record
+func reveal(room *storage.Room) map[string]string {
+	room.State = storage.RoomStateRevealed
+
+	votes := make(map[string]string, len(room.Votes))
+	for participantID, winner := range room.Votes {
+		votes[participantID] = winner
+	}
+	return votes
+}
+
+// cleanName trims and shortens a display name, defaulting to "Guest"
+func cleanName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Guest"
+	}
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = string(runes[:maxNameLength])
+	}
+	return name
+}
+
+// newRoomID generates a short room code that is easy to share out loud
+func newRoomID() (string, error) {
+	random := make([]byte, roomIDLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate room ID: %w", err)
+	}
+
+	id := make([]byte, roomIDLength)
+	for i, b := range random {
+		id[i] = roomIDAlphabet[int(b)%len(roomIDAlphabet)]
+	}
+	return string(id), nil
+}
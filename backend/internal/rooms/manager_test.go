@@ -0,0 +1,97 @@
+package rooms
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+)
+
+func TestAllVoted(t *testing.T) {
+	participants := []storage.RoomParticipant{{ID: "a"}, {ID: "b"}}
+
+	tests := []struct {
+		name         string
+		participants []storage.RoomParticipant
+		votes        map[string]string
+		want         bool
+	}{
+		{"nobody voted", participants, map[string]string{}, false},
+		{"some voted", participants, map[string]string{"a": "left"}, false},
+		{"everyone voted", participants, map[string]string{"a": "left", "b": "right"}, true},
+		{"votes from participants who left", participants[:1], map[string]string{"a": "left", "b": "right"}, true},
+		{"empty room", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := &storage.Room{Participants: tt.participants, Votes: tt.votes}
+			if got := allVoted(room); got != tt.want {
+				t.Errorf("allVoted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReveal(t *testing.T) {
+	room := &storage.Room{State: storage.RoomStateVoting, Votes: map[string]string{"a": "left", "b": "right"}}
+
+	votes := reveal(room)
+	if room.State != storage.RoomStateRevealed {
+		t.Errorf("state = %s, want %s", room.State, storage.RoomStateRevealed)
+	}
+	if !reflect.DeepEqual(votes, room.Votes) {
+		t.Errorf("revealed %v, want %v", votes, room.Votes)
+	}
+
+	// The returned votes are a copy, so later changes to the room do not alter what gets recorded
+	room.Votes["c"] = "left"
+	if _, ok := votes["c"]; ok {
+		t.Error("revealed votes share the room's map")
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	long := strings.Repeat("é", maxNameLength+5)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Ada", "Ada"},
+		{"trimmed", "  Ada \n", "Ada"},
+		{"empty", "", "Guest"},
+		{"blank", "   ", "Guest"},
+		{"too long", long, strings.Repeat("é", maxNameLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanName(tt.in); got != tt.want {
+				t.Errorf("cleanName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRoomID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := newRoomID()
+		if err != nil {
+			t.Fatalf("newRoomID: %v", err)
+		}
+		if len(id) != roomIDLength {
+			t.Errorf("room ID %q has length %d, want %d", id, len(id), roomIDLength)
+		}
+		if strings.Trim(id, roomIDAlphabet) != "" {
+			t.Errorf("room ID %q uses characters outside %s", id, roomIDAlphabet)
+		}
+		seen[id] = true
+	}
+	if len(seen) < 95 {
+		t.Errorf("only %d distinct IDs in 100", len(seen))
+	}
+}
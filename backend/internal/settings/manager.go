@@ -0,0 +1,326 @@
+// Package settings keeps every server instance in step with the runtime settings operators change through the
+// admin API: maintenance mode, provider enable/disable and weights, and how often scheduled generation runs
+// Settings live in Valkey; changes are announced over pub/sub and polled for as a fallback
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/storage"
+	"cgc-lb-and-cdn-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logger records settings changes and refresh failures
+var logger = logging.Logger("settings")
+
+const (
+	// maxProviderWeight caps provider weights so one provider cannot be made to crowd out the rest entirely by accident
+	maxProviderWeight = 100
+
+	// minGenerationInterval matches the generator cron, which runs every minute
+	minGenerationInterval = time.Minute
+
+	// maxGenerationInterval keeps scheduled generation from being paused by accident; use generation_enabled instead
+	maxGenerationInterval = 7 * 24 * time.Hour
+
+	// maintenanceRetryAfter is the Retry-After sent with maintenance responses, in seconds
+	maintenanceRetryAfter = "120"
+
+	// defaultMaintenanceMessage is shown when maintenance mode is on without a message
+	defaultMaintenanceMessage = "The service is down for maintenance, please try again shortly"
+)
+
+var (
+	// ErrInvalidSettings wraps every validation failure of a settings change
+	ErrInvalidSettings = errors.New("invalid settings")
+
+	// ErrUnavailable is returned for settings changes when Valkey is not configured
+	ErrUnavailable = errors.New("runtime settings require Valkey")
+
+	// ErrGenerationPaused is returned by ClaimScheduledGeneration while an operator has paused generation
+	ErrGenerationPaused = errors.New("scheduled generation is paused")
+
+	// ErrGenerationNotDue is returned by ClaimScheduledGeneration when the interval has not passed yet
+	ErrGenerationNotDue = errors.New("scheduled generation is not due yet")
+)
+
+// Patch is a partial settings change; fields left nil are not changed
+type Patch struct {
+	MaintenanceMode    *bool                    `json:"maintenance_mode"`
+	MaintenanceMessage *string                  `json:"maintenance_message"`
+	GenerationEnabled  *bool                    `json:"generation_enabled"`
+	GenerationInterval *string                  `json:"generation_interval"` // A duration such as "10m"
+	Providers          map[string]ProviderPatch `json:"providers"`
+}
+
+// ProviderPatch is a partial change to one provider's settings
+type ProviderPatch struct {
+	Enabled *bool    `json:"enabled"`
+	Weight  *float64 `json:"weight"`
+}
+
+// Manager caches the current runtime settings and tells listeners when they change
+type Manager struct {
+	valkey       *storage.ValkeyClient
+	providers    []string
+	pollInterval time.Duration
+
+	mutex     sync.RWMutex
+	current   *storage.RuntimeSettings
+	listeners []func(settings *storage.RuntimeSettings)
+}
+
+// NewManager creates a manager for the given provider names
+// With a nil Valkey client the defaults are used and settings cannot be changed
+func NewManager(valkey *storage.ValkeyClient, providers []string, pollInterval time.Duration) *Manager {
+	sorted := append([]string(nil), providers...)
+	sort.Strings(sorted)
+
+	return &Manager{
+		valkey:       valkey,
+		providers:    sorted,
+		pollInterval: pollInterval,
+		current:      storage.DefaultRuntimeSettings(),
+	}
+}
+
+// Start loads the settings and keeps them current until ctx is cancelled
+// Changes arrive over Valkey pub/sub; the settings are also re-read every poll interval in case an
+// announcement was missed while the subscription was reconnecting
+func (m *Manager) Start(ctx context.Context) {
+	if m.valkey == nil {
+		logger.Info("Valkey not configured, using default runtime settings")
+		return
+	}
+
+	m.refresh(ctx)
+
+	announcements, closeSubscription := m.valkey.SubscribeSettings(ctx)
+	go func() {
+		<-ctx.Done()
+		closeSubscription()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(m.pollInterval)
+		defer ticker.Stop()
+
+		logger.Info("Watching runtime settings", "poll_interval", m.pollInterval.String())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-announcements:
+				if !ok {
+					announcements = nil // Subscription closed; keep polling until ctx is cancelled
+					continue
+				}
+				m.refresh(ctx)
+			case <-ticker.C:
+				m.refresh(ctx)
+			}
+		}
+	}()
+}
+
+// Current returns the settings in effect; callers must not modify them
+func (m *Manager) Current() *storage.RuntimeSettings {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.current
+}
+
+// OnChange registers fn to be called with the new settings whenever they change, and once right away
+func (m *Manager) OnChange(fn func(settings *storage.RuntimeSettings)) {
+	m.mutex.Lock()
+	m.listeners = append(m.listeners, fn)
+	current := m.current
+	m.mutex.Unlock()
+
+	fn(current)
+}
+
+// Update validates and applies a change on behalf of actor and returns the new settings and audit entry
+// The entry is nil when the change left everything as it was
+func (m *Manager) Update(ctx context.Context, actor string, patch Patch) (*storage.RuntimeSettings, *storage.SettingsAuditEntry, error) {
+	if m.valkey == nil {
+		return nil, nil, ErrUnavailable
+	}
+
+	var interval time.Duration
+	if patch.GenerationInterval != nil {
+		parsed, err := time.ParseDuration(*patch.GenerationInterval)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: generation_interval %q is not a duration", ErrInvalidSettings, *patch.GenerationInterval)
+		}
+		if parsed < minGenerationInterval || parsed > maxGenerationInterval {
+			return nil, nil, fmt.Errorf("%w: generation_interval must be between %v and %v", ErrInvalidSettings, minGenerationInterval, maxGenerationInterval)
+		}
+		interval = parsed
+	}
+	for name, provider := range patch.Providers {
+		if !m.knownProvider(name) {
+			return nil, nil, fmt.Errorf("%w: unknown provider %q (providers: %s)", ErrInvalidSettings, name, strings.Join(m.providers, ", "))
+		}
+		if provider.Weight != nil && (*provider.Weight < 0 || *provider.Weight > maxProviderWeight) {
+			return nil, nil, fmt.Errorf("%w: weight for %s must be between 0 and %d", ErrInvalidSettings, name, maxProviderWeight)
+		}
+	}
+
+	updated, entry, err := m.valkey.UpdateRuntimeSettings(ctx, actor, func(settings *storage.RuntimeSettings) error {
+		if patch.MaintenanceMode != nil {
+			settings.MaintenanceMode = *patch.MaintenanceMode
+		}
+		if patch.MaintenanceMessage != nil {
+			settings.MaintenanceMessage = strings.TrimSpace(*patch.MaintenanceMessage)
+		}
+		if patch.GenerationEnabled != nil {
+			settings.GenerationEnabled = *patch.GenerationEnabled
+		}
+		if patch.GenerationInterval != nil {
+			settings.GenerationIntervalSeconds = int64(interval.Seconds())
+		}
+		for name, provider := range patch.Providers {
+			current := settings.Provider(name)
+			if provider.Enabled != nil {
+				current.Enabled = *provider.Enabled
+			}
+			if provider.Weight != nil {
+				current.Weight = *provider.Weight
+			}
+			settings.Providers[name] = current
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if entry != nil {
+		logger.InfoContext(ctx, "Runtime settings changed", "actor", actor, "version", entry.Version, "fields", len(entry.Changes))
+	}
+	m.apply(updated) // Don't wait for the announcement to come back to this instance
+	return updated, entry, nil
+}
+
+// ResetProvider asks every instance to clear a provider's error state, recording actor in the audit trail
+func (m *Manager) ResetProvider(ctx context.Context, actor, provider string) (*storage.RuntimeSettings, *storage.SettingsAuditEntry, error) {
+	if m.valkey == nil {
+		return nil, nil, ErrUnavailable
+	}
+	if !m.knownProvider(provider) {
+		return nil, nil, fmt.Errorf("%w: unknown provider %q (providers: %s)", ErrInvalidSettings, provider, strings.Join(m.providers, ", "))
+	}
+
+	updated, entry, err := m.valkey.UpdateRuntimeSettings(ctx, actor, func(settings *storage.RuntimeSettings) error {
+		current := settings.Provider(provider)
+		now := time.Now().UTC()
+		current.ResetAt = &now
+		settings.Providers[provider] = current
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.InfoContext(ctx, "Provider reset requested", "actor", actor, "provider", provider, "version", entry.Version)
+	m.apply(updated)
+	return updated, entry, nil
+}
+
+// History returns up to limit settings changes, newest first
+func (m *Manager) History(ctx context.Context, limit int64) ([]storage.SettingsAuditEntry, error) {
+	if m.valkey == nil {
+		return []storage.SettingsAuditEntry{}, nil
+	}
+	return m.valkey.ListSettingsAudit(ctx, limit)
+}
+
+// Maintenance rejects requests with 503 while maintenance mode is on
+func (m *Manager) Maintenance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := m.Current()
+		if !settings.MaintenanceMode {
+			c.Next()
+			return
+		}
+
+		message := settings.MaintenanceMessage
+		if message == "" {
+			message = defaultMaintenanceMessage
+		}
+
+		c.Header("Retry-After", maintenanceRetryAfter)
+		utils.RespondWithError(c, http.StatusServiceUnavailable, message, "MAINTENANCE", nil)
+		c.Abort()
+	}
+}
+
+// ClaimScheduledGeneration reports whether a scheduled generation run may go ahead, returning ErrGenerationPaused
+// or ErrGenerationNotDue if not; at most one run across all instances is allowed per generation interval
+func (m *Manager) ClaimScheduledGeneration(ctx context.Context) error {
+	settings := m.Current()
+	if !settings.GenerationEnabled {
+		return ErrGenerationPaused
+	}
+	if m.valkey == nil {
+		return nil
+	}
+
+	claimed, err := m.valkey.ClaimGenerationSlot(ctx, time.Duration(settings.GenerationIntervalSeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrGenerationNotDue
+	}
+	return nil
+}
+
+// refresh re-reads the settings from Valkey and applies them if they changed
+func (m *Manager) refresh(ctx context.Context) {
+	settings, err := m.valkey.GetRuntimeSettings(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to refresh runtime settings", "error", err)
+		return
+	}
+	m.apply(settings)
+}
+
+// apply makes settings current and notifies listeners if they are newer than the settings already in effect
+func (m *Manager) apply(settings *storage.RuntimeSettings) {
+	m.mutex.Lock()
+	if settings.Version <= m.current.Version {
+		m.mutex.Unlock()
+		return
+	}
+	m.current = settings
+	listeners := append([]func(settings *storage.RuntimeSettings){}, m.listeners...)
+	m.mutex.Unlock()
+
+	logger.Info("Applied runtime settings", "version", settings.Version,
+		"maintenance", settings.MaintenanceMode, "generation", settings.GenerationEnabled)
+	for _, listener := range listeners {
+		listener(settings)
+	}
+}
+
+// knownProvider reports whether name is a registered provider
+func (m *Manager) knownProvider(name string) bool {
+	for _, provider := range m.providers {
+		if provider == name {
+			return true
+		}
+	}
+	return false
+}
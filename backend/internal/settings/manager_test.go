@@ -0,0 +1,122 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestApply(t *testing.T) {
+	manager := NewManager(nil, []string{"openai"}, time.Minute)
+
+	var seen []int64
+	manager.OnChange(func(settings *storage.RuntimeSettings) {
+		seen = append(seen, settings.Version)
+	})
+
+	for _, version := range []int64{2, 1, 2, 3} {
+		settings := storage.DefaultRuntimeSettings()
+		settings.Version = version
+		manager.apply(settings)
+	}
+
+	// Listeners hear the current settings once on registration, then only newer versions
+	if want := []int64{0, 2, 3}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("listener saw versions %v, want %v", seen, want)
+	}
+	if manager.Current().Version != 3 {
+		t.Errorf("current version = %d, want 3", manager.Current().Version)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		maintenance bool
+		message     string
+		wantStatus  int
+		wantBody    string
+	}{
+		{"off", false, "", http.StatusOK, ""},
+		{"on", true, "", http.StatusServiceUnavailable, defaultMaintenanceMessage},
+		{"on with a message", true, "Back at noon", http.StatusServiceUnavailable, "Back at noon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewManager(nil, nil, time.Minute)
+			settings := storage.DefaultRuntimeSettings()
+			settings.Version = 1
+			settings.MaintenanceMode = tt.maintenance
+			settings.MaintenanceMessage = tt.message
+			manager.apply(settings)
+
+			router := gin.New()
+			router.GET("/", manager.Maintenance(), func(c *gin.Context) { c.Status(http.StatusOK) })
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want %q", recorder.Body, tt.wantBody)
+			}
+			if tt.maintenance && recorder.Header().Get("Retry-After") != maintenanceRetryAfter {
+				t.Errorf("Retry-After = %q, want %s", recorder.Header().Get("Retry-After"), maintenanceRetryAfter)
+			}
+		})
+	}
+}
+
+func TestWithoutValkey(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(nil, []string{"openai"}, time.Minute)
+
+	enabled := true
+	if _, _, err := manager.Update(ctx, "admin", Patch{MaintenanceMode: &enabled}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Update error = %v, want ErrUnavailable", err)
+	}
+	if _, _, err := manager.ResetProvider(ctx, "admin", "openai"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("ResetProvider error = %v, want ErrUnavailable", err)
+	}
+	if history, err := manager.History(ctx, 10); err != nil || len(history) != 0 {
+		t.Errorf("History = %v, %v, want empty", history, err)
+	}
+
+	// Scheduled generation is not coordinated without Valkey, but pausing it still works
+	if err := manager.ClaimScheduledGeneration(ctx); err != nil {
+		t.Errorf("ClaimScheduledGeneration = %v, want nil", err)
+	}
+	paused := storage.DefaultRuntimeSettings()
+	paused.Version = 1
+	paused.GenerationEnabled = false
+	manager.apply(paused)
+	if err := manager.ClaimScheduledGeneration(ctx); !errors.Is(err, ErrGenerationPaused) {
+		t.Errorf("ClaimScheduledGeneration while paused = %v, want ErrGenerationPaused", err)
+	}
+}
+
+func TestKnownProvider(t *testing.T) {
+	manager := NewManager(nil, []string{"openai", "freepik"}, time.Minute)
+
+	for name, want := range map[string]bool{"openai": true, "freepik": true, "google": false, "": false} {
+		if got := manager.knownProvider(name); got != want {
+			t.Errorf("knownProvider(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if strings.Join(manager.providers, ",") != "freepik,openai" {
+		t.Errorf("providers = %v, want them sorted", manager.providers)
+	}
+}
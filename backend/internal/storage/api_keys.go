@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// apiKeysKey is the hash of API keys, keyed by the SHA-256 of the secret; secrets themselves are never stored
+const apiKeysKey = "apikeys"
+
+// APIKey is an issued API key as stored in Valkey
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"` // First characters of the secret, to recognise a key without storing it
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StoreAPIKey saves a new API key under the hash of its secret
+func (v *ValkeyClient) StoreAPIKey(ctx context.Context, secretHash string, key *APIKey) error {
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal API key: %w", err)
+	}
+
+	stored, err := v.client.HSetNX(ctx, apiKeysKey, secretHash, keyJSON).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store API key: %w", err)
+	}
+	if !stored {
+		return fmt.Errorf("API key already exists")
+	}
+
+	return nil
+}
+
+// GetAPIKeyByHash looks up an API key by the hash of its secret, returning nil if there is no such key
+func (v *ValkeyClient) GetAPIKeyByHash(ctx context.Context, secretHash string) (*APIKey, error) {
+	keyJSON, err := v.client.HGet(ctx, apiKeysKey, secretHash).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(keyJSON), &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API key: %w", err)
+	}
+
+	return &key, nil
+}
+
+// ListAPIKeys returns every API key, oldest first
+func (v *ValkeyClient) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	stored, err := v.listAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, len(stored))
+	for i, entry := range stored {
+		keys[i] = entry.key
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey revokes an API key by ID, returning false if there is no such key
+func (v *ValkeyClient) DeleteAPIKey(ctx context.Context, id string) (bool, error) {
+	stored, err := v.listAPIKeys(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range stored {
+		if entry.key.ID != id {
+			continue
+		}
+		if err := v.client.HDel(ctx, apiKeysKey, entry.secretHash).Err(); err != nil {
+			return false, fmt.Errorf("failed to delete API key: %w", err)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// DeleteAPIKeysByName revokes every API key named name except keepID, returning how many were revoked
+func (v *ValkeyClient) DeleteAPIKeysByName(ctx context.Context, name, keepID string) (int, error) {
+	stored, err := v.listAPIKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var hashes []string
+	for _, entry := range stored {
+		if entry.key.Name == name && entry.key.ID != keepID {
+			hashes = append(hashes, entry.secretHash)
+		}
+	}
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+
+	if err := v.client.HDel(ctx, apiKeysKey, hashes...).Err(); err != nil {
+		return 0, fmt.Errorf("failed to delete API keys: %w", err)
+	}
+	return len(hashes), nil
+}
+
+// storedAPIKey is an API key along with the hash it is stored under
+type storedAPIKey struct {
+	secretHash string
+	key        APIKey
+}
+
+// listAPIKeys returns every stored API key, oldest first
+func (v *ValkeyClient) listAPIKeys(ctx context.Context) ([]storedAPIKey, error) {
+	entries, err := v.client.HGetAll(ctx, apiKeysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	stored := make([]storedAPIKey, 0, len(entries))
+	for secretHash, keyJSON := range entries {
+		var key APIKey
+		if err := json.Unmarshal([]byte(keyJSON), &key); err != nil {
+			continue // Skip malformed keys
+		}
+		stored = append(stored, storedAPIKey{secretHash: secretHash, key: key})
+	}
+
+	sort.Slice(stored, func(i, j int) bool { return stored[i].key.CreatedAt.Before(stored[j].key.CreatedAt) })
+	return stored, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeleteAPIKeysByName(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	created := time.Now().UTC()
+	for i, key := range []APIKey{
+		{ID: "old", Name: "cron-web-1", Scopes: []string{"generate"}},
+		{ID: "older", Name: "cron-web-1", Scopes: []string{"generate"}},
+		{ID: "new", Name: "cron-web-1", Scopes: []string{"generate"}},
+		{ID: "other", Name: "cron-web-2", Scopes: []string{"generate"}},
+	} {
+		key.CreatedAt = created.Add(time.Duration(i) * time.Second)
+		if err := v.StoreAPIKey(ctx, "hash-"+key.ID, &key); err != nil {
+			t.Fatalf("StoreAPIKey: %v", err)
+		}
+	}
+
+	revoked, err := v.DeleteAPIKeysByName(ctx, "cron-web-1", "new")
+	if err != nil {
+		t.Fatalf("DeleteAPIKeysByName: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("revoked %d keys, want 2", revoked)
+	}
+
+	keys, err := v.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	var ids []string
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	if len(ids) != 2 || ids[0] != "new" || ids[1] != "other" {
+		t.Errorf("remaining keys = %v, want [new other]", ids)
+	}
+
+	if key, err := v.GetAPIKeyByHash(ctx, "hash-old"); err != nil || key != nil {
+		t.Errorf("revoked key still resolves: %+v, %v", key, err)
+	}
+
+	if revoked, err := v.DeleteAPIKeysByName(ctx, "cron-web-3", ""); err != nil || revoked != 0 {
+		t.Errorf("DeleteAPIKeysByName(unknown) = %d, %v, want 0", revoked, err)
+	}
+}
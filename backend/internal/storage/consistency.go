@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CounterDrift compares a stored aggregate with the value recomputed from raw data
+type CounterDrift struct {
+	Stored   int64 `json:"stored"`
+	Computed int64 `json:"computed"`
+	Drift    int64 `json:"drift"` // Stored - Computed
+}
+
+// PairVoteDrift is a pair whose pair:votes counter disagrees with the vote log
+type PairVoteDrift struct {
+	PairID string `json:"pair_id"`
+	CounterDrift
+}
+
+// PairWinDrift is a pair whose win counter for one side disagrees with the vote log
+type PairWinDrift struct {
+	PairID string `json:"pair_id"`
+	Side   string `json:"side"`
+	CounterDrift
+}
+
+// ConsistencyReport lists every aggregate and index entry that disagrees with the raw data
+// The raw data is the vote log (both tiers) for counters and the pair:<id> keys for indexes
+type ConsistencyReport struct {
+	CheckedAt         time.Time               `json:"checked_at"`
+	Votes             int64                   `json:"votes"` // Votes found in the log
+	Pairs             int                     `json:"pairs"` // Stored pairs
+	TotalVotes        CounterDrift            `json:"total_votes"`
+	SideWins          map[string]CounterDrift `json:"side_wins"`
+	PairVotes         []PairVoteDrift         `json:"pair_votes"`         // Only pairs that drift
+	PairWins          []PairWinDrift          `json:"pair_wins"`          // Only pairs and sides that drift
+	MissingPairs      []string                `json:"missing_pairs"`      // Listed in pairs:all or pairs:archive without a pair:<id> key
+	DuplicateRotation []string                `json:"duplicate_rotation"` // Listed more than once in pairs:all
+	RotatingArchived  []string                `json:"rotating_archived"`  // Listed in both pairs:all and pairs:archive
+	OrphanedHashes    []string                `json:"orphaned_hashes"`    // phash:index members whose pair no longer exists
+	Consistent        bool                    `json:"consistent"`
+	Repaired          bool                    `json:"repaired"`
+}
+
+// CheckConsistency recomputes vote aggregates from the vote log and checks the pair indexes against the stored pairs
+// Votes recorded while the check runs can show up as drift of a few votes; run it again before repairing
+// With repair set, every aggregate and index is rewritten to match the raw data in one transaction
+func (v *ValkeyClient) CheckConsistency(ctx context.Context, repair bool) (*ConsistencyReport, error) {
+	report := &ConsistencyReport{
+		CheckedAt:         time.Now().UTC(),
+		SideWins:          make(map[string]CounterDrift),
+		PairVotes:         []PairVoteDrift{},
+		PairWins:          []PairWinDrift{},
+		MissingPairs:      []string{},
+		DuplicateRotation: []string{},
+		RotatingArchived:  []string{},
+		OrphanedHashes:    []string{},
+	}
+
+	// Recompute vote aggregates from the log
+	sideCounts := map[string]int64{"left": 0, "right": 0}
+	pairCounts := make(map[string]int64)
+	pairWins := map[string]map[string]int64{"left": {}, "right": {}}
+	err := v.ScanVotes(ctx, func(vote *Vote) error {
+		report.Votes++
+		sideCounts[vote.Winner]++
+		pairCounts[vote.PairID]++
+		if wins, ok := pairWins[vote.Winner]; ok {
+			wins[vote.PairID]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	storedTotal, err := v.GetTotalVotes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.TotalVotes = newCounterDrift(storedTotal, report.Votes)
+
+	storedSides, err := v.GetSideWins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for side, computed := range sideCounts {
+		report.SideWins[side] = newCounterDrift(storedSides[side], computed)
+	}
+
+	// Stored pairs are the source of truth for every pair index
+	pairs := make(map[string]bool)
+	err = v.ScanPairs(ctx, func(pair *ImagePair) error {
+		pairs[pair.PairID] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Pairs = len(pairs)
+
+	storedPairVotes, err := v.client.HGetAll(ctx, pairVotesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pair votes: %w", err)
+	}
+	for pairID := range pairs {
+		var stored int64
+		fmt.Sscanf(storedPairVotes[pairID], "%d", &stored)
+		if drift := newCounterDrift(stored, pairCounts[pairID]); drift.Drift != 0 {
+			report.PairVotes = append(report.PairVotes, PairVoteDrift{PairID: pairID, CounterDrift: drift})
+		}
+	}
+
+	for side, computed := range pairWins {
+		stored, err := v.client.ZRangeWithScores(ctx, pairWinsKey(side), 0, -1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s win counts: %w", side, err)
+		}
+		storedWins := make(map[string]int64, len(stored))
+		for _, win := range stored {
+			storedWins[win.Member.(string)] = int64(win.Score)
+		}
+
+		for pairID := range pairs {
+			if drift := newCounterDrift(storedWins[pairID], computed[pairID]); drift.Drift != 0 {
+				report.PairWins = append(report.PairWins, PairWinDrift{PairID: pairID, Side: side, CounterDrift: drift})
+			}
+		}
+	}
+
+	rotation, err := v.client.LRange(ctx, "pairs:all", 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pairs list: %w", err)
+	}
+	archived, err := v.client.ZRange(ctx, pairArchiveKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived pairs: %w", err)
+	}
+
+	inArchive := make(map[string]bool, len(archived))
+	for _, pairID := range archived {
+		inArchive[pairID] = true
+		if !pairs[pairID] {
+			report.MissingPairs = append(report.MissingPairs, pairID)
+		}
+	}
+
+	listed := make(map[string]int, len(rotation))
+	for _, pairID := range rotation {
+		listed[pairID]++
+	}
+	for pairID, count := range listed {
+		switch {
+		case !pairs[pairID]:
+			report.MissingPairs = append(report.MissingPairs, pairID)
+		case inArchive[pairID]:
+			report.RotatingArchived = append(report.RotatingArchived, pairID)
+		case count > 1:
+			report.DuplicateRotation = append(report.DuplicateRotation, pairID)
+		}
+	}
+
+	hashes, err := v.client.HGetAll(ctx, hashIndexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash index: %w", err)
+	}
+	for member := range hashes {
+		pairID, _, _ := strings.Cut(member, ":")
+		if !pairs[pairID] {
+			report.OrphanedHashes = append(report.OrphanedHashes, member)
+		}
+	}
+
+	sort.Slice(report.PairVotes, func(i, j int) bool { return report.PairVotes[i].PairID < report.PairVotes[j].PairID })
+	sort.Slice(report.PairWins, func(i, j int) bool {
+		if report.PairWins[i].PairID != report.PairWins[j].PairID {
+			return report.PairWins[i].PairID < report.PairWins[j].PairID
+		}
+		return report.PairWins[i].Side < report.PairWins[j].Side
+	})
+	for _, list := range [][]string{report.MissingPairs, report.DuplicateRotation, report.RotatingArchived, report.OrphanedHashes} {
+		sort.Strings(list)
+	}
+
+	report.Consistent = report.TotalVotes.Drift == 0 && report.SideWins["left"].Drift == 0 &&
+		report.SideWins["right"].Drift == 0 && len(report.PairVotes) == 0 && len(report.PairWins) == 0 &&
+		len(report.MissingPairs) == 0 && len(report.DuplicateRotation) == 0 && len(report.RotatingArchived) == 0 &&
+		len(report.OrphanedHashes) == 0
+
+	if repair && !report.Consistent {
+		if err := v.repairConsistency(ctx, report, hashes); err != nil {
+			return report, err
+		}
+		report.Repaired = true
+	}
+
+	return report, nil
+}
+
+// repairConsistency rewrites the aggregates and indexes flagged by a report
+func (v *ValkeyClient) repairConsistency(ctx context.Context, report *ConsistencyReport, hashes map[string]string) error {
+	_, err := v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, voteTotalKey, report.TotalVotes.Computed, 0)
+		for side, drift := range report.SideWins {
+			pipe.HSet(ctx, "side:wins", side, drift.Computed)
+		}
+		for _, drift := range report.PairVotes {
+			pipe.HSet(ctx, pairVotesKey, drift.PairID, drift.Computed)
+		}
+		for _, drift := range report.PairWins {
+			if drift.Computed == 0 {
+				pipe.ZRem(ctx, pairWinsKey(drift.Side), drift.PairID)
+			} else {
+				pipe.ZAdd(ctx, pairWinsKey(drift.Side), redis.Z{Score: float64(drift.Computed), Member: drift.PairID})
+			}
+		}
+
+		for _, pairID := range report.MissingPairs {
+			pipe.LRem(ctx, "pairs:all", 0, pairID)
+			pipe.ZRem(ctx, pairArchiveKey, pairID)
+		}
+		for _, pairID := range report.RotatingArchived {
+			pipe.LRem(ctx, "pairs:all", 0, pairID)
+		}
+		for _, pairID := range report.DuplicateRotation {
+			pipe.LRem(ctx, "pairs:all", 0, pairID)
+			pipe.LPush(ctx, "pairs:all", pairID)
+		}
+
+		for _, member := range report.OrphanedHashes {
+			pipe.HDel(ctx, hashIndexKey, member)
+			pipe.SRem(ctx, fmt.Sprintf("phash:%s", hashes[member]), member)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to repair aggregates: %w", err)
+	}
+
+	return nil
+}
+
+// newCounterDrift compares a stored counter with its recomputed value
+func newCounterDrift(stored, computed int64) CounterDrift {
+	return CounterDrift{Stored: stored, Computed: computed, Drift: stored - computed}
+}
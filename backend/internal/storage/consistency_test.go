@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(server *miniredis.Miniredis)
+		check   func(t *testing.T, report *ConsistencyReport)
+	}{
+		{
+			name:    "consistent",
+			corrupt: func(*miniredis.Miniredis) {},
+		},
+		{
+			name:    "total votes",
+			corrupt: func(server *miniredis.Miniredis) { server.Set(voteTotalKey, "7") },
+			check: func(t *testing.T, report *ConsistencyReport) {
+				if want := (CounterDrift{Stored: 7, Computed: 3, Drift: 4}); report.TotalVotes != want {
+					t.Errorf("TotalVotes = %+v, want %+v", report.TotalVotes, want)
+				}
+			},
+		},
+		{
+			name:    "side wins",
+			corrupt: func(server *miniredis.Miniredis) { server.HSet("side:wins", "right", "0") },
+			check: func(t *testing.T, report *ConsistencyReport) {
+				if want := (CounterDrift{Stored: 0, Computed: 1, Drift: -1}); report.SideWins["right"] != want {
+					t.Errorf("SideWins[right] = %+v, want %+v", report.SideWins["right"], want)
+				}
+			},
+		},
+		{
+			name:    "pair votes",
+			corrupt: func(server *miniredis.Miniredis) { server.HSet(pairVotesKey, "pair-1", "5") },
+			check: func(t *testing.T, report *ConsistencyReport) {
+				want := []PairVoteDrift{{PairID: "pair-1", CounterDrift: CounterDrift{Stored: 5, Computed: 2, Drift: 3}}}
+				if !reflect.DeepEqual(report.PairVotes, want) {
+					t.Errorf("PairVotes = %+v, want %+v", report.PairVotes, want)
+				}
+			},
+		},
+		{
+			name: "pair wins",
+			corrupt: func(server *miniredis.Miniredis) {
+				server.ZRem(pairWinsKey("left"), "pair-1")
+				server.ZAdd(pairWinsKey("right"), 4, "pair-2")
+			},
+			check: func(t *testing.T, report *ConsistencyReport) {
+				want := []PairWinDrift{
+					{PairID: "pair-1", Side: "left", CounterDrift: CounterDrift{Stored: 0, Computed: 1, Drift: -1}},
+					{PairID: "pair-2", Side: "right", CounterDrift: CounterDrift{Stored: 4, Computed: 0, Drift: 4}},
+				}
+				if !reflect.DeepEqual(report.PairWins, want) {
+					t.Errorf("PairWins = %+v, want %+v", report.PairWins, want)
+				}
+			},
+		},
+		{
+			name: "pair indexes",
+			corrupt: func(server *miniredis.Miniredis) {
+				server.Lpush("pairs:all", "ghost")
+				server.Lpush("pairs:all", "pair-1")
+				server.Lpush("pairs:all", "pair-2")
+				server.ZAdd(pairArchiveKey, 1, "pair-2")
+				server.HSet(hashIndexKey, "ghost:left", "abcd")
+			},
+			check: func(t *testing.T, report *ConsistencyReport) {
+				got := [][]string{report.MissingPairs, report.DuplicateRotation, report.RotatingArchived, report.OrphanedHashes}
+				want := [][]string{{"ghost"}, {"pair-1"}, {"pair-2"}, {"ghost:left"}}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("missing, duplicate, rotating archived and orphaned = %v, want %v", got, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, server := newTestValkey(t)
+
+			storeTestPair(t, v, &ImagePair{PairID: "pair-1", Provider: "openai", LeftURL: "l", RightURL: "r"})
+			storeTestPair(t, v, &ImagePair{PairID: "pair-2", Provider: "openai", LeftURL: "l", RightURL: "r"})
+			for _, vote := range []Vote{{PairID: "pair-1", Winner: "left"}, {PairID: "pair-1", Winner: "right"}, {PairID: "pair-2", Winner: "left"}} {
+				if err := v.RecordVote(ctx, &vote); err != nil {
+					t.Fatalf("RecordVote: %v", err)
+				}
+			}
+			tt.corrupt(server)
+
+			report, err := v.CheckConsistency(ctx, false)
+			if err != nil {
+				t.Fatalf("CheckConsistency: %v", err)
+			}
+			if report.Votes != 3 || report.Pairs != 2 {
+				t.Errorf("checked %d votes and %d pairs, want 3 and 2", report.Votes, report.Pairs)
+			}
+			if wantConsistent := tt.check == nil; report.Consistent != wantConsistent {
+				t.Fatalf("Consistent = %v, want %v: %+v", report.Consistent, wantConsistent, report)
+			}
+			if tt.check == nil {
+				return
+			}
+			tt.check(t, report)
+
+			repaired, err := v.CheckConsistency(ctx, true)
+			if err != nil {
+				t.Fatalf("CheckConsistency(repair): %v", err)
+			}
+			if !repaired.Repaired {
+				t.Error("repair did not run")
+			}
+
+			after, err := v.CheckConsistency(ctx, false)
+			if err != nil {
+				t.Fatalf("CheckConsistency: %v", err)
+			}
+			if !after.Consistent || after.Repaired {
+				t.Errorf("still inconsistent after repair: %+v", after)
+			}
+		})
+	}
+}
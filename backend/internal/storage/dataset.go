@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scanBatchSize is the COUNT hint used when iterating keys with SCAN
+const scanBatchSize = 500
+
+// ScanPairs calls fn for every stored pair, in no particular order
+// It iterates with SCAN so the whole keyspace is never loaded at once
+func (v *ValkeyClient) ScanPairs(ctx context.Context, fn func(*ImagePair) error) error {
+	var cursor uint64
+	for {
+		// pair:votes shares the prefix but is a hash; only string keys hold pairs
+		keys, next, err := v.client.ScanType(ctx, cursor, "pair:*", scanBatchSize, "string").Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan pairs: %w", err)
+		}
+
+		if len(keys) > 0 {
+			values, err := v.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return fmt.Errorf("failed to get pairs: %w", err)
+			}
+
+			for _, value := range values {
+				pairJSON, ok := value.(string)
+				if !ok {
+					continue // Deleted between SCAN and MGET
+				}
+
+				var pair ImagePair
+				if err := json.Unmarshal([]byte(pairJSON), &pair); err != nil {
+					continue // Skip malformed pairs
+				}
+				if err := fn(&pair); err != nil {
+					return err
+				}
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// ImportPair writes a pair exported from another cluster
+// Existing pairs are left untouched; retired pairs go to the archive instead of back into rotation
+// Returns whether pair:<id> was newly written
+func (v *ValkeyClient) ImportPair(ctx context.Context, pair *ImagePair) (bool, error) {
+	state, err := v.GetPairIndexState(ctx, pair.PairID)
+	if err != nil {
+		return false, err
+	}
+
+	if pair.RetiredAt == nil {
+		if err := v.RestorePair(ctx, pair, state); err != nil {
+			return false, err
+		}
+		return !state.Stored, nil
+	}
+
+	pairJSON, err := json.Marshal(pair)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal pair: %w", err)
+	}
+
+	_, err = v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if !state.Stored {
+			pipe.SetNX(ctx, fmt.Sprintf("pair:%s", pair.PairID), pairJSON, 0)
+			indexImageHashes(ctx, pipe, pair)
+		}
+		if !state.Archived {
+			pipe.ZAdd(ctx, pairArchiveKey, redis.Z{
+				Score:  float64(pair.RetiredAt.Unix()),
+				Member: pair.PairID,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to import retired pair: %w", err)
+	}
+
+	return !state.Stored, nil
+}
+
+// ImportVote replays a vote exported from another cluster, keeping its original timestamp
+// importID identifies the vote in its source dataset; a vote whose ID was imported before is skipped, so
+// importing the same dataset again does not double-count it. Imports are not live votes and are left out of
+// the vote metrics. Returns whether the vote was written
+func (v *ValkeyClient) ImportVote(ctx context.Context, vote *Vote, importID string) (bool, error) {
+	return v.storeVote(ctx, vote, voteTTL-time.Since(vote.Timestamp), importID)
+}
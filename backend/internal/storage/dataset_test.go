@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestImportVote(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	timestamp := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	votes := []struct {
+		importID string
+		vote     Vote
+	}{
+		{"pair-1/1-0", Vote{PairID: "pair-1", Winner: "left", Provider: "openai-test", Timestamp: timestamp}},
+		{"pair-1/2-0", Vote{PairID: "pair-1", Winner: "right", Provider: "openai-test", Timestamp: timestamp.Add(time.Second)}},
+		{"pair-2/1-0", Vote{PairID: "pair-2", Winner: "left", Provider: "openai-test", Timestamp: timestamp.Add(2 * time.Second)}},
+	}
+	leftBefore := testutil.ToFloat64(metrics.Votes.WithLabelValues("left", "openai-test"))
+
+	for round, wantWritten := range []bool{true, false} {
+		for _, tt := range votes {
+			vote := tt.vote
+			written, err := v.ImportVote(ctx, &vote, tt.importID)
+			if err != nil {
+				t.Fatalf("ImportVote: %v", err)
+			}
+			if written != wantWritten {
+				t.Errorf("round %d: ImportVote(%s) wrote %v, want %v", round, tt.importID, written, wantWritten)
+			}
+		}
+
+		total, err := v.GetTotalVotes(ctx)
+		if err != nil {
+			t.Fatalf("GetTotalVotes: %v", err)
+		}
+		if total != int64(len(votes)) {
+			t.Errorf("round %d: total votes = %d, want %d", round, total, len(votes))
+		}
+
+		sideWins, err := v.GetSideWins(ctx)
+		if err != nil {
+			t.Fatalf("GetSideWins: %v", err)
+		}
+		if sideWins["left"] != 2 || sideWins["right"] != 1 {
+			t.Errorf("round %d: side wins = %v, want 2 left and 1 right", round, sideWins)
+		}
+
+		logged, err := v.client.XLen(ctx, voteLogKey).Result()
+		if err != nil {
+			t.Fatalf("XLen: %v", err)
+		}
+		if logged != int64(len(votes)) {
+			t.Errorf("round %d: vote log holds %d entries, want %d", round, logged, len(votes))
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.Votes.WithLabelValues("left", "openai-test")); got != leftBefore {
+		t.Errorf("imports changed the vote metric from %v to %v", leftBefore, got)
+	}
+}
+
+func TestScanVotesSetsID(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	vote := &Vote{PairID: "pair-1", Winner: "left", Provider: "openai"}
+	if err := v.RecordVote(ctx, vote); err != nil {
+		t.Fatalf("RecordVote: %v", err)
+	}
+
+	var ids []string
+	err := v.ScanVotes(ctx, func(vote *Vote) error {
+		ids = append(ids, vote.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanVotes: %v", err)
+	}
+
+	entries, err := v.client.XRange(ctx, voteLogKey, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange: %v", err)
+	}
+	if len(ids) != 1 || len(entries) != 1 || ids[0] != entries[0].ID {
+		t.Errorf("scanned IDs %v, want the stream IDs of %v", ids, entries)
+	}
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// fraudFlagsKey is a sorted set of flagged subjects scored by when they were last flagged
+	fraudFlagsKey = "fraud:flags"
+
+	// quarantineKey is the hash of quarantined votes, keyed by quarantine ID
+	// Quarantined votes are kept out of the vote log and every counter until they are reinstated
+	quarantineKey = "votes:quarantine"
+
+	// sideHistoryTTL is how long a session's left/right tally is kept for the same-side signal
+	sideHistoryTTL = 24 * time.Hour
+
+	// ipSessionsTTL is the window over which distinct sessions per IP are counted
+	ipSessionsTTL = time.Hour
+)
+
+// VoteActivity is what is known about a voter when a new vote arrives, including that vote
+type VoteActivity struct {
+	SessionVotesLastMinute int64      // Votes from the session in the current minute
+	IPVotesLastMinute      int64      // Votes from the IP in the current minute
+	SessionLeft            int64      // Left votes from the session in the last day
+	SessionRight           int64      // Right votes from the session in the last day
+	IPSessions             int64      // Distinct sessions seen from the IP in the last hour
+	ServedAt               *time.Time // When the pair was served to the session; nil if it never was
+	SessionFlag            *FraudFlag // Active flag on the session, if any
+	IPFlag                 *FraudFlag // Active flag on the IP, if any
+}
+
+// FraudFlag marks a session or IP whose votes are suspicious
+// Subjects are "session:<id>" or "ip:<address>"; the flag expires on its own
+type FraudFlag struct {
+	Subject   string    `json:"subject"`
+	Reasons   []string  `json:"reasons"`
+	Score     float64   `json:"score"`
+	FlaggedAt time.Time `json:"flagged_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// QuarantinedVote is a vote held back from the statistics pending review
+type QuarantinedVote struct {
+	ID            string    `json:"id"`
+	Vote          Vote      `json:"vote"`
+	SessionID     string    `json:"session_id,omitempty"`
+	ClientIP      string    `json:"client_ip"`
+	Score         float64   `json:"score"`
+	Reasons       []string  `json:"reasons"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// ObserveVote counts a vote towards its voter's activity and returns the activity including it
+// sessionID may be empty for clients that do not send one
+func (v *ValkeyClient) ObserveVote(ctx context.Context, sessionID, clientIP, pairID, winner string) (*VoteActivity, error) {
+	minute := time.Now().Unix() / 60
+	ipRateKey := fmt.Sprintf("fraud:rate:ip:%s:%d", clientIP, minute)
+	ipSessionsKey := fmt.Sprintf("fraud:sessions:ip:%s", clientIP)
+
+	var ipRate, sessionRate, ipSessions *redis.IntCmd
+	var sides *redis.MapStringStringCmd
+	var served, sessionFlag, ipFlag *redis.StringCmd
+
+	// Errors are checked per command below; the pipeline's own error is only its first one
+	cmds, _ := v.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		ipRate = pipe.Incr(ctx, ipRateKey)
+		pipe.Expire(ctx, ipRateKey, 2*time.Minute)
+
+		if sessionID != "" {
+			sessionRateKey := fmt.Sprintf("fraud:rate:session:%s:%d", sessionID, minute)
+			sessionRate = pipe.Incr(ctx, sessionRateKey)
+			pipe.Expire(ctx, sessionRateKey, 2*time.Minute)
+
+			sidesKey := fmt.Sprintf("fraud:sides:session:%s", sessionID)
+			pipe.HIncrBy(ctx, sidesKey, winner, 1)
+			pipe.Expire(ctx, sidesKey, sideHistoryTTL)
+			sides = pipe.HGetAll(ctx, sidesKey)
+
+			pipe.SAdd(ctx, ipSessionsKey, sessionID)
+			pipe.Expire(ctx, ipSessionsKey, ipSessionsTTL)
+
+			served = pipe.HGet(ctx, sessionServedKey(sessionID), pairID)
+			sessionFlag = pipe.Get(ctx, fraudFlagKey("session:"+sessionID))
+		}
+
+		ipSessions = pipe.SCard(ctx, ipSessionsKey)
+		ipFlag = pipe.Get(ctx, fraudFlagKey("ip:"+clientIP))
+		return nil
+	})
+
+	// Only the lookups of the served time and the flags may miss
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !(err == redis.Nil && (cmd == served || cmd == sessionFlag || cmd == ipFlag)) {
+			return nil, fmt.Errorf("failed to observe vote: %s: %w", cmd.Name(), err)
+		}
+	}
+
+	activity := &VoteActivity{
+		IPVotesLastMinute: ipRate.Val(),
+		IPSessions:        ipSessions.Val(),
+		IPFlag:            decodeFraudFlag(ipFlag),
+	}
+
+	if sessionID != "" {
+		activity.SessionVotesLastMinute = sessionRate.Val()
+		activity.SessionLeft, _ = strconv.ParseInt(sides.Val()["left"], 10, 64)
+		activity.SessionRight, _ = strconv.ParseInt(sides.Val()["right"], 10, 64)
+		if servedMillis, err := served.Int64(); err == nil {
+			servedAt := time.UnixMilli(servedMillis)
+			activity.ServedAt = &servedAt
+		}
+		activity.SessionFlag = decodeFraudFlag(sessionFlag)
+	}
+
+	return activity, nil
+}
+
+// FlagSubject flags a session or IP until flag.ExpiresAt, replacing any earlier flag
+func (v *ValkeyClient) FlagSubject(ctx context.Context, flag *FraudFlag) error {
+	flagJSON, err := json.Marshal(flag)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fraud flag: %w", err)
+	}
+
+	_, err = v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, fraudFlagKey(flag.Subject), flagJSON, time.Until(flag.ExpiresAt))
+		pipe.ZAdd(ctx, fraudFlagsKey, redis.Z{Score: float64(flag.FlaggedAt.Unix()), Member: flag.Subject})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to flag %s: %w", flag.Subject, err)
+	}
+
+	return nil
+}
+
+// ListFraudFlags returns every active flag, most recently flagged first
+func (v *ValkeyClient) ListFraudFlags(ctx context.Context) ([]FraudFlag, error) {
+	subjects, err := v.client.ZRevRange(ctx, fraudFlagsKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fraud flags: %w", err)
+	}
+
+	flags := []FraudFlag{}
+	expired := []interface{}{}
+	for _, subject := range subjects {
+		flag := decodeFraudFlag(v.client.Get(ctx, fraudFlagKey(subject)))
+		if flag == nil {
+			expired = append(expired, subject)
+			continue
+		}
+		flags = append(flags, *flag)
+	}
+
+	// Flags expire on their own; drop them from the index as they are found
+	if len(expired) > 0 {
+		v.client.ZRem(ctx, fraudFlagsKey, expired...)
+	}
+
+	return flags, nil
+}
+
+// ClearFraudFlag removes a flag before it expires, returning false if the subject was not flagged
+func (v *ValkeyClient) ClearFraudFlag(ctx context.Context, subject string) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, fraudFlagKey(subject))
+		pipe.ZRem(ctx, fraudFlagsKey, subject)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to clear fraud flag: %w", err)
+	}
+
+	return deleted.Val() > 0, nil
+}
+
+// QuarantineVote holds a vote back from the vote log and every counter
+func (v *ValkeyClient) QuarantineVote(ctx context.Context, quarantined *QuarantinedVote) error {
+	quarantinedJSON, err := json.Marshal(quarantined)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantined vote: %w", err)
+	}
+
+	if err := v.client.HSet(ctx, quarantineKey, quarantined.ID, quarantinedJSON).Err(); err != nil {
+		return fmt.Errorf("failed to quarantine vote: %w", err)
+	}
+
+	return nil
+}
+
+// ListQuarantinedVotes returns quarantined votes, oldest first
+// A non-empty subject ("session:<id>" or "ip:<address>") restricts the list to that voter
+func (v *ValkeyClient) ListQuarantinedVotes(ctx context.Context, subject string) ([]QuarantinedVote, error) {
+	entries, err := v.client.HGetAll(ctx, quarantineKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined votes: %w", err)
+	}
+
+	votes := []QuarantinedVote{}
+	for _, entryJSON := range entries {
+		var quarantined QuarantinedVote
+		if err := json.Unmarshal([]byte(entryJSON), &quarantined); err != nil {
+			continue // Skip malformed entries
+		}
+		if subject != "" && subject != "session:"+quarantined.SessionID && subject != "ip:"+quarantined.ClientIP {
+			continue
+		}
+		votes = append(votes, quarantined)
+	}
+
+	sort.Slice(votes, func(i, j int) bool { return votes[i].QuarantinedAt.Before(votes[j].QuarantinedAt) })
+	return votes, nil
+}
+
+// ReinstateQuarantinedVote releases a quarantined vote into the vote log and counters with its original timestamp
+// Returns nil if there is no such quarantined vote
+func (v *ValkeyClient) ReinstateQuarantinedVote(ctx context.Context, id string) (*QuarantinedVote, error) {
+	quarantined, err := v.takeQuarantinedVote(ctx, id)
+	if err != nil || quarantined == nil {
+		return nil, err
+	}
+
+	if _, err := v.storeVote(ctx, &quarantined.Vote, voteTTL-time.Since(quarantined.Vote.Timestamp), ""); err != nil {
+		// Put it back so it can be retried
+		if requeueErr := v.QuarantineVote(ctx, quarantined); requeueErr != nil {
+			logger.ErrorContext(ctx, "Lost quarantined vote after failing to reinstate it", "vote_id", id, "error", requeueErr)
+		}
+		return nil, err
+	}
+	metrics.Votes.WithLabelValues(quarantined.Vote.Winner, quarantined.Vote.Provider).Inc()
+
+	return quarantined, nil
+}
+
+// DiscardQuarantinedVote deletes a quarantined vote for good
+// Returns nil if there is no such quarantined vote
+func (v *ValkeyClient) DiscardQuarantinedVote(ctx context.Context, id string) (*QuarantinedVote, error) {
+	return v.takeQuarantinedVote(ctx, id)
+}
+
+// takeQuarantinedVote removes a quarantined vote and returns it; only one caller can take a given vote
+func (v *ValkeyClient) takeQuarantinedVote(ctx context.Context, id string) (*QuarantinedVote, error) {
+	var entry *redis.StringCmd
+	var deleted *redis.IntCmd
+	_, err := v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		entry = pipe.HGet(ctx, quarantineKey, id)
+		deleted = pipe.HDel(ctx, quarantineKey, id)
+		return nil
+	})
+	if err == redis.Nil || (err == nil && deleted.Val() == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined vote: %w", err)
+	}
+
+	var quarantined QuarantinedVote
+	if err := json.Unmarshal([]byte(entry.Val()), &quarantined); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal quarantined vote: %w", err)
+	}
+
+	return &quarantined, nil
+}
+
+// decodeFraudFlag decodes a flag read from Valkey, returning nil if there is none
+func decodeFraudFlag(cmd *redis.StringCmd) *FraudFlag {
+	flagJSON, err := cmd.Result()
+	if err != nil {
+		return nil
+	}
+
+	var flag FraudFlag
+	if err := json.Unmarshal([]byte(flagJSON), &flag); err != nil {
+		return nil
+	}
+	return &flag
+}
+
+// fraudFlagKey is the key a subject's flag is stored under
+func fraudFlagKey(subject string) string {
+	return fmt.Sprintf("fraud:flag:%s", subject)
+}
+
+// sessionServedKey is the hash of pair ID to the time (unix milliseconds) it was served to a session
+func sessionServedKey(sessionID string) string {
+	return fmt.Sprintf("session:%s:served", sessionID)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestObserveVote(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	servedAt := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	server.HSet(sessionServedKey("s1"), "pair-1", strconv.FormatInt(servedAt.UnixMilli(), 10))
+	flag := &FraudFlag{Subject: "ip:192.0.2.1", Reasons: []string{"many_sessions"}, Score: 0.5, FlaggedAt: time.Now().UTC(), ExpiresAt: time.Now().Add(time.Hour).UTC()}
+	if err := v.FlagSubject(ctx, flag); err != nil {
+		t.Fatalf("FlagSubject: %v", err)
+	}
+
+	var activity *VoteActivity
+	for _, vote := range []struct{ session, pair, winner string }{
+		{"s1", "pair-1", "left"},
+		{"s2", "pair-1", "right"},
+		{"s1", "pair-1", "left"},
+		{"s1", "pair-1", "right"},
+	} {
+		var err error
+		activity, err = v.ObserveVote(ctx, vote.session, "192.0.2.1", vote.pair, vote.winner)
+		if err != nil {
+			t.Fatalf("ObserveVote: %v", err)
+		}
+	}
+
+	if activity.SessionVotesLastMinute != 3 || activity.IPVotesLastMinute != 4 {
+		t.Errorf("votes last minute = %d for the session and %d for the IP, want 3 and 4",
+			activity.SessionVotesLastMinute, activity.IPVotesLastMinute)
+	}
+	if activity.SessionLeft != 2 || activity.SessionRight != 1 {
+		t.Errorf("session sides = %d left and %d right, want 2 and 1", activity.SessionLeft, activity.SessionRight)
+	}
+	if activity.IPSessions != 2 {
+		t.Errorf("IP sessions = %d, want 2", activity.IPSessions)
+	}
+	if activity.ServedAt == nil || !activity.ServedAt.Equal(servedAt) {
+		t.Errorf("ServedAt = %v, want %v", activity.ServedAt, servedAt)
+	}
+	if activity.SessionFlag != nil {
+		t.Errorf("SessionFlag = %+v, want none", activity.SessionFlag)
+	}
+	if activity.IPFlag == nil || activity.IPFlag.Subject != flag.Subject {
+		t.Errorf("IPFlag = %+v, want %+v", activity.IPFlag, flag)
+	}
+
+	anonymous, err := v.ObserveVote(ctx, "", "192.0.2.9", "pair-1", "left")
+	if err != nil {
+		t.Fatalf("ObserveVote without a session: %v", err)
+	}
+	if anonymous.IPVotesLastMinute != 1 || anonymous.ServedAt != nil || anonymous.SessionVotesLastMinute != 0 {
+		t.Errorf("activity without a session = %+v", anonymous)
+	}
+}
+
+func TestObserveVoteReportsErrorsAfterMisses(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	// The unserved pair misses first; the IP flag lookup then fails on a key of the wrong type
+	server.HSet(fraudFlagKey("ip:192.0.2.1"), "subject", "ip:192.0.2.1")
+
+	if _, err := v.ObserveVote(ctx, "s1", "192.0.2.1", "pair-1", "left"); err == nil {
+		t.Error("ObserveVote hid the failed flag lookup behind the missing served time")
+	}
+}
+
+func TestFraudFlags(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, subject := range []string{"session:s1", "ip:192.0.2.1"} {
+		flag := &FraudFlag{Subject: subject, Reasons: []string{"vote_rate"}, Score: 1, FlaggedAt: now.Add(time.Duration(i) * time.Second), ExpiresAt: now.Add(time.Hour)}
+		if err := v.FlagSubject(ctx, flag); err != nil {
+			t.Fatalf("FlagSubject: %v", err)
+		}
+	}
+
+	flags, err := v.ListFraudFlags(ctx)
+	if err != nil {
+		t.Fatalf("ListFraudFlags: %v", err)
+	}
+	if len(flags) != 2 || flags[0].Subject != "ip:192.0.2.1" || flags[1].Subject != "session:s1" {
+		t.Errorf("flags = %+v, want the IP then the session", flags)
+	}
+
+	// An expired flag disappears from the list and the index
+	server.Del(fraudFlagKey("ip:192.0.2.1"))
+	if flags, err = v.ListFraudFlags(ctx); err != nil || len(flags) != 1 {
+		t.Errorf("flags after expiry = %+v, %v, want only the session", flags, err)
+	}
+	if members, _ := server.ZMembers(fraudFlagsKey); !reflect.DeepEqual(members, []string{"session:s1"}) {
+		t.Errorf("flag index = %v, want [session:s1]", members)
+	}
+
+	for _, want := range []bool{true, false} {
+		cleared, err := v.ClearFraudFlag(ctx, "session:s1")
+		if err != nil {
+			t.Fatalf("ClearFraudFlag: %v", err)
+		}
+		if cleared != want {
+			t.Errorf("ClearFraudFlag = %v, want %v", cleared, want)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+	storeTestPair(t, v, &ImagePair{PairID: "pair-1", Provider: "openai", LeftURL: "l", RightURL: "r"})
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i, quarantined := range []*QuarantinedVote{
+		{ID: "q1", Vote: Vote{PairID: "pair-1", Winner: "left", Provider: "openai", Timestamp: now.Add(-time.Hour)}, SessionID: "s1", ClientIP: "192.0.2.1"},
+		{ID: "q2", Vote: Vote{PairID: "pair-1", Winner: "right", Provider: "openai", Timestamp: now}, ClientIP: "192.0.2.2"},
+	} {
+		quarantined.QuarantinedAt = now.Add(time.Duration(i) * time.Second)
+		if err := v.QuarantineVote(ctx, quarantined); err != nil {
+			t.Fatalf("QuarantineVote: %v", err)
+		}
+	}
+
+	for subject, want := range map[string][]string{"": {"q1", "q2"}, "session:s1": {"q1"}, "ip:192.0.2.2": {"q2"}, "ip:192.0.2.3": nil} {
+		votes, err := v.ListQuarantinedVotes(ctx, subject)
+		if err != nil {
+			t.Fatalf("ListQuarantinedVotes: %v", err)
+		}
+		var ids []string
+		for _, vote := range votes {
+			ids = append(ids, vote.ID)
+		}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("ListQuarantinedVotes(%q) = %v, want %v", subject, ids, want)
+		}
+	}
+
+	total, _ := v.GetTotalVotes(ctx)
+	if total != 0 {
+		t.Errorf("quarantined votes were counted: total = %d", total)
+	}
+
+	reinstated, err := v.ReinstateQuarantinedVote(ctx, "q1")
+	if err != nil || reinstated == nil {
+		t.Fatalf("ReinstateQuarantinedVote = %+v, %v", reinstated, err)
+	}
+	var logged []Vote
+	err = v.ScanVotes(ctx, func(vote *Vote) error {
+		logged = append(logged, *vote)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanVotes: %v", err)
+	}
+	if len(logged) != 1 || logged[0].Winner != "left" || !logged[0].Timestamp.Equal(now.Add(-time.Hour)) {
+		t.Errorf("vote log after reinstating = %+v, want the left vote with its original timestamp", logged)
+	}
+
+	discarded, err := v.DiscardQuarantinedVote(ctx, "q2")
+	if err != nil || discarded == nil || discarded.Vote.Winner != "right" {
+		t.Fatalf("DiscardQuarantinedVote = %+v, %v", discarded, err)
+	}
+
+	// Both are gone now, and only one caller could take each
+	for _, id := range []string{"q1", "q2"} {
+		if again, err := v.ReinstateQuarantinedVote(ctx, id); err != nil || again != nil {
+			t.Errorf("ReinstateQuarantinedVote(%s) again = %+v, %v, want nil", id, again, err)
+		}
+	}
+	if total, _ := v.GetTotalVotes(ctx); total != 1 {
+		t.Errorf("total votes = %d, want 1", total)
+	}
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cgc-lb-and-cdn-backend/internal/imaging"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// hashIndexKey is a hash of "<pair-id>:<side>" to hex perceptual hash, scanned for near-duplicates
+// Exact matches are also kept in phash:<hex-hash> sets so they can be looked up without a scan
+const hashIndexKey = "phash:index"
+
+// HashMatch is an indexed image whose perceptual hash is close to a reference hash
+type HashMatch struct {
+	PairID   string `json:"pair_id"`
+	Side     string `json:"side"`
+	Hash     string `json:"hash"`
+	Distance int    `json:"distance"`
+}
+
+// indexImageHashes queues the commands that record the perceptual hashes of both images in a pair
+// Callers run it inside a transaction together with the write of the pair itself
+func indexImageHashes(ctx context.Context, pipe redis.Pipeliner, pair *ImagePair) {
+	for side, hash := range map[string]string{"left": pair.LeftHash, "right": pair.RightHash} {
+		if hash == "" {
+			continue // Pairs generated before hashing existed, or images that failed to decode
+		}
+
+		member := fmt.Sprintf("%s:%s", pair.PairID, side)
+		pipe.HSet(ctx, hashIndexKey, member, hash)
+		pipe.SAdd(ctx, fmt.Sprintf("phash:%s", hash), member)
+	}
+}
+
+// loadHashIndex returns every indexed image with its parsed hash
+func (v *ValkeyClient) loadHashIndex(ctx context.Context) ([]HashMatch, []uint64, error) {
+	entries, err := v.client.HGetAll(ctx, hashIndexKey).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read hash index: %w", err)
+	}
+
+	images := make([]HashMatch, 0, len(entries))
+	hashes := make([]uint64, 0, len(entries))
+	for member, hexHash := range entries {
+		hash, err := imaging.ParseHash(hexHash)
+		if err != nil {
+			continue // Skip malformed entries
+		}
+
+		pairID, side, found := strings.Cut(member, ":")
+		if !found {
+			continue
+		}
+
+		images = append(images, HashMatch{PairID: pairID, Side: side, Hash: hexHash})
+		hashes = append(hashes, hash)
+	}
+
+	return images, hashes, nil
+}
+
+// FindSimilarImages returns every indexed image within maxDistance bits of hash, closest first
+func (v *ValkeyClient) FindSimilarImages(ctx context.Context, hash uint64, maxDistance int) ([]HashMatch, error) {
+	if maxDistance <= 0 {
+		return v.findExactImages(ctx, imaging.FormatHash(hash))
+	}
+
+	images, hashes, err := v.loadHashIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]HashMatch, 0)
+	for i, candidate := range hashes {
+		if distance := imaging.HammingDistance(hash, candidate); distance <= maxDistance {
+			match := images[i]
+			match.Distance = distance
+			matches = append(matches, match)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+
+	return matches, nil
+}
+
+// findExactImages looks up images sharing an exact hash without scanning the whole index
+func (v *ValkeyClient) findExactImages(ctx context.Context, hexHash string) ([]HashMatch, error) {
+	members, err := v.client.SMembers(ctx, fmt.Sprintf("phash:%s", hexHash)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash set: %w", err)
+	}
+
+	matches := make([]HashMatch, 0, len(members))
+	for _, member := range members {
+		pairID, side, found := strings.Cut(member, ":")
+		if !found {
+			continue
+		}
+		matches = append(matches, HashMatch{PairID: pairID, Side: side, Hash: hexHash})
+	}
+
+	return matches, nil
+}
+
+// FindDuplicateGroups clusters indexed images that are within maxDistance bits of each other
+// Only groups spanning more than one pair are returned, since left/right similarity within a pair
+// is already rejected at generation time
+func (v *ValkeyClient) FindDuplicateGroups(ctx context.Context, maxDistance int) ([][]HashMatch, error) {
+	images, hashes, err := v.loadHashIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Greedy clustering: each unassigned image seeds a group of every later image close to it
+	// The index holds a few thousand images at most, so the quadratic scan is cheap
+	assigned := make([]bool, len(images))
+	groups := make([][]HashMatch, 0)
+	for i := range images {
+		if assigned[i] {
+			continue
+		}
+
+		group := []HashMatch{images[i]}
+		pairs := map[string]bool{images[i].PairID: true}
+		for j := i + 1; j < len(images); j++ {
+			if assigned[j] {
+				continue
+			}
+			if distance := imaging.HammingDistance(hashes[i], hashes[j]); distance <= maxDistance {
+				match := images[j]
+				match.Distance = distance
+				group = append(group, match)
+				pairs[match.PairID] = true
+				assigned[j] = true
+			}
+		}
+
+		if len(pairs) > 1 {
+			groups = append(groups, group)
+		}
+	}
+
+	// Largest groups first
+	sort.Slice(groups, func(i, j int) bool {
+		return len(groups[i]) > len(groups[j])
+	})
+
+	return groups, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"cgc-lb-and-cdn-backend/internal/imaging"
+)
+
+func TestFindSimilarImages(t *testing.T) {
+	v, _ := newTestValkey(t)
+	ctx := context.Background()
+
+	pairs := []*ImagePair{
+		{PairID: "a", LeftHash: imaging.FormatHash(0x00), RightHash: imaging.FormatHash(0xff)},
+		{PairID: "b", LeftHash: imaging.FormatHash(0x01), RightHash: imaging.FormatHash(0xffff0000)},
+		{PairID: "c", LeftHash: imaging.FormatHash(0x07)}, // Right image failed to decode
+	}
+	for _, pair := range pairs {
+		if err := v.StoreImagePair(ctx, pair); err != nil {
+			t.Fatalf("StoreImagePair(%s): %v", pair.PairID, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		hash        uint64
+		maxDistance int
+		want        []string // pair:side, closest first
+	}{
+		{"exact match", 0x00, 0, []string{"a:left"}},
+		{"no exact match", 0x03, 0, nil},
+		{"within one bit", 0x00, 1, []string{"a:left", "b:left"}},
+		{"within three bits", 0x00, 3, []string{"a:left", "b:left", "c:left"}},
+		{"far from everything", 0xffffffff00000000, 8, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := v.FindSimilarImages(ctx, tt.hash, tt.maxDistance)
+			if err != nil {
+				t.Fatalf("FindSimilarImages: %v", err)
+			}
+
+			var got []string
+			for i, match := range matches {
+				got = append(got, match.PairID+":"+match.Side)
+				if i > 0 && match.Distance < matches[i-1].Distance {
+					t.Errorf("matches are not sorted by distance: %+v", matches)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("matches = %v, want %v", got, tt.want)
+			}
+			// Equal distances come back in any order
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("matches = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindDuplicateGroups(t *testing.T) {
+	v, _ := newTestValkey(t)
+	ctx := context.Background()
+
+	pairs := []*ImagePair{
+		// Left and right of one pair are close, which alone is not a cross-pair duplicate
+		{PairID: "a", LeftHash: imaging.FormatHash(0xf0f0), RightHash: imaging.FormatHash(0xf0f1)},
+		{PairID: "b", LeftHash: imaging.FormatHash(0xff00ff00ff00ff00), RightHash: imaging.FormatHash(0x0f)},
+		{PairID: "c", LeftHash: imaging.FormatHash(0xff00ff00ff00ff01), RightHash: imaging.FormatHash(0xff00ff00ff00ff03)},
+	}
+	for _, pair := range pairs {
+		if err := v.StoreImagePair(ctx, pair); err != nil {
+			t.Fatalf("StoreImagePair(%s): %v", pair.PairID, err)
+		}
+	}
+
+	groups, err := v.FindDuplicateGroups(ctx, 2)
+	if err != nil {
+		t.Fatalf("FindDuplicateGroups: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("groups = %+v, want one group", groups)
+	}
+
+	var members []string
+	for _, match := range groups[0] {
+		members = append(members, match.PairID+":"+match.Side)
+	}
+	sort.Strings(members)
+	want := []string{"b:left", "c:left", "c:right"}
+	if len(members) != len(want) {
+		t.Fatalf("group = %v, want %v", members, want)
+	}
+	for i := range want {
+		if members[i] != want[i] {
+			t.Fatalf("group = %v, want %v", members, want)
+		}
+	}
+}
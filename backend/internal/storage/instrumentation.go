@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/metrics"
+	"cgc-lb-and-cdn-backend/internal/tracing"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records a client span for each Valkey command made within a trace
+var tracer = otel.Tracer("cgc-lb-and-cdn-backend/internal/storage")
+
+// metricsHook records the latency of every Valkey command, pipeline and transaction
+type metricsHook struct{}
+
+// DialHook implements redis.Hook
+func (metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := next(ctx, network, addr)
+		metrics.ValkeyCommandDuration.WithLabelValues("dial", metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+		return conn, err
+	}
+}
+
+// ProcessHook implements redis.Hook
+func (metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		metrics.ValkeyCommandDuration.WithLabelValues(cmd.Name(), commandOutcome(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook; transactions are recorded as "multi" and other pipelines as "pipeline"
+func (metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+
+		command := "pipeline"
+		if len(cmds) > 0 && cmds[0].Name() == "multi" {
+			command = "multi"
+		}
+		metrics.ValkeyCommandDuration.WithLabelValues(command, commandOutcome(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// tracingHook records a span for every Valkey command, pipeline and transaction made within a trace
+// Commands made outside one, such as the retention sweeper's, are not traced so they don't each start a trace
+type tracingHook struct{}
+
+// DialHook implements redis.Hook
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook implements redis.Hook
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !tracing.Traced(ctx) {
+			return next(ctx, cmd)
+		}
+
+		ctx, span := startValkeySpan(ctx, cmd.Name(), 1)
+		err := next(ctx, cmd)
+		endValkeySpan(span, err)
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !tracing.Traced(ctx) {
+			return next(ctx, cmds)
+		}
+
+		command := "pipeline"
+		if len(cmds) > 0 && cmds[0].Name() == "multi" {
+			command = "multi"
+		}
+		ctx, span := startValkeySpan(ctx, command, len(cmds))
+		err := next(ctx, cmds)
+		endValkeySpan(span, err)
+		return err
+	}
+}
+
+// startValkeySpan starts a client span named after the command; arguments are left out since they include vote
+// and client identifiers
+func startValkeySpan(ctx context.Context, command string, batchSize int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "valkey "+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation.name", command),
+			attribute.Int("db.operation.batch.size", batchSize),
+		),
+	)
+}
+
+// endValkeySpan ends span, recording err unless it is an ordinary answer (see commandOutcome)
+func endValkeySpan(span trace.Span, err error) {
+	if commandOutcome(err) == metrics.OutcomeSuccess {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// commandOutcome treats redis.Nil (key not found) and NOSCRIPT (a Lua script not cached yet, after which go-redis
+// retries with EVAL) as successes, since both are ordinary answers
+func commandOutcome(err error) string {
+	if err == redis.Nil || redis.HasErrorPrefix(err, "NOSCRIPT") {
+		return metrics.OutcomeSuccess
+	}
+	return metrics.Outcome(err)
+}
+
+// PairInventory reports how many pairs are in rotation ("active") and retired ("archived")
+// Used by the cgc_pairs gauge (see metrics.RegisterPairInventory)
+func (v *ValkeyClient) PairInventory(ctx context.Context) (map[string]int64, error) {
+	var active, archived *redis.IntCmd
+	_, err := v.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		active = pipe.LLen(ctx, "pairs:all")
+		archived = pipe.ZCard(ctx, pairArchiveKey)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pairs: %w", err)
+	}
+
+	return map[string]int64{
+		"active":   active.Val(),
+		"archived": archived.Val(),
+	}, nil
+}
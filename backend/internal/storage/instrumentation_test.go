@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"cgc-lb-and-cdn-backend/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// observations returns how many samples a Valkey command histogram series holds
+func observations(t *testing.T, command, outcome string) uint64 {
+	t.Helper()
+
+	var metric dto.Metric
+	if err := metrics.ValkeyCommandDuration.WithLabelValues(command, outcome).(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestCommandOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, metrics.OutcomeSuccess},
+		{redis.Nil, metrics.OutcomeSuccess},
+		{redis.TxFailedErr, metrics.OutcomeError},
+		{errors.New("connection refused"), metrics.OutcomeError},
+	}
+
+	for _, tt := range tests {
+		if got := commandOutcome(tt.err); got != tt.want {
+			t.Errorf("commandOutcome(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+	v.client.AddHook(metricsHook{})
+
+	getBefore := observations(t, "get", metrics.OutcomeSuccess)
+	pipelineBefore := observations(t, "pipeline", metrics.OutcomeSuccess)
+	multiBefore := observations(t, "multi", metrics.OutcomeSuccess)
+
+	// A missing key is an ordinary answer, not an error
+	if err := v.client.Get(ctx, "missing").Err(); err != redis.Nil {
+		t.Fatalf("Get = %v, want redis.Nil", err)
+	}
+	v.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "a")
+		pipe.Incr(ctx, "b")
+		return nil
+	})
+	v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "a")
+		return nil
+	})
+
+	// Pipelines and transactions count once each, not once per command
+	for _, tt := range []struct {
+		command string
+		before  uint64
+	}{{"get", getBefore}, {"pipeline", pipelineBefore}, {"multi", multiBefore}} {
+		if got := observations(t, tt.command, metrics.OutcomeSuccess) - tt.before; got != 1 {
+			t.Errorf("%s recorded %d times, want 1", tt.command, got)
+		}
+	}
+}
+
+func TestTracingHook(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+	v.client.AddHook(tracingHook{})
+
+	// This is the only storage test that installs a tracer provider
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	// Commands outside a trace, like the background sweeps', start no spans
+	v.client.Get(ctx, "missing")
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Fatalf("untraced command recorded %d spans", len(spans))
+	}
+
+	ctx, parent := otel.Tracer("test").Start(ctx, "request")
+	v.client.Get(ctx, "missing")
+	v.client.HSet(ctx, "hash", "field", "value")
+	v.client.Incr(ctx, "hash") // WRONGTYPE
+	v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "a")
+		pipe.Incr(ctx, "b")
+		return nil
+	})
+	parent.End()
+
+	spans := recorder.Ended()
+	want := []struct {
+		name  string
+		error bool
+	}{{"valkey get", false}, {"valkey hset", false}, {"valkey incr", true}, {"valkey multi", false}, {"request", false}}
+	if len(spans) != len(want) {
+		t.Fatalf("recorded %d spans, want %d", len(spans), len(want))
+	}
+	for i, w := range want {
+		if spans[i].Name() != w.name || (spans[i].Status().Code == codes.Error) != w.error {
+			t.Errorf("span %d = %q with status %v, want %q with error %v", i, spans[i].Name(), spans[i].Status(), w.name, w.error)
+		}
+		if i < len(want)-1 && spans[i].Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the request", spans[i].Name())
+		}
+	}
+}
+
+func TestPairInventory(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	server.Lpush("pairs:all", "pair-1")
+	server.Lpush("pairs:all", "pair-2")
+	server.ZAdd(pairArchiveKey, 1, "pair-3")
+
+	inventory, err := v.PairInventory(ctx)
+	if err != nil {
+		t.Fatalf("PairInventory: %v", err)
+	}
+	if want := map[string]int64{"active": 2, "archived": 1}; !reflect.DeepEqual(inventory, want) {
+		t.Errorf("PairInventory = %v, want %v", inventory, want)
+	}
+}
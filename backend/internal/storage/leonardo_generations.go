@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Leonardo generations that finish after their request has returned are recorded in Valkey, so whichever droplet
+// receives the completion callback, or polls for it when the callback is lost, can claim and finish them
+// leonardo:pending:<id> holds the generation, and leonardo:pending indexes them by start time (unix milliseconds).
+// A callback that arrives before its generation is recorded waits in leonardo:early:<id> for the registration.
+// While pending, a generation holds one of its provider's concurrency slots under its generation ID
+const (
+	pendingGenerationKeyPrefix = "leonardo:pending:"
+	pendingGenerationsKey      = "leonardo:pending"
+	earlyCompletionKeyPrefix   = "leonardo:early:"
+	pendingSweepLockKey        = "leonardo:sweep:lock"
+)
+
+// PendingGeneration is a Leonardo generation whose request has returned and which is finished in the background
+type PendingGeneration struct {
+	GenerationID string    `json:"generation_id"`
+	Provider     string    `json:"provider"`
+	PairID       string    `json:"pair_id"`
+	Prompt       string    `json:"prompt"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Instance     string    `json:"instance"` // Host name of the droplet that started it
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// GenerationCompletion is how a Leonardo generation ended, as reported by its completion callback
+type GenerationCompletion struct {
+	GenerationID string   `json:"generation_id"`
+	Status       string   `json:"status"` // COMPLETE or FAILED
+	ImageURLs    []string `json:"image_urls,omitempty"`
+}
+
+// registerGenerationScript records a pending generation unless its callback already came in, in which case the
+// early completion is handed back instead and nothing is recorded
+// KEYS: pending, index, provider slots, early completion
+// ARGV: generation ID, pending generation JSON, ttl in milliseconds
+var registerGenerationScript = redis.NewScript(`
+local early = redis.call('GET', KEYS[4])
+if early then
+	redis.call('DEL', KEYS[4])
+	return early
+end
+
+local ttl = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+redis.call('ZADD', KEYS[2], now, ARGV[1])
+redis.call('ZADD', KEYS[3], now + ttl, ARGV[1])
+for i = 2, 3 do
+	if redis.call('PTTL', KEYS[i]) < ttl then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return false
+`)
+
+// claimGenerationScript takes a pending generation, freeing its concurrency slot, so only one droplet finishes it
+// When it is not pending and a completion is given, the completion is kept for a registration still to come
+// KEYS: pending, index, provider slots, early completion
+// ARGV: generation ID, completion JSON or "", early completion ttl in milliseconds
+var claimGenerationScript = redis.NewScript(`
+redis.call('ZREM', KEYS[2], ARGV[1])
+local pending = redis.call('GET', KEYS[1])
+if pending then
+	redis.call('DEL', KEYS[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return pending
+end
+
+if ARGV[2] ~= '' then
+	redis.call('SET', KEYS[4], ARGV[2], 'PX', tonumber(ARGV[3]))
+end
+return false
+`)
+
+// RegisterPendingGeneration records a generation to be finished in the background, forgetting it after ttl
+// If its completion callback arrived first, that completion is returned and the generation is not recorded;
+// the caller then finishes it itself
+func (v *ValkeyClient) RegisterPendingGeneration(ctx context.Context, pending *PendingGeneration, ttl time.Duration) (*GenerationCompletion, error) {
+	pendingJSON, err := json.Marshal(pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pending generation: %w", err)
+	}
+
+	earlyJSON, err := registerGenerationScript.Run(ctx, v.client, pendingGenerationKeys(pending.Provider, pending.GenerationID),
+		pending.GenerationID, pendingJSON, ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to register pending generation: %w", err)
+	}
+
+	var completion GenerationCompletion
+	if err := json.Unmarshal([]byte(earlyJSON), &completion); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal early completion: %w", err)
+	}
+
+	return &completion, nil
+}
+
+// ClaimPendingGeneration takes a pending generation so the caller can finish it, returning nil if it is not
+// pending (already claimed, expired or never recorded)
+// A callback's completion is passed along so that, if the generation has not been recorded yet, the registration
+// picks it up within earlyTTL; pollers pass nil
+func (v *ValkeyClient) ClaimPendingGeneration(ctx context.Context, provider, generationID string, completion *GenerationCompletion, earlyTTL time.Duration) (*PendingGeneration, error) {
+	var completionJSON []byte
+	if completion != nil {
+		var err error
+		if completionJSON, err = json.Marshal(completion); err != nil {
+			return nil, fmt.Errorf("failed to marshal generation completion: %w", err)
+		}
+	}
+
+	pendingJSON, err := claimGenerationScript.Run(ctx, v.client, pendingGenerationKeys(provider, generationID),
+		generationID, string(completionJSON), earlyTTL.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending generation: %w", err)
+	}
+
+	var pending PendingGeneration
+	if err := json.Unmarshal([]byte(pendingJSON), &pending); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pending generation: %w", err)
+	}
+
+	return &pending, nil
+}
+
+// GetPendingGeneration looks up a pending generation without claiming it, returning nil if there is none with that ID
+func (v *ValkeyClient) GetPendingGeneration(ctx context.Context, generationID string) (*PendingGeneration, error) {
+	pendingJSON, err := v.client.Get(ctx, pendingGenerationKeyPrefix+generationID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending generation: %w", err)
+	}
+
+	var pending PendingGeneration
+	if err := json.Unmarshal([]byte(pendingJSON), &pending); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pending generation: %w", err)
+	}
+
+	return &pending, nil
+}
+
+// ListPendingGenerations returns the IDs of generations started before the given time, oldest first
+// The list may include generations that expired since; claiming them clears them from it
+func (v *ValkeyClient) ListPendingGenerations(ctx context.Context, startedBefore time.Time) ([]string, error) {
+	ids, err := v.client.ZRangeByScore(ctx, pendingGenerationsKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", startedBefore.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending generations: %w", err)
+	}
+	return ids, nil
+}
+
+// ClaimPendingGenerationSweep reports whether this droplet should check on pending generations now
+// One droplet per interval gets to, so the fallback polling does not multiply with the number of droplets
+func (v *ValkeyClient) ClaimPendingGenerationSweep(ctx context.Context, instance string, interval time.Duration) (bool, error) {
+	claimed, err := v.client.SetNX(ctx, pendingSweepLockKey, instance, interval).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim pending generation sweep: %w", err)
+	}
+	return claimed, nil
+}
+
+// pendingGenerationKeys returns the keys the registration and claim scripts work on
+func pendingGenerationKeys(provider, generationID string) []string {
+	return []string{
+		pendingGenerationKeyPrefix + generationID,
+		pendingGenerationsKey,
+		providerSlotsKeyPrefix + provider,
+		earlyCompletionKeyPrefix + generationID,
+	}
+}
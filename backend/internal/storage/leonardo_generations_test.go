@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPendingGenerations(t *testing.T) {
+	const (
+		ttl      = 10 * time.Minute
+		earlyTTL = time.Minute
+	)
+	completion := &GenerationCompletion{GenerationID: "gen-1", Status: "COMPLETE", ImageURLs: []string{"https://cdn/a.png"}}
+
+	type step struct {
+		advance        time.Duration // Moves the server clock, and expires keys, before the operation
+		op             string        // register, claim (a callback's) or poll (a claim without a completion)
+		wantCompletion bool          // register: the early completion was handed back
+		wantPending    bool          // claim and poll: the generation was claimed
+	}
+	tests := []struct {
+		name        string
+		steps       []step
+		wantStored  bool // Whether the generation is left pending, holding a slot
+		wantEarlyIn time.Duration
+	}{
+		{
+			name:       "registered",
+			steps:      []step{{0, "register", false, false}},
+			wantStored: true,
+		},
+		{
+			name: "callback claims it once",
+			steps: []step{
+				{0, "register", false, false},
+				{time.Second, "claim", false, true},
+				{0, "claim", false, false},
+			},
+			wantEarlyIn: earlyTTL, // A repeated callback cannot be told from an early one, and expires unused
+		},
+		{
+			name: "poller claims it",
+			steps: []step{
+				{0, "register", false, false},
+				{time.Second, "poll", false, true},
+				{0, "claim", false, false},
+			},
+			wantEarlyIn: earlyTTL, // Likewise the late callback
+		},
+		{
+			name:        "callback before registration",
+			steps:       []step{{0, "claim", false, false}},
+			wantEarlyIn: earlyTTL,
+		},
+		{
+			name: "registration picks up the early callback",
+			steps: []step{
+				{0, "claim", false, false},
+				{time.Second, "register", true, false},
+			},
+		},
+		{
+			name: "early callback expires",
+			steps: []step{
+				{0, "claim", false, false},
+				{earlyTTL + time.Second, "register", false, false},
+			},
+			wantStored: true,
+		},
+		{
+			name: "pending generation expires",
+			steps: []step{
+				{0, "register", false, false},
+				{ttl + time.Second, "poll", false, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, server := newTestValkey(t)
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			pending := &PendingGeneration{
+				GenerationID: "gen-1",
+				Provider:     "leonardo-ai",
+				PairID:       "pair-1",
+				Prompt:       "a fox",
+				Instance:     "droplet-1",
+				StartedAt:    now,
+			}
+
+			for i, step := range tt.steps {
+				now = now.Add(step.advance)
+				server.FastForward(step.advance)
+				server.SetTime(now)
+
+				switch step.op {
+				case "register":
+					early, err := v.RegisterPendingGeneration(ctx, pending, ttl)
+					if err != nil {
+						t.Fatalf("step %d: RegisterPendingGeneration: %v", i, err)
+					}
+					if (early != nil) != step.wantCompletion || (early != nil && !reflect.DeepEqual(early, completion)) {
+						t.Errorf("step %d: early completion = %+v, want %v", i, early, step.wantCompletion)
+					}
+				case "claim", "poll":
+					var callback *GenerationCompletion
+					if step.op == "claim" {
+						callback = completion
+					}
+					claimed, err := v.ClaimPendingGeneration(ctx, "leonardo-ai", "gen-1", callback, earlyTTL)
+					if err != nil {
+						t.Fatalf("step %d: ClaimPendingGeneration: %v", i, err)
+					}
+					if (claimed != nil) != step.wantPending || (claimed != nil && !reflect.DeepEqual(claimed, pending)) {
+						t.Errorf("step %d: claimed %+v, want %v", i, claimed, step.wantPending)
+					}
+				}
+			}
+
+			stored, err := v.GetPendingGeneration(ctx, "gen-1")
+			if err != nil {
+				t.Fatalf("GetPendingGeneration: %v", err)
+			}
+			listed, err := v.ListPendingGenerations(ctx, now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("ListPendingGenerations: %v", err)
+			}
+			slot, _ := server.ZScore(providerSlotsKeyPrefix+"leonardo-ai", "gen-1")
+			if (stored != nil) != tt.wantStored || (len(listed) == 1) != tt.wantStored || (slot > 0) != tt.wantStored {
+				t.Errorf("stored %v, listed %v and holding slot %v, want %v", stored != nil, listed, slot > 0, tt.wantStored)
+			}
+			if tt.wantStored && slot != float64(now.Add(ttl).UnixMilli()) {
+				t.Errorf("slot lease ends at %v, want the pending generation's ttl", time.UnixMilli(int64(slot)))
+			}
+			if got := server.TTL(earlyCompletionKeyPrefix + "gen-1"); got != tt.wantEarlyIn {
+				t.Errorf("early completion expires in %v, want %v", got, tt.wantEarlyIn)
+			}
+		})
+	}
+}
+
+func TestListPendingGenerations(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"gen-1", "gen-2", "gen-3"} {
+		server.SetTime(start.Add(time.Duration(i) * time.Minute))
+		if _, err := v.RegisterPendingGeneration(ctx, &PendingGeneration{GenerationID: id, Provider: "leonardo-ai"}, time.Hour); err != nil {
+			t.Fatalf("RegisterPendingGeneration(%s): %v", id, err)
+		}
+	}
+
+	tests := []struct {
+		before time.Time
+		want   []string
+	}{
+		{start, nil},
+		{start.Add(time.Minute), []string{"gen-1"}},
+		{start.Add(90 * time.Second), []string{"gen-1", "gen-2"}},
+		{start.Add(time.Hour), []string{"gen-1", "gen-2", "gen-3"}},
+	}
+
+	for _, tt := range tests {
+		ids, err := v.ListPendingGenerations(ctx, tt.before)
+		if err != nil {
+			t.Fatalf("ListPendingGenerations: %v", err)
+		}
+		if len(ids) != len(tt.want) || (len(ids) > 0 && !reflect.DeepEqual(ids, tt.want)) {
+			t.Errorf("started before %v: %v, want %v", tt.before.Sub(start), ids, tt.want)
+		}
+	}
+}
+
+func TestClaimPendingGenerationSweep(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	tests := []struct {
+		advance  time.Duration
+		instance string
+		want     bool
+	}{
+		{0, "droplet-1", true},
+		{0, "droplet-2", false},
+		{10 * time.Second, "droplet-1", false},
+		{11 * time.Second, "droplet-2", true},
+	}
+
+	for i, tt := range tests {
+		server.FastForward(tt.advance)
+		claimed, err := v.ClaimPendingGenerationSweep(ctx, tt.instance, 20*time.Second)
+		if err != nil {
+			t.Fatalf("ClaimPendingGenerationSweep: %v", err)
+		}
+		if claimed != tt.want {
+			t.Errorf("claim %d by %s = %v, want %v", i, tt.instance, claimed, tt.want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// pairVotesKey is a hash of pair ID to the number of votes the pair has received
+	pairVotesKey = "pair:votes"
+
+	// pairWinsKeyPrefix prefixes the per-side sorted sets of pair IDs scored by the votes that side of the pair won
+	// ("wins:left" and "wins:right")
+	pairWinsKeyPrefix = "wins:"
+
+	// pairArchiveKey is a sorted set of retired pair IDs scored by retirement time (unix seconds)
+	pairArchiveKey = "pairs:archive"
+
+	// pairUpdateRetries is how many times a retire or delete is retried when the pair changes underneath it
+	pairUpdateRetries = 10
+)
+
+// RetentionPolicy decides when a pair is retired from rotation
+// A zero value for either limit disables that rule
+type RetentionPolicy struct {
+	MaxVotes      int64         // Retire once a pair has received this many votes
+	MaxAge        time.Duration // Retire once a pair is this old
+	SweepInterval time.Duration // How often the background sweeper runs
+}
+
+// Enabled reports whether any retirement rule is active
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxVotes > 0 || p.MaxAge > 0
+}
+
+// pairWinsKey is the sorted set of win counts for one side ("left" or "right")
+func pairWinsKey(side string) string {
+	return pairWinsKeyPrefix + side
+}
+
+// GetPairVoteCount returns how many votes a pair has received
+func (v *ValkeyClient) GetPairVoteCount(ctx context.Context, pairID string) (int64, error) {
+	count, err := v.client.HGet(ctx, pairVotesKey, pairID).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get vote count for pair %s: %w", pairID, err)
+	}
+	return count, nil
+}
+
+// RetirePair removes a pair from rotation and moves it to the archive
+// The pair itself, its votes and its images are kept, so it still appears in winners and the archive listing
+// The pair is read and rewritten in a WATCH transaction, so a pair deleted meanwhile is never written back
+func (v *ValkeyClient) RetirePair(ctx context.Context, pairID, reason string) error {
+	key := fmt.Sprintf("pair:%s", pairID)
+	txf := func(tx *redis.Tx) error {
+		pair, err := getImagePair(ctx, tx, pairID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		pair.RetiredAt = &now
+		pair.RetiredReason = reason
+
+		pairJSON, err := json.Marshal(pair)
+		if err != nil {
+			return fmt.Errorf("failed to marshal pair: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, pairJSON, 0)
+			pipe.ZAdd(ctx, pairArchiveKey, redis.Z{Score: float64(now.Unix()), Member: pairID})
+			pipe.LRem(ctx, "pairs:all", 0, pairID)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < pairUpdateRetries; attempt++ {
+		err := v.client.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue // Changed (or deleted) by someone else between GET and EXEC
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retire pair %s: %w", pairID, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed to retire pair %s: too much contention", pairID)
+}
+
+// SweepRetiredPairs retires every pair in rotation that the policy says has run its course
+// Returns the number of pairs retired; safe to run concurrently on several droplets
+func (v *ValkeyClient) SweepRetiredPairs(ctx context.Context, policy RetentionPolicy) (int, error) {
+	if !policy.Enabled() {
+		return 0, nil
+	}
+
+	pairIDs, err := v.client.LRange(ctx, "pairs:all", 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get pairs list: %w", err)
+	}
+
+	retired := 0
+	for _, pairID := range pairIDs {
+		reason, err := v.retirementReason(ctx, pairID, policy)
+		if err != nil {
+			logger.WarnContext(ctx, "Skipping pair in retention sweep", "pair_id", pairID, "error", err)
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+
+		if err := v.RetirePair(ctx, pairID, reason); err != nil {
+			logger.WarnContext(ctx, "Failed to retire pair", "pair_id", pairID, "error", err)
+			continue
+		}
+		retired++
+	}
+
+	return retired, nil
+}
+
+// retirementReason returns why a pair should be retired, or "" if it should stay in rotation
+func (v *ValkeyClient) retirementReason(ctx context.Context, pairID string, policy RetentionPolicy) (string, error) {
+	if policy.MaxVotes > 0 {
+		votes, err := v.GetPairVoteCount(ctx, pairID)
+		if err != nil {
+			return "", err
+		}
+		if votes >= policy.MaxVotes {
+			return fmt.Sprintf("reached %d votes", votes), nil
+		}
+	}
+
+	if policy.MaxAge > 0 {
+		pair, err := v.GetImagePairByID(ctx, pairID)
+		if err != nil {
+			return "", err
+		}
+		if age := time.Since(pair.Timestamp); age >= policy.MaxAge {
+			return fmt.Sprintf("older than %s", policy.MaxAge), nil
+		}
+	}
+
+	return "", nil
+}
+
+// StartRetentionSweeper runs SweepRetiredPairs every policy.SweepInterval until ctx is cancelled
+// A sweep already under way when ctx is cancelled runs to completion, and Close waits for it
+func (v *ValkeyClient) StartRetentionSweeper(ctx context.Context, policy RetentionPolicy) {
+	if !policy.Enabled() || policy.SweepInterval <= 0 {
+		return
+	}
+
+	v.background.Add(1)
+	go func() {
+		defer v.background.Done()
+		ticker := time.NewTicker(policy.SweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				retired, err := v.SweepRetiredPairs(context.WithoutCancel(ctx), policy)
+				if err != nil {
+					logger.ErrorContext(ctx, "Retention sweep failed", "error", err)
+				} else if retired > 0 {
+					logger.InfoContext(ctx, "Retired pairs", "retired", retired)
+				}
+			}
+		}
+	}()
+}
+
+// GetArchivedPairs returns retired pairs, most recently retired first, along with the archive size
+func (v *ValkeyClient) GetArchivedPairs(ctx context.Context, offset, limit int64) ([]*ImagePair, int64, error) {
+	total, err := v.client.ZCard(ctx, pairArchiveKey).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count archived pairs: %w", err)
+	}
+
+	pairIDs, err := v.client.ZRevRange(ctx, pairArchiveKey, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get archived pairs: %w", err)
+	}
+
+	pairs := make([]*ImagePair, 0, len(pairIDs))
+	for _, pairID := range pairIDs {
+		pair, err := v.GetImagePairByID(ctx, pairID)
+		if err != nil {
+			continue // Deleted since it was archived
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, total, nil
+}
+
+// PairIndexState describes how much of a pair's index already exists in Valkey
+type PairIndexState struct {
+	Stored     bool // pair:<id> exists
+	InRotation bool // listed in pairs:all
+	Archived   bool // listed in pairs:archive
+}
+
+// GetPairIndexState reports whether a pair is stored and where it is listed
+func (v *ValkeyClient) GetPairIndexState(ctx context.Context, pairID string) (*PairIndexState, error) {
+	state := &PairIndexState{}
+
+	exists, err := v.client.Exists(ctx, fmt.Sprintf("pair:%s", pairID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pair %s: %w", pairID, err)
+	}
+	state.Stored = exists == 1
+
+	_, err = v.client.LPos(ctx, "pairs:all", pairID, redis.LPosArgs{}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to check rotation for pair %s: %w", pairID, err)
+	}
+	state.InRotation = err == nil
+
+	_, err = v.client.ZScore(ctx, pairArchiveKey, pairID).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to check archive for pair %s: %w", pairID, err)
+	}
+	state.Archived = err == nil
+
+	return state, nil
+}
+
+// RestorePair re-creates whatever is missing from a pair's index without duplicating what exists
+// An existing pair:<id> is never overwritten, and the pair is only added to pairs:all if it is
+// neither already there nor archived; everything missing is written in one transaction
+func (v *ValkeyClient) RestorePair(ctx context.Context, pair *ImagePair, state *PairIndexState) error {
+	pairJSON, err := json.Marshal(pair)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pair: %w", err)
+	}
+
+	_, err = v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if !state.Stored {
+			pipe.SetNX(ctx, fmt.Sprintf("pair:%s", pair.PairID), pairJSON, 0)
+			indexImageHashes(ctx, pipe, pair)
+		}
+		if !state.InRotation && !state.Archived {
+			pipe.LPush(ctx, "pairs:all", pair.PairID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore pair: %w", err)
+	}
+
+	return nil
+}
+
+// DeletePair removes every trace of a pair from Valkey: the pair and vote keys, its membership in
+// pairs:all and the archive, its vote count and its hash index entries, in one WATCH transaction so that a
+// concurrent retirement cannot write the pair back; then its entries in every session's viewed and served sets
+// pair is used for the hash index entries if the stored pair is already gone
+// Objects in Spaces are not touched; see ImagePair.ObjectKeys
+func (v *ValkeyClient) DeletePair(ctx context.Context, pair *ImagePair) error {
+	pairID := pair.PairID
+	key := fmt.Sprintf("pair:%s", pairID)
+
+	txf := func(tx *redis.Tx) error {
+		stored, err := getImagePair(ctx, tx, pairID)
+		if err == nil {
+			pair = stored
+		} else if !errors.Is(err, errPairNotFound) {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key, fmt.Sprintf("vote:%s", pairID))
+			pipe.LRem(ctx, "pairs:all", 0, pairID)
+			pipe.ZRem(ctx, pairArchiveKey, pairID)
+			pipe.HDel(ctx, pairVotesKey, pairID)
+			pipe.ZRem(ctx, pairWinsKey("left"), pairID)
+			pipe.ZRem(ctx, pairWinsKey("right"), pairID)
+			for side, hash := range map[string]string{"left": pair.LeftHash, "right": pair.RightHash} {
+				member := fmt.Sprintf("%s:%s", pairID, side)
+				pipe.HDel(ctx, hashIndexKey, member)
+				if hash != "" {
+					pipe.SRem(ctx, fmt.Sprintf("phash:%s", hash), member)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	deleted := false
+	for attempt := 0; attempt < pairUpdateRetries && !deleted; attempt++ {
+		err := v.client.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue // Retired or rewritten meanwhile; delete what is there now
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete pair %s: %w", pairID, err)
+		}
+		deleted = true
+	}
+	if !deleted {
+		return fmt.Errorf("failed to delete pair %s: too much contention", pairID)
+	}
+
+	return v.forgetSessionPair(ctx, pairID)
+}
+
+// forgetSessionPair removes a pair from every session's viewed set and served hash
+// Session keys expire after 24 hours, but a deleted pair should not linger in any of them
+func (v *ValkeyClient) forgetSessionPair(ctx context.Context, pairID string) error {
+	iter := v.client.Scan(ctx, 0, "session:*", 500).Iterator()
+	for iter.Next(ctx) {
+		var err error
+		switch key := iter.Val(); {
+		case strings.HasSuffix(key, ":viewed"):
+			err = v.client.SRem(ctx, key, pairID).Err()
+		case strings.HasSuffix(key, ":served"):
+			err = v.client.HDel(ctx, key, pairID).Err()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove pair from %s: %w", iter.Val(), err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan session keys: %w", err)
+	}
+
+	return nil
+}
+
+// ObjectKeys returns the Spaces keys of both images in the pair and all of their variants
+// Layout: images/<provider>/<pair-id>/<side>.png and <side>_<variant>.png
+func (p *ImagePair) ObjectKeys() []string {
+	keys := make([]string, 0, 2+len(p.LeftVariants)+len(p.RightVariants))
+	for side, variants := range map[string]map[string]string{"left": p.LeftVariants, "right": p.RightVariants} {
+		base := fmt.Sprintf("images/%s/%s/%s", p.Provider, p.PairID, side)
+		keys = append(keys, base+".png")
+		for name := range variants {
+			keys = append(keys, fmt.Sprintf("%s_%s.png", base, name))
+		}
+	}
+	return keys
+}
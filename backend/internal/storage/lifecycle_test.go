@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/imaging"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// storeTestPair stores a pair in rotation and fails the test on error
+func storeTestPair(t *testing.T, v *ValkeyClient, pair *ImagePair) {
+	t.Helper()
+	if err := v.StoreImagePair(context.Background(), pair); err != nil {
+		t.Fatalf("StoreImagePair(%s): %v", pair.PairID, err)
+	}
+}
+
+func TestSweepRetiredPairs(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      RetentionPolicy
+		votes       int64
+		age         time.Duration
+		wantRetired bool
+	}{
+		{"disabled", RetentionPolicy{}, 100, 1000 * time.Hour, false},
+		{"below vote limit", RetentionPolicy{MaxVotes: 10}, 9, 0, false},
+		{"at vote limit", RetentionPolicy{MaxVotes: 10}, 10, 0, true},
+		{"younger than max age", RetentionPolicy{MaxAge: 48 * time.Hour}, 0, 24 * time.Hour, false},
+		{"older than max age", RetentionPolicy{MaxAge: 48 * time.Hour}, 0, 72 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, server := newTestValkey(t)
+			ctx := context.Background()
+
+			storeTestPair(t, v, &ImagePair{PairID: "p", Timestamp: time.Now().Add(-tt.age)})
+			server.HSet(pairVotesKey, "p", "0")
+			if tt.votes > 0 {
+				server.HIncr(pairVotesKey, "p", int(tt.votes))
+			}
+
+			retired, err := v.SweepRetiredPairs(ctx, tt.policy)
+			if err != nil {
+				t.Fatalf("SweepRetiredPairs: %v", err)
+			}
+			if (retired == 1) != tt.wantRetired {
+				t.Fatalf("retired %d pairs, want retired = %v", retired, tt.wantRetired)
+			}
+
+			state, err := v.GetPairIndexState(ctx, "p")
+			if err != nil {
+				t.Fatalf("GetPairIndexState: %v", err)
+			}
+			if state.InRotation == tt.wantRetired || state.Archived != tt.wantRetired || !state.Stored {
+				t.Errorf("state = %+v, want retired = %v", state, tt.wantRetired)
+			}
+
+			pair, err := v.GetImagePairByID(ctx, "p")
+			if err != nil {
+				t.Fatalf("GetImagePairByID: %v", err)
+			}
+			if (pair.RetiredAt != nil) != tt.wantRetired || (pair.RetiredReason != "") != tt.wantRetired {
+				t.Errorf("retired at %v for %q, want retired = %v", pair.RetiredAt, pair.RetiredReason, tt.wantRetired)
+			}
+		})
+	}
+}
+
+func TestRetireMissingPair(t *testing.T) {
+	v, server := newTestValkey(t)
+
+	if err := v.RetirePair(context.Background(), "missing", "test"); !errors.Is(err, errPairNotFound) {
+		t.Fatalf("RetirePair error = %v, want pair not found", err)
+	}
+	if server.Exists("pair:missing") || server.Exists(pairArchiveKey) {
+		t.Error("retiring a missing pair wrote it")
+	}
+}
+
+// deleteOnReadHook deletes a pair through another connection right after the first read of it, as a concurrent
+// DeletePair would
+type deleteOnReadHook struct {
+	other *ValkeyClient
+	pair  *ImagePair
+	done  bool
+}
+
+func (h *deleteOnReadHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *deleteOnReadHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *deleteOnReadHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "get" && !h.done {
+			h.done = true
+			if err := h.other.DeletePair(ctx, h.pair); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+}
+
+func TestRetireRacingDelete(t *testing.T) {
+	v, server := newTestValkey(t)
+	ctx := context.Background()
+
+	pair := &ImagePair{PairID: "p", Timestamp: time.Now()}
+	storeTestPair(t, v, pair)
+
+	other := &ValkeyClient{client: redis.NewClient(&redis.Options{Addr: server.Addr()})}
+	defer other.client.Close()
+	v.client.AddHook(&deleteOnReadHook{other: other, pair: pair})
+
+	if err := v.RetirePair(ctx, "p", "test"); !errors.Is(err, errPairNotFound) {
+		t.Fatalf("RetirePair error = %v, want pair not found after the concurrent delete", err)
+	}
+	if server.Exists("pair:p") {
+		t.Error("retirement wrote back a pair deleted meanwhile")
+	}
+	if members, _ := server.ZMembers(pairArchiveKey); len(members) != 0 {
+		t.Errorf("archive = %v, want it empty", members)
+	}
+}
+
+func TestDeletePair(t *testing.T) {
+	v, server := newTestValkey(t)
+	ctx := context.Background()
+
+	leftHash, rightHash := imaging.FormatHash(1), imaging.FormatHash(2)
+	pair := &ImagePair{PairID: "p", Timestamp: time.Now(), LeftHash: leftHash, RightHash: rightHash}
+	storeTestPair(t, v, pair)
+	storeTestPair(t, v, &ImagePair{PairID: "kept", Timestamp: time.Now(), LeftHash: leftHash})
+	if err := v.RetirePair(ctx, "p", "test"); err != nil {
+		t.Fatalf("RetirePair: %v", err)
+	}
+	if err := v.RecordVote(ctx, &Vote{PairID: "p", Winner: "left"}); err != nil {
+		t.Fatalf("RecordVote: %v", err)
+	}
+	for _, session := range []string{"s1", "s2"} {
+		if err := v.MarkImageAsViewed(ctx, session, "p"); err != nil {
+			t.Fatalf("MarkImageAsViewed: %v", err)
+		}
+		if err := v.MarkImageAsViewed(ctx, session, "kept"); err != nil {
+			t.Fatalf("MarkImageAsViewed: %v", err)
+		}
+	}
+
+	// A stale copy of the pair is enough; DeletePair reads the stored one
+	if err := v.DeletePair(ctx, &ImagePair{PairID: "p"}); err != nil {
+		t.Fatalf("DeletePair: %v", err)
+	}
+
+	for _, key := range []string{"pair:p", "vote:p"} {
+		if server.Exists(key) {
+			t.Errorf("%s still exists", key)
+		}
+	}
+	if members, _ := server.ZMembers(pairArchiveKey); len(members) != 0 {
+		t.Errorf("archive = %v, want it empty", members)
+	}
+	if rotation, _ := server.List("pairs:all"); len(rotation) != 1 || rotation[0] != "kept" {
+		t.Errorf("rotation = %v, want [kept]", rotation)
+	}
+	if count, _ := v.GetPairVoteCount(ctx, "p"); count != 0 {
+		t.Errorf("pair vote count = %d, want 0", count)
+	}
+	if fields, _ := server.HKeys(hashIndexKey); len(fields) != 1 || fields[0] != "kept:left" {
+		t.Errorf("hash index = %v, want [kept:left]", fields)
+	}
+	if members, _ := server.Members("phash:" + leftHash); len(members) != 1 || members[0] != "kept:left" {
+		t.Errorf("phash:%s = %v, want [kept:left]", leftHash, members)
+	}
+	if server.Exists("phash:" + rightHash) {
+		t.Errorf("phash:%s still exists", rightHash)
+	}
+
+	for _, session := range []string{"s1", "s2"} {
+		if viewed, _ := server.Members(sessionKey(session)); len(viewed) != 1 || viewed[0] != "kept" {
+			t.Errorf("session %s viewed %v, want [kept]", session, viewed)
+		}
+		if served, _ := server.HKeys(sessionServedKey(session)); len(served) != 1 || served[0] != "kept" {
+			t.Errorf("session %s served %v, want [kept]", session, served)
+		}
+	}
+}
+
+// sessionKey is the set of pairs a session has viewed
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID + ":viewed"
+}
+
+func TestRestorePair(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(t *testing.T, v *ValkeyClient)
+		wantStored   bool // Whether the stored pair is the original rather than the restored copy
+		wantRotation []string
+		wantArchived bool
+	}{
+		{
+			name:         "missing entirely",
+			setup:        func(t *testing.T, v *ValkeyClient) {},
+			wantRotation: []string{"p"},
+		},
+		{
+			name: "stored but not listed",
+			setup: func(t *testing.T, v *ValkeyClient) {
+				storeTestPair(t, v, &ImagePair{PairID: "p", Prompt: "original"})
+				v.client.Del(context.Background(), "pairs:all")
+			},
+			wantStored:   true,
+			wantRotation: []string{"p"},
+		},
+		{
+			name: "already indexed",
+			setup: func(t *testing.T, v *ValkeyClient) {
+				storeTestPair(t, v, &ImagePair{PairID: "p", Prompt: "original"})
+			},
+			wantStored:   true,
+			wantRotation: []string{"p"},
+		},
+		{
+			name: "retired",
+			setup: func(t *testing.T, v *ValkeyClient) {
+				storeTestPair(t, v, &ImagePair{PairID: "p", Prompt: "original"})
+				if err := v.RetirePair(context.Background(), "p", "test"); err != nil {
+					t.Fatalf("RetirePair: %v", err)
+				}
+			},
+			wantStored:   true,
+			wantArchived: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, server := newTestValkey(t)
+			ctx := context.Background()
+			tt.setup(t, v)
+
+			// Restoring twice must not duplicate anything
+			for i := 0; i < 2; i++ {
+				state, err := v.GetPairIndexState(ctx, "p")
+				if err != nil {
+					t.Fatalf("GetPairIndexState: %v", err)
+				}
+				restored := &ImagePair{PairID: "p", Prompt: "restored", LeftHash: imaging.FormatHash(5)}
+				if err := v.RestorePair(ctx, restored, state); err != nil {
+					t.Fatalf("RestorePair: %v", err)
+				}
+			}
+
+			pair, err := v.GetImagePairByID(ctx, "p")
+			if err != nil {
+				t.Fatalf("GetImagePairByID: %v", err)
+			}
+			if (pair.Prompt == "original") != tt.wantStored {
+				t.Errorf("stored prompt = %q, want the original kept = %v", pair.Prompt, tt.wantStored)
+			}
+
+			rotation, _ := server.List("pairs:all")
+			if len(rotation) != len(tt.wantRotation) || (len(rotation) == 1 && rotation[0] != "p") {
+				t.Errorf("rotation = %v, want %v", rotation, tt.wantRotation)
+			}
+			if archived, _ := server.ZMembers(pairArchiveKey); (len(archived) == 1) != tt.wantArchived {
+				t.Errorf("archive = %v, want archived = %v", archived, tt.wantArchived)
+			}
+		})
+	}
+}
+
+func TestRetentionSweeperStopsBeforeClose(t *testing.T) {
+	v, server := newTestValkey(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	storeTestPair(t, v, &ImagePair{PairID: "p", Timestamp: time.Now()})
+	server.HSet(pairVotesKey, "p", "10")
+	v.StartRetentionSweeper(ctx, RetentionPolicy{MaxVotes: 10, SweepInterval: time.Millisecond})
+
+	deadline := time.Now().Add(time.Second)
+	for !server.Exists(pairArchiveKey) {
+		if time.Now().After(deadline) {
+			t.Fatal("sweeper never retired the pair")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- v.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close is still waiting after the sweeper's context was cancelled")
+	}
+}
+
+func TestCloseWaitsForBackgroundWork(t *testing.T) {
+	v, _ := newTestValkey(t)
+
+	// Stands in for a sweep or compaction already under way when shutdown starts
+	v.background.Add(1)
+	closed := make(chan error, 1)
+	go func() { closed <- v.Close() }()
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned while background work was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := v.Ping(context.Background()); err != nil {
+		t.Errorf("connection closed before background work finished: %v", err)
+	}
+	v.background.Done()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close never returned once background work finished")
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Provider concurrency slots, shared by every droplet
+// provider:slots:<name> holds the current holders scored by lease expiry; provider:queue:<name> holds waiters
+// scored by arrival (a ticket from provider:queue:<name>:seq), and provider:waiters:<name> the time each waiter
+// last polled, so waiters on a droplet that died drop out of the queue
+const (
+	providerSlotsKeyPrefix   = "provider:slots:"
+	providerQueueKeyPrefix   = "provider:queue:"
+	providerWaitersKeyPrefix = "provider:waiters:"
+)
+
+// acquireSlotScript queues the caller and gives it a slot if one is free and nobody queued ahead of it
+// Expired leases and waiters that stopped polling are cleared first; Valkey's clock is used throughout
+// Key expiry is only ever extended, since pending Leonardo generations hold slots with much longer leases
+// KEYS: slots, queue, waiters, ticket counter
+// ARGV: holder ID, limit, lease in milliseconds, waiter timeout in milliseconds
+// Returns {1, 0} when the slot was taken, or {0, waiters ahead} when the caller has to keep waiting
+var acquireSlotScript = redis.NewScript(`
+local id = ARGV[1]
+local limit = tonumber(ARGV[2])
+local lease = tonumber(ARGV[3])
+local timeout = tonumber(ARGV[4])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+for _, waiter in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now)) do
+	redis.call('ZREM', KEYS[2], waiter)
+	redis.call('ZREM', KEYS[3], waiter)
+end
+
+if not redis.call('ZSCORE', KEYS[2], id) then
+	redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[4]), id)
+end
+redis.call('ZADD', KEYS[3], now + timeout, id)
+
+local result = {0, 0}
+local free = limit - redis.call('ZCARD', KEYS[1])
+local position = redis.call('ZRANK', KEYS[2], id)
+if position < free then
+	redis.call('ZADD', KEYS[1], now + lease, id)
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('ZREM', KEYS[3], id)
+	result = {1, 0}
+else
+	result[2] = position
+end
+
+-- After the writes, so a key created just now gets its expiry too
+for _, key in ipairs(KEYS) do
+	if redis.call('PTTL', key) < lease + timeout then
+		redis.call('PEXPIRE', key, lease + timeout)
+	end
+end
+return result
+`)
+
+// renewSlotScript extends a held slot's lease; it returns 0 if the slot had already expired
+// KEYS: slots
+// ARGV: holder ID, lease in milliseconds
+var renewSlotScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local renewed = redis.call('ZADD', KEYS[1], 'XX', 'CH', now + tonumber(ARGV[2]), ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], tonumber(ARGV[2]))
+end
+return renewed
+`)
+
+// slotUsageScript counts live holders and waiters
+// KEYS: slots, queue, waiters
+var slotUsageScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+return {redis.call('ZCOUNT', KEYS[1], now, '+inf'), redis.call('ZCOUNT', KEYS[3], now, '+inf')}
+`)
+
+// AcquireProviderSlot joins the provider's queue as holder (or keeps its place in it) and takes a slot if one is free
+// and nobody is ahead; otherwise it returns how many waiters are ahead. Call it again at least every waiterTimeout
+// to stay queued, and call ReleaseProviderSlot when done, whether or not a slot was taken
+func (v *ValkeyClient) AcquireProviderSlot(ctx context.Context, provider, holder string, limit int, lease, waiterTimeout time.Duration) (bool, int64, error) {
+	keys := []string{
+		providerSlotsKeyPrefix + provider,
+		providerQueueKeyPrefix + provider,
+		providerWaitersKeyPrefix + provider,
+		providerQueueKeyPrefix + provider + ":seq",
+	}
+	values, err := acquireSlotScript.Run(ctx, v.client, keys, holder, limit, lease.Milliseconds(), waiterTimeout.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to acquire %s slot: %w", provider, err)
+	}
+	if len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected slot result: %v", values)
+	}
+
+	return values[0] == 1, values[1], nil
+}
+
+// RenewProviderSlot extends holder's lease on a provider slot, reporting false if the lease had already run out
+func (v *ValkeyClient) RenewProviderSlot(ctx context.Context, provider, holder string, lease time.Duration) (bool, error) {
+	renewed, err := renewSlotScript.Run(ctx, v.client, []string{providerSlotsKeyPrefix + provider}, holder, lease.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew %s slot: %w", provider, err)
+	}
+	return renewed == 1, nil
+}
+
+// ReleaseProviderSlot frees holder's slot, or takes it out of the queue if it never got one
+func (v *ValkeyClient) ReleaseProviderSlot(ctx context.Context, provider, holder string) error {
+	_, err := v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, providerSlotsKeyPrefix+provider, holder)
+		pipe.ZRem(ctx, providerQueueKeyPrefix+provider, holder)
+		pipe.ZRem(ctx, providerWaitersKeyPrefix+provider, holder)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release %s slot: %w", provider, err)
+	}
+	return nil
+}
+
+// ProviderSlotUsage returns how many slots of a provider are held and how many callers are waiting for one
+func (v *ValkeyClient) ProviderSlotUsage(ctx context.Context, provider string) (int64, int64, error) {
+	keys := []string{
+		providerSlotsKeyPrefix + provider,
+		providerQueueKeyPrefix + provider,
+		providerWaitersKeyPrefix + provider,
+	}
+	values, err := slotUsageScript.Run(ctx, v.client, keys).Int64Slice()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read %s slot usage: %w", provider, err)
+	}
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("unexpected slot usage result: %v", values)
+	}
+
+	return values[0], values[1], nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProviderSlots(t *testing.T) {
+	const (
+		lease         = 10 * time.Second
+		waiterTimeout = 5 * time.Second
+	)
+
+	type step struct {
+		advance time.Duration // Moves the server clock before the operation
+		op      string        // acquire, renew or release
+		holder  string
+		want    bool // Whether the slot was acquired or renewed
+		ahead   int64
+	}
+	tests := []struct {
+		name  string
+		limit int
+		steps []step
+	}{
+		{
+			name:  "free slots",
+			limit: 2,
+			steps: []step{
+				{0, "acquire", "a", true, 0},
+				{0, "acquire", "b", true, 0},
+				{0, "acquire", "c", false, 0},
+				{0, "acquire", "d", false, 1},
+				{0, "acquire", "c", false, 0}, // Polling again keeps the caller's place
+			},
+		},
+		{
+			name:  "first come, first served",
+			limit: 1,
+			steps: []step{
+				{0, "acquire", "a", true, 0},
+				{0, "acquire", "b", false, 0},
+				{0, "acquire", "c", false, 1},
+				{0, "release", "a", true, 0},
+				{0, "acquire", "c", false, 1}, // The free slot is b's
+				{0, "acquire", "b", true, 0},
+				{0, "release", "b", true, 0},
+				{0, "acquire", "c", true, 0},
+			},
+		},
+		{
+			name:  "released waiter leaves the queue",
+			limit: 1,
+			steps: []step{
+				{0, "acquire", "a", true, 0},
+				{0, "acquire", "b", false, 0},
+				{0, "acquire", "c", false, 1},
+				{0, "release", "b", true, 0},
+				{0, "acquire", "c", false, 0},
+			},
+		},
+		{
+			name:  "expired lease frees the slot",
+			limit: 1,
+			steps: []step{
+				{0, "acquire", "a", true, 0},
+				{0, "acquire", "b", false, 0},
+				{4 * time.Second, "acquire", "b", false, 0},
+				{4 * time.Second, "acquire", "b", false, 0},
+				{4 * time.Second, "acquire", "b", true, 0},
+				{0, "renew", "a", false, 0},
+			},
+		},
+		{
+			name:  "waiter that stopped polling drops out",
+			limit: 1,
+			steps: []step{
+				{0, "acquire", "a", true, 0},
+				{0, "acquire", "b", false, 0},
+				{0, "acquire", "c", false, 1},
+				{waiterTimeout + time.Second, "acquire", "c", false, 0},
+				{0, "release", "a", true, 0},
+				{0, "acquire", "c", true, 0},
+			},
+		},
+		{
+			name:  "renewed lease holds the slot",
+			limit: 1,
+			steps: []step{
+				{0, "acquire", "a", true, 0},
+				{8 * time.Second, "renew", "a", true, 0},
+				{4 * time.Second, "acquire", "b", false, 0},
+				{4 * time.Second, "acquire", "b", false, 0},
+				{4 * time.Second, "acquire", "b", true, 0},
+				{0, "renew", "c", false, 0}, // Never held a slot
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, server := newTestValkey(t)
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+			for i, step := range tt.steps {
+				now = now.Add(step.advance)
+				server.SetTime(now)
+
+				var got bool
+				var ahead int64
+				var err error
+				switch step.op {
+				case "acquire":
+					got, ahead, err = v.AcquireProviderSlot(ctx, "openai", step.holder, tt.limit, lease, waiterTimeout)
+				case "renew":
+					got, err = v.RenewProviderSlot(ctx, "openai", step.holder, lease)
+				case "release":
+					got, err = true, v.ReleaseProviderSlot(ctx, "openai", step.holder)
+				}
+				if err != nil {
+					t.Fatalf("step %d: %s %s: %v", i, step.op, step.holder, err)
+				}
+				if got != step.want || ahead != step.ahead {
+					t.Errorf("step %d: %s %s = %v with %d ahead, want %v with %d ahead", i, step.op, step.holder, got, ahead, step.want, step.ahead)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderSlotUsage(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	server.SetTime(now)
+
+	for _, holder := range []string{"a", "b", "c"} {
+		if _, _, err := v.AcquireProviderSlot(ctx, "openai", holder, 1, 10*time.Second, 5*time.Second); err != nil {
+			t.Fatalf("AcquireProviderSlot(%s): %v", holder, err)
+		}
+	}
+
+	tests := []struct {
+		advance    time.Duration
+		wantInUse  int64
+		wantQueued int64
+	}{
+		{0, 1, 2},
+		{6 * time.Second, 1, 0}, // The waiters stopped polling
+		{5 * time.Second, 0, 0}, // The lease ran out
+	}
+
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		server.SetTime(now)
+
+		inUse, queued, err := v.ProviderSlotUsage(ctx, "openai")
+		if err != nil {
+			t.Fatalf("ProviderSlotUsage: %v", err)
+		}
+		if inUse != tt.wantInUse || queued != tt.wantQueued {
+			t.Errorf("check %d: %d in use and %d queued, want %d and %d", i, inUse, queued, tt.wantInUse, tt.wantQueued)
+		}
+	}
+}
+
+func TestProviderSlotKeysOnlyGainTime(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	// A pending Leonardo generation holds its slot far longer than an ordinary call
+	if _, _, err := v.AcquireProviderSlot(ctx, "leonardo", "pending", 2, time.Hour, 5*time.Second); err != nil {
+		t.Fatalf("AcquireProviderSlot: %v", err)
+	}
+	if ttl := server.TTL(providerSlotsKeyPrefix + "leonardo"); ttl != time.Hour+5*time.Second {
+		t.Errorf("new slots key expires in %v, want the lease plus the waiter timeout", ttl)
+	}
+	if _, _, err := v.AcquireProviderSlot(ctx, "leonardo", "call", 2, 10*time.Second, 5*time.Second); err != nil {
+		t.Fatalf("AcquireProviderSlot: %v", err)
+	}
+	if _, err := v.RenewProviderSlot(ctx, "leonardo", "call", 10*time.Second); err != nil {
+		t.Fatalf("RenewProviderSlot: %v", err)
+	}
+
+	if ttl := server.TTL(providerSlotsKeyPrefix + "leonardo"); ttl < time.Hour {
+		t.Errorf("slots key expires in %v, want at least the pending generation's lease", ttl)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// eventsChannel is the pub/sub channel live events are fanned out on, so every droplet sees every event
+const eventsChannel = "events"
+
+// PublishEvent broadcasts an encoded event to every subscribed server instance
+func (v *ValkeyClient) PublishEvent(ctx context.Context, payload []byte) error {
+	if err := v.client.Publish(ctx, eventsChannel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// SubscribeEvents returns a channel of encoded events published by any server instance
+// The subscription reconnects on its own after connection errors; the channel is closed once close is called
+func (v *ValkeyClient) SubscribeEvents(ctx context.Context) (<-chan string, func() error) {
+	return v.subscribe(ctx, eventsChannel)
+}
+
+// subscribe relays the payloads published on channel until the returned close function is called
+func (v *ValkeyClient) subscribe(ctx context.Context, channel string) (<-chan string, func() error) {
+	pubsub := v.client.Subscribe(ctx, channel)
+
+	messages := make(chan string, 64)
+	go func() {
+		defer close(messages)
+		for msg := range pubsub.Channel() {
+			messages <- msg.Payload
+		}
+	}()
+
+	return messages, pubsub.Close
+}
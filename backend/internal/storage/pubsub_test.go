@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	messages, closeSubscription := v.SubscribeEvents(ctx)
+
+	// The subscription is established asynchronously
+	deadline := time.Now().Add(time.Second)
+	for len(server.PubSubChannels("")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription never reached the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, payload := range []string{`{"type":"vote"}`, `{"type":"pair"}`} {
+		if err := v.PublishEvent(ctx, []byte(payload)); err != nil {
+			t.Fatalf("PublishEvent: %v", err)
+		}
+		select {
+		case got := <-messages:
+			if got != payload {
+				t.Errorf("received %q, want %q", got, payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %s never arrived", payload)
+		}
+	}
+
+	if err := closeSubscription(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Error("received an event after closing")
+		}
+	case <-time.After(time.Second):
+		t.Error("channel not closed after closing the subscription")
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitResult is the outcome of taking a token from a set of rate limit buckets
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int64         // Tokens left in the emptiest bucket
+	RetryAfter time.Duration // How long until a request would be allowed (zero when allowed)
+	Reset      time.Duration // How long until the emptiest bucket is full again
+}
+
+// takeTokenScript implements token buckets shared by every droplet
+// A request takes one token from every bucket it is keyed by, and only if all of them have one, so a client
+// cannot dodge its IP bucket by rotating session IDs (or the reverse)
+// Valkey's clock is used so droplets with skewed clocks agree; tokens are stored as strings to keep fractions
+// KEYS: one bucket per client identity
+// ARGV: capacity, refill window in milliseconds (capacity tokens refill over one window)
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local rate = capacity / window
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local allowed = 1
+local retry = 0
+local tokens = {}
+for i, key in ipairs(KEYS) do
+	local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+	local available = tonumber(bucket[1])
+	local updated = tonumber(bucket[2])
+	if available == nil or updated == nil then
+		available = capacity
+		updated = now
+	end
+	available = math.min(capacity, available + math.max(0, now - updated) * rate)
+	if available < 1 then
+		allowed = 0
+		retry = math.max(retry, math.ceil((1 - available) / rate))
+	end
+	tokens[i] = available
+end
+
+local lowest = capacity
+for i, key in ipairs(KEYS) do
+	local available = tokens[i]
+	if allowed == 1 then
+		available = available - 1
+	end
+	redis.call('HSET', key, 'tokens', tostring(available), 'ts', now)
+	redis.call('PEXPIRE', key, window)
+	lowest = math.min(lowest, available)
+end
+
+return {allowed, math.floor(lowest), retry, math.ceil((capacity - lowest) / rate)}
+`)
+
+// TakeRateLimitToken takes one token from each bucket in keys, allowing capacity requests per window per bucket
+func (v *ValkeyClient) TakeRateLimitToken(ctx context.Context, keys []string, capacity int64, window time.Duration) (*RateLimitResult, error) {
+	values, err := takeTokenScript.Run(ctx, v.client, keys, capacity, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result: %v", values)
+	}
+
+	return &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
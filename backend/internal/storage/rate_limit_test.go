@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTakeRateLimitToken(t *testing.T) {
+	// 4 tokens per 4096ms refills one token every 1024ms, which keeps the script's float maths exact
+	const capacity = 4
+	const window = 4096 * time.Millisecond
+	const refill = 1024 * time.Millisecond
+
+	type take struct {
+		advance    time.Duration // Moves the server clock before taking
+		keys       []string
+		want       bool
+		remaining  int64
+		retryAfter time.Duration
+		reset      time.Duration
+	}
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{
+			name: "drain and refill",
+			takes: []take{
+				{0, []string{"a"}, true, 3, 0, refill},
+				{0, []string{"a"}, true, 2, 0, 2 * refill},
+				{0, []string{"a"}, true, 1, 0, 3 * refill},
+				{0, []string{"a"}, true, 0, 0, window},
+				{0, []string{"a"}, false, 0, refill, window},
+				{refill / 2, []string{"a"}, false, 0, refill / 2, window - refill/2},
+				{refill / 2, []string{"a"}, true, 0, 0, window},
+			},
+		},
+		{
+			name: "refill stops at capacity",
+			takes: []take{
+				{0, []string{"a"}, true, 3, 0, refill},
+				{10 * window, []string{"a"}, true, 3, 0, refill},
+			},
+		},
+		{
+			name: "every bucket needs a token",
+			takes: []take{
+				{0, []string{"ip"}, true, 3, 0, refill},
+				{0, []string{"ip"}, true, 2, 0, 2 * refill},
+				{0, []string{"ip"}, true, 1, 0, 3 * refill},
+				{0, []string{"ip"}, true, 0, 0, window},
+				{0, []string{"ip", "session"}, false, 0, refill, window},
+				// The rejected request took nothing from the session bucket
+				{0, []string{"session"}, true, 3, 0, refill},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, server := newTestValkey(t)
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+			for i, step := range tt.takes {
+				now = now.Add(step.advance)
+				server.SetTime(now)
+
+				result, err := v.TakeRateLimitToken(ctx, step.keys, capacity, window)
+				if err != nil {
+					t.Fatalf("take %d: %v", i, err)
+				}
+				want := RateLimitResult{Allowed: step.want, Remaining: step.remaining, RetryAfter: step.retryAfter, Reset: step.reset}
+				if *result != want {
+					t.Errorf("take %d from %v = %+v, want %+v", i, step.keys, *result, want)
+				}
+			}
+
+			for _, key := range server.Keys() {
+				if ttl := server.TTL(key); ttl != window {
+					t.Errorf("%s expires in %v, want %v", key, ttl, window)
+				}
+			}
+		})
+	}
+}
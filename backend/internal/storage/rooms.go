@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Room states
+const (
+	RoomStateWaiting  = "waiting"  // Created, waiting for the host to start
+	RoomStateVoting   = "voting"   // Participants are voting on the current pair
+	RoomStateRevealed = "revealed" // Votes for the current pair have been revealed
+	RoomStateClosed   = "closed"   // Everyone left; the room is deleted on save
+)
+
+const (
+	// roomTTL is how long a room survives without any activity
+	roomTTL = 2 * time.Hour
+
+	// roomUpdateRetries bounds the optimistic-locking retries of UpdateRoom
+	roomUpdateRetries = 10
+)
+
+// ErrRoomNotFound is returned for rooms that never existed, expired or were closed
+var ErrRoomNotFound = errors.New("room not found")
+
+// RoomParticipant is someone connected to a room
+type RoomParticipant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	JoinedAt  time.Time `json:"joined_at"`
+	SessionID string    `json:"session_id,omitempty"` // Of the connection, for scoring the participant's votes
+	ClientIP  string    `json:"client_ip,omitempty"`
+}
+
+// Room is the shared state of a multiplayer battle room
+// It lives in Valkey so participants connected to different droplets see the same room
+type Room struct {
+	RoomID       string            `json:"room_id"`
+	HostToken    string            `json:"host_token"`        // Secret handed to the creator; connecting with it claims the host role
+	HostID       string            `json:"host_id,omitempty"` // Participant currently hosting
+	State        string            `json:"state"`
+	Round        int               `json:"round"`
+	PairID       string            `json:"pair_id,omitempty"` // Pair shown in the current round
+	ShownPairIDs []string          `json:"shown_pair_ids"`    // Pairs already shown, never repeated
+	Participants []RoomParticipant `json:"participants"`      // In join order
+	Votes        map[string]string `json:"votes"`             // Participant ID -> "left" or "right" for the current round
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// CreateRoom stores a new room, returning false if the room ID is already taken
+func (v *ValkeyClient) CreateRoom(ctx context.Context, room *Room) (bool, error) {
+	roomJSON, err := json.Marshal(room)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal room: %w", err)
+	}
+
+	created, err := v.client.SetNX(ctx, roomKey(room.RoomID), roomJSON, roomTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to create room: %w", err)
+	}
+
+	return created, nil
+}
+
+// GetRoom retrieves a room by ID
+func (v *ValkeyClient) GetRoom(ctx context.Context, roomID string) (*Room, error) {
+	roomJSON, err := v.client.Get(ctx, roomKey(roomID)).Result()
+	if err == redis.Nil {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	var room Room
+	if err := json.Unmarshal([]byte(roomJSON), &room); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal room: %w", err)
+	}
+
+	return &room, nil
+}
+
+// UpdateRoom applies update to a room atomically, retrying if another droplet changed the room concurrently
+// update may run several times and must only modify the room it is given; an error from it aborts the update
+// Rooms left in RoomStateClosed are deleted; every save refreshes the room TTL
+func (v *ValkeyClient) UpdateRoom(ctx context.Context, roomID string, update func(room *Room) error) (*Room, error) {
+	key := roomKey(roomID)
+
+	var room *Room
+	txf := func(tx *redis.Tx) error {
+		roomJSON, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return ErrRoomNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get room: %w", err)
+		}
+
+		room = &Room{}
+		if err := json.Unmarshal([]byte(roomJSON), room); err != nil {
+			return fmt.Errorf("failed to unmarshal room: %w", err)
+		}
+		if err := update(room); err != nil {
+			return err
+		}
+		room.UpdatedAt = time.Now().UTC()
+
+		updatedJSON, err := json.Marshal(room)
+		if err != nil {
+			return fmt.Errorf("failed to marshal room: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if room.State == RoomStateClosed {
+				pipe.Del(ctx, key)
+			} else {
+				pipe.Set(ctx, key, updatedJSON, roomTTL)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < roomUpdateRetries; attempt++ {
+		err := v.client.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue // Changed by someone else between GET and EXEC
+		}
+		if err != nil {
+			return nil, err
+		}
+		return room, nil
+	}
+
+	return nil, fmt.Errorf("failed to update room %s: too much contention", roomID)
+}
+
+// PublishRoomUpdate broadcasts an encoded room update to the room's subscribers on every droplet
+func (v *ValkeyClient) PublishRoomUpdate(ctx context.Context, roomID string, payload []byte) error {
+	if err := v.client.Publish(ctx, roomChannel(roomID), payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish room update: %w", err)
+	}
+	return nil
+}
+
+// SubscribeRoom returns a channel of encoded updates for a room; the channel is closed once close is called
+func (v *ValkeyClient) SubscribeRoom(ctx context.Context, roomID string) (<-chan string, func() error) {
+	return v.subscribe(ctx, roomChannel(roomID))
+}
+
+// roomKey is the key a room is stored under
+func roomKey(roomID string) string {
+	return fmt.Sprintf("room:%s", roomID)
+}
+
+// roomChannel is the pub/sub channel a room's updates are published on
+func roomChannel(roomID string) string {
+	return fmt.Sprintf("room:%s:updates", roomID)
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateRoom(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	room := &Room{RoomID: "ABC234", HostToken: "secret", State: RoomStateWaiting, CreatedAt: time.Now()}
+	for _, want := range []bool{true, false} {
+		created, err := v.CreateRoom(ctx, room)
+		if err != nil {
+			t.Fatalf("CreateRoom: %v", err)
+		}
+		if created != want {
+			t.Errorf("CreateRoom = %v, want %v", created, want)
+		}
+	}
+	if ttl := server.TTL(roomKey("ABC234")); ttl != roomTTL {
+		t.Errorf("room TTL = %v, want %v", ttl, roomTTL)
+	}
+
+	stored, err := v.GetRoom(ctx, "ABC234")
+	if err != nil {
+		t.Fatalf("GetRoom: %v", err)
+	}
+	if stored.HostToken != "secret" || stored.State != RoomStateWaiting {
+		t.Errorf("stored room = %+v", stored)
+	}
+	if _, err := v.GetRoom(ctx, "ZZZZZZ"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("GetRoom(missing) = %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestUpdateRoom(t *testing.T) {
+	errRejected := errors.New("rejected")
+
+	tests := []struct {
+		name       string
+		roomID     string
+		update     func(room *Room) error
+		wantErr    error
+		wantState  string // Empty when the room should be gone
+		wantRounds int
+	}{
+		{"missing room", "ZZZZZZ", func(*Room) error { return nil }, ErrRoomNotFound, RoomStateWaiting, 0},
+		{"rejected update", "ABC234", func(room *Room) error { room.Round = 9; return errRejected }, errRejected, RoomStateWaiting, 0},
+		{"start voting", "ABC234", func(room *Room) error { room.State = RoomStateVoting; room.Round++; return nil }, nil, RoomStateVoting, 1},
+		{"close", "ABC234", func(room *Room) error { room.State = RoomStateClosed; return nil }, nil, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, server := newTestValkey(t)
+			if _, err := v.CreateRoom(ctx, &Room{RoomID: "ABC234", State: RoomStateWaiting}); err != nil {
+				t.Fatalf("CreateRoom: %v", err)
+			}
+			server.SetTTL(roomKey("ABC234"), time.Minute)
+
+			_, err := v.UpdateRoom(ctx, tt.roomID, tt.update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateRoom error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, err := v.GetRoom(ctx, "ABC234")
+			if tt.wantState == "" {
+				if !errors.Is(err, ErrRoomNotFound) {
+					t.Errorf("closed room still stored: %+v, %v", stored, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetRoom: %v", err)
+			}
+			if stored.State != tt.wantState || stored.Round != tt.wantRounds {
+				t.Errorf("room state %s round %d, want %s round %d", stored.State, stored.Round, tt.wantState, tt.wantRounds)
+			}
+			if tt.wantErr == nil && server.TTL(roomKey("ABC234")) != roomTTL {
+				t.Errorf("save did not refresh the room TTL")
+			}
+		})
+	}
+}
+
+func TestUpdateRoomRetriesConcurrentChange(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+	if _, err := v.CreateRoom(ctx, &Room{RoomID: "ABC234", State: RoomStateVoting, Votes: map[string]string{}}); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	other := &ValkeyClient{client: newTestValkeyClient(t, server)}
+
+	calls := 0
+	room, err := v.UpdateRoom(ctx, "ABC234", func(room *Room) error {
+		calls++
+		if calls == 1 {
+			// Another droplet records a vote between this GET and EXEC
+			if _, err := other.UpdateRoom(ctx, "ABC234", func(room *Room) error {
+				room.Votes["bob"] = "right"
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		room.Votes["alice"] = "left"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateRoom: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("update ran %d times, want 2", calls)
+	}
+	if room.Votes["alice"] != "left" || room.Votes["bob"] != "right" {
+		t.Errorf("votes = %v, want both participants' votes", room.Votes)
+	}
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// runtimeSettingsKey holds the runtime settings shared by every server instance as JSON
+	runtimeSettingsKey = "settings:runtime"
+
+	// settingsAuditKey is a capped list of settings changes, newest first
+	settingsAuditKey = "settings:audit"
+
+	// maxSettingsAudit is how many settings changes are kept
+	maxSettingsAudit = 1000
+
+	// settingsChannel is the pub/sub channel a new settings version is announced on
+	settingsChannel = "settings:updates"
+
+	// generationSlotKey exists while scheduled generation is not yet due again
+	generationSlotKey = "generation:slot"
+)
+
+// RuntimeSettings are flags and tunables operators change without a redeploy
+// Every server instance watches them and applies changes live
+type RuntimeSettings struct {
+	Version int64 `json:"version"`
+
+	// MaintenanceMode turns away every API request except the admin endpoints
+	MaintenanceMode    bool   `json:"maintenance_mode"`
+	MaintenanceMessage string `json:"maintenance_message,omitempty"`
+
+	// Providers overrides per provider; providers without an entry are enabled with weight 1
+	Providers map[string]ProviderSettings `json:"providers"`
+
+	// Scheduled generation (the cron generator) runs at most once per interval while enabled
+	GenerationEnabled         bool  `json:"generation_enabled"`
+	GenerationIntervalSeconds int64 `json:"generation_interval_seconds"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+}
+
+// ProviderSettings controls whether a provider is used and how often it is picked first
+type ProviderSettings struct {
+	Enabled bool       `json:"enabled"`
+	Weight  float64    `json:"weight"`             // Relative chance of being tried first; 0 makes it a last resort
+	ResetAt *time.Time `json:"reset_at,omitempty"` // Every instance clears the provider's error state when this moves forward
+}
+
+// SettingsChange records one settings field changing
+type SettingsChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// SettingsAuditEntry records who changed the runtime settings, when, and what changed
+type SettingsAuditEntry struct {
+	Version int64                     `json:"version"`
+	Actor   string                    `json:"actor"`
+	At      time.Time                 `json:"at"`
+	Changes map[string]SettingsChange `json:"changes"`
+}
+
+// DefaultRuntimeSettings are used until an operator changes anything
+func DefaultRuntimeSettings() *RuntimeSettings {
+	return &RuntimeSettings{
+		Providers:                 map[string]ProviderSettings{},
+		GenerationEnabled:         true,
+		GenerationIntervalSeconds: 300,
+	}
+}
+
+// Provider returns the settings for a provider, defaulting to enabled with weight 1
+func (s *RuntimeSettings) Provider(name string) ProviderSettings {
+	if provider, ok := s.Providers[name]; ok {
+		return provider
+	}
+	return ProviderSettings{Enabled: true, Weight: 1}
+}
+
+// GetRuntimeSettings returns the current runtime settings, or the defaults if none have been saved
+func (v *ValkeyClient) GetRuntimeSettings(ctx context.Context) (*RuntimeSettings, error) {
+	settingsJSON, err := v.client.Get(ctx, runtimeSettingsKey).Result()
+	if err == redis.Nil {
+		return DefaultRuntimeSettings(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runtime settings: %w", err)
+	}
+
+	return decodeRuntimeSettings(settingsJSON)
+}
+
+// UpdateRuntimeSettings applies update to the current settings, bumps the version and records the change in the
+// audit trail in one transaction, then announces the new version to every instance
+// Returns the new settings and the audit entry; the entry is nil when update changed nothing
+func (v *ValkeyClient) UpdateRuntimeSettings(ctx context.Context, actor string, update func(settings *RuntimeSettings) error) (*RuntimeSettings, *SettingsAuditEntry, error) {
+	var updated *RuntimeSettings
+	var entry *SettingsAuditEntry
+
+	txf := func(tx *redis.Tx) error {
+		updated, entry = nil, nil
+
+		current := DefaultRuntimeSettings()
+		settingsJSON, err := tx.Get(ctx, runtimeSettingsKey).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if current, err = decodeRuntimeSettings(settingsJSON); err != nil {
+				return err
+			}
+		}
+
+		next, err := decodeRuntimeSettings(encodeRuntimeSettings(current))
+		if err != nil {
+			return err
+		}
+		if err := update(next); err != nil {
+			return err
+		}
+
+		changes := diffSettings(current, next)
+		if len(changes) == 0 {
+			updated = current
+			return nil
+		}
+
+		next.Version = current.Version + 1
+		next.UpdatedAt = time.Now().UTC()
+		next.UpdatedBy = actor
+		entry = &SettingsAuditEntry{Version: next.Version, Actor: actor, At: next.UpdatedAt, Changes: changes}
+
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, runtimeSettingsKey, encodeRuntimeSettings(next), 0)
+			pipe.LPush(ctx, settingsAuditKey, entryJSON)
+			pipe.LTrim(ctx, settingsAuditKey, 0, maxSettingsAudit-1)
+			return nil
+		})
+		updated = next
+		return err
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		err := v.client.Watch(ctx, txf, runtimeSettingsKey)
+		if err == redis.TxFailedErr {
+			continue // Someone else changed the settings; re-apply on top of theirs
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to update runtime settings: %w", err)
+		}
+
+		if entry != nil {
+			// Instances that miss the announcement pick the change up on their next poll
+			if err := v.client.Publish(ctx, settingsChannel, updated.Version).Err(); err != nil {
+				logger.WarnContext(ctx, "Failed to announce settings version", "version", updated.Version, "error", err)
+			}
+		}
+		return updated, entry, nil
+	}
+
+	return nil, nil, fmt.Errorf("failed to update runtime settings: too many concurrent changes")
+}
+
+// ListSettingsAudit returns up to limit settings changes, newest first
+func (v *ValkeyClient) ListSettingsAudit(ctx context.Context, limit int64) ([]SettingsAuditEntry, error) {
+	entriesJSON, err := v.client.LRange(ctx, settingsAuditKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list settings audit: %w", err)
+	}
+
+	entries := []SettingsAuditEntry{}
+	for _, entryJSON := range entriesJSON {
+		var entry SettingsAuditEntry
+		if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+			continue // Skip malformed entries
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// SubscribeSettings returns a channel that receives the version number of each settings change
+func (v *ValkeyClient) SubscribeSettings(ctx context.Context) (<-chan string, func() error) {
+	return v.subscribe(ctx, settingsChannel)
+}
+
+// ClaimGenerationSlot reports whether scheduled generation is due, claiming the slot for interval if it is
+// Only one caller across all instances gets true per interval
+func (v *ValkeyClient) ClaimGenerationSlot(ctx context.Context, interval time.Duration) (bool, error) {
+	claimed, err := v.client.SetNX(ctx, generationSlotKey, time.Now().UTC().Format(time.RFC3339), interval).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim generation slot: %w", err)
+	}
+	return claimed, nil
+}
+
+// decodeRuntimeSettings decodes stored settings on top of the defaults, so fields added later get their default
+func decodeRuntimeSettings(settingsJSON string) (*RuntimeSettings, error) {
+	settings := DefaultRuntimeSettings()
+	if err := json.Unmarshal([]byte(settingsJSON), settings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal runtime settings: %w", err)
+	}
+	if settings.Providers == nil {
+		settings.Providers = map[string]ProviderSettings{}
+	}
+	return settings, nil
+}
+
+// diffSettings lists the fields that differ between two settings, keyed by their dotted JSON path
+// (e.g. "providers.freepik.weight"); bookkeeping fields are ignored
+func diffSettings(before, after *RuntimeSettings) map[string]SettingsChange {
+	beforeFields := flattenSettings(before)
+	afterFields := flattenSettings(after)
+
+	keys := make(map[string]bool)
+	for key := range beforeFields {
+		keys[key] = true
+	}
+	for key := range afterFields {
+		keys[key] = true
+	}
+
+	changes := make(map[string]SettingsChange)
+	for key := range keys {
+		if key == "version" || key == "updated_at" || key == "updated_by" {
+			continue
+		}
+		if !reflect.DeepEqual(beforeFields[key], afterFields[key]) {
+			changes[key] = SettingsChange{From: beforeFields[key], To: afterFields[key]}
+		}
+	}
+
+	return changes
+}
+
+// flattenSettings turns settings into a map of dotted JSON paths to leaf values
+func flattenSettings(settings *RuntimeSettings) map[string]interface{} {
+	var tree map[string]interface{}
+	json.Unmarshal([]byte(encodeRuntimeSettings(settings)), &tree)
+
+	fields := make(map[string]interface{})
+	var walk func(prefix string, node map[string]interface{})
+	walk = func(prefix string, node map[string]interface{}) {
+		for key, value := range node {
+			if child, ok := value.(map[string]interface{}); ok {
+				walk(prefix+key+".", child)
+				continue
+			}
+			fields[prefix+key] = value
+		}
+	}
+	walk("", tree)
+
+	return fields
+}
+
+// encodeRuntimeSettings encodes settings; they only hold JSON-safe types, so this cannot fail
+func encodeRuntimeSettings(settings *RuntimeSettings) string {
+	settingsJSON, _ := json.Marshal(settings)
+	return string(settingsJSON)
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetRuntimeSettings(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	settings, err := v.GetRuntimeSettings(ctx)
+	if err != nil {
+		t.Fatalf("GetRuntimeSettings: %v", err)
+	}
+	if !reflect.DeepEqual(settings, DefaultRuntimeSettings()) {
+		t.Errorf("settings without a stored value = %+v, want the defaults", settings)
+	}
+
+	// Settings saved before a field existed get that field's default
+	server.Set(runtimeSettingsKey, `{"version":3,"maintenance_mode":true}`)
+	settings, err = v.GetRuntimeSettings(ctx)
+	if err != nil {
+		t.Fatalf("GetRuntimeSettings: %v", err)
+	}
+	if settings.Version != 3 || !settings.MaintenanceMode || !settings.GenerationEnabled ||
+		settings.GenerationIntervalSeconds != 300 || settings.Providers == nil {
+		t.Errorf("older settings = %+v, want the stored fields over the defaults", settings)
+	}
+	if provider := settings.Provider("openai"); !provider.Enabled || provider.Weight != 1 {
+		t.Errorf("Provider(openai) = %+v, want enabled with weight 1", provider)
+	}
+}
+
+func TestUpdateRuntimeSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		update      func(*RuntimeSettings) error
+		wantVersion int64
+		wantChanges map[string]SettingsChange
+		wantErr     bool
+	}{
+		{
+			name:        "no change",
+			update:      func(*RuntimeSettings) error { return nil },
+			wantVersion: 1,
+		},
+		{
+			name: "maintenance",
+			update: func(s *RuntimeSettings) error {
+				s.MaintenanceMode = true
+				s.MaintenanceMessage = "upgrading"
+				return nil
+			},
+			wantVersion: 2,
+			wantChanges: map[string]SettingsChange{
+				"maintenance_mode":    {From: false, To: true},
+				"maintenance_message": {From: nil, To: "upgrading"},
+			},
+		},
+		{
+			name: "provider weight",
+			update: func(s *RuntimeSettings) error {
+				s.Providers["freepik"] = ProviderSettings{Enabled: true, Weight: 3}
+				return nil
+			},
+			wantVersion: 2,
+			wantChanges: map[string]SettingsChange{"providers.freepik.weight": {From: 2.0, To: 3.0}},
+		},
+		{
+			name:        "rejected",
+			update:      func(*RuntimeSettings) error { return errors.New("no") },
+			wantVersion: 1,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, _ := newTestValkey(t)
+
+			// Start from version 1 with one provider override
+			_, _, err := v.UpdateRuntimeSettings(ctx, "setup", func(s *RuntimeSettings) error {
+				s.Providers["freepik"] = ProviderSettings{Enabled: true, Weight: 2}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("UpdateRuntimeSettings(setup): %v", err)
+			}
+
+			updated, entry, err := v.UpdateRuntimeSettings(ctx, "admin", tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateRuntimeSettings error = %v, want error %v", err, tt.wantErr)
+			}
+
+			stored, err := v.GetRuntimeSettings(ctx)
+			if err != nil {
+				t.Fatalf("GetRuntimeSettings: %v", err)
+			}
+			if stored.Version != tt.wantVersion {
+				t.Errorf("stored version = %d, want %d", stored.Version, tt.wantVersion)
+			}
+			if tt.wantErr {
+				return
+			}
+			if updated.Version != tt.wantVersion {
+				t.Errorf("returned version = %d, want %d", updated.Version, tt.wantVersion)
+			}
+
+			audit, err := v.ListSettingsAudit(ctx, 10)
+			if err != nil {
+				t.Fatalf("ListSettingsAudit: %v", err)
+			}
+			if tt.wantChanges == nil {
+				if entry != nil || len(audit) != 1 {
+					t.Errorf("unchanged settings were audited: %+v, %d entries", entry, len(audit))
+				}
+				return
+			}
+
+			if entry == nil || entry.Version != tt.wantVersion || entry.Actor != "admin" || !reflect.DeepEqual(entry.Changes, tt.wantChanges) {
+				t.Errorf("audit entry = %+v, want version %d by admin with %v", entry, tt.wantVersion, tt.wantChanges)
+			}
+			if stored.UpdatedBy != "admin" {
+				t.Errorf("UpdatedBy = %q, want admin", stored.UpdatedBy)
+			}
+			if len(audit) != 2 || audit[0].Version != tt.wantVersion {
+				t.Errorf("audit = %+v, want the new entry first", audit)
+			}
+		})
+	}
+}
+
+func TestUpdateRuntimeSettingsRetriesOnConcurrentChange(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+	other := &ValkeyClient{client: newTestValkeyClient(t, server)}
+
+	attempts := 0
+	updated, _, err := v.UpdateRuntimeSettings(ctx, "first", func(s *RuntimeSettings) error {
+		attempts++
+		if attempts == 1 {
+			// Another instance saves a change between our read and our write
+			if _, _, err := other.UpdateRuntimeSettings(ctx, "second", func(s *RuntimeSettings) error {
+				s.GenerationEnabled = false
+				return nil
+			}); err != nil {
+				t.Fatalf("concurrent UpdateRuntimeSettings: %v", err)
+			}
+		}
+		s.MaintenanceMode = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateRuntimeSettings: %v", err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("update ran %d times, want 2", attempts)
+	}
+	if updated.Version != 2 || !updated.MaintenanceMode || updated.GenerationEnabled {
+		t.Errorf("settings = %+v, want version 2 with both changes", updated)
+	}
+}
+
+func TestSubscribeSettings(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	versions, closeSubscription := v.SubscribeSettings(ctx)
+	defer closeSubscription()
+
+	deadline := time.Now().Add(time.Second)
+	for len(server.PubSubChannels("")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription never reached the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, _, err := v.UpdateRuntimeSettings(ctx, "admin", func(s *RuntimeSettings) error {
+		s.GenerationIntervalSeconds = 600
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateRuntimeSettings: %v", err)
+	}
+
+	select {
+	case version := <-versions:
+		if version != "1" {
+			t.Errorf("announced version %q, want 1", version)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("settings change was never announced")
+	}
+}
+
+func TestClaimGenerationSlot(t *testing.T) {
+	ctx := context.Background()
+	v, server := newTestValkey(t)
+
+	for i, want := range []bool{true, false} {
+		claimed, err := v.ClaimGenerationSlot(ctx, 5*time.Minute)
+		if err != nil {
+			t.Fatalf("ClaimGenerationSlot: %v", err)
+		}
+		if claimed != want {
+			t.Errorf("claim %d = %v, want %v", i, claimed, want)
+		}
+	}
+
+	server.FastForward(5 * time.Minute)
+	if claimed, err := v.ClaimGenerationSlot(ctx, 5*time.Minute); err != nil || !claimed {
+		t.Errorf("claim after the interval = %v, %v, want true", claimed, err)
+	}
+}
@@ -0,0 +1,482 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/tracing"
+)
+
+const (
+	// multipartThreshold is the object size above which uploads are split into parts
+	multipartThreshold = 16 << 20
+
+	// multipartPartSize is the size of each part (S3 requires at least 5 MiB for all but the last)
+	multipartPartSize = 8 << 20
+
+	// maxUploadAttempts is how many times a request is tried before giving up on 5xx or network errors
+	maxUploadAttempts = 4
+
+	// baseRetryDelay is the first backoff delay; it doubles on each retry
+	baseRetryDelay = 250 * time.Millisecond
+)
+
+// SpacesClient uploads and manages objects in DigitalOcean Spaces using AWS Signature Version 4
+// A single client (and its HTTP connection pool) is shared by every upload
+type SpacesClient struct {
+	bucket     string
+	endpoint   string // e.g. nyc3.digitaloceanspaces.com
+	region     string // e.g. nyc3
+	signer     signer
+	httpClient *http.Client
+}
+
+// NewSpacesClient creates a new Spaces client
+// cfg.Timeout caps each request; cancelling the caller's context stops an upload sooner
+func NewSpacesClient(cfg config.SpacesConfig) (*SpacesClient, error) {
+	if cfg.Endpoint == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("missing DO Spaces configuration (DO_SPACES_ENDPOINT, DO_SPACES_ACCESS_KEY, DO_SPACES_SECRET_KEY)")
+	}
+
+	region := strings.Split(cfg.Endpoint, ".")[0]
+	return &SpacesClient{
+		bucket:   cfg.Bucket,
+		endpoint: cfg.Endpoint,
+		region:   region,
+		signer: signer{
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			region:    region,
+			service:   "s3",
+		},
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(http.DefaultTransport),
+		},
+	}, nil
+}
+
+// Bucket returns the bucket name
+func (s *SpacesClient) Bucket() string {
+	return s.bucket
+}
+
+// CDNURL returns the public CDN URL for an object key
+func (s *SpacesClient) CDNURL(key string) string {
+	return fmt.Sprintf("https://%s.%s.cdn.digitaloceanspaces.com/%s", s.bucket, s.region, key)
+}
+
+// Opener returns a fresh reader over an object's content and its size (-1 if unknown)
+// Streaming uploads call it once per attempt, so retries re-read the source from the start
+type Opener func() (io.ReadCloser, int64, error)
+
+// unsignedPayload is the SigV4 payload hash used when the body is streamed and cannot be hashed up front
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// PutObject uploads a public-read object, switching to a multipart upload above the size threshold
+// metadata keys are sent as x-amz-meta-<key> headers; values that are not plain printable ASCII
+// are RFC 2047 encoded so they survive transport and signing unchanged (see DecodeMetadata)
+func (s *SpacesClient) PutObject(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string) error {
+	headers := objectHeaders(contentType, metadata)
+
+	if len(data) > multipartThreshold {
+		return s.putMultipart(ctx, key, bytes.NewReader(data), headers)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, headers, data)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// PutObjectStream uploads a public-read object straight from its source without buffering it in memory
+// Objects of known size up to the multipart threshold are sent as a single unsigned-payload PUT;
+// larger or unknown-size streams are uploaded in parts, holding at most one part in memory
+func (s *SpacesClient) PutObjectStream(ctx context.Context, key string, open Opener, contentType string, metadata map[string]string) error {
+	headers := objectHeaders(contentType, metadata)
+
+	first, size, err := open()
+	if err != nil {
+		return fmt.Errorf("failed to open source for %s: %w", key, err)
+	}
+
+	if size < 0 || size > multipartThreshold {
+		defer first.Close()
+		return s.putMultipart(ctx, key, first, headers)
+	}
+
+	// The first attempt reuses the reader opened above to learn the size; retries reopen the source
+	opened := first
+	body := payload{
+		hash: unsignedPayload,
+		open: func() (io.ReadCloser, int64, error) {
+			if opened != nil {
+				reader := opened
+				opened = nil
+				return reader, size, nil
+			}
+			return open()
+		},
+	}
+
+	resp, err := s.doPayload(ctx, http.MethodPut, key, nil, headers, body)
+	if opened != nil {
+		opened.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// objectHeaders builds the headers for a public-read object upload
+func objectHeaders(contentType string, metadata map[string]string) map[string]string {
+	headers := map[string]string{
+		"Content-Type": contentType,
+		"x-amz-acl":    "public-read",
+	}
+	for name, value := range metadata {
+		headers["x-amz-meta-"+strings.ToLower(name)] = EncodeMetadata(value)
+	}
+	return headers
+}
+
+// PutPrivateObject uploads an object that is only readable with the bucket credentials
+// Used for data that must not be served through the CDN, such as archived votes
+func (s *SpacesClient) PutPrivateObject(ctx context.Context, key string, data []byte, contentType string) error {
+	headers := map[string]string{
+		"Content-Type": contentType,
+		"x-amz-acl":    "private",
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, headers, data)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// GetObject downloads an object; the caller must close the returned body
+func (s *SpacesClient) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+// DeleteObject removes an object (deleting a missing object is not an error)
+func (s *SpacesClient) DeleteObject(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// ObjectInfo describes an object returned by ListObjects
+type ObjectInfo struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// listBucketResult is the response to ListObjectsV2
+type listBucketResult struct {
+	Contents              []ObjectInfo `xml:"Contents"`
+	IsTruncated           bool         `xml:"IsTruncated"`
+	NextContinuationToken string       `xml:"NextContinuationToken"`
+}
+
+// ListObjects returns every object under prefix, following continuation tokens
+func (s *SpacesClient) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	token := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse listing of %s: %w", prefix, err)
+		}
+
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Ping checks that the bucket is reachable with the configured credentials
+func (s *SpacesClient) Ping(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach bucket %s: %w", s.bucket, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// HeadObject returns an object's user metadata (x-amz-meta-* headers, prefix stripped and values decoded)
+func (s *SpacesClient) HeadObject(ctx context.Context, key string) (map[string]string, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to head %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	metadata := make(map[string]string)
+	for name, values := range resp.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
+			metadata[strings.TrimPrefix(lower, "x-amz-meta-")] = DecodeMetadata(values[0])
+		}
+	}
+
+	return metadata, nil
+}
+
+// EncodeMetadata encodes a metadata value as an RFC 2047 encoded-word when it contains
+// non-ASCII or control characters (newlines in prompts, for example)
+func EncodeMetadata(value string) string {
+	return mime.BEncoding.Encode("UTF-8", value)
+}
+
+// DecodeMetadata reverses EncodeMetadata; plain values are returned unchanged
+func DecodeMetadata(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// initiateMultipartUploadResult is the response to CreateMultipartUpload
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// completedPart identifies an uploaded part in CompleteMultipartUpload
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// completeMultipartUpload is the request body for CompleteMultipartUpload
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// putMultipart uploads a stream in parts, aborting the upload if any part fails
+// Only one part is held in memory at a time, and each part is retried independently
+func (s *SpacesClient) putMultipart(ctx context.Context, key string, source io.Reader, headers map[string]string) error {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, headers, nil)
+	if err != nil {
+		return fmt.Errorf("failed to initiate multipart upload of %s: %w", key, err)
+	}
+
+	var initiated initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("failed to parse multipart upload ID for %s: %v", key, err)
+	}
+
+	uploadID := initiated.UploadID
+	parts := make([]completedPart, 0)
+	buf := make([]byte, multipartPartSize)
+
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(source, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			s.abortMultipart(key, uploadID)
+			return fmt.Errorf("failed to read part %d of %s: %w", partNumber, key, readErr)
+		}
+
+		// An empty stream still needs one (empty) part; otherwise stop at the end of the stream
+		if n == 0 && partNumber > 1 {
+			break
+		}
+
+		query := url.Values{
+			"partNumber": {fmt.Sprintf("%d", partNumber)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, buf[:n])
+		if err != nil {
+			s.abortMultipart(key, uploadID)
+			return fmt.Errorf("failed to upload part %d of %s: %w", partNumber, key, err)
+		}
+		resp.Body.Close()
+
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		if readErr != nil {
+			break // Short read: that was the last part
+		}
+	}
+
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return fmt.Errorf("failed to marshal multipart completion: %w", err)
+	}
+
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, map[string]string{"Content-Type": "application/xml"}, body)
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// abortMultipart releases the parts of a failed multipart upload
+// It uses a fresh context because the caller's context may be the reason the upload failed
+func (s *SpacesClient) abortMultipart(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to abort multipart upload", "upload_id", uploadID, "key", key, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// payload describes a request body; open is called once per attempt so retries can resend it
+type payload struct {
+	open func() (io.ReadCloser, int64, error)
+	hash string // hex SHA-256 of the body, or unsignedPayload
+}
+
+// do sends a signed request with an in-memory body (see doPayload)
+func (s *SpacesClient) do(ctx context.Context, method, key string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	return s.doPayload(ctx, method, key, query, headers, payload{
+		hash: sha256Hex(body),
+		open: func() (io.ReadCloser, int64, error) {
+			return io.NopCloser(bytes.NewReader(body)), int64(len(body)), nil
+		},
+	})
+}
+
+// doPayload sends a signed request, retrying with exponential backoff on network errors and 5xx responses
+// Any other non-2xx response is returned as an error immediately; on success the caller owns resp.Body
+func (s *SpacesClient) doPayload(ctx context.Context, method, key string, query url.Values, headers map[string]string, body payload) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < maxUploadAttempts; attempt++ {
+		if attempt > 0 {
+			delay := baseRetryDelay << (attempt - 1)
+			delay += time.Duration(rand.Int63n(int64(delay / 2))) // jitter
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		reader, length, err := body.open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open request body: %w", err)
+		}
+
+		req, err := s.newSignedRequest(ctx, method, key, query, headers, reader, length, body.hash)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+
+		// The transport closes the request body
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		lastErr = fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(respBody))
+
+		if resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+
+	return nil, fmt.Errorf("gave up after %d attempts: %w", maxUploadAttempts, lastErr)
+}
+
+// newSignedRequest builds a request for the bucket's virtual-hosted endpoint and signs it with SigV4
+func (s *SpacesClient) newSignedRequest(ctx context.Context, method, key string, query url.Values, headers map[string]string, body io.ReadCloser, length int64, payloadHash string) (*http.Request, error) {
+	host := fmt.Sprintf("%s.%s", s.bucket, s.endpoint)
+	canonicalURI := "/" + encodePath(key)
+	canonicalQuery := encodeQuery(query)
+
+	rawURL := fmt.Sprintf("https://%s%s", host, canonicalURI)
+	if canonicalQuery != "" {
+		rawURL += "?" + canonicalQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = length
+	if length == 0 {
+		body.Close()
+		req.Body = http.NoBody
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	s.signer.sign(req, canonicalURI, canonicalQuery, payloadHash, time.Now())
+
+	return req, nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSpaces is an in-memory S3 endpoint supporting single and multipart uploads
+// failures maps "<method> <query key>" to the number of 500 responses to send before succeeding
+type fakeSpaces struct {
+	mutex      sync.Mutex
+	objects    map[string][]byte
+	parts      map[string]map[int][]byte // Upload ID to part number to data
+	aborted    []string
+	requests   []string
+	failures   map[string]int
+	rejectPart bool
+}
+
+func newFakeSpaces() *fakeSpaces {
+	return &fakeSpaces{
+		objects:  make(map[string][]byte),
+		parts:    make(map[string]map[int][]byte),
+		failures: make(map[string]int),
+	}
+}
+
+func (f *fakeSpaces) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	operation := r.Method
+	for _, name := range []string{"uploads", "partNumber", "uploadId"} {
+		if query.Has(name) {
+			operation += " " + name
+			break
+		}
+	}
+	f.requests = append(f.requests, operation)
+	if f.failures[operation] > 0 {
+		f.failures[operation]--
+		http.Error(w, "try again", http.StatusInternalServerError)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := fmt.Sprintf("upload-%d", len(f.parts)+1)
+		f.parts[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		if f.rejectPart {
+			http.Error(w, "bad part", http.StatusBadRequest)
+			return
+		}
+		var number int
+		fmt.Sscanf(query.Get("partNumber"), "%d", &number)
+		f.parts[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var completion completeMultipartUpload
+		if err := xml.Unmarshal(body, &completion); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := f.parts[query.Get("uploadId")]
+		var object []byte
+		for i, part := range completion.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				http.Error(w, "parts out of order", http.StatusBadRequest)
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.parts, query.Get("uploadId"))
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.aborted = append(f.aborted, query.Get("uploadId"))
+		delete(f.parts, query.Get("uploadId"))
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+
+	case r.Method == http.MethodHead:
+		if _, ok := f.objects[key]; key != "" && !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		w.Write(object)
+
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+// newTestSpaces returns a client whose requests all go to an in-memory S3 endpoint
+func newTestSpaces(t *testing.T, fake *fakeSpaces) *SpacesClient {
+	t.Helper()
+
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	return &SpacesClient{
+		bucket:     "bucket",
+		endpoint:   "nyc3.digitaloceanspaces.com",
+		region:     "nyc3",
+		signer:     signer{accessKey: "key", secretKey: "secret", region: "nyc3", service: "s3"},
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+// streamOf returns an Opener over data that reports an unknown size, forcing a multipart upload
+func streamOf(data []byte) Opener {
+	return func() (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader(data)), -1, nil
+	}
+}
+
+func TestPutObjectStream(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), (2*multipartPartSize+1024)/16)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		size         int64 // -1 for a stream of unknown size
+		failures     map[string]int
+		wantRequests []string
+	}{
+		{
+			name:         "small object in one request",
+			data:         []byte("png bytes"),
+			size:         9,
+			wantRequests: []string{"PUT"},
+		},
+		{
+			name:         "retried after a server error",
+			data:         []byte("png bytes"),
+			size:         9,
+			failures:     map[string]int{"PUT": 1},
+			wantRequests: []string{"PUT", "PUT"},
+		},
+		{
+			name:         "empty stream as one empty part",
+			data:         []byte{},
+			size:         -1,
+			wantRequests: []string{"POST uploads", "PUT partNumber", "POST uploadId"},
+		},
+		{
+			name: "stream in parts",
+			data: large,
+			size: -1,
+			wantRequests: []string{
+				"POST uploads", "PUT partNumber", "PUT partNumber", "PUT partNumber", "POST uploadId",
+			},
+		},
+		{
+			name:     "part retried on its own",
+			data:     large,
+			size:     -1,
+			failures: map[string]int{"PUT partNumber": 1},
+			wantRequests: []string{
+				"POST uploads", "PUT partNumber", "PUT partNumber", "PUT partNumber", "PUT partNumber", "POST uploadId",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeSpaces()
+			for operation, count := range tt.failures {
+				fake.failures[operation] = count
+			}
+			spaces := newTestSpaces(t, fake)
+
+			open := func() (io.ReadCloser, int64, error) {
+				return io.NopCloser(bytes.NewReader(tt.data)), tt.size, nil
+			}
+			if err := spaces.PutObjectStream(context.Background(), "images/p/left.png", open, "image/png", nil); err != nil {
+				t.Fatalf("PutObjectStream: %v", err)
+			}
+
+			if !bytes.Equal(fake.objects["images/p/left.png"], tt.data) {
+				t.Errorf("stored %d bytes, want %d", len(fake.objects["images/p/left.png"]), len(tt.data))
+			}
+			if strings.Join(fake.requests, ", ") != strings.Join(tt.wantRequests, ", ") {
+				t.Errorf("requests = %v, want %v", fake.requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadAbortsOnFailure(t *testing.T) {
+	fake := newFakeSpaces()
+	fake.rejectPart = true
+	spaces := newTestSpaces(t, fake)
+
+	err := spaces.PutObjectStream(context.Background(), "images/p/left.png", streamOf([]byte("data")), "image/png", nil)
+	if err == nil {
+		t.Fatal("PutObjectStream succeeded, want an error")
+	}
+	if len(fake.aborted) != 1 || len(fake.parts) != 0 {
+		t.Errorf("aborted uploads = %v, pending uploads = %d; want the upload aborted", fake.aborted, len(fake.parts))
+	}
+	if _, ok := fake.objects["images/p/left.png"]; ok {
+		t.Error("object was stored despite the failed part")
+	}
+}
+
+func TestRequestGivesUpAfterRepeatedServerErrors(t *testing.T) {
+	fake := newFakeSpaces()
+	fake.failures["PUT"] = maxUploadAttempts
+	spaces := newTestSpaces(t, fake)
+
+	err := spaces.PutObject(context.Background(), "key", []byte("data"), "image/png", nil)
+	if err == nil || !strings.Contains(err.Error(), "gave up") {
+		t.Fatalf("PutObject error = %v, want it to give up", err)
+	}
+	if len(fake.requests) != maxUploadAttempts {
+		t.Errorf("sent %d requests, want %d", len(fake.requests), maxUploadAttempts)
+	}
+}
+
+func TestSpacesPing(t *testing.T) {
+	tests := []struct {
+		name      string
+		accessKey string
+		failures  int
+		wantErr   bool
+	}{
+		{"reachable", "key", 0, false},
+		{"recovers from a server error", "key", 1, false},
+		{"rejected credentials", "other", 0, true},
+		{"unavailable", "key", maxUploadAttempts, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeSpaces()
+			fake.failures["HEAD"] = tt.failures
+			spaces := newTestSpaces(t, fake)
+			spaces.signer.accessKey = tt.accessKey
+
+			if err := spaces.Ping(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Ping = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataEncoding(t *testing.T) {
+	for _, value := range []string{"plain prompt", "line one\nline two", "ünïcode 🚀", ""} {
+		encoded := EncodeMetadata(value)
+		for _, c := range encoded {
+			if c < ' ' || c > '~' {
+				t.Errorf("EncodeMetadata(%q) = %q, which is not printable ASCII", value, encoded)
+				break
+			}
+		}
+		if decoded := DecodeMetadata(encoded); decoded != value {
+			t.Errorf("DecodeMetadata(EncodeMetadata(%q)) = %q", value, decoded)
+		}
+	}
+
+	headers := objectHeaders("image/png", map[string]string{"Prompt": "a cat", "Pair-ID": "p"})
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := "Content-Type x-amz-acl x-amz-meta-pair-id x-amz-meta-prompt"; strings.Join(names, " ") != want {
+		t.Errorf("headers = %v, want %s", names, want)
+	}
+}
@@ -4,22 +4,33 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
-	"os"
+	"sync"
 	"time"
 
+	"cgc-lb-and-cdn-backend/internal/config"
+	"cgc-lb-and-cdn-backend/internal/logging"
+	"cgc-lb-and-cdn-backend/internal/metrics"
+
 	"github.com/redis/go-redis/v9"
 )
 
+// logger is shared by the Valkey and Spaces clients and their background jobs
+var logger = logging.Logger("storage")
+
 // ValkeyClient wraps the Redis client for vote persistence
 type ValkeyClient struct {
-	client *redis.Client
+	client      *redis.Client
+	voteArchive *SpacesClient  // Cold tier of the vote log; nil keeps every vote in Valkey
+	background  sync.WaitGroup // Retention sweeps and vote compactions that Close waits for
 }
 
 // Vote represents a user vote
 // Simplified structure: pair-id is the atomic unit, both images share the same provider
 type Vote struct {
+	ID        string    `json:"-"` // The vote log entry ID, set when a vote is read back from the log
 	PairID    string    `json:"pair_id"`
 	Winner    string    `json:"winner"`   // "left" or "right"
 	Provider  string    `json:"provider"` // The provider that generated this pair
@@ -38,29 +49,38 @@ type ImagePair struct {
 	LeftURL   string    `json:"left_url"`  // CDN URL for left image
 	RightURL  string    `json:"right_url"` // CDN URL for right image
 	Timestamp time.Time `json:"timestamp"`
-}
 
-// NewValkeyClient creates a new Valkey client
-func NewValkeyClient() (*ValkeyClient, error) {
-	host := os.Getenv("DO_VALKEY_HOST")
-	port := os.Getenv("DO_VALKEY_PORT")
-	password := os.Getenv("DO_VALKEY_PASSWORD")
+	// Resized variants stored next to each image, keyed by variant name ("256", "512", "1024", "placeholder")
+	LeftVariants  map[string]string `json:"left_variants,omitempty"`
+	RightVariants map[string]string `json:"right_variants,omitempty"`
+
+	// Hex-encoded perceptual hashes used for duplicate detection
+	LeftHash  string `json:"left_hash,omitempty"`
+	RightHash string `json:"right_hash,omitempty"`
 
-	if host == "" || port == "" {
+	// Set when the pair is retired from rotation and moved to the archive
+	RetiredAt     *time.Time `json:"retired_at,omitempty"`
+	RetiredReason string     `json:"retired_reason,omitempty"`
+}
+
+// NewValkeyClient creates a new Valkey client and checks the connection
+func NewValkeyClient(cfg config.ValkeyConfig) (*ValkeyClient, error) {
+	if !cfg.Configured() {
 		return nil, fmt.Errorf("valkey configuration missing (DO_VALKEY_HOST or DO_VALKEY_PORT)")
 	}
 
-	addr := fmt.Sprintf("%s:%s", host, port)
-
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
+		Addr:     cfg.Addr(),
+		Password: cfg.Password,
 		DB:       0,
 		TLSConfig: &tls.Config{
 			MinVersion: tls.VersionTLS12,
 		},
 	})
 
+	client.AddHook(metricsHook{})
+	client.AddHook(tracingHook{})
+
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -72,48 +92,74 @@ func NewValkeyClient() (*ValkeyClient, error) {
 	return &ValkeyClient{client: client}, nil
 }
 
+// voteTTL is how long the per-pair vote:<pair-id> key is kept
+const voteTTL = 30 * 24 * time.Hour
+
 // RecordVote stores a vote in Valkey
 func (v *ValkeyClient) RecordVote(ctx context.Context, vote *Vote) error {
 	vote.Timestamp = time.Now()
+	if _, err := v.storeVote(ctx, vote, voteTTL, ""); err != nil {
+		return err
+	}
+	metrics.Votes.WithLabelValues(vote.Winner, vote.Provider).Inc()
+	return nil
+}
 
-	// Store vote with unique key
-	voteKey := fmt.Sprintf("vote:%s", vote.PairID)
+// recordVoteScript writes a vote and every counter derived from it in one atomic step, so a failure can never
+// leave a vote in the log without its side, pair and win counts (or the reverse)
+// The log entry uses the vote's timestamp as its ID when possible; XADD rejects IDs older than the newest
+// entry (clock skew, or importing into a live cluster), in which case XADD * picks an ID after the newest one.
+// Compaction archives votes by their own timestamp, so such an entry still lands in the right day
+// An optional seventh key names a set of import IDs; the vote is skipped (returning 0) when its ID is already there
+// KEYS: vote:<pair-id>, votes:log, votes:total, side:wins, pair:votes, wins:<winner>[, votes:imported]
+// ARGV: vote JSON, vote key TTL in milliseconds (0 skips the key), stream ID, winner, pair ID[, import ID]
+var recordVoteScript = redis.NewScript(`
+if KEYS[7] and redis.call('SADD', KEYS[7], ARGV[6]) == 0 then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+end
+local added = redis.pcall('XADD', KEYS[2], ARGV[3], 'vote', ARGV[1])
+if type(added) == 'table' and added.err then
+	redis.call('XADD', KEYS[2], '*', 'vote', ARGV[1])
+end
+redis.call('INCR', KEYS[3])
+redis.call('HINCRBY', KEYS[4], ARGV[4], 1)
+redis.call('HINCRBY', KEYS[5], ARGV[5], 1)
+redis.call('ZINCRBY', KEYS[6], 1, ARGV[5])
+return 1
+`)
+
+// storeVote writes a vote and updates every counter derived from it
+// ttl is the remaining lifetime of the vote:<pair-id> key; the key is skipped when it has already expired
+// A non-empty importID deduplicates the vote against votes:imported; returns whether the vote was written
+func (v *ValkeyClient) storeVote(ctx context.Context, vote *Vote, ttl time.Duration, importID string) (bool, error) {
 	voteJSON, err := json.Marshal(vote)
 	if err != nil {
-		return fmt.Errorf("failed to marshal vote: %w", err)
-	}
-
-	// Store vote with 30-day expiration
-	if err := v.client.Set(ctx, voteKey, voteJSON, 30*24*time.Hour).Err(); err != nil {
-		return fmt.Errorf("failed to store vote: %w", err)
+		return false, fmt.Errorf("failed to marshal vote: %w", err)
 	}
 
-	// Add to votes list for analytics
-	if err := v.client.LPush(ctx, "votes:all", voteJSON).Err(); err != nil {
-		return fmt.Errorf("failed to add to votes list: %w", err)
+	ttlMillis := ttl.Milliseconds()
+	if ttlMillis < 0 {
+		ttlMillis = 0
 	}
 
-	// Trim votes list to last 10,000 votes
-	if err := v.client.LTrim(ctx, "votes:all", 0, 9999).Err(); err != nil {
-		return fmt.Errorf("failed to trim votes list: %w", err)
+	keys := []string{
+		fmt.Sprintf("vote:%s", vote.PairID), voteLogKey, voteTotalKey, "side:wins", pairVotesKey, pairWinsKey(vote.Winner),
 	}
-
-	// Track side preference (which side users tend to choose overall)
-	// This is useful for detecting position bias
-	if err := v.client.HIncrBy(ctx, "side:wins", vote.Winner, 1).Err(); err != nil {
-		return fmt.Errorf("failed to increment side wins: %w", err)
+	args := []interface{}{voteJSON, ttlMillis, voteLogID(vote), vote.Winner, vote.PairID}
+	if importID != "" {
+		keys = append(keys, importedVotesKey)
+		args = append(args, importID)
 	}
 
-	return nil
-}
-
-// GetTotalVotes returns the total number of votes recorded
-func (v *ValkeyClient) GetTotalVotes(ctx context.Context) (int64, error) {
-	count, err := v.client.LLen(ctx, "votes:all").Result()
+	written, err := recordVoteScript.Run(ctx, v.client, keys, args...).Int()
 	if err != nil {
-		return 0, fmt.Errorf("failed to get total votes: %w", err)
+		return false, fmt.Errorf("failed to record vote: %w", err)
 	}
-	return count, nil
+
+	return written == 1, nil
 }
 
 // GetSideWins returns the vote counts for left and right sides
@@ -137,58 +183,62 @@ func (v *ValkeyClient) GetSideWins(ctx context.Context) (map[string]int64, error
 	return result, nil
 }
 
-// GetRecentVotes retrieves the most recent votes
-func (v *ValkeyClient) GetRecentVotes(ctx context.Context, limit int64) ([]*Vote, error) {
-	voteStrings, err := v.client.LRange(ctx, "votes:all", 0, limit-1).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get recent votes: %w", err)
+// Ping checks that Valkey answers
+func (v *ValkeyClient) Ping(ctx context.Context) error {
+	if err := v.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping Valkey: %w", err)
 	}
-
-	votes := make([]*Vote, 0, len(voteStrings))
-	for _, voteStr := range voteStrings {
-		var vote Vote
-		if err := json.Unmarshal([]byte(voteStr), &vote); err != nil {
-			continue // Skip malformed votes
-		}
-		votes = append(votes, &vote)
-	}
-
-	return votes, nil
+	return nil
 }
 
-// Close closes the Valkey client connection
+// Close waits for a running retention sweep or vote compaction to finish, then closes the connection
+// Cancel the contexts passed to StartRetentionSweeper and StartVoteCompactor first
 func (v *ValkeyClient) Close() error {
+	v.background.Wait()
 	return v.client.Close()
 }
 
 // StoreImagePair stores an image pair in Valkey
+// The pair, its rotation entry and its hash index entries are written in one MULTI/EXEC transaction
 func (v *ValkeyClient) StoreImagePair(ctx context.Context, pair *ImagePair) error {
-	// Store pair with unique key
 	pairKey := fmt.Sprintf("pair:%s", pair.PairID)
 	pairJSON, err := json.Marshal(pair)
 	if err != nil {
 		return fmt.Errorf("failed to marshal pair: %w", err)
 	}
 
-	// Store pair (no expiration - we want to keep all pairs)
-	if err := v.client.Set(ctx, pairKey, pairJSON, 0).Err(); err != nil {
-		return fmt.Errorf("failed to store pair: %w", err)
-	}
+	_, err = v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// Store pair (no expiration - we want to keep all pairs)
+		pipe.Set(ctx, pairKey, pairJSON, 0)
+
+		// Add pair ID to the list of all pairs for random selection
+		pipe.LPush(ctx, "pairs:all", pair.PairID)
 
-	// Add pair ID to the list of all pairs for random selection
-	if err := v.client.LPush(ctx, "pairs:all", pair.PairID).Err(); err != nil {
-		return fmt.Errorf("failed to add to pairs list: %w", err)
+		// Index perceptual hashes so repeated outputs across pairs can be found later
+		indexImageHashes(ctx, pipe, pair)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store pair: %w", err)
 	}
 
 	return nil
 }
 
+// errPairNotFound is wrapped by the error returned for a pair ID with no pair:<id> key
+var errPairNotFound = errors.New("pair not found")
+
 // GetImagePairByID retrieves a specific image pair by its ID
 func (v *ValkeyClient) GetImagePairByID(ctx context.Context, pairID string) (*ImagePair, error) {
+	return getImagePair(ctx, v.client, pairID)
+}
+
+// getImagePair reads a pair through client, which may be a WATCH transaction
+func getImagePair(ctx context.Context, client redis.Cmdable, pairID string) (*ImagePair, error) {
 	pairKey := fmt.Sprintf("pair:%s", pairID)
-	pairJSON, err := v.client.Get(ctx, pairKey).Result()
+	pairJSON, err := client.Get(ctx, pairKey).Result()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("pair not found: %s", pairID)
+		return nil, fmt.Errorf("%w: %s", errPairNotFound, pairID)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pair: %w", err)
@@ -262,22 +312,25 @@ type WinningImagePair struct {
 
 // MarkImageAsViewed records that a session has viewed a specific image pair
 // This helps prevent showing the same images to the same user in a short period
+// The time it was served is kept too, so fraud detection can tell how long the session looked before voting
 func (v *ValkeyClient) MarkImageAsViewed(ctx context.Context, sessionID string, pairID string) error {
-	// Store viewed pair ID in a set for this session
-	// Key format: session:<session_id>:viewed
-	// Set expires after 24 hours (session lifetime)
+	// Store viewed pair ID in a set for this session, and when it was served in a hash
+	// Key format: session:<session_id>:viewed, session:<session_id>:served
+	// Both expire after 24 hours (session lifetime)
 	sessionKey := fmt.Sprintf("session:%s:viewed", sessionID)
-
-	// Add pair ID to the session's viewed set
-	if err := v.client.SAdd(ctx, sessionKey, pairID).Err(); err != nil {
+	servedKey := sessionServedKey(sessionID)
+
+	_, err := v.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, sessionKey, pairID)
+		pipe.Expire(ctx, sessionKey, 24*time.Hour)
+		pipe.HSet(ctx, servedKey, pairID, time.Now().UnixMilli())
+		pipe.Expire(ctx, servedKey, 24*time.Hour)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to mark image as viewed: %w", err)
 	}
 
-	// Set expiration on the session key (24 hours)
-	if err := v.client.Expire(ctx, sessionKey, 24*time.Hour).Err(); err != nil {
-		return fmt.Errorf("failed to set session expiration: %w", err)
-	}
-
 	return nil
 }
 
@@ -305,7 +358,7 @@ func (v *ValkeyClient) GetRandomImagePairForSession(ctx context.Context, session
 	viewedPairIDs, err := v.GetViewedPairIDs(ctx, sessionID)
 	if err != nil {
 		// Log error but continue with just the provided exclusions
-		fmt.Printf("[WARN] Failed to get viewed pairs for session %s: %v\n", sessionID, err)
+		logger.WarnContext(ctx, "Failed to get viewed pairs", "session_id", sessionID, "error", err)
 		viewedPairIDs = []string{}
 	}
 
@@ -333,7 +386,7 @@ func (v *ValkeyClient) GetRandomImagePairForSession(ctx context.Context, session
 	// Mark this pair as viewed for this session
 	if err := v.MarkImageAsViewed(ctx, sessionID, pair.PairID); err != nil {
 		// Log error but don't fail the request
-		fmt.Printf("[WARN] Failed to mark pair %s as viewed for session %s: %v\n", pair.PairID, sessionID, err)
+		logger.WarnContext(ctx, "Failed to mark pair as viewed", "pair_id", pair.PairID, "session_id", sessionID, "error", err)
 	}
 
 	return pair, nil
@@ -348,37 +401,30 @@ func (v *ValkeyClient) GetWinningImages(ctx context.Context, side string) ([]Win
 		return nil, fmt.Errorf("invalid side parameter: must be 'left' or 'right'")
 	}
 
-	// Get all votes
-	voteStrings, err := v.client.LRange(ctx, "votes:all", 0, -1).Result()
+	// The win counters are already ordered by vote count
+	wins, err := v.client.ZRevRangeWithScores(ctx, pairWinsKey(side), 0, -1).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get votes: %w", err)
+		return nil, fmt.Errorf("failed to get win counts: %w", err)
+	}
+	if len(wins) == 0 {
+		return nil, nil
 	}
 
-	// Track winning images by pair ID with vote counts
-	winningPairVotes := make(map[string]int64)
-	for _, voteStr := range voteStrings {
-		var vote Vote
-		if err := json.Unmarshal([]byte(voteStr), &vote); err != nil {
-			continue // Skip malformed votes
-		}
-
-		// Only include winners for the specified side
-		if vote.Winner == side {
-			winningPairVotes[vote.PairID]++
-		}
+	pairKeys := make([]string, len(wins))
+	for i, win := range wins {
+		pairKeys[i] = fmt.Sprintf("pair:%s", win.Member)
+	}
+	pairValues, err := v.client.MGet(ctx, pairKeys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get winning pairs: %w", err)
 	}
 
-	// Retrieve the winning pairs with vote counts
 	var winningPairs []WinningImagePair
-	for pairID, voteCount := range winningPairVotes {
-		pairKey := fmt.Sprintf("pair:%s", pairID)
-		pairJSON, err := v.client.Get(ctx, pairKey).Result()
-		if err == redis.Nil {
+	for i, value := range pairValues {
+		pairJSON, ok := value.(string)
+		if !ok {
 			continue // Pair no longer exists
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to get pair %s: %w", pairID, err)
-		}
 
 		var pair ImagePair
 		if err := json.Unmarshal([]byte(pairJSON), &pair); err != nil {
@@ -387,18 +433,9 @@ func (v *ValkeyClient) GetWinningImages(ctx context.Context, side string) ([]Win
 
 		winningPairs = append(winningPairs, WinningImagePair{
 			ImagePair: pair,
-			VoteCount: voteCount,
+			VoteCount: int64(wins[i].Score),
 		})
 	}
 
-	// Sort by vote count descending (most votes first)
-	for i := 0; i < len(winningPairs)-1; i++ {
-		for j := i + 1; j < len(winningPairs); j++ {
-			if winningPairs[j].VoteCount > winningPairs[i].VoteCount {
-				winningPairs[i], winningPairs[j] = winningPairs[j], winningPairs[i]
-			}
-		}
-	}
-
 	return winningPairs, nil
 }
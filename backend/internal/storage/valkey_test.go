@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestValkey returns a client backed by an in-memory server, which runs the Lua scripts too
+// Both are closed when the test ends
+func newTestValkey(t *testing.T) (*ValkeyClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	return &ValkeyClient{client: newTestValkeyClient(t, server)}, server
+}
+
+// newTestValkeyClient opens another connection to a test server, as a second droplet would
+func newTestValkeyClient(t *testing.T, server *miniredis.Miniredis) *redis.Client {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRecordVoteScript(t *testing.T) {
+	tests := []struct {
+		name        string
+		age         time.Duration
+		importID    string
+		imported    []string // Import IDs already in votes:imported
+		wantWritten bool
+		wantVoteKey bool
+	}{
+		{"live vote", 0, "", nil, true, true},
+		{"vote key already expired", voteTTL + time.Hour, "", nil, true, false},
+		{"first import", time.Hour, "pair-1/1-0", nil, true, true},
+		{"repeated import", time.Hour, "pair-1/1-0", []string{"pair-1/1-0"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, server := newTestValkey(t)
+			for _, id := range tt.imported {
+				server.SAdd(importedVotesKey, id)
+			}
+
+			vote := &Vote{PairID: "pair-1", Winner: "right", Provider: "openai", Timestamp: time.Now().Add(-tt.age)}
+			written, err := v.storeVote(ctx, vote, voteTTL-tt.age, tt.importID)
+			if err != nil {
+				t.Fatalf("storeVote: %v", err)
+			}
+			if written != tt.wantWritten {
+				t.Errorf("written = %v, want %v", written, tt.wantWritten)
+			}
+
+			want := int64(0)
+			if tt.wantWritten {
+				want = 1
+			}
+			total, _ := v.GetTotalVotes(ctx)
+			sideWins, _ := v.GetSideWins(ctx)
+			pairVotes, _ := v.GetPairVoteCount(ctx, "pair-1")
+			logged, _ := v.client.XLen(ctx, voteLogKey).Result()
+			wins, _ := v.client.ZScore(ctx, pairWinsKey("right"), "pair-1").Result()
+			for name, got := range map[string]int64{
+				"votes:total": total, "side:wins right": sideWins["right"], "pair:votes": pairVotes,
+				"votes:log": logged, "wins:right": int64(wins),
+			} {
+				if got != want {
+					t.Errorf("%s = %d, want %d", name, got, want)
+				}
+			}
+			if sideWins["left"] != 0 {
+				t.Errorf("side:wins left = %d, want 0", sideWins["left"])
+			}
+
+			if got := server.Exists("vote:pair-1"); got != tt.wantVoteKey {
+				t.Errorf("vote:pair-1 exists = %v, want %v", got, tt.wantVoteKey)
+			}
+			if tt.wantVoteKey {
+				if ttl := server.TTL("vote:pair-1"); ttl <= 0 || ttl > voteTTL {
+					t.Errorf("vote:pair-1 TTL = %v, want within %v", ttl, voteTTL)
+				}
+			}
+			if tt.wantWritten && tt.age > 0 {
+				entries, _ := v.client.XRange(ctx, voteLogKey, "-", "+").Result()
+				if ms, _ := parseStreamID(entries[0].ID); ms != vote.Timestamp.UnixMilli() {
+					t.Errorf("stream ID %s does not carry the vote timestamp %d", entries[0].ID, vote.Timestamp.UnixMilli())
+				}
+			}
+		})
+	}
+}
+
+func TestStoreImagePair(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	pair := &ImagePair{PairID: "pair-1", Prompt: "a fox", Provider: "openai", LeftURL: "l", RightURL: "r", LeftHash: "00ff", RightHash: "ff00"}
+	if err := v.StoreImagePair(ctx, pair); err != nil {
+		t.Fatalf("StoreImagePair: %v", err)
+	}
+
+	stored, err := v.GetImagePairByID(ctx, "pair-1")
+	if err != nil {
+		t.Fatalf("GetImagePairByID: %v", err)
+	}
+	if stored.Prompt != pair.Prompt || stored.LeftURL != pair.LeftURL {
+		t.Errorf("stored pair = %+v, want %+v", stored, pair)
+	}
+	if rotation, _ := v.client.LRange(ctx, "pairs:all", 0, -1).Result(); len(rotation) != 1 || rotation[0] != "pair-1" {
+		t.Errorf("pairs:all = %v, want [pair-1]", rotation)
+	}
+	if hashes, _ := v.client.HGetAll(ctx, hashIndexKey).Result(); hashes["pair-1:left"] != "00ff" || hashes["pair-1:right"] != "ff00" {
+		t.Errorf("hash index = %v", hashes)
+	}
+
+	if _, err := v.GetImagePairByID(ctx, "missing"); !errors.Is(err, errPairNotFound) {
+		t.Errorf("GetImagePairByID(missing) = %v, want errPairNotFound", err)
+	}
+}
+
+func TestValkeyPing(t *testing.T) {
+	v, server := newTestValkey(t)
+	ctx := context.Background()
+
+	if err := v.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	server.Close()
+	if err := v.Ping(ctx); err == nil {
+		t.Error("Ping succeeded with the server down")
+	}
+}
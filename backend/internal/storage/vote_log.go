@@ -0,0 +1,570 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The vote log has two tiers. Recent votes live in the votes:log stream, whose entry IDs are the vote
+// timestamps in milliseconds (or the next free ID when a vote arrives out of order). Whole UTC days older than
+// the hot window are compacted into gzipped JSONL objects in Spaces (votes/<yyyy>/<mm>/<dd>.jsonl.gz), filed by
+// each vote's own timestamp, and trimmed from the stream; votes:archive lists those objects. Readers walk the
+// archive first and then the stream, so every vote is seen exactly once.
+const (
+	// voteLogKey is the stream of recent votes; each entry holds the vote JSON in its "vote" field
+	voteLogKey = "votes:log"
+
+	// voteTotalKey counts every vote ever recorded, including archived ones
+	voteTotalKey = "votes:total"
+
+	// voteArchiveKey is a sorted set of archived day objects in Spaces scored by the day's start (unix seconds)
+	voteArchiveKey = "votes:archive"
+
+	// voteCompactionLockKey stops several instances from compacting the same day at once
+	voteCompactionLockKey = "votes:compaction:lock"
+
+	// importedVotesKey is the set of import IDs of votes replayed from exported datasets
+	importedVotesKey = "votes:imported"
+
+	// legacyVoteListKey is the capped list that held votes before the stream existed
+	legacyVoteListKey = "votes:all"
+
+	// legacyVoteMigratingKey holds the legacy list while it is moved into the stream
+	legacyVoteMigratingKey = legacyVoteListKey + ":migrating"
+
+	// pairWinsSeededKey is set once the per-pair win counters have been built from the vote log
+	pairWinsSeededKey = "wins:seeded"
+
+	// voteReadBatch is how many stream entries are fetched per XRANGE call
+	voteReadBatch = 1000
+
+	// voteArchiveDay is the width of an archive bucket
+	voteArchiveDay = 24 * time.Hour
+)
+
+// VoteLogPolicy controls when votes move from the stream to Spaces
+type VoteLogPolicy struct {
+	HotWindow       time.Duration // Votes newer than this always stay in Valkey
+	CompactInterval time.Duration // How often the background compactor runs (0 disables it)
+}
+
+// archivedVote is one line of an archived day; the stream ID keeps tiers from overlapping
+type archivedVote struct {
+	StreamID string `json:"stream_id"`
+	Vote
+}
+
+// SetVoteArchive enables the Spaces tier of the vote log
+// Without it votes are never compacted and stay in the stream indefinitely
+func (v *ValkeyClient) SetVoteArchive(spaces *SpacesClient) {
+	v.voteArchive = spaces
+}
+
+// voteLogID is the stream ID requested for a vote: its timestamp in milliseconds with an automatic sequence
+func voteLogID(vote *Vote) string {
+	return fmt.Sprintf("%d-*", vote.Timestamp.UnixMilli())
+}
+
+// GetTotalVotes returns the exact number of votes ever recorded
+func (v *ValkeyClient) GetTotalVotes(ctx context.Context) (int64, error) {
+	count, err := v.client.Get(ctx, voteTotalKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total votes: %w", err)
+	}
+	return count, nil
+}
+
+// ScanVotes calls fn for every vote in both tiers, oldest first
+func (v *ValkeyClient) ScanVotes(ctx context.Context, fn func(*Vote) error) error {
+	archives, err := v.client.ZRange(ctx, voteArchiveKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list vote archives: %w", err)
+	}
+
+	// A compaction that uploaded a day but has not trimmed the stream yet leaves the same entries in both tiers
+	// Votes added out of order are archived under an earlier day than their ID, so the newest ID of any archive counts
+	start := "-"
+	newest := ""
+	for _, key := range archives {
+		votes, err := v.readVoteArchive(ctx, key)
+		if err != nil {
+			return err
+		}
+		for i := range votes {
+			votes[i].Vote.ID = votes[i].StreamID
+			if err := fn(&votes[i].Vote); err != nil {
+				return err
+			}
+			if newest == "" || compareStreamIDs(votes[i].StreamID, newest) > 0 {
+				newest = votes[i].StreamID
+				start = "(" + newest
+			}
+		}
+	}
+
+	return v.scanVoteLog(ctx, start, "+", func(_ string, vote *Vote) error {
+		return fn(vote)
+	})
+}
+
+// GetRecentVotes retrieves the most recent votes, newest first
+func (v *ValkeyClient) GetRecentVotes(ctx context.Context, limit int64) ([]*Vote, error) {
+	entries, err := v.client.XRevRangeN(ctx, voteLogKey, "+", "-", limit).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent votes: %w", err)
+	}
+
+	votes := make([]*Vote, 0, limit)
+	oldest := ""
+	for _, entry := range entries {
+		if vote, ok := decodeVoteEntry(entry); ok {
+			votes = append(votes, vote)
+		}
+		oldest = entry.ID
+	}
+	if int64(len(votes)) >= limit {
+		return votes, nil
+	}
+
+	// Not enough recent votes: continue into the archive, newest day first
+	archives, err := v.client.ZRevRange(ctx, voteArchiveKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vote archives: %w", err)
+	}
+	for _, key := range archives {
+		archived, err := v.readVoteArchive(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(archived) - 1; i >= 0; i-- {
+			if oldest != "" && compareStreamIDs(archived[i].StreamID, oldest) >= 0 {
+				continue // Still in the stream, already returned
+			}
+			votes = append(votes, &archived[i].Vote)
+			if int64(len(votes)) >= limit {
+				return votes, nil
+			}
+		}
+	}
+
+	return votes, nil
+}
+
+// CompactVotes moves every whole UTC day older than the hot window from the stream into Spaces
+// Returns the number of votes archived
+func (v *ValkeyClient) CompactVotes(ctx context.Context, policy VoteLogPolicy) (int, error) {
+	if v.voteArchive == nil {
+		return 0, nil
+	}
+
+	locked, err := v.client.SetNX(ctx, voteCompactionLockKey, "1", 10*time.Minute).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire compaction lock: %w", err)
+	}
+	if !locked {
+		return 0, nil // Another instance is compacting
+	}
+	defer v.client.Del(context.Background(), voteCompactionLockKey)
+
+	// Days that start before this boundary and end before the hot window are eligible
+	boundary := time.Now().Add(-policy.HotWindow).UTC().Truncate(voteArchiveDay)
+
+	archived := 0
+	for {
+		oldest, err := v.client.XRangeN(ctx, voteLogKey, "-", "+", 1).Result()
+		if err != nil {
+			return archived, fmt.Errorf("failed to read vote log: %w", err)
+		}
+		if len(oldest) == 0 {
+			return archived, nil
+		}
+
+		ms, _ := parseStreamID(oldest[0].ID)
+		dayStart := time.UnixMilli(ms).UTC().Truncate(voteArchiveDay)
+		dayEnd := dayStart.Add(voteArchiveDay)
+		if dayEnd.After(boundary) {
+			return archived, nil
+		}
+
+		count, err := v.compactVoteDay(ctx, dayStart, dayEnd)
+		if err != nil {
+			return archived, err
+		}
+		archived += count
+	}
+}
+
+// compactVoteDay archives the stream entries whose IDs fall in one day, then trims them from the stream
+// Each vote is filed under the day of its own timestamp, which is earlier than its ID's day when it was added out
+// of order (clock skew or an import); days that were already archived are merged with
+func (v *ValkeyClient) compactVoteDay(ctx context.Context, dayStart, dayEnd time.Time) (int, error) {
+	days := make(map[time.Time][]archivedVote)
+	count := 0
+	end := strconv.FormatInt(dayEnd.UnixMilli()-1, 10)
+	err := v.scanVoteLog(ctx, strconv.FormatInt(dayStart.UnixMilli(), 10), end, func(id string, vote *Vote) error {
+		day := dayStart
+		if !vote.Timestamp.IsZero() && vote.Timestamp.Before(dayStart) {
+			day = vote.Timestamp.UTC().Truncate(voteArchiveDay)
+		}
+		days[day] = append(days[day], archivedVote{StreamID: id, Vote: *vote})
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for day, votes := range days {
+		if err := v.archiveVoteDay(ctx, day, votes); err != nil {
+			return 0, err
+		}
+	}
+
+	// Only trim once every day is safely in Spaces; XTRIM MINID keeps every entry at or after dayEnd
+	if err := v.client.XTrimMinID(ctx, voteLogKey, strconv.FormatInt(dayEnd.UnixMilli(), 10)).Err(); err != nil {
+		return 0, fmt.Errorf("failed to trim vote log: %w", err)
+	}
+
+	logger.InfoContext(ctx, "Archived votes", "votes", count, "day", dayStart.Format("2006-01-02"), "archives", len(days))
+	return count, nil
+}
+
+// archiveVoteDay writes the votes of one day to Spaces, merging with the object already archived for that day
+func (v *ValkeyClient) archiveVoteDay(ctx context.Context, day time.Time, votes []archivedVote) error {
+	key := fmt.Sprintf("votes/%s.jsonl.gz", day.Format("2006/01/02"))
+
+	if err := v.client.ZScore(ctx, voteArchiveKey, key).Err(); err == nil {
+		existing, err := v.readVoteArchive(ctx, key)
+		if err != nil {
+			return err
+		}
+		votes = mergeArchivedVotes(existing, votes)
+	} else if err != redis.Nil {
+		return fmt.Errorf("failed to check vote archive: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for i := range votes {
+		if err := encoder.Encode(&votes[i]); err != nil {
+			return fmt.Errorf("failed to encode archived vote: %w", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress vote archive: %w", err)
+	}
+
+	if err := v.voteArchive.PutPrivateObject(ctx, key, buf.Bytes(), "application/gzip"); err != nil {
+		return err
+	}
+	if err := v.client.ZAdd(ctx, voteArchiveKey, redis.Z{Score: float64(day.Unix()), Member: key}).Err(); err != nil {
+		return fmt.Errorf("failed to record vote archive: %w", err)
+	}
+
+	return nil
+}
+
+// StartVoteCompactor runs CompactVotes every policy.CompactInterval until ctx is cancelled
+// Cancelling ctx lets a compaction in progress finish its upload rather than abandoning it half-archived
+func (v *ValkeyClient) StartVoteCompactor(ctx context.Context, policy VoteLogPolicy) {
+	if v.voteArchive == nil || policy.CompactInterval <= 0 {
+		return
+	}
+
+	v.background.Add(1)
+	go func() {
+		defer v.background.Done()
+		ticker := time.NewTicker(policy.CompactInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := v.CompactVotes(context.WithoutCancel(ctx), policy); err != nil {
+					logger.ErrorContext(ctx, "Vote log compaction failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// migrateLegacyVoteScript moves the oldest vote of the claimed legacy list into the stream in one step, so a crash
+// or a second instance draining the same list can never append a vote twice
+// It does nothing (returning 0) when the list's oldest entry is no longer the one the caller read
+// KEYS: votes:all:migrating, votes:log
+// ARGV: vote JSON as read from the list, stream ID ("" drops a malformed vote without logging it)
+var migrateLegacyVoteScript = redis.NewScript(`
+if redis.call('LINDEX', KEYS[1], -1) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] ~= '' then
+	local added = redis.pcall('XADD', KEYS[2], ARGV[2], 'vote', ARGV[1])
+	if type(added) == 'table' and added.err then
+		redis.call('XADD', KEYS[2], '*', 'vote', ARGV[1])
+	end
+end
+redis.call('RPOP', KEYS[1])
+return 1
+`)
+
+// MigrateLegacyVotes moves the votes held in the old capped votes:all list into the stream
+// and seeds votes:total from side:wins, which has counted every vote since the beginning
+// Safe to call from every instance at startup: the list is claimed by renaming it, and a claimed list left
+// behind by a crashed migration is finished first
+func (v *ValkeyClient) MigrateLegacyVotes(ctx context.Context) (int, error) {
+	migrated := 0
+	for {
+		count, err := v.drainLegacyVotes(ctx)
+		migrated += count
+		if err != nil {
+			return migrated, err
+		}
+
+		// RENAMENX never overwrites a list another instance claimed in the meantime; that one is drained next round
+		if err := v.client.RenameNX(ctx, legacyVoteListKey, legacyVoteMigratingKey).Err(); err != nil {
+			if strings.Contains(err.Error(), "no such key") {
+				break // Nothing (left) to migrate
+			}
+			return migrated, fmt.Errorf("failed to claim legacy votes: %w", err)
+		}
+	}
+	if migrated == 0 {
+		return 0, nil
+	}
+
+	sideWins, err := v.GetSideWins(ctx)
+	if err != nil {
+		return migrated, err
+	}
+	if err := v.client.Set(ctx, voteTotalKey, sideWins["left"]+sideWins["right"], 0).Err(); err != nil {
+		return migrated, fmt.Errorf("failed to seed total votes: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// drainLegacyVotes moves the claimed legacy list into the stream oldest first, one vote at a time
+// The list is newest first, so its last element is the oldest vote
+func (v *ValkeyClient) drainLegacyVotes(ctx context.Context) (int, error) {
+	migrated := 0
+	for {
+		voteJSON, err := v.client.LIndex(ctx, legacyVoteMigratingKey, -1).Result()
+		if err == redis.Nil {
+			return migrated, nil
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read legacy votes: %w", err)
+		}
+
+		streamID := ""
+		var vote Vote
+		if err := json.Unmarshal([]byte(voteJSON), &vote); err == nil {
+			streamID = voteLogID(&vote)
+		}
+
+		keys := []string{legacyVoteMigratingKey, voteLogKey}
+		moved, err := migrateLegacyVoteScript.Run(ctx, v.client, keys, voteJSON, streamID).Int()
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate legacy vote: %w", err)
+		}
+		if moved == 1 && streamID != "" {
+			migrated++
+		}
+	}
+}
+
+// SeedPairWins builds the per-pair win counters from the vote log on clusters that predate them
+// Safe to call from every instance at startup: only the first one to claim the seed scans the log. Votes
+// recorded while it scans can be missed; the consistency check corrects that drift
+// Returns whether this call built the counters
+func (v *ValkeyClient) SeedPairWins(ctx context.Context) (bool, error) {
+	claimed, err := v.client.SetNX(ctx, pairWinsSeededKey, time.Now().Unix(), 0).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim win counter seed: %w", err)
+	}
+	if !claimed {
+		return false, nil
+	}
+
+	if err := v.seedPairWins(ctx); err != nil {
+		// Let the next start try again
+		if releaseErr := v.client.Del(context.WithoutCancel(ctx), pairWinsSeededKey).Err(); releaseErr != nil {
+			logger.ErrorContext(ctx, "Failed to release win counter seed", "error", releaseErr)
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// seedPairWins counts every stored pair's wins in the vote log and writes the counters
+func (v *ValkeyClient) seedPairWins(ctx context.Context) error {
+	pairs := make(map[string]bool)
+	err := v.ScanPairs(ctx, func(pair *ImagePair) error {
+		pairs[pair.PairID] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wins := map[string]map[string]int64{"left": {}, "right": {}}
+	err = v.ScanVotes(ctx, func(vote *Vote) error {
+		if counts, ok := wins[vote.Winner]; ok && pairs[vote.PairID] {
+			counts[vote.PairID]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = v.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for side, counts := range wins {
+			for pairID, count := range counts {
+				pipe.ZAdd(ctx, pairWinsKey(side), redis.Z{Score: float64(count), Member: pairID})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write win counters: %w", err)
+	}
+
+	return nil
+}
+
+// scanVoteLog calls fn for every stream entry between start and end (XRANGE syntax), in batches
+func (v *ValkeyClient) scanVoteLog(ctx context.Context, start, end string, fn func(id string, vote *Vote) error) error {
+	for {
+		entries, err := v.client.XRangeN(ctx, voteLogKey, start, end, voteReadBatch).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read vote log: %w", err)
+		}
+
+		for _, entry := range entries {
+			if vote, ok := decodeVoteEntry(entry); ok {
+				if err := fn(entry.ID, vote); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(entries) < voteReadBatch {
+			return nil
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// readVoteArchive downloads and decodes one archived day
+func (v *ValkeyClient) readVoteArchive(ctx context.Context, key string) ([]archivedVote, error) {
+	if v.voteArchive == nil {
+		return nil, fmt.Errorf("vote archive %s exists but Spaces is not configured", key)
+	}
+
+	body, err := v.voteArchive.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", key, err)
+	}
+
+	var votes []archivedVote
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var vote archivedVote
+		if err := json.Unmarshal(scanner.Bytes(), &vote); err != nil {
+			continue // Skip malformed votes
+		}
+		votes = append(votes, vote)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	return votes, nil
+}
+
+// decodeVoteEntry extracts the vote from a stream entry
+func decodeVoteEntry(entry redis.XMessage) (*Vote, bool) {
+	voteJSON, ok := entry.Values["vote"].(string)
+	if !ok {
+		return nil, false
+	}
+
+	var vote Vote
+	if err := json.Unmarshal([]byte(voteJSON), &vote); err != nil {
+		return nil, false // Skip malformed votes
+	}
+	vote.ID = entry.ID
+	return &vote, true
+}
+
+// mergeArchivedVotes combines two archives of the same day, dropping entries present in both
+func mergeArchivedVotes(existing, added []archivedVote) []archivedVote {
+	seen := make(map[string]bool, len(existing))
+	for _, vote := range existing {
+		seen[vote.StreamID] = true
+	}
+
+	merged := existing
+	for _, vote := range added {
+		if !seen[vote.StreamID] {
+			merged = append(merged, vote)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return compareStreamIDs(merged[i].StreamID, merged[j].StreamID) < 0
+	})
+	return merged
+}
+
+// parseStreamID splits a stream entry ID ("<ms>-<seq>") into its parts
+func parseStreamID(id string) (int64, int64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseInt(msPart, 10, 64)
+	seq, _ := strconv.ParseInt(seqPart, 10, 64)
+	return ms, seq
+}
+
+// compareStreamIDs orders stream entry IDs numerically
+func compareStreamIDs(a, b string) int {
+	aMs, aSeq := parseStreamID(a)
+	bMs, bSeq := parseStreamID(b)
+
+	switch {
+	case aMs != bMs:
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	case aSeq < bSeq:
+		return -1
+	case aSeq > bSeq:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// daysAgo returns noon UTC the given number of days before today
+func daysAgo(days int) time.Time {
+	return time.Now().UTC().Truncate(voteArchiveDay).Add(-time.Duration(days)*voteArchiveDay + 12*time.Hour)
+}
+
+// storeTestVote records a vote with the given timestamp as the vote script would
+func storeTestVote(t *testing.T, v *ValkeyClient, pairID, winner string, timestamp time.Time) {
+	t.Helper()
+	vote := &Vote{PairID: pairID, Winner: winner, Provider: "openai", Timestamp: timestamp}
+	if _, err := v.storeVote(context.Background(), vote, voteTTL-time.Since(timestamp), ""); err != nil {
+		t.Fatalf("storeVote: %v", err)
+	}
+}
+
+// scannedVotes returns the pair and winner of every vote ScanVotes reports, in order
+func scannedVotes(t *testing.T, v *ValkeyClient) []string {
+	t.Helper()
+	var votes []string
+	err := v.ScanVotes(context.Background(), func(vote *Vote) error {
+		votes = append(votes, vote.PairID+":"+vote.Winner)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanVotes: %v", err)
+	}
+	return votes
+}
+
+// archivedDays returns the archive keys listed in votes:archive
+func archivedDays(t *testing.T, v *ValkeyClient) []string {
+	t.Helper()
+	keys, err := v.client.ZRange(context.Background(), voteArchiveKey, 0, -1).Result()
+	if err != nil {
+		t.Fatalf("ZRange: %v", err)
+	}
+	return keys
+}
+
+func archiveKey(timestamp time.Time) string {
+	return fmt.Sprintf("votes/%s.jsonl.gz", timestamp.Format("2006/01/02"))
+}
+
+func TestCompactVotes(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+	fake := newFakeSpaces()
+	v.SetVoteArchive(newTestSpaces(t, fake))
+
+	storeTestVote(t, v, "pair-1", "left", daysAgo(5))
+	storeTestVote(t, v, "pair-1", "right", daysAgo(3))
+	// Added on day 2 with a clock that was a day behind: the ID is day 2, the vote belongs to day 3
+	late, _ := json.Marshal(Vote{PairID: "pair-2", Winner: "left", Timestamp: daysAgo(3).Add(time.Hour)})
+	if err := v.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: voteLogKey,
+		ID:     strconv.FormatInt(daysAgo(2).UnixMilli(), 10) + "-0",
+		Values: map[string]interface{}{"vote": late},
+	}).Err(); err != nil {
+		t.Fatalf("XAdd: %v", err)
+	}
+	storeTestVote(t, v, "pair-3", "left", time.Now())
+
+	archived, err := v.CompactVotes(ctx, VoteLogPolicy{HotWindow: time.Hour})
+	if err != nil {
+		t.Fatalf("CompactVotes: %v", err)
+	}
+	if archived != 3 {
+		t.Errorf("archived %d votes, want 3", archived)
+	}
+
+	wantDays := []string{archiveKey(daysAgo(5)), archiveKey(daysAgo(3))}
+	if got := archivedDays(t, v); !reflect.DeepEqual(got, wantDays) {
+		t.Errorf("archives = %v, want %v", got, wantDays)
+	}
+	if length, _ := v.client.XLen(ctx, voteLogKey).Result(); length != 1 {
+		t.Errorf("stream holds %d votes after compaction, want only today's", length)
+	}
+
+	want := []string{"pair-1:left", "pair-1:right", "pair-2:left", "pair-3:left"}
+	if got := scannedVotes(t, v); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanVotes = %v, want %v", got, want)
+	}
+
+	recent, err := v.GetRecentVotes(ctx, 10)
+	if err != nil {
+		t.Fatalf("GetRecentVotes: %v", err)
+	}
+	if len(recent) != 4 || recent[0].PairID != "pair-3" {
+		t.Errorf("GetRecentVotes returned %d votes starting with %+v, want 4 starting with today's", len(recent), recent[0])
+	}
+
+	// Compacting again is a no-op
+	if archived, err := v.CompactVotes(ctx, VoteLogPolicy{HotWindow: time.Hour}); err != nil || archived != 0 {
+		t.Errorf("second CompactVotes = %d, %v; want 0, nil", archived, err)
+	}
+}
+
+func TestCompactVotesMergesArchivedDay(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+	v.SetVoteArchive(newTestSpaces(t, newFakeSpaces()))
+
+	storeTestVote(t, v, "pair-1", "left", daysAgo(4))
+	if _, err := v.CompactVotes(ctx, VoteLogPolicy{HotWindow: time.Hour}); err != nil {
+		t.Fatalf("CompactVotes: %v", err)
+	}
+
+	// A late import into the day that is already archived
+	storeTestVote(t, v, "pair-2", "right", daysAgo(4).Add(time.Minute))
+	if _, err := v.CompactVotes(ctx, VoteLogPolicy{HotWindow: time.Hour}); err != nil {
+		t.Fatalf("CompactVotes: %v", err)
+	}
+
+	if got := archivedDays(t, v); len(got) != 1 {
+		t.Errorf("archives = %v, want a single day", got)
+	}
+	want := []string{"pair-1:left", "pair-2:right"}
+	if got := scannedVotes(t, v); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanVotes = %v, want %v", got, want)
+	}
+}
+
+func TestScanVotesSkipsUntrimmedEntries(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+	v.SetVoteArchive(newTestSpaces(t, newFakeSpaces()))
+
+	storeTestVote(t, v, "pair-1", "left", daysAgo(3))
+	storeTestVote(t, v, "pair-2", "right", daysAgo(2))
+
+	// A compaction that uploaded the days but stopped before trimming the stream
+	days := make(map[time.Time][]archivedVote)
+	err := v.scanVoteLog(ctx, "-", "+", func(id string, vote *Vote) error {
+		day := vote.Timestamp.UTC().Truncate(voteArchiveDay)
+		days[day] = append(days[day], archivedVote{StreamID: id, Vote: *vote})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scanVoteLog: %v", err)
+	}
+	for day, votes := range days {
+		if err := v.archiveVoteDay(ctx, day, votes); err != nil {
+			t.Fatalf("archiveVoteDay: %v", err)
+		}
+	}
+
+	want := []string{"pair-1:left", "pair-2:right"}
+	if got := scannedVotes(t, v); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanVotes = %v, want %v", got, want)
+	}
+}
+
+func TestRecordVoteOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	storeTestVote(t, v, "pair-1", "left", daysAgo(1))
+	storeTestVote(t, v, "pair-2", "left", daysAgo(3))
+
+	entries, err := v.client.XRange(ctx, voteLogKey, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("stream holds %d entries, want 2", len(entries))
+	}
+	if compareStreamIDs(entries[1].ID, entries[0].ID) <= 0 {
+		t.Errorf("out-of-order vote got ID %s, not after %s", entries[1].ID, entries[0].ID)
+	}
+}
+
+func TestGetWinningImages(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	for _, pairID := range []string{"pair-1", "pair-2", "pair-3"} {
+		storeTestPair(t, v, &ImagePair{PairID: pairID, Provider: "openai", LeftURL: "l", RightURL: "r", Timestamp: time.Now()})
+	}
+	votes := []struct{ pairID, winner string }{
+		{"pair-1", "left"}, {"pair-2", "left"}, {"pair-2", "left"}, {"pair-2", "right"},
+		{"pair-3", "left"}, {"pair-3", "left"}, {"pair-3", "left"}, {"gone", "left"},
+	}
+	for _, vote := range votes {
+		if err := v.RecordVote(ctx, &Vote{PairID: vote.pairID, Winner: vote.winner, Provider: "openai"}); err != nil {
+			t.Fatalf("RecordVote: %v", err)
+		}
+	}
+	if err := v.DeletePair(ctx, &ImagePair{PairID: "pair-1"}); err != nil {
+		t.Fatalf("DeletePair: %v", err)
+	}
+
+	tests := []struct {
+		side string
+		want []string
+	}{
+		{"left", []string{"pair-3:3", "pair-2:2"}},
+		{"right", []string{"pair-2:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.side, func(t *testing.T) {
+			winners, err := v.GetWinningImages(ctx, tt.side)
+			if err != nil {
+				t.Fatalf("GetWinningImages: %v", err)
+			}
+			var got []string
+			for _, winner := range winners {
+				got = append(got, fmt.Sprintf("%s:%d", winner.PairID, winner.VoteCount))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("winners = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := v.GetWinningImages(ctx, "middle"); err == nil {
+		t.Error("GetWinningImages accepted an invalid side")
+	}
+}
+
+func TestSeedPairWins(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	storeTestPair(t, v, &ImagePair{PairID: "pair-1", Provider: "openai", LeftURL: "l", RightURL: "r"})
+	storeTestVote(t, v, "pair-1", "left", daysAgo(2))
+	storeTestVote(t, v, "pair-1", "left", daysAgo(1))
+	storeTestVote(t, v, "deleted", "right", daysAgo(1))
+	// A cluster from before the counters existed
+	if err := v.client.Del(ctx, pairWinsKey("left"), pairWinsKey("right")).Err(); err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+
+	seeded, err := v.SeedPairWins(ctx)
+	if err != nil || !seeded {
+		t.Fatalf("SeedPairWins = %v, %v; want true, nil", seeded, err)
+	}
+	if wins, _ := v.client.ZScore(ctx, pairWinsKey("left"), "pair-1").Result(); wins != 2 {
+		t.Errorf("pair-1 has %v left wins, want 2", wins)
+	}
+	if exists, _ := v.client.Exists(ctx, pairWinsKey("right")).Result(); exists != 0 {
+		t.Error("seeded win counters for a pair that no longer exists")
+	}
+
+	// Only the first call builds the counters
+	storeTestVote(t, v, "pair-1", "left", time.Now())
+	if seeded, err := v.SeedPairWins(ctx); err != nil || seeded {
+		t.Errorf("second SeedPairWins = %v, %v; want false, nil", seeded, err)
+	}
+	if wins, _ := v.client.ZScore(ctx, pairWinsKey("left"), "pair-1").Result(); wins != 3 {
+		t.Errorf("pair-1 has %v left wins, want 3", wins)
+	}
+}
+
+func TestSeedPairWinsReleasesClaimOnError(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	// An archive without Spaces configured cannot be read
+	if err := v.client.ZAdd(ctx, voteArchiveKey, redis.Z{Score: 0, Member: "votes/2026/01/01.jsonl.gz"}).Err(); err != nil {
+		t.Fatalf("ZAdd: %v", err)
+	}
+	if _, err := v.SeedPairWins(ctx); err == nil {
+		t.Fatal("SeedPairWins succeeded without reading the archive")
+	}
+	if exists, _ := v.client.Exists(ctx, pairWinsSeededKey).Result(); exists != 0 {
+		t.Error("a failed seed kept its claim")
+	}
+}
+
+// pushLegacyVotes fills a legacy list newest first, as LPUSH did
+func pushLegacyVotes(t *testing.T, v *ValkeyClient, key string, votes ...Vote) {
+	t.Helper()
+	for _, vote := range votes {
+		voteJSON, _ := json.Marshal(vote)
+		if err := v.client.LPush(context.Background(), key, voteJSON).Err(); err != nil {
+			t.Fatalf("LPush: %v", err)
+		}
+	}
+}
+
+func TestMigrateLegacyVotes(t *testing.T) {
+	first := Vote{PairID: "pair-1", Winner: "left", Timestamp: daysAgo(3)}
+	second := Vote{PairID: "pair-2", Winner: "right", Timestamp: daysAgo(2)}
+	third := Vote{PairID: "pair-3", Winner: "left", Timestamp: daysAgo(1)}
+
+	tests := []struct {
+		name      string
+		legacy    []Vote // Still in votes:all
+		migrating []Vote // Left in votes:all:migrating by a crashed migration
+		logged    []Vote // Already moved into the stream by that migration
+		want      []string
+	}{
+		{"nothing to migrate", nil, nil, nil, nil},
+		{"fresh", []Vote{first, second, third}, nil, nil, []string{"pair-1:left", "pair-2:right", "pair-3:left"}},
+		{"resume after a crash", nil, []Vote{second, third}, []Vote{first}, []string{"pair-1:left", "pair-2:right", "pair-3:left"}},
+		{"resume and claim a new list", []Vote{third}, []Vote{first, second}, nil, []string{"pair-1:left", "pair-2:right", "pair-3:left"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v, _ := newTestValkey(t)
+
+			pushLegacyVotes(t, v, legacyVoteListKey, tt.legacy...)
+			pushLegacyVotes(t, v, legacyVoteMigratingKey, tt.migrating...)
+			for _, vote := range tt.logged {
+				voteJSON, _ := json.Marshal(vote)
+				if err := v.client.XAdd(ctx, &redis.XAddArgs{Stream: voteLogKey, ID: voteLogID(&vote), Values: map[string]interface{}{"vote": voteJSON}}).Err(); err != nil {
+					t.Fatalf("XAdd: %v", err)
+				}
+			}
+			if err := v.client.HSet(ctx, "side:wins", "left", 2, "right", 1).Err(); err != nil {
+				t.Fatalf("HSet: %v", err)
+			}
+
+			migrated, err := v.MigrateLegacyVotes(ctx)
+			if err != nil {
+				t.Fatalf("MigrateLegacyVotes: %v", err)
+			}
+			if want := len(tt.legacy) + len(tt.migrating); migrated != want {
+				t.Errorf("migrated %d votes, want %d", migrated, want)
+			}
+
+			if got := scannedVotes(t, v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vote log = %v, want %v", got, tt.want)
+			}
+			for _, key := range []string{legacyVoteListKey, legacyVoteMigratingKey} {
+				if exists, _ := v.client.Exists(ctx, key).Result(); exists != 0 {
+					t.Errorf("%s still exists", key)
+				}
+			}
+
+			total, _ := v.GetTotalVotes(ctx)
+			if wantTotal := int64(len(tt.want)); migrated > 0 && total != wantTotal {
+				t.Errorf("votes:total = %d, want %d", total, wantTotal)
+			}
+
+			// Running it again moves nothing
+			if migrated, err := v.MigrateLegacyVotes(ctx); err != nil || migrated != 0 {
+				t.Errorf("second MigrateLegacyVotes = %d, %v; want 0, nil", migrated, err)
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyVoteScriptSkipsStaleTail(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestValkey(t)
+
+	vote := Vote{PairID: "pair-1", Winner: "left", Timestamp: daysAgo(1)}
+	pushLegacyVotes(t, v, legacyVoteMigratingKey, vote)
+	keys := []string{legacyVoteMigratingKey, voteLogKey}
+
+	// Another instance already moved the vote this one read
+	moved, err := migrateLegacyVoteScript.Run(ctx, v.client, keys, `{"stale":true}`, voteLogID(&vote)).Int()
+	if err != nil || moved != 0 {
+		t.Fatalf("script = %d, %v; want 0, nil", moved, err)
+	}
+	if length, _ := v.client.XLen(ctx, voteLogKey).Result(); length != 0 {
+		t.Errorf("a stale read appended %d votes", length)
+	}
+
+	// A malformed vote is dropped without being logged
+	if err := v.client.LPush(ctx, legacyVoteMigratingKey, "not json").Err(); err != nil {
+		t.Fatalf("LPush: %v", err)
+	}
+	if err := v.client.RPop(ctx, legacyVoteMigratingKey).Err(); err != nil {
+		t.Fatalf("RPop: %v", err)
+	}
+	moved, err = migrateLegacyVoteScript.Run(ctx, v.client, keys, "not json", "").Int()
+	if err != nil || moved != 1 {
+		t.Fatalf("script = %d, %v; want 1, nil", moved, err)
+	}
+	if length, _ := v.client.XLen(ctx, voteLogKey).Result(); length != 0 {
+		t.Errorf("a malformed vote was logged")
+	}
+}
+
+func TestMergeArchivedVotes(t *testing.T) {
+	archived := func(ids ...string) []archivedVote {
+		votes := make([]archivedVote, len(ids))
+		for i, id := range ids {
+			votes[i] = archivedVote{StreamID: id}
+		}
+		return votes
+	}
+	ids := func(votes []archivedVote) []string {
+		var out []string
+		for _, vote := range votes {
+			out = append(out, vote.StreamID)
+		}
+		return out
+	}
+
+	got := ids(mergeArchivedVotes(archived("1-0", "3-0"), archived("2-0", "3-0", "10-0", "3-1")))
+	want := []string{"1-0", "2-0", "3-0", "3-1", "10-0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %v, want %v", got, want)
+	}
+	if !sort.SliceIsSorted(got, func(i, j int) bool { return compareStreamIDs(got[i], got[j]) < 0 }) {
+		t.Error("merged archive is not in stream order")
+	}
+}
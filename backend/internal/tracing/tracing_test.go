@@ -0,0 +1,202 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"cgc-lb-and-cdn-backend/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recorder holds every span ended during the tests; the global provider can only be installed once per process
+var recorder = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	os.Exit(m.Run())
+}
+
+// endedSince returns the spans ended after the first skip
+func endedSince(skip int) []sdktrace.ReadOnlySpan {
+	return recorder.Ended()[skip:]
+}
+
+// attributeValue returns the value of key on span, or "" if it is not set
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+
+	var traceID string
+	router.GET("/api/v1/rooms/:id", func(c *gin.Context) {
+		traceID = TraceID(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fail", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+	router.GET("/readyz", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	const parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		path        string
+		traceparent string
+		wantSpan    string
+		wantStatus  string
+		wantError   bool
+	}{
+		{"route template", "/api/v1/rooms/abc", "", "GET /api/v1/rooms/:id", "200", false},
+		{"continues the caller's trace", "/api/v1/rooms/abc", "00-" + parentTraceID + "-00f067aa0ba902b7-01", "GET /api/v1/rooms/:id", "200", false},
+		{"server error", "/fail", "", "GET /fail", "502", true},
+		{"unmatched", "/nowhere", "", "GET unmatched", "404", false},
+		{"health checks are not traced", "/readyz", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(recorder.Ended())
+			traceID = ""
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := endedSince(before)
+			if tt.wantSpan == "" {
+				if len(spans) != 0 {
+					t.Errorf("recorded %d spans, want none", len(spans))
+				}
+				return
+			}
+			if len(spans) != 1 {
+				t.Fatalf("recorded %d spans, want 1", len(spans))
+			}
+
+			span := spans[0]
+			if span.Name() != tt.wantSpan || span.SpanKind() != trace.SpanKindServer {
+				t.Errorf("span %q of kind %v, want server span %q", span.Name(), span.SpanKind(), tt.wantSpan)
+			}
+			if got := attributeValue(span, "http.response.status_code"); got != tt.wantStatus {
+				t.Errorf("status attribute = %q, want %s", got, tt.wantStatus)
+			}
+			if (span.Status().Code == codes.Error) != tt.wantError {
+				t.Errorf("span status = %v, want error %v", span.Status(), tt.wantError)
+			}
+			if tt.traceparent != "" && span.SpanContext().TraceID().String() != parentTraceID {
+				t.Errorf("trace ID = %s, want the caller's %s", span.SpanContext().TraceID(), parentTraceID)
+			}
+			if traceID != "" && traceID != span.SpanContext().TraceID().String() {
+				t.Errorf("handler saw trace ID %s, want %s", traceID, span.SpanContext().TraceID())
+			}
+		})
+	}
+}
+
+func TestTraced(t *testing.T) {
+	ctx := context.Background()
+	if Traced(ctx) || TraceID(ctx) != "" {
+		t.Error("a context without a span counts as traced")
+	}
+
+	ctx, span := otel.Tracer("test").Start(ctx, "work")
+	defer span.End()
+	if !Traced(ctx) || TraceID(ctx) != span.SpanContext().TraceID().String() {
+		t.Errorf("Traced = %v and TraceID = %q inside a span", Traced(ctx), TraceID(ctx))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var traceparents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	get := func(ctx context.Context) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	before := len(recorder.Ended())
+	get(context.Background())
+	if len(endedSince(before)) != 0 || traceparents[0] != "" {
+		t.Errorf("a request outside a trace was traced: %d spans, traceparent %q", len(endedSince(before)), traceparents[0])
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "generate")
+	get(ctx)
+	parent.End()
+
+	spans := endedSince(before)
+	if len(spans) != 2 || spans[0].SpanKind() != trace.SpanKindClient || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("spans = %v, want a client span under the parent", spans)
+	}
+	if spans[0].Name() != "GET "+server.Listener.Addr().String() {
+		t.Errorf("client span name = %q, want the method and host", spans[0].Name())
+	}
+	if traceparents[1] == "" {
+		t.Error("request within a trace has no traceparent header")
+	}
+}
+
+func TestEnd(t *testing.T) {
+	before := len(recorder.Ended())
+
+	_, ok := otel.Tracer("test").Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := otel.Tracer("test").Start(context.Background(), "failed")
+	End(failed, errors.New("provider timed out"))
+
+	spans := endedSince(before)
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	if spans[0].Status().Code == codes.Error || len(spans[0].Events()) != 0 {
+		t.Errorf("successful span has status %v and events %v", spans[0].Status(), spans[0].Events())
+	}
+	if spans[1].Status().Code != codes.Error || spans[1].Status().Description != "provider timed out" || len(spans[1].Events()) != 1 {
+		t.Errorf("failed span has status %v and events %v, want the error recorded", spans[1].Status(), spans[1].Events())
+	}
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: "none"})
+	if err != nil {
+		t.Fatalf("Setup(none): %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "zipkin"}); err == nil {
+		t.Error("Setup accepted an unknown exporter")
+	}
+}
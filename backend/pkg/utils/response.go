@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrorResponse represents an error response
@@ -11,6 +12,7 @@ type ErrorResponse struct {
 	Error   string            `json:"error"`
 	Code    string            `json:"code,omitempty"`
 	Details map[string]string `json:"details,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
 }
 
 // SuccessResponse represents a success response
@@ -26,6 +28,7 @@ func RespondWithError(c *gin.Context, statusCode int, message, code string, deta
 		Error:   message,
 		Code:    code,
 		Details: details,
+		Meta:    withTraceID(c, nil),
 	})
 }
 
@@ -34,6 +37,32 @@ func RespondWithSuccess(c *gin.Context, data interface{}, message string, meta m
 	c.JSON(http.StatusOK, SuccessResponse{
 		Data:    data,
 		Message: message,
-		Meta:    meta,
+		Meta:    withTraceID(c, meta),
 	})
 }
+
+// RespondWithAccepted sends a success response for work that carries on after the response, such as a generation
+// finished in the background
+func RespondWithAccepted(c *gin.Context, data interface{}, message string, meta map[string]string) {
+	c.JSON(http.StatusAccepted, SuccessResponse{
+		Data:    data,
+		Message: message,
+		Meta:    withTraceID(c, meta),
+	})
+}
+
+// withTraceID adds the request's trace ID to meta as "trace_id" when the request is being traced,
+// so a slow or failed response can be looked up in the tracing backend
+func withTraceID(c *gin.Context, meta map[string]string) map[string]string {
+	spanContext := trace.SpanContextFromContext(c.Request.Context())
+	if !spanContext.IsValid() || !spanContext.IsSampled() {
+		return meta
+	}
+
+	withID := make(map[string]string, len(meta)+1)
+	for key, value := range meta {
+		withID[key] = value
+	}
+	withID["trace_id"] = spanContext.TraceID().String()
+	return withID
+}
@@ -13,6 +13,8 @@ import {
   getVotedPairIds,
   saveVotedPairIds,
   getSessionId,
+  subscribeToEvents,
+  toSrcSet,
   type ImagePair,
 } from '../services/dataService'
 
@@ -83,8 +85,10 @@ export default function ImageBattle() {
       setVotedPairIds(newVotedPairIds)
       saveVotedPairIds(newVotedPairIds)
 
-      // Fetch updated team scores
-      fetchTeamScores()
+      // Live mode gets updated scores from the vote event; lite mode reads them back from localStorage
+      if (!config.features.enableLiveStatistics) {
+        fetchTeamScores()
+      }
 
       // Wait for animation to complete
       setTimeout(() => {
@@ -168,11 +172,21 @@ export default function ImageBattle() {
     loadImagePair()
     fetchTeamScores()
 
-    // Refresh team scores periodically (only when API is enabled)
+    // Follow everyone's votes live (only when API is enabled)
     // Note: enableLiveStatistics is derived from enableAPI in config
     if (config.features.enableLiveStatistics) {
-      const interval = setInterval(fetchTeamScores, 30000)
-      return () => clearInterval(interval)
+      return subscribeToEvents({
+        onVote: (event) => {
+          setTeamScores({
+            left: event.side_wins.left || 0,
+            right: event.side_wins.right || 0,
+          })
+        },
+        // Catch up on votes missed while the stream was down
+        onConnectionChange: (connected) => {
+          if (connected) fetchTeamScores()
+        },
+      })
     }
   }, [])
 
@@ -397,8 +411,11 @@ export default function ImageBattle() {
               >
                 <img
                   src={imagePair.left_url}
+                  srcSet={toSrcSet(imagePair.left_srcset)}
+                  sizes="(max-width: 768px) 100vw, 50vw"
                   alt="Left choice"
                   className="w-full h-full object-cover"
+                  style={imagePair.left_placeholder ? { backgroundImage: `url(${imagePair.left_placeholder})`, backgroundSize: 'cover' } : undefined}
                   onError={(e) => {
                     const target = e.target as HTMLImageElement
                     target.src = 'data:image/svg+xml;base64,PHN2ZyB3aWR0aD0iMjAwIiBoZWlnaHQ9IjIwMCIgeG1sbnM9Imh0dHA6Ly93d3cudzMub3JnLzIwMDAvc3ZnIj48cmVjdCB3aWR0aD0iMTAwJSIgaGVpZ2h0PSIxMDAlIiBmaWxsPSIjZGRkIi8+PHRleHQgeD0iNTAlIiB5PSI1MCUiIGZvbnQtc2l6ZT0iMTQiIHRleHQtYW5jaG9yPSJtaWRkbGUiIGR5PSIuM2VtIj5JbWFnZSBOb3QgRm91bmQ8L3RleHQ+PC9zdmc+'
@@ -442,8 +459,11 @@ export default function ImageBattle() {
               >
                 <img
                   src={imagePair.right_url}
+                  srcSet={toSrcSet(imagePair.right_srcset)}
+                  sizes="(max-width: 768px) 100vw, 50vw"
                   alt="Right choice"
                   className="w-full h-full object-cover"
+                  style={imagePair.right_placeholder ? { backgroundImage: `url(${imagePair.right_placeholder})`, backgroundSize: 'cover' } : undefined}
                   onError={(e) => {
                     const target = e.target as HTMLImageElement
                     target.src = 'data:image/svg+xml;base64,PHN2ZyB3aWR0aD0iMjAwIiBoZWlnaHQ9IjIwMCIgeG1sbnM9Imh0dHA6Ly93d3cudzMub3JnLzIwMDAvc3ZnIj48cmVjdCB3aWR0aD0iMTAwJSIgaGVpZ2h0PSIxMDAlIiBmaWxsPSIjZGRkIi8+PHRleHQgeD0iNTAlIiB5PSI1MCUiIGZvbnQtc2l6ZT0iMTQiIHRleHQtYW5jaG9yPSJtaWRkbGUiIGR5PSIuM2VtIj5JbWFnZSBOb3QgRm91bmQ8L3RleHQ+PC9zdmc+'
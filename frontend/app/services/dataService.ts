@@ -14,6 +14,21 @@ export interface ImagePair {
   provider: string
   left_url: string
   right_url: string
+  // Responsive variants keyed by width descriptor ("256w", "512w", "1024w")
+  left_srcset?: Record<string, string>
+  right_srcset?: Record<string, string>
+  left_placeholder?: string
+  right_placeholder?: string
+}
+
+/**
+ * Convert a width-descriptor map into an img srcSet attribute value
+ */
+export function toSrcSet(srcset?: Record<string, string>): string | undefined {
+  if (!srcset || Object.keys(srcset).length === 0) return undefined
+  return Object.entries(srcset)
+    .map(([descriptor, url]) => `${url} ${descriptor}`)
+    .join(', ')
 }
 
 // Optimized format from static data (just the essentials)
@@ -211,6 +226,8 @@ export async function submitVote(pairId: string, winner: 'left' | 'right'): Prom
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
+        // Votes are rate limited per session as well as per IP
+        'X-Session-ID': getSessionId(),
       },
       body: JSON.stringify({
         pair_id: pairId,
@@ -218,6 +235,10 @@ export async function submitVote(pairId: string, winner: 'left' | 'right'): Prom
       }),
     })
 
+    if (response.status === 429) {
+      const retryAfter = response.headers.get('Retry-After')
+      throw new Error(`Voting too fast - try again in ${retryAfter || 'a few'} seconds`)
+    }
     if (!response.ok) {
       throw new Error('Failed to submit vote')
     }
@@ -257,6 +278,75 @@ export async function fetchStatistics(): Promise<Statistics> {
   }
 }
 
+// Live event payloads pushed by GET /events
+export interface VoteEvent {
+  pair_id: string
+  winner: 'left' | 'right'
+  side_wins: {
+    left: number
+    right: number
+  }
+  total_votes: number
+}
+
+export interface PairEvent {
+  pair_id: string
+  prompt: string
+  provider: string
+  left_url: string
+  right_url: string
+  generated_at: string
+}
+
+export interface ProviderStatusEvent {
+  name: string
+  available: boolean
+  last_error?: string
+  error_count: number
+  quota_hit: boolean
+  rate_limited: boolean
+}
+
+export interface EventHandlers {
+  onVote?: (event: VoteEvent) => void
+  onPair?: (event: PairEvent) => void
+  onProviderStatus?: (event: ProviderStatusEvent) => void
+  // Called with false when the stream drops (the browser reconnects on its own) and true once it is back
+  onConnectionChange?: (connected: boolean) => void
+}
+
+/**
+ * Subscribe to live votes, new pairs and provider status changes
+ * - Full mode: Server-Sent Events from the API, covering activity on every server
+ * - Lite mode: No-op (there is no shared state to follow)
+ * Returns a function that closes the subscription
+ */
+export function subscribeToEvents(handlers: EventHandlers): () => void {
+  if (config.isLiteMode || typeof window === 'undefined' || typeof EventSource === 'undefined') {
+    return () => {}
+  }
+
+  const source = new EventSource(`${config.api.baseUrl}/events`)
+  const listen = <T>(type: string, handler?: (event: T) => void) => {
+    if (!handler) return
+    source.addEventListener(type, (message) => {
+      try {
+        handler(JSON.parse((message as MessageEvent).data))
+      } catch (err) {
+        console.error(`Malformed ${type} event:`, err)
+      }
+    })
+  }
+
+  listen('vote', handlers.onVote)
+  listen('pair', handlers.onPair)
+  listen('provider_status', handlers.onProviderStatus)
+  source.onopen = () => handlers.onConnectionChange?.(true)
+  source.onerror = () => handlers.onConnectionChange?.(false)
+
+  return () => source.close()
+}
+
 /**
  * Fetch winners for a side
  * - Full mode: Fetch from API
@@ -188,16 +188,18 @@ func main() {
 			},
 
 			// Health check configuration
-			// Check Nginx on port 80 which will proxy /health to backend:8080
-			// More tolerant settings to allow time for initial deployment
+			// Check Nginx on port 80 which will proxy /readyz to backend:8080
+			// Readiness covers Valkey and Spaces only, so a droplet stays in rotation for voting while providers are down
+			// A slow healthy threshold allows time for initial deployment; a shutting-down backend fails /readyz
+			// for SHUTDOWN_DRAIN_DELAY (35s), so it must be marked DOWN within that time
 			Healthcheck: &digitalocean.LoadBalancerHealthcheckArgs{
 				Protocol:               pulumi.String("http"),
 				Port:                   pulumi.Int(80),
-				Path:                   pulumi.String("/health"),
+				Path:                   pulumi.String("/readyz"),
 				CheckIntervalSeconds:   pulumi.Int(10),
 				ResponseTimeoutSeconds: pulumi.Int(15), // Increased to 15s for slower responses
 				HealthyThreshold:       pulumi.Int(2),  // Needs 2 successful checks to mark UP
-				UnhealthyThreshold:     pulumi.Int(8),  // Increased to 8 (80 seconds of failures before marking DOWN)
+				UnhealthyThreshold:     pulumi.Int(3),  // 30 seconds of failures before marking DOWN, within the drain delay
 			},
 
 			// Sticky sessions
@@ -508,6 +510,7 @@ DO_SPACES_SECRET_KEY=${DO_SPACES_SECRET_KEY}
 DO_VALKEY_HOST=${DO_VALKEY_HOST}
 DO_VALKEY_PORT=${DO_VALKEY_PORT}
 DO_VALKEY_PASSWORD=${DO_VALKEY_PASSWORD}
+LOG_LEVEL=info
 ENVEOF
 
 # Create systemd service file for backend
@@ -526,6 +529,9 @@ EnvironmentFile=/opt/cgc-lb-and-cdn-backend/.env
 ExecStart=/opt/cgc-lb-and-cdn-backend/server
 Restart=always
 RestartSec=10
+# SIGTERM drains for SHUTDOWN_DRAIN_DELAY, then waits up to SHUTDOWN_TIMEOUT for in-flight generations
+KillSignal=SIGTERM
+TimeoutStopSec=180
 
 [Install]
 WantedBy=multi-user.target
@@ -546,9 +552,9 @@ chown -R cgc-lb-and-cdn-service:cgc-lb-and-cdn-service /opt/cgc-lb-and-cdn-backe
 
 # Verify environment variables are set
 echo "[$(date)] Verifying environment variables..."
-echo "GOOGLE_API_KEY: ${GOOGLE_API_KEY:0:10}..."
-echo "LEONARDO_API_KEY: ${LEONARDO_API_KEY:0:10}..."
-echo "FREEPIK_API_KEY: ${FREEPIK_API_KEY:0:10}..."
+echo "GOOGLE_API_KEY: $([ -n "$GOOGLE_API_KEY" ] && echo set || echo missing)"
+echo "LEONARDO_API_KEY: $([ -n "$LEONARDO_API_KEY" ] && echo set || echo missing)"
+echo "FREEPIK_API_KEY: $([ -n "$FREEPIK_API_KEY" ] && echo set || echo missing)"
 echo "VALKEY_HOST: $DO_VALKEY_HOST"
 echo "VALKEY_PORT: $DO_VALKEY_PORT"
 
@@ -562,6 +568,8 @@ mkdir -p "$GOCACHE"
 cd /opt/cgc-lb-and-cdn-backend
 go mod download
 go build -o server ./cmd/server
+go build -o reindex ./cmd/reindex
+go build -o apikey ./cmd/apikey
 
 echo "[$(date)] Starting backend service..."
 systemctl start cgc-lb-and-cdn-backend.service
@@ -573,92 +581,52 @@ systemctl status cgc-lb-and-cdn-backend.service --no-pager || true
 
 # Test health endpoint
 echo "[$(date)] Testing health endpoint..."
-curl -v http://localhost:8080/health || echo "Health check failed!"
+curl -v http://localhost:8080/health/deep || echo "Health check failed!"
 
 # Recreate Valkey indexes from DO Spaces if requested (rebuilds from single source of truth)
 if [ "${RECREATE_VALKEY}" = "true" ]; then
   echo "[$(date)] Recreating Valkey indexes from DO Spaces..."
   echo "[$(date)] This will rebuild the image pair indexes from the data stored in DO Spaces"
 
-  # First, flush the existing Valkey data
-  echo "[$(date)] Flushing existing Valkey data..."
-  redis-cli -h ${DO_VALKEY_HOST} -p ${DO_VALKEY_PORT} -a ${DO_VALKEY_PASSWORD} --tls FLUSHALL && \
-    echo "[$(date)] ✅ Valkey database flushed successfully" || \
-    echo "[$(date)] ⚠️  Failed to flush Valkey database"
-
-  # List all objects in the images/ prefix from DO Spaces
+  # First, clear only the keys the rebuild recreates: pair:<id>, pairs:all and the phash:* hash index
+  # Votes (votes:log, votes:archive and every counter, including pair:votes), pairs:archive, API keys,
+  # settings and the fraud quarantine are not in Spaces and are kept
+  echo "[$(date)] Clearing the pair index..."
+  valkey_cli() { redis-cli -h ${DO_VALKEY_HOST} -p ${DO_VALKEY_PORT} -a ${DO_VALKEY_PASSWORD} --tls --no-auth-warning "$@"; }
+  { valkey_cli --scan --pattern 'pair:*' | grep -vx 'pair:votes'; valkey_cli --scan --pattern 'phash:*'; echo pairs:all; } | \
+    xargs -r -n 500 redis-cli -h ${DO_VALKEY_HOST} -p ${DO_VALKEY_PORT} -a ${DO_VALKEY_PASSWORD} --tls --no-auth-warning UNLINK > /dev/null && \
+    echo "[$(date)] ✅ Pair index cleared" || \
+    echo "[$(date)] ⚠️  Failed to clear the pair index"
+
+  # Rebuild pair:* and pairs:all from the images/ prefix and its x-amz-meta-* metadata
+  # The report lists restored pairs plus any orphaned or half-written objects left behind by failed uploads
   echo "[$(date)] Reading image pairs from DO Spaces (bucket: ${DO_SPACES_BUCKET})..."
-
-  # Structure: images/<provider>/<pair_id>/<side>.png
-  # We need to find all unique pair_id values
-  TEMP_LISTING="/tmp/spaces-listing.txt"
-  s3cmd ls --recursive "s3://${DO_SPACES_BUCKET}/images/" > "$TEMP_LISTING" 2>&1 || {
-    echo "[$(date)] ⚠️  Failed to list DO Spaces objects"
-    echo "[$(date)] Continuing with empty Valkey - bootstrap will generate new pairs"
+  (set -a && . /opt/cgc-lb-and-cdn-backend/.env && set +a && \
+    /opt/cgc-lb-and-cdn-backend/reindex -workers 16 > /tmp/reindex-report.json) && \
+    echo "[$(date)] ✅ Recreated Valkey indexes from DO Spaces (report: /tmp/reindex-report.json)" || {
+    echo "[$(date)] ⚠️  Reindex from DO Spaces reported errors (report: /tmp/reindex-report.json)"
+    echo "[$(date)] Bootstrap process will generate new pairs if none were restored"
   }
-
-  if [ -f "$TEMP_LISTING" ] && [ -s "$TEMP_LISTING" ]; then
-    # Extract unique pair IDs from the listing
-    # Expected format: 2024-01-01 12:00  12345  s3://bucket/images/provider/pair-id/side.png
-    PAIR_COUNT=0
-
-    # Process each unique pair_id
-    for PROVIDER_DIR in $(s3cmd ls "s3://${DO_SPACES_BUCKET}/images/" | grep DIR | awk '{print $2}'); do
-      PROVIDER_NAME=$(basename "$PROVIDER_DIR" | sed 's:/$::')
-      echo "[$(date)] Processing provider: ${PROVIDER_NAME}"
-
-      for PAIR_DIR in $(s3cmd ls "${PROVIDER_DIR}" | grep DIR | awk '{print $2}'); do
-        PAIR_ID=$(basename "$PAIR_DIR" | sed 's:/$::')
-
-        # Check if both left.png and right.png exist
-        LEFT_URL="https://${DO_SPACES_BUCKET}.${DO_SPACES_ENDPOINT}/images/${PROVIDER_NAME}/${PAIR_ID}/left.png"
-        RIGHT_URL="https://${DO_SPACES_BUCKET}.${DO_SPACES_ENDPOINT}/images/${PROVIDER_NAME}/${PAIR_ID}/right.png"
-
-        # Get metadata from left image (contains prompt and other info)
-        METADATA_FILE="/tmp/metadata-${PAIR_ID}.txt"
-        s3cmd info "s3://${DO_SPACES_BUCKET}/images/${PROVIDER_NAME}/${PAIR_ID}/left.png" > "$METADATA_FILE" 2>&1
-
-        # Extract prompt from metadata (stored as x-amz-meta-prompt header)
-        # Use sed to trim whitespace instead of xargs to avoid quote interpretation issues
-        PROMPT=$(grep -i "x-amz-meta-prompt" "$METADATA_FILE" | cut -d: -f2- | sed -e 's/^[[:space:]]*//' -e 's/[[:space:]]*$//')
-        if [ -z "$PROMPT" ]; then
-          PROMPT="Unknown prompt"
-        fi
-
-        # Escape double quotes in prompt for JSON
-        PROMPT=$(echo "$PROMPT" | sed 's/"/\\"/g')
-
-        # Store the pair in Valkey using the same format as the backend
-        # We'll use redis-cli to store the JSON directly
-        TIMESTAMP=$(date -Iseconds)
-        PAIR_JSON="{\"pair_id\":\"${PAIR_ID}\",\"prompt\":\"${PROMPT}\",\"provider\":\"${PROVIDER_NAME}\",\"left_url\":\"${LEFT_URL}\",\"right_url\":\"${RIGHT_URL}\",\"timestamp\":\"${TIMESTAMP}\"}"
-
-        # Store pair in Valkey
-        redis-cli -h ${DO_VALKEY_HOST} -p ${DO_VALKEY_PORT} -a ${DO_VALKEY_PASSWORD} --tls \
-          SET "pair:${PAIR_ID}" "$PAIR_JSON" >/dev/null 2>&1 && \
-        redis-cli -h ${DO_VALKEY_HOST} -p ${DO_VALKEY_PORT} -a ${DO_VALKEY_PASSWORD} --tls \
-          LPUSH "pairs:all" "${PAIR_ID}" >/dev/null 2>&1 && \
-          PAIR_COUNT=$((PAIR_COUNT + 1))
-
-        rm -f "$METADATA_FILE"
-      done
-    done
-
-    echo "[$(date)] ✅ Recreated ${PAIR_COUNT} image pairs in Valkey from DO Spaces"
-    rm -f "$TEMP_LISTING"
-  else
-    echo "[$(date)] ⚠️  No images found in DO Spaces, Valkey will be empty"
-    echo "[$(date)] Bootstrap process will generate initial pairs"
-  fi
 else
   echo "[$(date)] Valkey recreation not requested, preserving existing data"
 fi
 
+# Issue this droplet an API key with the generate scope for bootstrap and the cron generator
+# -replace revokes the key issued on the previous boot, so reboots do not pile up cron-<hostname> keys
+# POST /api/v1/generate spends provider credits, so it is not open to anonymous callers
+echo "[$(date)] Issuing generator API key..."
+GENERATOR_API_KEY=$(set -a && . /opt/cgc-lb-and-cdn-backend/.env && set +a && \
+  /opt/cgc-lb-and-cdn-backend/apikey create -name "cron-$(hostname)" -scopes generate -replace) || {
+  echo "[$(date)] ⚠️  Failed to issue generator API key - image generation from cron will be rejected"
+  GENERATOR_API_KEY=""
+}
+
 # Bootstrap: Generate initial image pairs to prevent empty database
 echo "[$(date)] Bootstrapping image pairs..."
 for i in 1 2; do
   echo "[$(date)] Generating bootstrap image pair $i/2..."
   curl -s -X POST http://localhost:8080/api/v1/generate \
+    -H "Authorization: Bearer ${GENERATOR_API_KEY}" \
     -H "Content-Type: application/json" \
     -d "{\"prompt\": \"bootstrap-image-$i\"}" || echo "Bootstrap generation $i failed"
 
@@ -690,9 +658,36 @@ server {
         access_log off;
     }
 
-    # Proxy health check to backend
-    location /health {
-        proxy_pass http://localhost:8080/health;
+    # Proxy health checks to backend; /health/deep stays on the backend port
+    location ~ ^/(livez|readyz|health)$ {
+        proxy_pass http://localhost:8080;
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+    }
+
+    # Stream live events without buffering; the backend sends a heartbeat every 20s
+    location /api/v1/events {
+        proxy_pass http://localhost:8080;
+        proxy_http_version 1.1;
+        proxy_set_header Connection "";
+        proxy_buffering off;
+        proxy_cache off;
+        proxy_read_timeout 1h;
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+    }
+
+    # Upgrade battle room connections to WebSocket
+    location ~ ^/api/v1/rooms/[^/]+/ws$ {
+        proxy_pass http://localhost:8080;
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection "upgrade";
+        proxy_read_timeout 1h;
         proxy_set_header Host $host;
         proxy_set_header X-Real-IP $remote_addr;
         proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
@@ -775,7 +770,7 @@ netstat -tlnp | grep -E ':(80|443|3000|8080)' || echo "No services listening on
 echo ""
 echo "=== Backend health check ==="
 sleep 3
-curl -v http://localhost:8080/health || echo "Backend health check failed!"
+curl -v http://localhost:8080/health/deep || echo "Backend health check failed!"
 
 echo ""
 echo "=== Frontend health check ==="
@@ -878,7 +873,7 @@ CONSOLIDATED="/tmp/cgc-lb-and-cdn-logs-${TIMESTAMP}.log"
   echo ""
 
   echo "=== Health Check Test ==="
-  curl -s http://localhost:8080/health 2>&1 || echo "Health check failed or backend not responding"
+  curl -s http://localhost:8080/health/deep 2>&1 || echo "Health check failed or backend not responding"
   echo ""
 
   echo "=== System Info ==="
@@ -991,8 +986,9 @@ if acquire_lock; then
 
   # Call the backend API to generate a new image pair
   RESPONSE=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/api/v1/generate \
+    -H "Authorization: Bearer ${GENERATOR_API_KEY}" \
     -H "Content-Type: application/json" \
-    -d '{"prompt": "auto-generated"}' 2>&1)
+    -d '{"prompt": "auto-generated", "scheduled": true}' 2>&1)
 
   HTTP_CODE=$(echo "$RESPONSE" | tail -n 1)
   BODY=$(echo "$RESPONSE" | head -n -1)
@@ -1001,6 +997,15 @@ if acquire_lock; then
     PAIR_ID=$(echo "$BODY" | grep -o '"pair_id":"[^"]*"' | cut -d'"' -f4)
     PROVIDER=$(echo "$BODY" | grep -o '"provider":"[^"]*"' | cut -d'"' -f4)
     echo "[$TIMESTAMP] ✅ Successfully generated image pair: $PAIR_ID (Provider: $PROVIDER)" >> "$LOGFILE"
+  elif [ "$HTTP_CODE" = "202" ]; then
+    # Accepted by a provider that finishes the pair in the background (Leonardo completion callbacks)
+    PAIR_ID=$(echo "$BODY" | grep -o '"pair_id":"[^"]*"' | cut -d'"' -f4)
+    PROVIDER=$(echo "$BODY" | grep -o '"provider":"[^"]*"' | cut -d'"' -f4)
+    echo "[$TIMESTAMP] ⏳ Image pair generation accepted: $PAIR_ID (Provider: $PROVIDER)" >> "$LOGFILE"
+  elif [ "$HTTP_CODE" = "409" ]; then
+    # Paused or not due yet according to the runtime settings (see /api/v1/admin/settings)
+    CODE=$(echo "$BODY" | grep -o '"code":"[^"]*"' | cut -d'"' -f4)
+    echo "[$TIMESTAMP] ⏭️  Generation skipped by runtime settings ($CODE)" >> "$LOGFILE"
   else
     echo "[$TIMESTAMP] ❌ Failed to generate images (HTTP $HTTP_CODE): $BODY" >> "$LOGFILE"
   fi
@@ -1019,6 +1024,7 @@ export DO_SPACES_BUCKET="${DO_SPACES_BUCKET}"
 export DO_VALKEY_HOST="${DO_VALKEY_HOST}"
 export DO_VALKEY_PORT="${DO_VALKEY_PORT}"
 export DO_VALKEY_PASSWORD="${DO_VALKEY_PASSWORD}"
+export GENERATOR_API_KEY="${GENERATOR_API_KEY}"
 ENVEOF
 chown cgc-lb-and-cdn-service:cgc-lb-and-cdn-service /var/lib/cgc-lb-and-cdn-service/.env-cron
 chmod 600 /var/lib/cgc-lb-and-cdn-service/.env-cron
@@ -1032,11 +1038,9 @@ PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin
 # Upload logs every ${LOG_UPLOAD_INTERVAL_MINUTES} minutes
 */${LOG_UPLOAD_INTERVAL_MINUTES} * * * * cgc-lb-and-cdn-service /usr/local/bin/upload-logs.sh >> /var/log/cgc-lb-and-cdn-log-upload.log 2>&1
 
-# Generate image pairs every 15 minutes to keep database populated
-*/15 * * * * cgc-lb-and-cdn-service /usr/local/bin/generate-images.sh
-
-# Generate extra images during peak hours (9 AM - 9 PM) every 5 minutes
-*/5 9-21 * * * cgc-lb-and-cdn-service /usr/local/bin/generate-images.sh
+# Check every minute whether an image pair is due; how often one is actually generated (and whether
+# generation is paused) is a runtime setting operators change through /api/v1/admin/settings
+* * * * * cgc-lb-and-cdn-service /usr/local/bin/generate-images.sh
 CRONEOF
 
 # Set proper permissions on cron file